@@ -19,7 +19,6 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/zmap/zdns/internal/util"
-	"github.com/zmap/zdns/pkg/zdns"
 )
 
 // alookupCmd represents the alookup command
@@ -30,13 +29,8 @@ var alookupCmd = &cobra.Command{
 the information that exists in a single record.
 
 Specifically, alookup acts similar to nslookup and will follow CNAME records.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		GC.Module = strings.ToUpper("alookup")
-		zdns.Run(GC, cmd.Flags(),
-			&Timeout, &IterationTimeout,
-			&Class_string, &Servers_string,
-			&Config_file, &Localaddr_string,
-			&Localif_string, &NanoSeconds, &ClientSubnet_string, &NSID)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runModule(cmd, strings.ToUpper("alookup"))
 	},
 }
 
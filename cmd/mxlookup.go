@@ -19,7 +19,6 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/zmap/zdns/internal/util"
-	"github.com/zmap/zdns/pkg/zdns"
 )
 
 // mxlookupCmd represents the mxlookup command
@@ -28,13 +27,8 @@ var mxlookupCmd = &cobra.Command{
 	Short: "Run a more exhaustive mxlookup",
 	Long: `mxlookup will additionally do an A lookup for the IP addresses that
 correspond with an exchange record.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		GC.Module = strings.ToUpper("mxlookup")
-		zdns.Run(GC, cmd.Flags(),
-			&Timeout, &IterationTimeout,
-			&Class_string, &Servers_string,
-			&Config_file, &Localaddr_string,
-			&Localif_string, &NanoSeconds, &ClientSubnet_string, &NSID)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runModule(cmd, strings.ToUpper("mxlookup"))
 	},
 }
 
@@ -14,13 +14,22 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/zmap/zdns/internal/util"
+	"github.com/zmap/zdns/pkg/metrics"
 	"github.com/zmap/zdns/pkg/zdns"
 )
 
@@ -30,6 +39,7 @@ var GC zdns.GlobalConf
 // TODO: these options may need to be set as flags or in GC, to standardize.
 var (
 	Servers_string      string
+	Modules_string      string
 	Localaddr_string    string
 	Localif_string      string
 	Config_file         string
@@ -39,6 +49,7 @@ var (
 	NanoSeconds         bool
 	ClientSubnet_string string
 	NSID                bool
+	Metrics_addr        string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -51,24 +62,94 @@ and parsing raw DNS packets.
 
 ZDNS also includes its own recursive resolution and a cache to further optimize performance.`,
 	ValidArgs: zdns.Validlookups(),
-	Args:      cobra.ExactValidArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		GC.Module = strings.ToUpper(args[0])
-		zdns.Run(GC, cmd.Flags(),
-			// 15, 4
-			&Timeout, &IterationTimeout,
-			// INET, ""
-			&Class_string, &Servers_string,
-			// /etc/resolv.conf, ""
-			&Config_file, &Localaddr_string,
-			// "", false, "", false
-			&Localif_string, &NanoSeconds, &ClientSubnet_string, &NSID)
+	Args: func(cmd *cobra.Command, args []string) error {
+		// with --modules the module argument is left out
+		if Modules_string != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("--modules: cannot be combined with module %s", args[0])
+			}
+			return nil
+		}
+		return cobra.ExactValidArgs(1)(cmd, args)
 	},
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return runModule(cmd, "")
+		}
+		return runModule(cmd, strings.ToUpper(args[0]))
+	},
+}
+
+// runModule validates the command line for the given module and runs the scan.
+// Any configuration mistake is returned to cobra, which prints it.
+func runModule(cmd *cobra.Command, module string) error {
+	GC.Module = module
+	if Modules_string != "" {
+		GC.Modules = strings.Split(Modules_string, ",")
+	}
+	runner, err := zdns.NewRunner(GC, zdns.RunOptions{
+		Flags:            cmd.Flags(),
+		Timeout:          Timeout,
+		IterationTimeout: IterationTimeout,
+		Class:            Class_string,
+		NameServers:      Servers_string,
+		ConfigFile:       Config_file,
+		LocalAddrs:       Localaddr_string,
+		LocalInterface:   Localif_string,
+		NanoSeconds:      NanoSeconds,
+		ClientSubnet:     ClientSubnet_string,
+		NSID:             NSID,
+	})
+	if err != nil {
+		return err
+	}
+	if Metrics_addr != "" {
+		m, err := serveMetrics(Metrics_addr, runner.Conf().NameServers)
+		if err != nil {
+			return err
+		}
+		runner.Conf().Metrics = m
+	}
+	// the first interrupt stops reading input and cancels the lookups in
+	// flight, whose results are written with the CANCELLED status, so that
+	// the output is complete and the checkpoint is saved.
+	// Any further interrupt kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	return runner.RunContext(ctx)
+}
+
+// serveMetrics exports the metrics of the scan for Prometheus on
+// http://addr/metrics for as long as the process runs. Queries to other name
+// servers than nameServers are counted together.
+func serveMetrics(addr string, nameServers []string) (zdns.Metrics, error) {
+	reg := prometheus.NewRegistry()
+	m, err := metrics.NewPrometheus(reg, nameServers)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("--metrics-addr: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Warn("metrics endpoint stopped: ", err)
+		}
+	}()
+	return m, nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	// input/output handlers can be registered from any package's init, so
+	// their options are only added here, once all of them have run
+	zdns.AddHandlerFlags(rootCmd.PersistentFlags())
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -87,17 +168,37 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.PersistentFlags().IntVar(&GC.Threads, "threads", 1000, "number of lightweight go threads")
+	rootCmd.PersistentFlags().Float64Var(&GC.Rate, "rate", 0, "maximum number of queries per second sent in total, including retries. 0 means unlimited")
+	rootCmd.PersistentFlags().Float64Var(&GC.PerNameServerRate, "per-nameserver-rate", 0, "maximum number of queries per second sent to any single name server, including retries. 0 means unlimited")
 	rootCmd.PersistentFlags().IntVar(&GC.GoMaxProcs, "go-processes", 0, "number of OS processes (GOMAXPROCS)")
 	rootCmd.PersistentFlags().StringVar(&GC.NamePrefix, "prefix", "", "name to be prepended to what's passed in (e.g., www.)")
 	rootCmd.PersistentFlags().StringVar(&GC.NameOverride, "override-name", "", "name overrides all passed in names")
 	rootCmd.PersistentFlags().BoolVar(&GC.AlexaFormat, "alexa", false, "is input file from Alexa Top Million download")
 	rootCmd.PersistentFlags().BoolVar(&GC.MetadataFormat, "metadata-passthrough", false, "if input records have the form 'name,METADATA', METADATA will be propagated to the output")
+	rootCmd.PersistentFlags().StringVar(&GC.InputFormat, "input-format", "text", "format of input lines. Options: text, jsonl (one JSON object per line with name, module, nameserver, class and metadata)")
 	rootCmd.PersistentFlags().BoolVar(&GC.IterativeResolution, "iterative", false, "Perform own iteration instead of relying on recursive resolver")
 	rootCmd.PersistentFlags().BoolVar(&GC.FollowCName, "iter-follow-cname", false, "When performing iterative mode queries for A records, enable tracking of CNAME record resolution.")
 	rootCmd.PersistentFlags().BoolVar(&GC.LookupAllNameServers, "all-nameservers", false, "Perform the lookup via all the nameservers for the domain.")
+	rootCmd.PersistentFlags().StringVar(&GC.InputHandlerName, "input-handler", "file", "registered input handler used to read names")
+	rootCmd.PersistentFlags().StringVar(&GC.OutputHandlerName, "output-handler", "file", "registered output handler used to write results")
+	rootCmd.PersistentFlags().IntVar(&GC.Shards, "shards", 1, "split the input between this many instances")
+	rootCmd.PersistentFlags().IntVar(&GC.Shard, "shard", 0, "which of the --shards instances this is, counting from 0")
+	rootCmd.PersistentFlags().Int64Var(&GC.Seed, "seed", 0, "seed for assigning input lines to shards. Must be the same for all shards of a scan")
 	rootCmd.PersistentFlags().StringVar(&GC.InputFilePath, "input-file", "-", "names to read")
 	rootCmd.PersistentFlags().StringVar(&GC.OutputFilePath, "output-file", "-", "where should JSON output be saved")
+	rootCmd.PersistentFlags().StringVar(&GC.OutputFormat, "output-format", "json", "how results are written. Options: json, csv, tsv (one row per record), zone (RFC 1035 master file records)")
+	rootCmd.PersistentFlags().StringSliceVar(&GC.ZoneSections, "zone-sections", []string{"answer"}, "sections of responses written by --output-format zone. Options: answer, authority, additional")
+	rootCmd.PersistentFlags().StringVar(&GC.OutputDir, "output-dir", "", "write the results of each status to their own file in this directory, e.g. NOERROR.jsonl or NOERROR.csv, instead of to --output-file")
+	rootCmd.PersistentFlags().StringVar(&GC.FailedNamesFilePath, "failed-names-file", "", "where should the input lines of lookups that timed out or failed be saved, for use as the input of a retry")
+	rootCmd.PersistentFlags().StringVar(&GC.DnstapFilePath, "dnstap-file", "", "where should every query put on the wire and its response be saved, as dnstap in a Frame Streams file")
+	rootCmd.PersistentFlags().StringVar(&GC.PcapFilePath, "pcap-file", "", "where should every query put on the wire and its response be saved, as the packets they were in a pcap file")
+	rootCmd.PersistentFlags().StringVar(&GC.InputCompression, "input-compression", "auto", "compression of --input-file. Options: auto (from the .gz or .zst extension), none, gzip, zstd")
+	rootCmd.PersistentFlags().StringVar(&GC.OutputCompression, "output-compression", "auto", "compression of --output-file. Options: auto (from the .gz or .zst extension), none, gzip, zstd")
 	rootCmd.PersistentFlags().StringVar(&GC.MetadataFilePath, "metadata-file", "", "where should JSON metadata be saved")
+	rootCmd.PersistentFlags().StringVar(&GC.StatusUpdatesFilePath, "status-updates-file", "", "where should a CSV line on the progress of the scan be written every second (- for stderr)")
+	rootCmd.PersistentFlags().StringVar(&Metrics_addr, "metrics-addr", "", "address to serve Prometheus metrics on at /metrics, e.g. :9090")
+	rootCmd.PersistentFlags().StringVar(&GC.CheckpointFilePath, "checkpoint-file", "", "where should scan progress be saved periodically, for use with --resume")
+	rootCmd.PersistentFlags().BoolVar(&GC.Resume, "resume", false, "continue an interrupted scan from --checkpoint-file, appending to --output-file")
 	rootCmd.PersistentFlags().StringVar(&GC.LogFilePath, "log-file", "", "where should JSON logs be saved")
 
 	rootCmd.PersistentFlags().StringVar(&GC.ResultVerbosity, "result-verbosity", "normal", "Sets verbosity of each output record. Options: short, normal, long, trace")
@@ -110,10 +211,16 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&GC.TCPOnly, "tcp-only", false, "Only perform lookups over TCP")
 	rootCmd.PersistentFlags().BoolVar(&GC.UDPOnly, "udp-only", false, "Only perform lookups over UDP")
 	rootCmd.PersistentFlags().BoolVar(&GC.CheckingDisabled, "checking-disabled", false, "Sends DNS packets with the CD bit set")
+	rootCmd.PersistentFlags().BoolVar(&GC.TLS, "tls", false, "Query name servers over DNS-over-TLS, as if given as tls://host. Their port defaults to 853")
+	rootCmd.PersistentFlags().StringVar(&GC.TLSServerName, "tls-server-name", "", "server name indication sent to DNS-over-TLS name servers in place of their host")
+	rootCmd.PersistentFlags().StringVar(&GC.TLSAuthName, "tls-auth-name", "", "verify the certificates of DNS-over-TLS name servers against this name")
+	rootCmd.PersistentFlags().StringVar(&GC.TLSCAFilePath, "tls-ca-file", "", "verify the certificates of DNS-over-TLS name servers against the CAs of this PEM bundle, for --tls-auth-name or the server name")
+	rootCmd.PersistentFlags().StringSliceVar(&GC.TLSSPKIPins, "tls-spki-pin", nil, "base64 SHA-256 digest of a public key the certificate of DNS-over-TLS name servers, or a CA of their verified chain, must have. Can be repeated")
 	rootCmd.PersistentFlags().BoolVar(&GC.RecycleSockets, "recycle-sockets", true, "Create long-lived unbound UDP socket for each thread at launch and reuse for all (UDP) queries")
 	rootCmd.PersistentFlags().BoolVar(&GC.NameServerMode, "name-server-mode", false, "Treats input as nameservers to query with a static query rather than queries to send to a static name server")
 
-	rootCmd.PersistentFlags().StringVar(&Servers_string, "name-servers", "", "List of DNS servers to use. Can be passed as comma-delimited string or via @/path/to/file. If no port is specified, defaults to 53.")
+	rootCmd.PersistentFlags().StringVar(&Modules_string, "modules", "", "comma-delimited list of modules to look up every name with, e.g. A,AAAA,MXLOOKUP. Results are combined into one record per name, keyed by module. Replaces the module argument")
+	rootCmd.PersistentFlags().StringVar(&Servers_string, "name-servers", "", "List of DNS servers to use. Can be passed as comma-delimited string or via @/path/to/file. If no port is specified, defaults to 53, or 853 for DNS-over-TLS servers given as tls://host.")
 	rootCmd.PersistentFlags().StringVar(&Localaddr_string, "local-addr", "", "comma-delimited list of local addresses to use")
 	rootCmd.PersistentFlags().StringVar(&Localif_string, "local-interface", "", "local interface to use")
 	rootCmd.PersistentFlags().StringVar(&Config_file, "conf-file", "/etc/resolv.conf", "config file for DNS servers")
@@ -0,0 +1,90 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zmap/zdns/pkg/miekg"
+	"github.com/zmap/zdns/pkg/zdns"
+)
+
+// schemaCmd represents the schema command
+var schemaCmd = &cobra.Command{
+	Use:   "schema MODULE",
+	Short: "Print the JSON Schema of the output of a module",
+	Long: `schema prints the JSON Schema of the results a module writes with
+--output-format json, for the given --result-verbosity and --include-fields.
+
+With --modules, the schema is of the results combined per name instead.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if Modules_string != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("--modules: cannot be combined with module %s", args[0])
+			}
+			return nil
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		groups, err := zdns.OutputGroups(GC.ResultVerbosity, GC.IncludeInOutput)
+		if err != nil {
+			return err
+		}
+		// the result types declared by the modules
+		types := make(map[string]reflect.Type)
+		opts := zdns.SchemaOptions{
+			Groups:        groups,
+			ResultType:    func(module string) reflect.Type { return types[module] },
+			TraceStepType: reflect.TypeOf(miekg.TraceStep{}),
+		}
+		if len(args) > 0 {
+			opts.Module = strings.ToUpper(args[0])
+			if zdns.GetLookup(opts.Module) == nil {
+				return fmt.Errorf("invalid lookup module %q. Valid modules: %s", args[0], zdns.ValidlookupsString())
+			}
+			if types[opts.Module], err = zdns.LookupResultType(opts.Module); err != nil {
+				return err
+			}
+		}
+		if Modules_string != "" {
+			for _, m := range strings.Split(Modules_string, ",") {
+				module := strings.ToUpper(strings.TrimSpace(m))
+				if zdns.GetLookup(module) == nil {
+					return fmt.Errorf("--modules: invalid lookup module %q. Valid modules: %s", m, zdns.ValidlookupsString())
+				}
+				if types[module], err = zdns.LookupResultType(module); err != nil {
+					return fmt.Errorf("--modules: %w", err)
+				}
+				opts.Modules = append(opts.Modules, module)
+			}
+		}
+		out, err := json.MarshalIndent(zdns.OutputSchema(opts), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(out))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
@@ -37,10 +37,16 @@ func main() {
 	classStr := "INET"
 	f := pflag.NewFlagSet("", pflag.ExitOnError)
 	f.String("blacklist-file", "", "")
-	zdns.Run(gc, f, &timeout, &iterationTimeout,
-		&classStr, new(string),
-		new(string), new(string),
-		new(string), new(bool),
-		new(string), new(bool),
-	)
+	runner, err := zdns.NewRunner(gc, zdns.RunOptions{
+		Flags:            f,
+		Timeout:          timeout,
+		IterationTimeout: iterationTimeout,
+		Class:            classStr,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err := runner.Run(); err != nil {
+		panic(err)
+	}
 }
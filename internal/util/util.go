@@ -29,11 +29,22 @@ var reV6 *regexp.Regexp
 
 const EnvPrefix = "ZDNS"
 
+// TLSScheme prefixes name servers that are queried over DNS-over-TLS, e.g.
+// tls://1.1.1.1:853
+const TLSScheme = "tls://"
+
 func AddDefaultPortToDNSServerName(s string) string {
+	if addr, ok := strings.CutPrefix(s, TLSScheme); ok {
+		return TLSScheme + addDefaultPort(addr, "853")
+	}
+	return addDefaultPort(s, "53")
+}
+
+func addDefaultPort(s, port string) string {
 	if !rePort.MatchString(s) {
-		return s + ":53"
+		return s + ":" + port
 	} else if reV6.MatchString(s) {
-		return "[" + s + "]:53"
+		return "[" + s + "]:" + port
 	} else {
 		return s
 	}
@@ -0,0 +1,183 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// COMPRESSION_AUTO picks the compression from the file extension
+	COMPRESSION_AUTO = "auto"
+	COMPRESSION_NONE = "none"
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_ZSTD = "zstd"
+)
+
+// ResolveCompression validates compression and, if it is COMPRESSION_AUTO or
+// empty, replaces it by the compression implied by the extension of path
+func ResolveCompression(compression, path string) (string, error) {
+	switch strings.ToLower(compression) {
+	case "", COMPRESSION_AUTO:
+		switch {
+		case strings.HasSuffix(path, ".gz"):
+			return COMPRESSION_GZIP, nil
+		case strings.HasSuffix(path, ".zst"):
+			return COMPRESSION_ZSTD, nil
+		}
+		return COMPRESSION_NONE, nil
+	case COMPRESSION_NONE:
+		return COMPRESSION_NONE, nil
+	case COMPRESSION_GZIP:
+		return COMPRESSION_GZIP, nil
+	case COMPRESSION_ZSTD:
+		return COMPRESSION_ZSTD, nil
+	}
+	return "", fmt.Errorf("invalid compression %q. Options: auto, none, gzip, zstd", compression)
+}
+
+// NewDecompressingReader returns a reader of the data in r decompressed
+// according to compression, which must already be resolved. Concatenated
+// gzip members and zstd frames are read as one stream.
+func NewDecompressingReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case COMPRESSION_GZIP:
+		return gzip.NewReader(bufio.NewReaderSize(r, 1<<16))
+	case COMPRESSION_ZSTD:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// NewCompressingWriter returns a writer compressing to w according to
+// compression, which must already be resolved. Compression happens on a
+// separate goroutine, so writes only copy data into a buffer. Close must be
+// called to flush everything to w; it does not close w.
+func NewCompressingWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	bw := bufio.NewWriterSize(w, 1<<20)
+	var enc io.WriteCloser
+	switch compression {
+	case COMPRESSION_GZIP:
+		enc = gzip.NewWriter(bw)
+	case COMPRESSION_ZSTD:
+		var err error
+		if enc, err = zstd.NewWriter(bw); err != nil {
+			return nil, err
+		}
+	default:
+		return nopWriteCloser{w}, nil
+	}
+	a := &asyncWriter{
+		enc:    enc,
+		out:    bw,
+		buf:    make([]byte, 0, asyncChunkSize),
+		chunks: make(chan []byte, asyncChunks),
+		free:   make(chan []byte, asyncChunks+1),
+		done:   make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+const (
+	asyncChunkSize = 1 << 16
+	asyncChunks    = 16
+)
+
+// asyncWriter collects writes into chunks and hands them to a goroutine that
+// feeds them to enc
+type asyncWriter struct {
+	enc io.WriteCloser
+	out *bufio.Writer
+
+	buf    []byte
+	chunks chan []byte
+	// free returns chunks to the writer for reuse
+	free chan []byte
+	done chan struct{}
+	// err is the first error of enc, which stops it from being written to
+	err atomic.Pointer[error]
+}
+
+func (a *asyncWriter) run() {
+	defer close(a.done)
+	for chunk := range a.chunks {
+		if a.err.Load() == nil {
+			if _, err := a.enc.Write(chunk); err != nil {
+				a.err.Store(&err)
+			}
+		}
+		a.free <- chunk[:0]
+	}
+}
+
+func (a *asyncWriter) Write(p []byte) (int, error) {
+	if err := a.err.Load(); err != nil {
+		return 0, *err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := copy(a.buf[len(a.buf):cap(a.buf)], p)
+		a.buf = a.buf[:len(a.buf)+m]
+		p = p[m:]
+		if len(a.buf) == cap(a.buf) {
+			a.flushChunk()
+		}
+	}
+	return n, nil
+}
+
+func (a *asyncWriter) flushChunk() {
+	a.chunks <- a.buf
+	select {
+	case a.buf = <-a.free:
+	default:
+		a.buf = make([]byte, 0, asyncChunkSize)
+	}
+}
+
+// Close compresses whatever is still buffered and writes out the end of the
+// compressed stream
+func (a *asyncWriter) Close() error {
+	if len(a.buf) > 0 {
+		a.flushChunk()
+	}
+	close(a.chunks)
+	<-a.done
+	if err := a.err.Load(); err != nil {
+		return *err
+	}
+	if err := a.enc.Close(); err != nil {
+		return err
+	}
+	return a.out.Flush()
+}
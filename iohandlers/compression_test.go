@@ -0,0 +1,100 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestResolveCompression(t *testing.T) {
+	for _, tc := range []struct {
+		compression, path, want string
+	}{
+		{"", "names.txt", COMPRESSION_NONE},
+		{"auto", "names.txt.gz", COMPRESSION_GZIP},
+		{"auto", "names.txt.zst", COMPRESSION_ZSTD},
+		{"auto", "-", COMPRESSION_NONE},
+		{"GZIP", "-", COMPRESSION_GZIP},
+		{"none", "names.txt.gz", COMPRESSION_NONE},
+	} {
+		got, err := ResolveCompression(tc.compression, tc.path)
+		assert.NilError(t, err)
+		assert.Equal(t, got, tc.want, "%q %q", tc.compression, tc.path)
+	}
+	_, err := ResolveCompression("bzip2", "names.txt")
+	assert.ErrorContains(t, err, "invalid compression")
+}
+
+// roundTrip writes lines through a FileOutputHandler and reads them back
+// through a FileInputHandler
+func roundTrip(t *testing.T, path, compression string, lines []string) []string {
+	out := NewFileOutputHandler(path)
+	out.SetCompression(compression)
+	results := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	outErr := make(chan error, 1)
+	go func() { outErr <- out.WriteResults(results, &wg) }()
+	for _, line := range lines {
+		results <- line
+	}
+	close(results)
+	wg.Wait()
+	assert.NilError(t, <-outErr)
+
+	in := NewFileInputHandler(path)
+	in.SetCompression(compression)
+	names := make(chan interface{})
+	wg.Add(1)
+	inErr := make(chan error, 1)
+	go func() { inErr <- in.FeedChannel(names, &wg) }()
+	var got []string
+	for name := range names {
+		got = append(got, name.(string))
+	}
+	wg.Wait()
+	assert.NilError(t, <-inErr)
+	return got
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	// enough lines to fill several of the chunks handed to the compressor
+	lines := make([]string, 50000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`{"name":"name%d.example","status":"NOERROR"}`, i)
+	}
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		file, compression, magic string
+	}{
+		{"out.jsonl", "", "{"},
+		{"out.jsonl.gz", "", "\x1f\x8b"},
+		{"out.jsonl.zst", "", "\x28\xb5\x2f\xfd"},
+		{"forced", COMPRESSION_ZSTD, "\x28\xb5\x2f\xfd"},
+	} {
+		path := filepath.Join(dir, tc.file)
+		got := roundTrip(t, path, tc.compression, lines)
+		assert.DeepEqual(t, got, lines)
+		data, err := os.ReadFile(path)
+		assert.NilError(t, err)
+		assert.Equal(t, string(data[:len(tc.magic)]), tc.magic, tc.file)
+	}
+}
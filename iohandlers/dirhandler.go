@@ -0,0 +1,173 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DirOutputHandler splits results by their status and writes those of each
+// status to their own file in a directory, e.g. NOERROR.jsonl
+type DirOutputHandler struct {
+	dir         string
+	ext         string
+	compression string
+}
+
+func NewDirOutputHandler(dir string) *DirOutputHandler {
+	return &DirOutputHandler{
+		dir: dir,
+		ext: ".jsonl",
+	}
+}
+
+// SetExtension sets the extension of the output files, .jsonl by default
+func (h *DirOutputHandler) SetExtension(ext string) {
+	h.ext = ext
+}
+
+// SetCompression sets how the output files are compressed, one of the
+// COMPRESSION_ constants. By default they're not compressed.
+func (h *DirOutputHandler) SetCompression(compression string) {
+	h.compression = compression
+}
+
+// StatusLine is an output line together with the status of the lookup it's
+// the result of. Lines without a status, like the header of CSV output, head
+// every file.
+type StatusLine struct {
+	Line   string
+	Status string
+}
+
+type statusFile struct {
+	f *os.File
+	w io.WriteCloser
+}
+
+// statusFileName returns the name of the file for results with status. Any
+// status that can't safely be used as a file name ends up in UNKNOWN.
+func statusFileName(status string) string {
+	if status == "" {
+		return "UNKNOWN"
+	}
+	for _, c := range status {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return "UNKNOWN"
+		}
+	}
+	return status
+}
+
+// WriteResults writes JSON results to the file of the status they contain.
+// Results that aren't JSON end up in UNKNOWN.
+func (h *DirOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	lines := make(chan StatusLine)
+	go func() {
+		defer close(lines)
+		for n := range results {
+			var res struct {
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal([]byte(n), &res); err != nil {
+				res.Status = ""
+			}
+			// a line without a status would be taken for a header
+			if res.Status == "" {
+				res.Status = "UNKNOWN"
+			}
+			lines <- StatusLine{Line: n, Status: res.Status}
+		}
+	}()
+	return h.WriteStatusResults(lines, wg)
+}
+
+// WriteStatusResults writes results to the file of their status, whatever
+// the output format
+func (h *DirOutputHandler) WriteStatusResults(results <-chan StatusLine, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+
+	compression, err := ResolveCompression(h.compression, "")
+	if err != nil {
+		drainStatus(results)
+		return err
+	}
+	ext := h.ext
+	switch compression {
+	case COMPRESSION_GZIP:
+		ext += ".gz"
+	case COMPRESSION_ZSTD:
+		ext += ".zst"
+	}
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		drainStatus(results)
+		return err
+	}
+
+	files := make(map[string]*statusFile)
+	var header []string
+	var firstErr error
+	for n := range results {
+		if n.Status == "" {
+			header = append(header, n.Line)
+			for _, sf := range files {
+				io.WriteString(sf.w, n.Line+"\n")
+			}
+			continue
+		}
+		name := statusFileName(n.Status)
+		sf, ok := files[name]
+		if !ok {
+			sf, err = openStatusFile(filepath.Join(h.dir, name+ext), compression)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			files[name] = sf
+			for _, line := range header {
+				io.WriteString(sf.w, line+"\n")
+			}
+		}
+		io.WriteString(sf.w, n.Line+"\n")
+	}
+	for _, sf := range files {
+		if err := sf.w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := sf.f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func openStatusFile(path, compression string) (*statusFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewCompressingWriter(f, compression)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &statusFile{f: f, w: w}, nil
+}
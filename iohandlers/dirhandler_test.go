@@ -0,0 +1,78 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package iohandlers
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestDirOutputHandler(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	h := NewDirOutputHandler(dir)
+	results := make(chan string, 5)
+	results <- `{"name":"a.example","status":"NOERROR"}`
+	results <- `{"name":"b.example","status":"TIMEOUT"}`
+	results <- `{"name":"c.example","status":"NOERROR"}`
+	results <- `{"name":"d.example","status":"../NOERROR"}`
+	results <- `not json`
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.NilError(t, h.WriteResults(results, &wg))
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.DeepEqual(t, names, []string{"NOERROR.jsonl", "TIMEOUT.jsonl", "UNKNOWN.jsonl"})
+
+	data, err := os.ReadFile(filepath.Join(dir, "NOERROR.jsonl"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), `{"name":"a.example","status":"NOERROR"}`+"\n"+`{"name":"c.example","status":"NOERROR"}`+"\n")
+	data, err = os.ReadFile(filepath.Join(dir, "UNKNOWN.jsonl"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), `{"name":"d.example","status":"../NOERROR"}`+"\n"+"not json\n")
+}
+
+func TestDirOutputHandlerStatusResults(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	h := NewDirOutputHandler(dir)
+	h.SetExtension(".csv")
+	results := make(chan StatusLine, 4)
+	results <- StatusLine{Line: "name,status"}
+	results <- StatusLine{Line: "a.example,NOERROR", Status: "NOERROR"}
+	results <- StatusLine{Line: "b.example,TIMEOUT", Status: "TIMEOUT"}
+	results <- StatusLine{Line: "c.example,NOERROR", Status: "NOERROR"}
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.NilError(t, h.WriteStatusResults(results, &wg))
+	wg.Wait()
+
+	data, err := os.ReadFile(filepath.Join(dir, "NOERROR.csv"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "name,status\na.example,NOERROR\nc.example,NOERROR\n")
+	data, err = os.ReadFile(filepath.Join(dir, "TIMEOUT.csv"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "name,status\nb.example,TIMEOUT\n")
+}
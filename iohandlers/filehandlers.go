@@ -16,12 +16,15 @@ package iohandlers
 
 import (
 	"bufio"
+	"context"
+	"io"
 	"os"
 	"sync"
 )
 
 type FileInputHandler struct {
-	filepath string
+	filepath    string
+	compression string
 }
 
 func NewFileInputHandler(filepath string) *FileInputHandler {
@@ -30,7 +33,18 @@ func NewFileInputHandler(filepath string) *FileInputHandler {
 	}
 }
 
+// SetCompression sets how the input file is compressed, one of the
+// COMPRESSION_ constants. By default it's picked from the file extension.
+func (h *FileInputHandler) SetCompression(compression string) {
+	h.compression = compression
+}
+
 func (h *FileInputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup) error {
+	return h.FeedChannelContext(context.Background(), in, wg)
+}
+
+// FeedChannelContext is FeedChannel stopping once ctx is done
+func (h *FileInputHandler) FeedChannelContext(ctx context.Context, in chan<- interface{}, wg *sync.WaitGroup) error {
 	defer close(in)
 	defer (*wg).Done()
 
@@ -43,19 +57,24 @@ func (h *FileInputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup
 		if err != nil {
 			return err
 		}
+		defer f.Close()
 	}
-	s := bufio.NewScanner(f)
-	for s.Scan() {
-		in <- s.Text()
+	compression, err := ResolveCompression(h.compression, h.filepath)
+	if err != nil {
+		return err
 	}
-	if err := s.Err(); err != nil {
+	r, err := NewDecompressingReader(f, compression)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer r.Close()
+	return feedLines(ctx, r, in)
 }
 
 type FileOutputHandler struct {
-	filepath string
+	filepath    string
+	append      bool
+	compression string
 }
 
 func NewFileOutputHandler(filepath string) *FileOutputHandler {
@@ -64,6 +83,21 @@ func NewFileOutputHandler(filepath string) *FileOutputHandler {
 	}
 }
 
+// NewAppendingFileOutputHandler returns a FileOutputHandler that adds to the
+// end of an existing file instead of truncating it
+func NewAppendingFileOutputHandler(filepath string) *FileOutputHandler {
+	return &FileOutputHandler{
+		filepath: filepath,
+		append:   true,
+	}
+}
+
+// SetCompression sets how the output file is compressed, one of the
+// COMPRESSION_ constants. By default it's picked from the file extension.
+func (h *FileOutputHandler) SetCompression(compression string) {
+	h.compression = compression
+}
+
 func (h *FileOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
 	defer (*wg).Done()
 
@@ -71,15 +105,55 @@ func (h *FileOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGro
 	if h.filepath == "" || h.filepath == "-" {
 		f = os.Stdout
 	} else {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if h.append {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
 		var err error
-		f, err = os.OpenFile(h.filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		f, err = os.OpenFile(h.filepath, flags, 0644)
 		if err != nil {
+			drain(results)
 			return err
 		}
 		defer f.Close()
 	}
+	compression, err := ResolveCompression(h.compression, h.filepath)
+	if err != nil {
+		drain(results)
+		return err
+	}
+	w, err := NewCompressingWriter(f, compression)
+	if err != nil {
+		drain(results)
+		return err
+	}
 	for n := range results {
-		f.WriteString(n + "\n")
+		io.WriteString(w, n+"\n")
+	}
+	return w.Close()
+}
+
+// feedLines sends the lines of r to in until r is exhausted or ctx is done
+func feedLines(ctx context.Context, r io.Reader, in chan<- interface{}) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		select {
+		case in <- s.Text():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return s.Err()
+}
+
+// drain discards the results of an output handler that can't write them, so
+// that the lookups don't block forever
+func drain(results <-chan string) {
+	for range results {
+	}
+}
+
+func drainStatus(results <-chan StatusLine) {
+	for range results {
 	}
-	return nil
 }
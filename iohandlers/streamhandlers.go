@@ -15,7 +15,7 @@
 package iohandlers
 
 import (
-	"bufio"
+	"context"
 	"io"
 	"sync"
 )
@@ -31,17 +31,15 @@ func NewStreamInputHandler(r io.Reader) *StreamInputHandler {
 }
 
 func (h *StreamInputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup) error {
+	return h.FeedChannelContext(context.Background(), in, wg)
+}
+
+// FeedChannelContext is FeedChannel stopping once ctx is done
+func (h *StreamInputHandler) FeedChannelContext(ctx context.Context, in chan<- interface{}, wg *sync.WaitGroup) error {
 	defer close(in)
 	defer (*wg).Done()
 
-	s := bufio.NewScanner(h.reader)
-	for s.Scan() {
-		in <- s.Text()
-	}
-	if err := s.Err(); err != nil {
-		return err
-	}
-	return nil
+	return feedLines(ctx, h.reader, in)
 }
 
 type StreamOutputHandler struct {
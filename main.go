@@ -23,6 +23,7 @@ import (
 	_ "github.com/zmap/zdns/pkg/mxlookup"
 	_ "github.com/zmap/zdns/pkg/nslookup"
 	_ "github.com/zmap/zdns/pkg/spf"
+	_ "github.com/zmap/zdns/pkg/sqlite"
 )
 
 func main() {
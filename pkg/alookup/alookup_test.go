@@ -15,6 +15,7 @@
 package alookup
 
 import (
+	"context"
 	"reflect"
 	"testing"
 
@@ -27,7 +28,7 @@ var mockResults = make(map[string]miekg.IpResult)
 
 var status = zdns.STATUS_NOERROR
 
-func (s *Lookup) DoTargetedLookup(l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (interface{}, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoTargetedLookup(ctx context.Context, l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (interface{}, []interface{}, zdns.Status, error) {
 	retv := miekg.IpResult{}
 	if res, ok := mockResults[name]; ok {
 		if lookupIpv4 {
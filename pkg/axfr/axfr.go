@@ -15,8 +15,11 @@
 package axfr
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -38,21 +41,61 @@ type Lookup struct {
 // This LookupClient is created to call the actual implementation of DoMiekgLookup
 type LookupClient struct{}
 
-func (lc LookupClient) ProtocolLookup(s *miekg.Lookup, q miekg.Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
-	return s.DoMiekgLookup(q, nameServer)
+func (lc LookupClient) ProtocolLookup(ctx context.Context, s *miekg.Lookup, q miekg.Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoMiekgLookup(ctx, q, nameServer)
 }
 
 type AXFRServerResult struct {
-	Server  string `json:"server" groups:"short,normal,long,trace"`
-	Status  zdns.Status
-	Error   string        `json:"error,omitempty" groups:"short,normal,long,trace"`
-	Records []interface{} `json:"records,omitempty" groups:"short,normal,long,trace"`
+	Server  string      `json:"server" groups:"short,normal,long,trace"`
+	Status  zdns.Status `json:"status" groups:"short,normal,long,trace"`
+	Error   string      `json:"error,omitempty" groups:"short,normal,long,trace"`
+	Records []miekg.RR  `json:"records,omitempty" groups:"short,normal,long,trace"`
+}
+
+// InterfaceTypes returns the types of the records of r, for the JSON Schema
+// of its output
+func (r AXFRServerResult) InterfaceTypes(field string) []reflect.Type {
+	if field == "Records" {
+		return miekg.AnswerTypes
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes every record of r into its answer struct
+func (r *AXFRServerResult) UnmarshalJSON(data []byte) error {
+	type result AXFRServerResult
+	var raw struct {
+		result
+		Records []json.RawMessage `json:"records"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = AXFRServerResult(raw.result)
+	var err error
+	r.Records, err = miekg.UnmarshalAnswers(raw.Records)
+	return err
 }
 
 type AXFRResult struct {
 	Servers []AXFRServerResult `json:"servers,omitempty" groups:"short,normal,long,trace"`
 }
 
+// FlatRecords returns the records transferred from every server, with the
+// server as their resolver
+func (r AXFRResult) FlatRecords(_ string) []zdns.FlatRecord {
+	var records []zdns.FlatRecord
+	for _, server := range r.Servers {
+		for _, rr := range server.Records {
+			if rec, ok := miekg.FlatRecord("answer", rr); ok {
+				rec.Resolver = server.Server
+				records = append(records, rec)
+			}
+		}
+	}
+	return records
+}
+
 func dotName(name string) string {
 	return strings.Join([]string{name, "."}, "")
 }
@@ -105,10 +148,14 @@ func (s *Lookup) DoAXFR(name, server string) AXFRServerResult {
 }
 
 func (s *Lookup) DoLookup(name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoLookupContext(context.Background(), name, nameServer)
+}
+
+func (s *Lookup) DoLookupContext(ctx context.Context, name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
 	var retv AXFRResult
 	l := LookupClient{}
 	if nameServer == "" {
-		parsedNS, trace, status, err := s.DoNSLookup(l, name, true, false, nameServer)
+		parsedNS, trace, status, err := s.DoNSLookup(ctx, l, name, true, false, nameServer)
 		if status != zdns.STATUS_NOERROR {
 			return nil, trace, status, err
 		}
@@ -144,6 +191,10 @@ type GlobalLookupFactory struct {
 	BlMu          sync.Mutex
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(AXFRResult{})
+}
+
 // Command-line Help Documentation. This is the descriptive text what is
 // returned when you run zdns module --help
 func (s *GlobalLookupFactory) Help() string {
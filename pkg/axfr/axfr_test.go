@@ -15,10 +15,14 @@
 package axfr
 
 import (
+	"context"
+	"encoding/json"
 	"net"
 	"reflect"
 	"testing"
 
+	"github.com/hashicorp/go-version"
+	"github.com/liip/sheriff"
 	"github.com/zmap/dns"
 	"github.com/zmap/zdns/pkg/miekg"
 	"github.com/zmap/zdns/pkg/nslookup"
@@ -73,7 +77,7 @@ var nsRecords = make(map[string]nslookup.Result)
 var nsStatus = zdns.STATUS_NOERROR
 
 // Mock the actual NS lookup.
-func (s *Lookup) DoNSLookup(l LookupClient, name string, lookupIpv4 bool, lookupIpv6 bool, nameServer string) (nslookup.Result, zdns.Trace, zdns.Status, error) {
+func (s *Lookup) DoNSLookup(ctx context.Context, l LookupClient, name string, lookupIpv4 bool, lookupIpv6 bool, nameServer string) (nslookup.Result, zdns.Trace, zdns.Status, error) {
 	if res, ok := nsRecords[name]; ok {
 		return res, nil, nsStatus, nil
 	} else {
@@ -206,8 +210,8 @@ func TestLookupSingleNS(t *testing.T) {
 		naptr,
 	}
 
-	expectedServersMap := make(map[string][]interface{})
-	expectedServersMap[ip1] = make([]interface{}, len(axfrRecords[hostPort1]))
+	expectedServersMap := make(map[string][]miekg.RR)
+	expectedServersMap[ip1] = make([]miekg.RR, len(axfrRecords[hostPort1]))
 	for i, rec := range axfrRecords[hostPort1] {
 		expectedServersMap[ip1][i] = miekg.ParseAnswer(rec)
 	}
@@ -278,12 +282,12 @@ func TestLookupTwoNS(t *testing.T) {
 		ipv6,
 	}
 
-	expectedServersMap := make(map[string][]interface{})
-	expectedServersMap[ip1] = make([]interface{}, len(axfrRecords[hostPort1]))
+	expectedServersMap := make(map[string][]miekg.RR)
+	expectedServersMap[ip1] = make([]miekg.RR, len(axfrRecords[hostPort1]))
 	for i, rec := range axfrRecords[hostPort1] {
 		expectedServersMap[ip1][i] = miekg.ParseAnswer(rec)
 	}
-	expectedServersMap[ip2] = make([]interface{}, len(axfrRecords[hostPort2]))
+	expectedServersMap[ip2] = make([]miekg.RR, len(axfrRecords[hostPort2]))
 	for i, rec := range axfrRecords[hostPort2] {
 		expectedServersMap[ip2][i] = miekg.ParseAnswer(rec)
 	}
@@ -314,8 +318,8 @@ func TestFailureInTransfer(t *testing.T) {
 
 	transferError = "Error in transfer."
 
-	expectedServersMap := make(map[string][]interface{})
-	expectedServersMap[ip1] = make([]interface{}, 0)
+	expectedServersMap := make(map[string][]miekg.RR)
+	expectedServersMap[ip1] = make([]miekg.RR, 0)
 
 	res, _, status, _ := l.DoLookup("example.com", "")
 	// The overall status should be no error
@@ -347,8 +351,8 @@ func TestErrorInEnvelope(t *testing.T) {
 
 	envelopeError = "Error in envelope."
 
-	expectedServersMap := make(map[string][]interface{})
-	expectedServersMap[ip1] = make([]interface{}, 0)
+	expectedServersMap := make(map[string][]miekg.RR)
+	expectedServersMap[ip1] = make([]miekg.RR, 0)
 
 	res, _, status, _ := l.DoLookup("example.com", "")
 	// The overall status should be no error
@@ -404,7 +408,7 @@ func TestErrorInNsLookup(t *testing.T) {
 	assert.Equal(t, res, nil)
 }
 
-func verifyResult(t *testing.T, servers []AXFRServerResult, expectedServersMap map[string][]interface{}) {
+func verifyResult(t *testing.T, servers []AXFRServerResult, expectedServersMap map[string][]miekg.RR) {
 	serversLength := len(servers)
 	expectedServersLength := len(expectedServersMap)
 
@@ -423,3 +427,24 @@ func verifyResult(t *testing.T, servers []AXFRServerResult, expectedServersMap m
 		}
 	}
 }
+
+// Test that the status of every server is written, by sheriff as by
+// zdns.ResultEncoder, whatever was written before it
+func TestResultStatus(t *testing.T) {
+	res := &zdns.Result{Name: "example.com", Status: "NOERROR", Data: AXFRResult{Servers: []AXFRServerResult{
+		{Server: "192.0.2.3", Status: zdns.STATUS_ERROR, Error: "Error in transfer."},
+		{Server: "192.0.2.4", Status: zdns.STATUS_NOERROR},
+	}}}
+	for _, groups := range [][]string{{"short"}, {"normal", ""}, {"trace"}} {
+		out, err := zdns.NewResultEncoder(groups).Encode(res)
+		assert.NilError(t, err)
+		assert.Equal(t, string(out), `{"data":{"servers":[{"error":"Error in transfer.","server":"192.0.2.3","status":"ERROR"},{"server":"192.0.2.4","status":"NOERROR"}]},"name":"example.com","status":"NOERROR"}`)
+
+		v, _ := version.NewVersion("0.0.0")
+		data, err := sheriff.Marshal(&sheriff.Options{Groups: groups, ApiVersion: v}, res)
+		assert.NilError(t, err)
+		expected, err := json.Marshal(data)
+		assert.NilError(t, err)
+		assert.Equal(t, string(out), string(expected))
+	}
+}
@@ -15,9 +15,11 @@
 package bindversion
 
 import (
+	"context"
 	"github.com/zmap/dns"
 	"github.com/zmap/zdns/pkg/miekg"
 	"github.com/zmap/zdns/pkg/zdns"
+	"reflect"
 )
 
 // result to be returned by scan of host
@@ -26,6 +28,13 @@ type Result struct {
 	Resolver    string `json:"resolver" groups:"resolver,short,normal,long,trace"`
 }
 
+func (r Result) FlatRecords(_ string) []zdns.FlatRecord {
+	if r.BindVersion == "" {
+		return nil
+	}
+	return []zdns.FlatRecord{{Section: "answer", Name: "VERSION.BIND", Type: "TXT", Class: "CH", Answer: r.BindVersion, Rdata: miekg.ZoneText(r.BindVersion), Resolver: r.Resolver}}
+}
+
 // Per Connection Lookup ======================================================
 type Lookup struct {
 	Factory *RoutineLookupFactory
@@ -33,7 +42,11 @@ type Lookup struct {
 }
 
 func (s *Lookup) DoLookup(_, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
-	innerRes, trace, status, err := s.DoMiekgLookup(miekg.Question{Name: "VERSION.BIND", Type: s.DNSType, Class: s.DNSClass}, nameServer)
+	return s.DoLookupContext(context.Background(), "", nameServer)
+}
+
+func (s *Lookup) DoLookupContext(ctx context.Context, _, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	innerRes, trace, status, err := s.DoMiekgLookup(ctx, miekg.Question{Name: "VERSION.BIND", Type: s.DNSType, Class: s.DNSClass}, nameServer)
 	resString, resStatus, err := s.CheckTxtRecords(innerRes, status, err)
 	res := Result{BindVersion: resString}
 	return res, trace, resStatus, err
@@ -57,6 +70,10 @@ type GlobalLookupFactory struct {
 	miekg.GlobalLookupFactory
 }
 
+func (glf *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 func (glf *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
 	glf.GlobalLookupFactory.Initialize(c)
 	c.Class = dns.ClassCHAOS
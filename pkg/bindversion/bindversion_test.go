@@ -15,6 +15,7 @@
 package bindversion
 
 import (
+	"context"
 	"testing"
 
 	"github.com/zmap/dns"
@@ -31,7 +32,7 @@ type QueryRecord struct {
 var mockResults = make(map[string]miekg.Result)
 var queries []QueryRecord
 
-func (s *Lookup) DoMiekgLookup(question miekg.Question, nameServer string) (miekg.Result, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoMiekgLookup(ctx context.Context, question miekg.Question, nameServer string) (miekg.Result, []interface{}, zdns.Status, error) {
 	queries = append(queries, QueryRecord{Question: question, NameServer: nameServer})
 	if res, ok := mockResults[question.Name]; ok {
 		return res, nil, zdns.STATUS_NOERROR, nil
@@ -61,7 +62,7 @@ func InitTest() (*zdns.GlobalConf, *GlobalLookupFactory, *RoutineLookupFactory,
 func TestBindVersionLookup_Valid_1(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["VERSION.BIND"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "VERSION.BIND", Answer: "Nominum Vantio 5.4.1.0", Class: "CHAOS"}},
 	}
 	res, _, status, _ := l.DoLookup("VERSION.BIND", "1.2.3.4")
@@ -77,7 +78,7 @@ func TestBindVersionLookup_Valid_1(t *testing.T) {
 func TestBindVersionLookup_NotValid_1(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["VERSION.BIND"] = miekg.Result{
-		Answers: []interface{}{},
+		Answers: []miekg.RR{},
 	}
 	res, _, status, _ := l.DoLookup("VERSION.BIND", "1.2.3.4")
 	assert.Equal(t, queries[0].Class, uint16(dns.ClassCHAOS))
@@ -15,6 +15,7 @@
 package dmarc
 
 import (
+	"context"
 	"testing"
 
 	"github.com/zmap/dns"
@@ -31,7 +32,7 @@ type QueryRecord struct {
 var mockResults = make(map[string]miekg.Result)
 var queries []QueryRecord
 
-func (s *Lookup) DoMiekgLookup(question miekg.Question, nameServer string) (miekg.Result, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoMiekgLookup(ctx context.Context, question miekg.Question, nameServer string) (miekg.Result, []interface{}, zdns.Status, error) {
 	queries = append(queries, QueryRecord{Question: question, NameServer: nameServer})
 	if res, ok := mockResults[question.Name]; ok {
 		return res, nil, zdns.STATUS_NOERROR, nil
@@ -63,7 +64,7 @@ func InitTest() (*zdns.GlobalConf, *GlobalLookupFactory, *RoutineLookupFactory,
 func TestDmarcLookup_Valid_1(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["_dmarc.zdns-testing.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "some TXT record"},
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "v=DMARC1; p=none; rua=mailto:postmaster@censys.io"}},
 	}
@@ -80,7 +81,7 @@ func TestDmarcLookup_Valid_1(t *testing.T) {
 func TestDmarcLookup_Valid_2(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["_dmarc.zdns-testing.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "some TXT record"},
 			// Capital V in V=DMARC1; should pass
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "V=DMARC1; p=none; rua=mailto:postmaster@censys.io"}},
@@ -98,7 +99,7 @@ func TestDmarcLookup_Valid_2(t *testing.T) {
 func TestDmarcLookup_Valid_3(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["_dmarc.zdns-testing.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "some TXT record"},
 			// spaces and tabs should pass
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "v\t\t\t=\t\t  DMARC1\t\t; p=none; rua=mailto:postmaster@censys.io"}},
@@ -116,7 +117,7 @@ func TestDmarcLookup_Valid_3(t *testing.T) {
 func TestDmarcLookup_NotValid_1(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["_dmarc.zdns-testing.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "some TXT record"},
 			// spaces before "v" should not be accepted
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "\t\t   v   =DMARC1; p=none; rua=mailto:postmaster@censys.io"}},
@@ -134,7 +135,7 @@ func TestDmarcLookup_NotValid_1(t *testing.T) {
 func TestDmarcLookup_NotValid_2(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["_dmarc.zdns-testing.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "some TXT record"},
 			// DMARC1 should be capital letters
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "v=DMARc1; p=none; rua=mailto:postmaster@censys.io"}},
@@ -152,7 +153,7 @@ func TestDmarcLookup_NotValid_2(t *testing.T) {
 func TestDmarcLookup_NotValid_3(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["_dmarc.zdns-testing.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "some TXT record"},
 			// ; has to be present after DMARC1
 			miekg.Answer{Name: "_dmarc.zdns-testing.com", Answer: "v=DMARc1. p=none; rua=mailto:postmaster@censys.io"}},
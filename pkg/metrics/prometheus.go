@@ -0,0 +1,160 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package metrics exports the measurements of zdns.Metrics to Prometheus
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/zmap/zdns/pkg/zdns"
+)
+
+const namespace = "zdns"
+
+// OtherNameServers is the nameserver label of queries to name servers that
+// weren't configured
+const OtherNameServers = "other"
+
+// Prometheus is a zdns.Metrics that records into Prometheus collectors
+type Prometheus struct {
+	queries        *prometheus.CounterVec
+	responses      *prometheus.CounterVec
+	retries        *prometheus.CounterVec
+	queryDuration  *prometheus.HistogramVec
+	lookups        *prometheus.CounterVec
+	lookupDuration *prometheus.HistogramVec
+	cacheHits      prometheus.Counter
+	cacheMisses    prometheus.Counter
+	cacheEvictions prometheus.Counter
+	udpPoolIdle    prometheus.Gauge
+	udpPoolOpen    prometheus.Gauge
+
+	nameServers map[string]bool
+}
+
+// NewPrometheus creates the zdns collectors and registers them with reg.
+// Queries are labelled with their name server if it's one of nameServers,
+// and with OtherNameServers if not. Otherwise iterative lookups, which query
+// whichever authoritative name servers they're referred to, would make a
+// label value of every name server they come across.
+func NewPrometheus(reg prometheus.Registerer, nameServers []string) (*Prometheus, error) {
+	p := &Prometheus{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "queries_total",
+			Help:      "Queries sent, including retries.",
+		}, []string{"protocol", "nameserver"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "responses_total",
+			Help:      "Outcomes of queries sent. rcode is empty if no response was received.",
+		}, []string{"rcode", "status"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "query_retries_total",
+			Help:      "Queries sent again after the previous try timed out.",
+		}, []string{"nameserver"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Time from sending a query until its response or timeout.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"protocol"}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "lookups_total",
+			Help:      "Lookups finished, by module and status.",
+		}, []string{"module", "status"}),
+		lookupDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "lookup_duration_seconds",
+			Help:      "Time taken by a lookup, including retries and iteration.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, []string{"module"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Iterative cache lookups answered from the cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Iterative cache lookups not answered from the cache.",
+		}),
+		cacheEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_evictions_total",
+			Help:      "Entries evicted from the iterative cache to make room.",
+		}),
+		udpPoolIdle: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "udp_pool_idle_conns",
+			Help:      "Idle sockets in the UDP socket pool.",
+		}),
+		udpPoolOpen: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "udp_pool_open_conns",
+			Help:      "Open sockets of the UDP socket pool, idle or in use.",
+		}),
+	}
+	p.nameServers = make(map[string]bool, len(nameServers))
+	for _, ns := range nameServers {
+		p.nameServers[ns] = true
+	}
+	for _, c := range []prometheus.Collector{
+		p.queries, p.responses, p.retries, p.queryDuration, p.lookups, p.lookupDuration,
+		p.cacheHits, p.cacheMisses, p.cacheEvictions, p.udpPoolIdle, p.udpPoolOpen,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Prometheus) QueryDone(protocol, nameServer string, try int, rcode string, status zdns.Status, rtt time.Duration) {
+	if !p.nameServers[nameServer] {
+		nameServer = OtherNameServers
+	}
+	p.queries.WithLabelValues(protocol, nameServer).Inc()
+	p.responses.WithLabelValues(rcode, string(status)).Inc()
+	if try > 0 {
+		p.retries.WithLabelValues(nameServer).Inc()
+	}
+	p.queryDuration.WithLabelValues(protocol).Observe(rtt.Seconds())
+}
+
+func (p *Prometheus) LookupDone(module string, status zdns.Status, duration time.Duration) {
+	p.lookups.WithLabelValues(module, string(status)).Inc()
+	p.lookupDuration.WithLabelValues(module).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) CacheLookup(hit bool) {
+	if hit {
+		p.cacheHits.Inc()
+	} else {
+		p.cacheMisses.Inc()
+	}
+}
+
+func (p *Prometheus) CacheEviction() {
+	p.cacheEvictions.Inc()
+}
+
+func (p *Prometheus) UDPPoolConns(idle, open int) {
+	p.udpPoolIdle.Set(float64(idle))
+	p.udpPoolOpen.Set(float64(open))
+}
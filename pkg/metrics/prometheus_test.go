@@ -0,0 +1,61 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zmap/zdns/pkg/zdns"
+	"gotest.tools/v3/assert"
+)
+
+var _ zdns.Metrics = (*Prometheus)(nil)
+
+func TestPrometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p, err := NewPrometheus(reg, []string{"192.0.2.53:53"})
+	assert.NilError(t, err)
+
+	p.QueryDone("udp", "192.0.2.53:53", 0, "", zdns.STATUS_TIMEOUT, time.Second)
+	p.QueryDone("udp", "192.0.2.53:53", 1, "NOERROR", zdns.STATUS_NOERROR, 10*time.Millisecond)
+	p.QueryDone("udp", "198.51.100.53:53", 0, "NOERROR", zdns.STATUS_NOERROR, 10*time.Millisecond)
+	p.QueryDone("udp", "203.0.113.53:53", 0, "NOERROR", zdns.STATUS_NOERROR, 10*time.Millisecond)
+	p.LookupDone("A", zdns.STATUS_NOERROR, time.Second)
+	p.CacheLookup(true)
+	p.CacheLookup(false)
+	p.CacheLookup(false)
+	p.CacheEviction()
+	p.UDPPoolConns(3, 10)
+
+	assert.Equal(t, testutil.ToFloat64(p.queries.WithLabelValues("udp", "192.0.2.53:53")), 2.0)
+	assert.Equal(t, testutil.ToFloat64(p.responses.WithLabelValues("", "TIMEOUT")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(p.queries.WithLabelValues("udp", OtherNameServers)), 2.0)
+	assert.Equal(t, testutil.CollectAndCount(p.queries), 2)
+	assert.Equal(t, testutil.ToFloat64(p.responses.WithLabelValues("NOERROR", "NOERROR")), 3.0)
+	assert.Equal(t, testutil.ToFloat64(p.retries.WithLabelValues("192.0.2.53:53")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(p.lookups.WithLabelValues("A", "NOERROR")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(p.cacheHits), 1.0)
+	assert.Equal(t, testutil.ToFloat64(p.cacheMisses), 2.0)
+	assert.Equal(t, testutil.ToFloat64(p.cacheEvictions), 1.0)
+	assert.Equal(t, testutil.ToFloat64(p.udpPoolIdle), 3.0)
+	assert.Equal(t, testutil.ToFloat64(p.udpPoolOpen), 10.0)
+
+	// registering twice with the same registry must fail rather than panic
+	_, err = NewPrometheus(reg, nil)
+	assert.Assert(t, err != nil)
+}
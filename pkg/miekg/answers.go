@@ -18,6 +18,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"reflect"
 	"strconv"
 	"strings"
 
@@ -42,6 +43,11 @@ type AFSDBAnswer struct {
 	Hostname string `json:"hostname" groups:"short,normal,long,trace"`
 }
 
+type APLAnswer struct {
+	Answer
+	Prefixes []string `json:"prefixes" groups:"short,normal,long,trace"`
+}
+
 type CAAAnswer struct {
 	Answer
 	Tag   string `json:"tag" groups:"short,normal,long,trace"`
@@ -57,6 +63,13 @@ type CERTAnswer struct {
 	Certificate string `json:"certificate" groups:"short,normal,long,trace"`
 }
 
+type CSYNCAnswer struct {
+	Answer
+	Serial     uint32 `json:"serial" groups:"short,normal,long,trace"`
+	Flags      uint16 `json:"flags" groups:"short,normal,long,trace"`
+	TypeBitMap string `json:"type_bit_map" groups:"short,normal,long,trace"`
+}
+
 type DNSKEYAnswer struct {
 	Answer
 	Flags     uint16 `json:"flags" groups:"short,normal,long,trace"`
@@ -241,6 +254,19 @@ type TLSAAnswer struct {
 	Certificate  string `json:"certificate" groups:"short,normal,long,trace"`
 }
 
+type TSIGAnswer struct {
+	Answer
+	Algorithm  string `json:"algorithm" groups:"short,normal,long,trace"`
+	TimeSigned uint64 `json:"time_signed" groups:"short,normal,long,trace"`
+	Fudge      uint16 `json:"fudge" groups:"short,normal,long,trace"`
+	MACSize    uint16 `json:"mac_size" groups:"short,normal,long,trace"`
+	MAC        string `json:"mac" groups:"short,normal,long,trace"`
+	OrigId     uint16 `json:"original_id" groups:"short,normal,long,trace"`
+	Error      uint16 `json:"error" groups:"short,normal,long,trace"`
+	OtherLen   uint16 `json:"other_len" groups:"short,normal,long,trace"`
+	OtherData  string `json:"other_data" groups:"short,normal,long,trace"`
+}
+
 type TALINKAnswer struct {
 	Answer
 	PreviousName string `json:"previous_name" groups:"short,normal,long,trace"`
@@ -249,11 +275,19 @@ type TALINKAnswer struct {
 
 type URIAnswer struct {
 	Answer
-	Priority uint16 `json:"previous_name" groups:"short,normal,long,trace"`
+	Priority uint16 `json:"priority" groups:"short,normal,long,trace"`
 	Weight   uint16 `json:"weight" groups:"short,normal,long,trace"`
 	Target   string `json:"target" groups:"short,normal,long,trace"`
 }
 
+type ZONEMDAnswer struct {
+	Answer
+	Serial uint32 `json:"serial" groups:"short,normal,long,trace"`
+	Scheme uint8  `json:"scheme" groups:"short,normal,long,trace"`
+	Hash   uint8  `json:"hash" groups:"short,normal,long,trace"`
+	Digest string `json:"digest" groups:"short,normal,long,trace"`
+}
+
 // copy-paste from zmap/dns/types.go >>>>>
 //
 // Copyright (c) 2009 The Go Authors.
@@ -402,6 +436,23 @@ func makeBitString(bm []uint16) string {
 	return retv
 }
 
+// certTypeString and algorithmString return the mnemonic of the type and
+// algorithm of a CERT record, or their number if they have none, as they are
+// presented
+func certTypeString(t uint16) string {
+	if s, ok := dns.CertTypeToString[t]; ok {
+		return s
+	}
+	return strconv.Itoa(int(t))
+}
+
+func algorithmString(alg uint8) string {
+	if s, ok := dns.AlgorithmToString[alg]; ok {
+		return s
+	}
+	return strconv.Itoa(int(alg))
+}
+
 func makeBaseAnswer(hdr *dns.RR_Header, answer string) Answer {
 	return Answer{
 		Ttl:     hdr.Ttl,
@@ -413,6 +464,40 @@ func makeBaseAnswer(hdr *dns.RR_Header, answer string) Answer {
 		Answer:  answer}
 }
 
+// makeAPLAnswer writes the prefixes of an APL record as they are presented,
+// e.g. "!1:192.0.2.0/24"
+func makeAPLAnswer(cAns *dns.APL) APLAnswer {
+	prefixes := make([]string, len(cAns.Prefixes))
+	for i, p := range cAns.Prefixes {
+		family := "1:"
+		if len(p.Network.IP) == net.IPv6len {
+			family = "2:"
+		}
+		ones, _ := p.Network.Mask.Size()
+		prefixes[i] = family + p.Network.IP.String() + "/" + strconv.Itoa(ones)
+		if p.Negation {
+			prefixes[i] = "!" + prefixes[i]
+		}
+	}
+	return APLAnswer{
+		Answer:   makeBaseAnswer(&cAns.Hdr, ""),
+		Prefixes: prefixes,
+	}
+}
+
+// makeGenericAnswer writes the data of records of types ParseAnswer doesn't
+// know in the generic format of RFC 3597, e.g. "\# 3 616263"
+func makeGenericAnswer(ans dns.RR) Answer {
+	generic, ok := ans.(*dns.RFC3597)
+	if !ok {
+		generic = new(dns.RFC3597)
+		if err := generic.ToRFC3597(ans); err != nil {
+			return makeBaseAnswer(ans.Header(), "")
+		}
+	}
+	return makeBaseAnswer(ans.Header(), fmt.Sprintf("\\# %d %s", len(generic.Rdata)/2, generic.Rdata))
+}
+
 func makeSVCBAnswer(cAns *dns.SVCB) SVCBAnswer {
 	var params map[string]interface{}
 	if len(cAns.Value) > 0 {
@@ -542,7 +627,7 @@ func makeEDNSAnswer(cAns *dns.OPT) EDNSAnswer {
 }
 
 // TODO 使用具体的类型
-func ParseAnswer(ans dns.RR) interface{} {
+func ParseAnswer(ans dns.RR) RR {
 	switch cAns := ans.(type) {
 	// Prioritize common types in expected order
 	case *dns.A:
@@ -556,7 +641,7 @@ func ParseAnswer(ans dns.RR) interface{} {
 				ip = "::ffff:" + ip
 			} else {
 				v4compat := true
-				for _, o := range cAns.AAAA[:11] {
+				for _, o := range cAns.AAAA[:12] {
 					if o != 0 {
 						v4compat = false
 						break
@@ -628,6 +713,22 @@ func ParseAnswer(ans dns.RR) interface{} {
 			DigestType: cAns.DigestType,
 			Digest:     cAns.Digest,
 		}
+	case *dns.DLV:
+		return DSAnswer{
+			Answer:     makeBaseAnswer(&cAns.Hdr, ""),
+			KeyTag:     cAns.KeyTag,
+			Algorithm:  cAns.Algorithm,
+			DigestType: cAns.DigestType,
+			Digest:     cAns.Digest,
+		}
+	case *dns.TA:
+		return DSAnswer{
+			Answer:     makeBaseAnswer(&cAns.Hdr, ""),
+			KeyTag:     cAns.KeyTag,
+			Algorithm:  cAns.Algorithm,
+			DigestType: cAns.DigestType,
+			Digest:     cAns.Digest,
+		}
 	case *dns.RRSIG:
 		return RRSIGAnswer{
 			Answer:      makeBaseAnswer(&cAns.Hdr, ""),
@@ -656,6 +757,8 @@ func ParseAnswer(ans dns.RR) interface{} {
 		return makeBaseAnswer(&cAns.Hdr, cAns.Mf)
 	case *dns.MD:
 		return makeBaseAnswer(&cAns.Hdr, cAns.Md)
+	case *dns.MR:
+		return makeBaseAnswer(&cAns.Hdr, cAns.Mr)
 	case *dns.NSAPPTR:
 		return makeBaseAnswer(&cAns.Hdr, cAns.Ptr)
 	case *dns.NIMLOC:
@@ -768,6 +871,22 @@ func ParseAnswer(ans dns.RR) interface{} {
 			Algorithm: cAns.Algorithm,
 			PublicKey: cAns.PublicKey,
 		}
+	case *dns.KEY:
+		return DNSKEYAnswer{
+			Answer:    makeBaseAnswer(&cAns.Hdr, ""),
+			Flags:     cAns.Flags,
+			Protocol:  cAns.Protocol,
+			Algorithm: cAns.Algorithm,
+			PublicKey: cAns.PublicKey,
+		}
+	case *dns.RKEY:
+		return DNSKEYAnswer{
+			Answer:    makeBaseAnswer(&cAns.Hdr, ""),
+			Flags:     cAns.Flags,
+			Protocol:  cAns.Protocol,
+			Algorithm: cAns.Algorithm,
+			PublicKey: cAns.PublicKey,
+		}
 	case *dns.AFSDB:
 		return AFSDBAnswer{
 			Answer:   makeBaseAnswer(&cAns.Hdr, ""),
@@ -790,9 +909,9 @@ func ParseAnswer(ans dns.RR) interface{} {
 	case *dns.CERT:
 		return CERTAnswer{
 			Answer:      makeBaseAnswer(&cAns.Hdr, ""),
-			Type:        dns.CertTypeToString[cAns.Type],
+			Type:        certTypeString(cAns.Type),
 			KeyTag:      cAns.KeyTag,
-			Algorithm:   dns.AlgorithmToString[cAns.Algorithm],
+			Algorithm:   algorithmString(cAns.Algorithm),
 			Certificate: cAns.Certificate,
 		}
 	case *dns.PX:
@@ -888,19 +1007,88 @@ func ParseAnswer(ans dns.RR) interface{} {
 		return makeSVCBAnswer(cAns)
 	case *dns.OPT:
 		return makeEDNSAnswer(cAns)
-	default:
-		return struct {
-			Type     string `json:"type"`
-			rrType   uint16
-			Class    string `json:"class"`
-			rrClass  uint16
-			Unparsed dns.RR `json:"-"`
-		}{
-			Type:     dns.Type(ans.Header().Rrtype).String(),
-			rrType:   ans.Header().Rrtype,
-			Class:    dns.Class(ans.Header().Class).String(),
-			rrClass:  ans.Header().Class,
-			Unparsed: ans,
+	case *dns.RP:
+		return RPAnswer{
+			Answer: makeBaseAnswer(&cAns.Hdr, ""),
+			Mbox:   cAns.Mbox,
+			Txt:    cAns.Txt,
+		}
+	case *dns.URI:
+		return URIAnswer{
+			Answer:   makeBaseAnswer(&cAns.Hdr, ""),
+			Priority: cAns.Priority,
+			Weight:   cAns.Weight,
+			Target:   cAns.Target,
+		}
+	case *dns.APL:
+		return makeAPLAnswer(cAns)
+	case *dns.CSYNC:
+		return CSYNCAnswer{
+			Answer:     makeBaseAnswer(&cAns.Hdr, ""),
+			Serial:     cAns.Serial,
+			Flags:      cAns.Flags,
+			TypeBitMap: makeBitString(cAns.TypeBitMap),
+		}
+	case *dns.ZONEMD:
+		return ZONEMDAnswer{
+			Answer: makeBaseAnswer(&cAns.Hdr, ""),
+			Serial: cAns.Serial,
+			Scheme: cAns.Scheme,
+			Hash:   cAns.Hash,
+			Digest: cAns.Digest,
+		}
+	case *dns.TSIG:
+		return TSIGAnswer{
+			Answer:     makeBaseAnswer(&cAns.Hdr, ""),
+			Algorithm:  cAns.Algorithm,
+			TimeSigned: cAns.TimeSigned,
+			Fudge:      cAns.Fudge,
+			MACSize:    cAns.MACSize,
+			MAC:        cAns.MAC,
+			OrigId:     cAns.OrigId,
+			Error:      cAns.Error,
+			OtherLen:   cAns.OtherLen,
+			OtherData:  cAns.OtherData,
 		}
+	case *dns.ANY:
+		return makeBaseAnswer(&cAns.Hdr, "")
+	default:
+		return makeGenericAnswer(ans)
 	}
 }
+
+// AnswerTypes are the types of the records returned by ParseAnswer
+var AnswerTypes = []reflect.Type{
+	reflect.TypeOf(Answer{}),
+	reflect.TypeOf(AFSDBAnswer{}),
+	reflect.TypeOf(APLAnswer{}),
+	reflect.TypeOf(CAAAnswer{}),
+	reflect.TypeOf(CERTAnswer{}),
+	reflect.TypeOf(CSYNCAnswer{}),
+	reflect.TypeOf(DNSKEYAnswer{}),
+	reflect.TypeOf(DSAnswer{}),
+	reflect.TypeOf(EDNSAnswer{}),
+	reflect.TypeOf(GPOSAnswer{}),
+	reflect.TypeOf(HINFOAnswer{}),
+	reflect.TypeOf(HIPAnswer{}),
+	reflect.TypeOf(LOCAnswer{}),
+	reflect.TypeOf(MINFOAnswer{}),
+	reflect.TypeOf(NAPTRAnswer{}),
+	reflect.TypeOf(NSECAnswer{}),
+	reflect.TypeOf(NSEC3Answer{}),
+	reflect.TypeOf(PrefAnswer{}),
+	reflect.TypeOf(PXAnswer{}),
+	reflect.TypeOf(RPAnswer{}),
+	reflect.TypeOf(RRSIGAnswer{}),
+	reflect.TypeOf(SMIMEAAnswer{}),
+	reflect.TypeOf(SOAAnswer{}),
+	reflect.TypeOf(SRVAnswer{}),
+	reflect.TypeOf(SSHFPAnswer{}),
+	reflect.TypeOf(SVCBAnswer{}),
+	reflect.TypeOf(TALINKAnswer{}),
+	reflect.TypeOf(TKEYAnswer{}),
+	reflect.TypeOf(TLSAAnswer{}),
+	reflect.TypeOf(TSIGAnswer{}),
+	reflect.TypeOf(URIAnswer{}),
+	reflect.TypeOf(ZONEMDAnswer{}),
+}
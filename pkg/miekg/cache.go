@@ -26,7 +26,7 @@ import (
 type IsCached bool
 
 type TimedAnswer struct {
-	Answer    interface{}
+	Answer    RR
 	ExpiresAt time.Time
 }
 
@@ -50,13 +50,8 @@ func (s *Cache) VerboseLog(depth int, threadID int, args ...interface{}) {
 	log.Debug(makeVerbosePrefix(depth, threadID), args)
 }
 
-func (s *Cache) AddCachedAnswer(answer interface{}, depth int, threadID int) {
-	a, ok := answer.(Answer)
-	if !ok {
-		// we can't cache this entry because we have no idea what to name it
-		return
-	}
-	q := questionFromAnswer(a)
+func (s *Cache) AddCachedAnswer(answer RR, depth int, threadID int) {
+	q := questionFromAnswer(answer)
 
 	// only cache records that can help prevent future iteration: A(AAA), NS, (C|D)NAME.
 	// This will prevent some entries that will never help future iteration (e.g., PTR)
@@ -67,7 +62,7 @@ func (s *Cache) AddCachedAnswer(answer interface{}, depth int, threadID int) {
 	if !(q.Type == dns.TypeA || q.Type == dns.TypeAAAA || q.Type == dns.TypeNS || q.Type == dns.TypeDNAME || q.Type == dns.TypeCNAME) {
 		return
 	}
-	expiresAt := time.Now().Add(time.Duration(a.Ttl) * time.Second)
+	expiresAt := time.Now().Add(time.Duration(answer.TTL()) * time.Second)
 	s.IterativeCache.Lock(q)
 	// don't bother to move this to the top of the linked list. we're going
 	// to add this record back in momentarily and that will take care of this
@@ -84,7 +79,7 @@ func (s *Cache) AddCachedAnswer(answer interface{}, depth int, threadID int) {
 	ta := TimedAnswer{
 		Answer:    answer,
 		ExpiresAt: expiresAt}
-	ca.Answers[a] = ta
+	ca.Answers[answer] = ta
 	s.IterativeCache.Add(q, ca)
 	s.VerboseGlobalLog(depth+1, threadID, "Add cached answer ", q, " ", ca)
 	s.IterativeCache.Unlock(q)
@@ -100,9 +95,9 @@ func (s *Cache) GetCachedResult(q Question, isAuthCheck bool, depth int, threadI
 		s.IterativeCache.Unlock(q)
 		return retv, false
 	}
-	retv.Authorities = make([]interface{}, 0)
-	retv.Answers = make([]interface{}, 0)
-	retv.Additional = make([]interface{}, 0)
+	retv.Authorities = make([]RR, 0)
+	retv.Answers = make([]RR, 0)
+	retv.Additional = make([]RR, 0)
 	cachedRes, ok := unres.(CachedResult)
 	if !ok {
 		panic("bad cache entry")
@@ -139,14 +134,9 @@ func (s *Cache) GetCachedResult(q Question, isAuthCheck bool, depth int, threadI
 	return retv, true
 }
 
-func (s *Cache) SafeAddCachedAnswer(a interface{}, layer string, debugType string, depth int, threadID int) {
-	ans, ok := a.(Answer)
-	if !ok {
-		s.VerboseLog(depth+1, threadID, "unable to cast ", debugType, ": ", layer, ": ", a)
-		return
-	}
-	if ok, _ := nameIsBeneath(ans.Name, layer); !ok {
-		log.Info("detected poison ", debugType, ": ", ans.Name, "(", ans.Type, "): ", layer, ": ", a)
+func (s *Cache) SafeAddCachedAnswer(a RR, layer string, debugType string, depth int, threadID int) {
+	if ok, _ := nameIsBeneath(a.Owner(), layer); !ok {
+		log.Info("detected poison ", debugType, ": ", a.Owner(), "(", dns.TypeToString[a.RRType()], "): ", layer, ": ", a)
 		return
 	}
 	s.AddCachedAnswer(a, depth, threadID)
@@ -0,0 +1,150 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zmap/zdns/pkg/zdns"
+)
+
+var answerType = reflect.TypeOf(Answer{})
+
+// FlatRecord flattens a record returned by ParseAnswer. Records of complex
+// types get their type-specific fields written space-separated as the
+// answer, in the order they're declared, e.g. "10 mail.example.com" for MX.
+// EDNS pseudo-records aren't records and are skipped.
+func FlatRecord(section string, rec interface{}) (zdns.FlatRecord, bool) {
+	v := reflect.ValueOf(rec)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return zdns.FlatRecord{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return zdns.FlatRecord{}, false
+	}
+	if v.Type() == answerType {
+		return flatAnswer(section, v.Interface().(Answer), v.Interface(), nil), true
+	}
+	base := v.FieldByName("Answer")
+	if base.IsValid() && base.Type() == answerType {
+		var fields []string
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.Anonymous || !f.IsExported() || f.Tag.Get("json") == "-" {
+				continue
+			}
+			if s := flatValue(v.Field(i)); s != "" {
+				fields = append(fields, s)
+			}
+		}
+		return flatAnswer(section, base.Interface().(Answer), v.Interface(), fields), true
+	}
+	return zdns.FlatRecord{}, false
+}
+
+// flatAnswer flattens rec, whose Answer is a
+func flatAnswer(section string, a Answer, rec interface{}, fields []string) zdns.FlatRecord {
+	if a.Answer != "" {
+		fields = append(fields, a.Answer)
+	}
+	rdata, _ := ZoneRdata(rec)
+	return zdns.FlatRecord{
+		Section: section,
+		Name:    a.Name,
+		Type:    a.Type,
+		Class:   a.Class,
+		TTL:     a.Ttl,
+		HasTTL:  true,
+		Answer:  strings.Join(fields, " "),
+		Rdata:   rdata,
+	}
+}
+
+func flatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Slice:
+		parts := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			parts = append(parts, flatValue(v.Index(i)))
+		}
+		return strings.Join(parts, " ")
+	case reflect.Map:
+		parts := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			parts = append(parts, fmt.Sprintf("%v=%v", iter.Key().Interface(), iter.Value().Interface()))
+		}
+		sort.Strings(parts)
+		return strings.Join(parts, " ")
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+		return flatValue(v.Elem())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func flatSection(records []zdns.FlatRecord, section, resolver string, answers []RR) []zdns.FlatRecord {
+	for _, ans := range answers {
+		if rec, ok := FlatRecord(section, ans); ok {
+			rec.Resolver = resolver
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+func (r Result) FlatRecords(name string) []zdns.FlatRecord {
+	records := flatSection(nil, "answer", r.Resolver, r.Answers)
+	records = flatSection(records, "authority", r.Resolver, r.Authorities)
+	return flatSection(records, "additional", r.Resolver, r.Additional)
+}
+
+// FlatAddresses flattens addresses found for name into A and AAAA records,
+// whose TTL is unknown
+func FlatAddresses(section, name string, ipv4, ipv6 []string) []zdns.FlatRecord {
+	records := make([]zdns.FlatRecord, 0, len(ipv4)+len(ipv6))
+	for _, ip := range ipv4 {
+		records = append(records, zdns.FlatRecord{Section: section, Name: name, Type: "A", Answer: ip, Rdata: ip})
+	}
+	for _, ip := range ipv6 {
+		records = append(records, zdns.FlatRecord{Section: section, Name: name, Type: "AAAA", Answer: ip, Rdata: ip})
+	}
+	return records
+}
+
+func (r IpResult) FlatRecords(name string) []zdns.FlatRecord {
+	return FlatAddresses("answer", name, r.IPv4Addresses, r.IPv6Addresses)
+}
+
+func (r NSResult) FlatRecords(name string) []zdns.FlatRecord {
+	var records []zdns.FlatRecord
+	for _, ns := range r.Servers {
+		records = append(records, zdns.FlatRecord{Section: "answer", Name: name, Type: ns.Type, TTL: ns.TTL, HasTTL: true, Answer: ns.Name, Rdata: ZoneName(ns.Name)})
+	}
+	for _, ns := range r.Servers {
+		records = append(records, FlatAddresses("additional", ns.Name, ns.IPv4Addresses, ns.IPv6Addresses)...)
+	}
+	return records
+}
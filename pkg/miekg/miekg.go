@@ -15,8 +15,11 @@
 package miekg
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"net"
+	"reflect"
 	"regexp"
 	"strings"
 	"sync"
@@ -52,12 +55,23 @@ type Question struct {
 
 // result to be returned by scan of host
 type Result struct {
-	Answers     []interface{} `json:"answers,omitempty" groups:"short,normal,long,trace"`
-	Additional  []interface{} `json:"additionals,omitempty" groups:"short,normal,long,trace"`
-	Authorities []interface{} `json:"authorities,omitempty" groups:"short,normal,long,trace"`
-	Protocol    string        `json:"protocol" groups:"protocol,normal,long,trace"`
-	Resolver    string        `json:"resolver" groups:"resolver,normal,long,trace"`
-	Flags       DNSFlags      `json:"flags" groups:"flags,long,trace"`
+	Answers     []RR     `json:"answers,omitempty" groups:"short,normal,long,trace"`
+	Additional  []RR     `json:"additionals,omitempty" groups:"short,normal,long,trace"`
+	Authorities []RR     `json:"authorities,omitempty" groups:"short,normal,long,trace"`
+	Protocol    string   `json:"protocol" groups:"protocol,normal,long,trace"`
+	TLS         *TLSInfo `json:"tls,omitempty" groups:"protocol,long,trace"`
+	Resolver    string   `json:"resolver" groups:"resolver,normal,long,trace"`
+	Flags       DNSFlags `json:"flags" groups:"flags,long,trace"`
+}
+
+// InterfaceTypes returns the types of the records of r, for the JSON Schema
+// of its output
+func (r Result) InterfaceTypes(field string) []reflect.Type {
+	switch field {
+	case "Answers", "Additional", "Authorities":
+		return AnswerTypes
+	}
+	return nil
 }
 
 type ExtendedResult struct {
@@ -121,13 +135,13 @@ type GlobalLookupFactory struct {
 
 // Lookup client interface for helping in mocking
 type LookupClient interface {
-	ProtocolLookup(s *Lookup, q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error)
+	ProtocolLookup(ctx context.Context, s *Lookup, q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error)
 }
 
 type MiekgLookupClient struct{}
 
-func (lc MiekgLookupClient) ProtocolLookup(s *Lookup, q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
-	return s.DoMiekgLookup(q, nameServer)
+func (lc MiekgLookupClient) ProtocolLookup(ctx context.Context, s *Lookup, q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoMiekgLookup(ctx, q, nameServer)
 }
 
 func (s *GlobalLookupFactory) BlacklistInit() error {
@@ -157,8 +171,17 @@ func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
 	}
 
 	if s.IterativeCache == nil {
-		s.IterativeCache = new(Cache)
-		s.IterativeCache.Init(c.CacheSize)
+		// every module of a run shares a single cache
+		if cache, ok := c.IterativeCache.(*Cache); ok {
+			s.IterativeCache = cache
+		} else {
+			s.IterativeCache = new(Cache)
+			s.IterativeCache.Init(c.CacheSize)
+			s.IterativeCache.IterativeCache.RegisterCB(func(interface{}, interface{}) {
+				c.GetMetrics().CacheEviction()
+			})
+			c.IterativeCache = s.IterativeCache
+		}
 	}
 
 	s.DNSClass = dns.ClassINET
@@ -186,6 +209,7 @@ type RoutineLookupFactory struct {
 	Factory              *GlobalLookupFactory
 	Client               *dns.Client
 	TCPClient            *dns.Client
+	TLSClient            *TLSClient
 	Retries              int
 	MaxDepth             int
 	Timeout              time.Duration
@@ -242,6 +266,7 @@ func (s *RoutineLookupFactory) Initialize(c *zdns.GlobalConf) {
 			LocalAddr: &net.TCPAddr{IP: s.LocalAddr},
 		}
 	}
+	s.TLSClient = NewTLSClient(c.GetTLSConfig(), s.Timeout, s.LocalAddr)
 	s.IterativeTimeout = c.Timeout
 	s.Retries = c.Retries
 	s.MaxDepth = c.MaxDepth
@@ -265,6 +290,14 @@ func (s *RoutineLookupFactory) Initialize(c *zdns.GlobalConf) {
 	}
 }
 
+// Close closes the connections kept open by s, once its routine is done
+func (s *RoutineLookupFactory) Close() error {
+	if s.TLSClient == nil {
+		return nil
+	}
+	return s.TLSClient.Close()
+}
+
 func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
 	a := Lookup{Factory: s}
 	nameServer := s.Factory.RandomNameServer()
@@ -295,8 +328,25 @@ func (s *Lookup) Initialize(nameServer string, dnsType uint16, dnsClass uint16,
 	return nil
 }
 
-func (s *Lookup) doLookup(q Question, nameServer string, recursive bool) (Result, zdns.Status, error) {
-	return DoLookupWorker(s.Factory.Client, s.Factory.TCPClient, s.Conn, q, nameServer, recursive, s.Factory.EdnsOptions, s.Factory.Dnssec, s.Factory.Factory.GlobalConf.CheckingDisabled)
+func (s *Lookup) SetDNSClass(dnsClass uint16) {
+	s.DNSClass = dnsClass
+}
+
+// doLookup sends a single query and returns its result and how long the
+// query took, not counting the wait for the rate limiter
+func (s *Lookup) doLookup(ctx context.Context, q Question, nameServer string, recursive bool) (Result, zdns.Status, time.Duration, error) {
+	opts := WorkerOptions{
+		UDP:              s.Factory.Client,
+		TCP:              s.Factory.TCPClient,
+		TLS:              s.Factory.TLSClient,
+		Conn:             s.Conn,
+		EDNSOptions:      s.Factory.EdnsOptions,
+		DNSSEC:           s.Factory.Dnssec,
+		CheckingDisabled: s.Factory.Factory.GlobalConf.CheckingDisabled,
+		Limiter:          s.Factory.Factory.GlobalConf.RateLimiter,
+		WireTap:          s.Factory.Factory.GlobalConf.GetWireTap(),
+	}
+	return doLookupWorker(ctx, opts, q, nameServer, recursive)
 }
 
 // CheckTxtRecords common function for all modules based on search in TXT record
@@ -317,62 +367,166 @@ func (s *Lookup) CheckTxtRecords(res interface{}, status zdns.Status, err error)
 func (s *Lookup) FindTxtRecord(res Result) (string, error) {
 
 	for _, a := range res.Answers {
-		ans, _ := a.(Answer)
-		if s.Factory.PrefixRegexp == nil || s.Factory.PrefixRegexp.MatchString(ans.Answer) {
-			return ans.Answer, nil
+		txt := a.Data()
+		if txt == "" {
+			continue
+		}
+		if s.Factory.PrefixRegexp == nil || s.Factory.PrefixRegexp.MatchString(txt) {
+			return txt, nil
 		}
 	}
 	return "", errors.New("no such TXT record found")
 }
 
-// Expose the inner logic so other tools can use it
-func DoLookupWorker(udp *dns.Client, tcp *dns.Client, conn *dns.Conn, q Question, nameServer string, recursive bool, ednsOptions []dns.EDNS0, dnssec bool, checkingDisabled bool) (Result, zdns.Status, error) {
-	res := Result{Answers: []interface{}{}, Authorities: []interface{}{}, Additional: []interface{}{}}
+// exchange sends m over conn and waits for the matching response. The dns
+// library only honours context deadlines, so once ctx is done the connection
+// deadline is pulled forward to unblock the pending read. What goes over the
+// wire is written to wt, if set.
+func exchange(ctx context.Context, c *dns.Client, m *dns.Msg, conn *dns.Conn, wt zdns.WireTap) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	if wt != nil {
+		var tc *tapConn
+		conn, tc = tap(conn)
+		queryTime := time.Now()
+		defer func() {
+			if len(tc.written) == 0 {
+				return
+			}
+			protocol, remote := "tcp", conn.Conn.RemoteAddr()
+			if conn.UnboundUDP {
+				protocol, remote = "udp", conn.RemoteAddr
+			} else if _, ok := remote.(*net.UDPAddr); ok {
+				protocol = "udp"
+			} else if _, ok := tc.Conn.(*tls.Conn); ok {
+				protocol = "tls"
+			}
+			wt.Write(tc.exchange(protocol, remote, queryTime))
+		}()
+	}
+	r, _, err := c.ExchangeWithConn(m, conn)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return r, err
+}
+
+func dialAndExchange(ctx context.Context, c *dns.Client, m *dns.Msg, nameServer string, wt zdns.WireTap) (*dns.Msg, error) {
+	conn, err := c.DialContext(ctx, nameServer)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return exchange(ctx, c, m, conn, wt)
+}
+
+// WorkerOptions are how DoLookupWorker queries name servers
+type WorkerOptions struct {
+	// UDP is the client of UDP queries. Without it, queries go over TCP.
+	UDP *dns.Client
+	// TCP is the client of TCP queries, and of UDP queries again when their
+	// response is truncated
+	TCP *dns.Client
+	// TLS is the client of name servers given as tls://host:port
+	TLS *TLSClient
+	// Conn, if set, is the socket UDP queries are sent from
+	Conn *dns.Conn
+
+	EDNSOptions      []dns.EDNS0
+	DNSSEC           bool
+	CheckingDisabled bool
+
+	// Limiter, if set, holds queries back to the rate limit
+	Limiter *zdns.RateLimiter
+	// WireTap, if set, is given every message sent and received
+	WireTap zdns.WireTap
+}
+
+// Expose the inner logic so other tools can use it. Name servers given as
+// tls://host:port are queried over DNS-over-TLS.
+func DoLookupWorker(ctx context.Context, opts WorkerOptions, q Question, nameServer string, recursive bool) (Result, zdns.Status, error) {
+	res, status, _, err := doLookupWorker(ctx, opts, q, nameServer, recursive)
+	return res, status, err
+}
+
+// doLookupWorker is DoLookupWorker also returning how long the query took,
+// not counting the wait for the rate limiter
+func doLookupWorker(ctx context.Context, opts WorkerOptions, q Question, nameServer string, recursive bool) (Result, zdns.Status, time.Duration, error) {
+	res := Result{Answers: []RR{}, Authorities: []RR{}, Additional: []RR{}}
 	res.Resolver = nameServer
 
+	if err := opts.Limiter.Wait(ctx, nameServer); err != nil {
+		return res, zdns.STATUS_CANCELLED, 0, err
+	}
+
 	m := new(dns.Msg)
 	m.SetQuestion(dotName(q.Name), q.Type)
 	m.Question[0].Qclass = q.Class
 	m.RecursionDesired = recursive
-	m.CheckingDisabled = checkingDisabled
+	m.CheckingDisabled = opts.CheckingDisabled
 
-	m.SetEdns0(1232, dnssec)
+	m.SetEdns0(1232, opts.DNSSEC)
 	ednsOpt := m.IsEdns0()
 	if ednsOpt != nil {
-		ednsOpt.Option = append(ednsOpt.Option, ednsOptions...)
+		ednsOpt.Option = append(ednsOpt.Option, opts.EDNSOptions...)
 	}
 
+	start := time.Now()
+	status, err := opts.exchange(ctx, m, nameServer, &res)
+	rtt := time.Since(start)
+	// if record comes back truncated, but we have a TCP connection, try again with that
+	if status == zdns.STATUS_TRUNCATED && opts.TCP != nil {
+		opts.UDP = nil
+		res, status, tcpRTT, err := doLookupWorker(ctx, opts, q, nameServer, recursive)
+		return res, status, rtt + tcpRTT, err
+	}
+	return res, status, rtt, err
+}
+
+// exchange sends m to nameServer and fills res in with the response
+func (opts *WorkerOptions) exchange(ctx context.Context, m *dns.Msg, nameServer string, res *Result) (zdns.Status, error) {
 	var r *dns.Msg
 	var err error
-	if udp != nil {
+	if addr, ok := strings.CutPrefix(nameServer, zdns.TLSScheme); ok {
+		res.Protocol = "tls"
+		if opts.TLS == nil {
+			return zdns.STATUS_ERROR, errors.New("no DNS-over-TLS client to query " + nameServer)
+		}
+		r, res.TLS, err = opts.TLS.exchange(ctx, m, addr, opts.WireTap)
+	} else if opts.UDP != nil {
 		res.Protocol = "udp"
-		if conn != nil {
+		if conn := opts.Conn; conn != nil {
 			dst, _ := net.ResolveUDPAddr("udp", nameServer)
-			r, _, err = udp.ExchangeWithConnTo(m, conn, dst)
+			conn.UnboundUDP = true
+			conn.RemoteAddr = dst
+			r, err = exchange(ctx, opts.UDP, m, conn, opts.WireTap)
 		} else {
-			r, _, err = udp.Exchange(m, nameServer)
+			r, err = dialAndExchange(ctx, opts.UDP, m, nameServer, opts.WireTap)
 		}
-		// if record comes back truncated, but we have a TCP connection, try again with that
 		if r != nil && (r.Truncated || r.Rcode == dns.RcodeBadTrunc) {
-			if tcp != nil {
-				return DoLookupWorker(nil, tcp, conn, q, nameServer, recursive, ednsOptions, dnssec, checkingDisabled)
-			} else {
-				return res, zdns.STATUS_TRUNCATED, err
-			}
+			return zdns.STATUS_TRUNCATED, err
 		}
 	} else {
 		res.Protocol = "tcp"
-		r, _, err = tcp.Exchange(m, nameServer)
+		r, err = dialAndExchange(ctx, opts.TCP, m, nameServer, opts.WireTap)
+	}
+	if ctx.Err() != nil {
+		return zdns.STATUS_CANCELLED, ctx.Err()
 	}
 	if err != nil || r == nil {
 		if nerr, ok := err.(net.Error); ok {
 			if nerr.Timeout() {
-				return res, zdns.STATUS_TIMEOUT, nil
+				return zdns.STATUS_TIMEOUT, nil
 			} else if nerr.Temporary() {
-				return res, zdns.STATUS_TEMPORARY, err
+				return zdns.STATUS_TEMPORARY, err
 			}
 		}
-		return res, zdns.STATUS_ERROR, err
+		return zdns.STATUS_ERROR, err
 	}
 
 	if r.Rcode != dns.RcodeSuccess {
@@ -382,7 +536,7 @@ func DoLookupWorker(udp *dns.Client, tcp *dns.Client, conn *dns.Conn, q Question
 				res.Additional = append(res.Additional, inner)
 			}
 		}
-		return res, TranslateMiekgErrorCode(r.Rcode), nil
+		return TranslateMiekgErrorCode(r.Rcode), nil
 	}
 
 	res.Flags.Response = r.Response
@@ -413,12 +567,12 @@ func DoLookupWorker(udp *dns.Client, tcp *dns.Client, conn *dns.Conn, q Question
 			res.Authorities = append(res.Authorities, inner)
 		}
 	}
-	return res, zdns.STATUS_NOERROR, nil
+	return zdns.STATUS_NOERROR, nil
 }
 
-func (s *Lookup) tracedRetryingLookup(q Question, nameServer string, recursive bool) (Result, zdns.Trace, zdns.Status, error) {
+func (s *Lookup) tracedRetryingLookup(ctx context.Context, q Question, nameServer string, recursive bool) (Result, zdns.Trace, zdns.Status, error) {
 
-	res, status, try, err := s.retryingLookup(q, nameServer, recursive)
+	res, status, try, err := s.retryingLookup(ctx, q, nameServer, recursive)
 
 	trace := make([]interface{}, 0)
 
@@ -439,7 +593,7 @@ func (s *Lookup) tracedRetryingLookup(q Question, nameServer string, recursive b
 	return res, trace, status, err
 }
 
-func (s *Lookup) retryingLookup(q Question, nameServer string, recursive bool) (Result, zdns.Status, int, error) {
+func (s *Lookup) retryingLookup(ctx context.Context, q Question, nameServer string, recursive bool) (Result, zdns.Status, int, error) {
 	s.VerboseLog(1, "****WIRE LOOKUP*** ", dns.TypeToString[q.Type], " ", q.Name, " ", nameServer)
 
 	var origTimeout time.Duration
@@ -449,7 +603,11 @@ func (s *Lookup) retryingLookup(q Question, nameServer string, recursive bool) (
 		origTimeout = s.Factory.TCPClient.Timeout
 	}
 	for i := 0; i <= s.Factory.Retries; i++ {
-		result, status, err := s.doLookup(q, nameServer, recursive)
+		result, status, rtt, err := s.doLookup(ctx, q, nameServer, recursive)
+		if stats := s.Factory.Factory.GlobalConf.QueryStats; stats != nil {
+			stats.Record(nameServer, i, status, rtt)
+		}
+		s.Factory.Factory.GlobalConf.GetMetrics().QueryDone(result.Protocol, nameServer, i, responseRcode(status), status, rtt)
 		if (status != zdns.STATUS_TIMEOUT && status != zdns.STATUS_TEMPORARY) || i == s.Factory.Retries {
 			if s.Factory.Client != nil {
 				s.Factory.Client.Timeout = origTimeout
@@ -457,6 +615,9 @@ func (s *Lookup) retryingLookup(q Question, nameServer string, recursive bool) (
 			if s.Factory.TCPClient != nil {
 				s.Factory.TCPClient.Timeout = origTimeout
 			}
+			if s.Factory.TLSClient != nil {
+				s.Factory.TLSClient.Timeout = origTimeout
+			}
 			return result, status, (i + 1), err
 		}
 		if s.Factory.Client != nil {
@@ -465,16 +626,33 @@ func (s *Lookup) retryingLookup(q Question, nameServer string, recursive bool) (
 		if s.Factory.TCPClient != nil {
 			s.Factory.TCPClient.Timeout = 2 * s.Factory.TCPClient.Timeout
 		}
+		if s.Factory.TLSClient != nil {
+			s.Factory.TLSClient.Timeout = 2 * s.Factory.TLSClient.Timeout
+		}
 	}
 
 	// TODO 不确定有没有错误
 	panic("loop must return")
 }
 
-func (s *Lookup) cachedRetryingLookup(q Question, nameServer, layer string, depth int) (Result, IsCached, zdns.Status, int, error) {
+// responseRcode returns the rcode of the response a query got, or "" if it
+// got none. doLookup reports rcodes as their status.
+func responseRcode(status zdns.Status) string {
+	if _, ok := dns.StringToRcode[string(status)]; ok {
+		return string(status)
+	}
+	return ""
+}
+
+func (s *Lookup) cachedRetryingLookup(ctx context.Context, q Question, nameServer, layer string, depth int) (Result, IsCached, zdns.Status, int, error) {
 	var isCached IsCached
 	isCached = false
 	s.VerboseLog(depth+1, "Cached retrying lookup. Name: ", q, ", Layer: ", layer, ", Nameserver: ", nameServer)
+	if err := ctx.Err(); err != nil {
+		s.VerboseLog(depth+2, "CANCELLED ", q, ", Layer: ", layer, ", Nameserver: ", nameServer)
+		var r Result
+		return r, isCached, zdns.STATUS_CANCELLED, 0, err
+	}
 	if s.IterativeStop.Before(time.Now()) {
 		s.VerboseLog(depth+2, "ITERATIVE_TIMEOUT ", q, ", Layer: ", layer, ", Nameserver: ", nameServer)
 		var r Result
@@ -482,6 +660,7 @@ func (s *Lookup) cachedRetryingLookup(q Question, nameServer, layer string, dept
 	}
 	// First, we check the answer
 	cachedResult, ok := s.Factory.Factory.IterativeCache.GetCachedResult(q, false, depth+1, s.Factory.ThreadID)
+	s.Factory.Factory.GlobalConf.GetMetrics().CacheLookup(ok)
 	if ok {
 		isCached = true
 		return cachedResult, isCached, zdns.STATUS_NOERROR, 0, nil
@@ -526,6 +705,7 @@ func (s *Lookup) cachedRetryingLookup(q Question, nameServer, layer string, dept
 		qAuth.Type = dns.TypeNS
 		qAuth.Class = dns.ClassINET
 		cachedResult, ok = s.Factory.Factory.IterativeCache.GetCachedResult(qAuth, true, depth+2, s.Factory.ThreadID)
+		s.Factory.Factory.GlobalConf.GetMetrics().CacheLookup(ok)
 		if ok {
 			isCached = true
 			return cachedResult, isCached, zdns.STATUS_NOERROR, 0, nil
@@ -535,27 +715,26 @@ func (s *Lookup) cachedRetryingLookup(q Question, nameServer, layer string, dept
 	// Alright, we're not sure what to do, go to the wire.
 	s.VerboseLog(depth+2, "Wire lookup for name: ", q.Name, " (", q.Type, ") at nameserver: ", nameServer)
 	// 具体发送请求的位置
-	result, status, try, err := s.retryingLookup(q, nameServer, false)
+	result, status, try, err := s.retryingLookup(ctx, q, nameServer, false)
 
 	s.Factory.Factory.IterativeCache.CacheUpdate(layer, result, depth+2, s.Factory.ThreadID)
 	return result, isCached, status, try, err
 }
 
-func (s *Lookup) extractAuthority(authority interface{}, layer string, depth int, result Result, trace []interface{}) (string, zdns.Status, string, []interface{}) {
+func (s *Lookup) extractAuthority(ctx context.Context, authority RR, layer string, depth int, result Result, trace []interface{}) (string, zdns.Status, string, []interface{}) {
 
-	// Is it an answer
-	ans, ok := authority.(Answer)
-	if !ok {
+	// Is it a name server
+	if authority.RRType() != dns.TypeNS {
 		return "", zdns.STATUS_FORMERR, layer, trace
 	}
 
 	// Is the layering correct
-	ok, layer = nameIsBeneath(ans.Name, layer)
+	ok, layer := nameIsBeneath(authority.Owner(), layer)
 	if !ok {
 		return "", zdns.STATUS_AUTHFAIL, layer, trace
 	}
 
-	server := strings.TrimSuffix(ans.Answer, ".")
+	server := strings.TrimSuffix(authority.Data(), ".")
 
 	// Short circuit a lookup from the glue
 	// Normally this would be handled by caching, but we want to support following glue
@@ -567,21 +746,16 @@ func (s *Lookup) extractAuthority(authority interface{}, layer string, depth int
 		q.Name = server
 		q.Type = dns.TypeA
 		q.Class = dns.ClassINET
-		res, trace, status, _ = s.iterativeLookup(q, s.NameServer, depth+1, ".", trace)
+		res, trace, status, _ = s.iterativeLookup(ctx, q, s.NameServer, depth+1, ".", trace)
 	}
-	if status == zdns.STATUS_ITER_TIMEOUT {
+	if status == zdns.STATUS_ITER_TIMEOUT || status == zdns.STATUS_CANCELLED {
 		return "", status, "", trace
 	}
 	if status == zdns.STATUS_NOERROR {
 		// XXX we don't actually check the question here
 		for _, inner_a := range res.Answers {
-			inner_ans, ok := inner_a.(Answer)
-			if !ok {
-				continue
-			}
-			if inner_ans.Type == "A" {
-				server := strings.TrimSuffix(inner_ans.Answer, ".") + ":53"
-				return server, zdns.STATUS_NOERROR, layer, trace
+			if addr := inner_a.AsA(); addr.Is4() {
+				return addr.String() + ":53", zdns.STATUS_NOERROR, layer, trace
 			}
 		}
 	}
@@ -629,7 +803,7 @@ func handleStatus(status *zdns.Status, err error) (*zdns.Status, error) {
 	}
 }
 
-func (s *Lookup) iterateOnAuthorities(q Question, depth int, result Result, layer string, trace []interface{}) (Result, []interface{}, zdns.Status, error) {
+func (s *Lookup) iterateOnAuthorities(ctx context.Context, q Question, depth int, result Result, layer string, trace []interface{}) (Result, []interface{}, zdns.Status, error) {
 	//
 	if len(result.Authorities) == 0 {
 		var r Result
@@ -637,13 +811,18 @@ func (s *Lookup) iterateOnAuthorities(q Question, depth int, result Result, laye
 	}
 	for i, elem := range result.Authorities {
 		s.VerboseLog(depth+1, "Trying Authority: ", elem)
-		ns, ns_status, layer, trace := s.extractAuthority(elem, layer, depth, result, trace)
+		ns, ns_status, layer, trace := s.extractAuthority(ctx, elem, layer, depth, result, trace)
 		s.VerboseLog((depth + 1), "Output from extract authorities: ", ns)
 		if ns_status == zdns.STATUS_ITER_TIMEOUT {
 			s.VerboseLog((depth + 2), "--> Hit iterative timeout: ")
 			var r Result
 			return r, trace, zdns.STATUS_ITER_TIMEOUT, nil
 		}
+		if ns_status == zdns.STATUS_CANCELLED {
+			s.VerboseLog((depth + 2), "--> Lookup cancelled")
+			var r Result
+			return r, trace, zdns.STATUS_CANCELLED, ctx.Err()
+		}
 		if ns_status != zdns.STATUS_NOERROR {
 			var err error
 			new_status, err := handleStatus(&ns_status, err)
@@ -670,10 +849,13 @@ func (s *Lookup) iterateOnAuthorities(q Question, depth int, result Result, laye
 				}
 			}
 		}
-		r, trace, status, err := s.iterativeLookup(q, ns, depth+1, layer, trace)
+		r, trace, status, err := s.iterativeLookup(ctx, q, ns, depth+1, layer, trace)
 		if isStatusAnswer(status) {
 			s.VerboseLog((depth + 1), "--> Auth Resolution success: ", status)
 			return r, trace, status, err
+		} else if status == zdns.STATUS_CANCELLED {
+			s.VerboseLog((depth + 1), "--> Auth resolution cancelled")
+			return r, trace, status, err
 		} else if i+1 < len(result.Authorities) {
 			s.VerboseLog((depth + 2), "--> Auth resolution of ", ns, " Failed: ", status, ". Will try next authority")
 			continue
@@ -686,7 +868,7 @@ func (s *Lookup) iterateOnAuthorities(q Question, depth int, result Result, laye
 	panic("should not be able to reach here")
 }
 
-func (s *Lookup) iterativeLookup(q Question, nameServer string,
+func (s *Lookup) iterativeLookup(ctx context.Context, q Question, nameServer string,
 	depth int, layer string, trace []interface{}) (Result, []interface{}, zdns.Status, error) {
 	//
 	if log.GetLevel() == log.DebugLevel {
@@ -697,7 +879,7 @@ func (s *Lookup) iterativeLookup(q Question, nameServer string,
 		s.VerboseLog((depth + 1), "-> Max recursion depth reached")
 		return r, trace, zdns.STATUS_ERROR, errors.New("Max recursion depth reached")
 	}
-	result, isCached, status, try, err := s.cachedRetryingLookup(q, nameServer, layer, depth)
+	result, isCached, status, try, err := s.cachedRetryingLookup(ctx, q, nameServer, layer, depth)
 	if s.Factory.Trace && status == zdns.STATUS_NOERROR {
 		var t TraceStep
 		t.Result = result
@@ -720,11 +902,11 @@ func (s *Lookup) iterativeLookup(q Question, nameServer string,
 			s.VerboseLog((depth + 1), "-> answers found")
 			if len(result.Authorities) > 0 {
 				s.VerboseLog((depth + 2), "Dropping ", len(result.Authorities), " authority answers from output")
-				result.Authorities = make([]interface{}, 0)
+				result.Authorities = make([]RR, 0)
 			}
 			if len(result.Additional) > 0 {
 				s.VerboseLog((depth + 2), "Dropping ", len(result.Additional), " additional answers from output")
-				result.Additional = make([]interface{}, 0)
+				result.Additional = make([]RR, 0)
 			}
 		} else {
 			s.VerboseLog((depth + 1), "-> authoritative response found")
@@ -732,14 +914,14 @@ func (s *Lookup) iterativeLookup(q Question, nameServer string,
 		return result, trace, status, err
 	} else if len(result.Authorities) != 0 {
 		s.VerboseLog((depth + 1), "-> Authority found, iterating")
-		return s.iterateOnAuthorities(q, depth, result, layer, trace)
+		return s.iterateOnAuthorities(ctx, q, depth, result, layer, trace)
 	} else {
 		s.VerboseLog((depth + 1), "-> No Authority found, error")
 		return result, trace, zdns.STATUS_ERROR, errors.New("NOERROR record without any answers or authorities")
 	}
 }
 
-func (s *Lookup) DoMiekgLookup(q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+func (s *Lookup) DoMiekgLookup(ctx context.Context, q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
 	if nameServer == "" {
 		nameServer = s.NameServer
 	}
@@ -776,21 +958,17 @@ func (s *Lookup) DoMiekgLookup(q Question, nameServer string) (interface{}, zdns
 					a     []string
 				)
 
-				result, trace, status, err = s.iterativeLookup(q, nameServer, 1, ".", make([]interface{}, 0))
-				for _, item := range result.Answers {
-					answer, ok := item.(Answer)
-					if !ok {
-						continue
-					}
-					switch answer.Type {
-					case dns.Type(dns.TypeA).String():
-						a = append(a, answer.Answer)
-					case dns.Type(dns.TypeCNAME).String():
+				result, trace, status, err = s.iterativeLookup(ctx, q, nameServer, 1, ".", make([]interface{}, 0))
+				for _, answer := range result.Answers {
+					switch answer.RRType() {
+					case dns.TypeA:
+						a = append(a, answer.Data())
+					case dns.TypeCNAME:
 						if cname != "" {
 							continue
 						}
 
-						cname = answer.Answer
+						cname = answer.Data()
 					}
 				}
 
@@ -808,7 +986,7 @@ func (s *Lookup) DoMiekgLookup(q Question, nameServer string) (interface{}, zdns
 				break
 			}
 		default:
-			result, trace, status, err = s.iterativeLookup(q, nameServer, 1, ".", make([]interface{}, 0))
+			result, trace, status, err = s.iterativeLookup(ctx, q, nameServer, 1, ".", make([]interface{}, 0))
 		}
 
 		s.VerboseLog(0, "MIEKG-OUT: iterative lookup for ", q.Name, " (", q.Type, "): status: ", status, " , err: ", err)
@@ -817,34 +995,32 @@ func (s *Lookup) DoMiekgLookup(q Question, nameServer string) (interface{}, zdns
 		}
 		return result, trace, status, err
 	} else {
-		return s.tracedRetryingLookup(q, nameServer, true)
+		return s.tracedRetryingLookup(ctx, q, nameServer, true)
 	}
 }
 
-func populateResults(records []interface{}, dnsType uint16, candidateSet map[string][]Answer, cnameSet map[string][]Answer, garbage map[string][]Answer) {
-	for _, a := range records {
+func populateResults(records []RR, dnsType uint16, candidateSet map[string][]RR, cnameSet map[string][]RR, garbage map[string][]RR) {
+	for _, ans := range records {
 		// filter only valid answers of requested type or CNAME (#163)
-		if ans, ok := a.(Answer); ok {
-			lowerCaseName := strings.ToLower(strings.TrimSuffix(ans.Name, "."))
-			// Verify that the answer type matches requested type
-			if VerifyAddress(ans.Type, ans.Answer) {
-				ansType := dns.StringToType[ans.Type]
-				if dnsType == ansType {
-					candidateSet[lowerCaseName] = append(candidateSet[lowerCaseName], ans)
-				} else if ok && dns.TypeCNAME == ansType {
-					cnameSet[lowerCaseName] = append(cnameSet[lowerCaseName], ans)
-				} else {
-					garbage[lowerCaseName] = append(garbage[lowerCaseName], ans)
-				}
+		lowerCaseName := strings.ToLower(strings.TrimSuffix(ans.Owner(), "."))
+		ansType := ans.RRType()
+		// Verify that the answer type matches requested type
+		if VerifyAddress(dns.TypeToString[ansType], ans.Data()) {
+			if dnsType == ansType {
+				candidateSet[lowerCaseName] = append(candidateSet[lowerCaseName], ans)
+			} else if dns.TypeCNAME == ansType {
+				cnameSet[lowerCaseName] = append(cnameSet[lowerCaseName], ans)
 			} else {
 				garbage[lowerCaseName] = append(garbage[lowerCaseName], ans)
 			}
+		} else {
+			garbage[lowerCaseName] = append(garbage[lowerCaseName], ans)
 		}
 	}
 }
 
 // Function to recursively search for IP addresses
-func (s *Lookup) DoIpsLookup(lc LookupClient, name string, nameServer string, dnsType uint16, candidateSet map[string][]Answer, cnameSet map[string][]Answer, origName string, depth int) ([]string, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoIpsLookup(ctx context.Context, lc LookupClient, name string, nameServer string, dnsType uint16, candidateSet map[string][]RR, cnameSet map[string][]RR, origName string, depth int) ([]string, []interface{}, zdns.Status, error) {
 	// avoid infinite loops
 	if name == origName && depth != 0 {
 		return nil, make([]interface{}, 0), zdns.STATUS_ERROR, errors.New("infinite redirection loop")
@@ -855,12 +1031,12 @@ func (s *Lookup) DoIpsLookup(lc LookupClient, name string, nameServer string, dn
 	// check if the record is already in our cache. if not, perform normal A lookup and
 	// see what comes back. Then iterate over results and if needed, perform further lookups
 	var trace []interface{}
-	garbage := map[string][]Answer{}
+	garbage := map[string][]RR{}
 	if _, ok := candidateSet[name]; !ok {
 		var miekgResult interface{}
 		var status zdns.Status
 		var err error
-		miekgResult, trace, status, err = lc.ProtocolLookup(s, Question{Name: name, Type: dnsType}, nameServer)
+		miekgResult, trace, status, err = lc.ProtocolLookup(ctx, s, Question{Name: name, Type: dnsType}, nameServer)
 		if status != zdns.STATUS_NOERROR || err != nil {
 			return nil, trace, status, err
 		}
@@ -873,13 +1049,13 @@ func (s *Lookup) DoIpsLookup(lc LookupClient, name string, nameServer string, dn
 		// we have IP addresses to hand back to the user. let's make an easy-to-use array of strings
 		var ips []string
 		for _, answer := range res {
-			ips = append(ips, answer.Answer)
+			ips = append(ips, answer.Data())
 		}
 		return ips, trace, zdns.STATUS_NOERROR, nil
 	} else if res, ok = cnameSet[name]; ok && len(res) > 0 {
 		// we have a CNAME and need to further recurse to find IPs
-		shortName := strings.ToLower(strings.TrimSuffix(res[0].Answer, "."))
-		res, secondTrace, status, err := s.DoIpsLookup(lc, shortName, nameServer, dnsType, candidateSet, cnameSet, origName, depth+1)
+		shortName := strings.ToLower(strings.TrimSuffix(res[0].Data(), "."))
+		res, secondTrace, status, err := s.DoIpsLookup(ctx, lc, shortName, nameServer, dnsType, candidateSet, cnameSet, origName, depth+1)
 		trace = append(trace, secondTrace...)
 		return res, trace, status, err
 	} else if res, ok = garbage[name]; ok && len(res) > 0 {
@@ -891,11 +1067,11 @@ func (s *Lookup) DoIpsLookup(lc LookupClient, name string, nameServer string, dn
 	}
 }
 
-func (s *Lookup) DoTargetedLookup(l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (interface{}, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoTargetedLookup(ctx context.Context, l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (interface{}, []interface{}, zdns.Status, error) {
 	name = strings.ToLower(name)
 	res := IpResult{}
-	candidateSet := map[string][]Answer{}
-	cnameSet := map[string][]Answer{}
+	candidateSet := map[string][]RR{}
+	cnameSet := map[string][]RR{}
 	var ipv4 []string
 	var ipv6 []string
 	var ipv4Trace []interface{}
@@ -904,17 +1080,17 @@ func (s *Lookup) DoTargetedLookup(l LookupClient, name, nameServer string, looku
 	var ipv6status zdns.Status
 
 	if lookupIpv4 {
-		ipv4, ipv4Trace, ipv4status, _ = s.DoIpsLookup(l, name, nameServer, dns.TypeA, candidateSet, cnameSet, name, 0)
+		ipv4, ipv4Trace, ipv4status, _ = s.DoIpsLookup(ctx, l, name, nameServer, dns.TypeA, candidateSet, cnameSet, name, 0)
 		if len(ipv4) > 0 {
 			ipv4 = Unique(ipv4)
 			res.IPv4Addresses = make([]string, len(ipv4))
 			copy(res.IPv4Addresses, ipv4)
 		}
 	}
-	candidateSet = map[string][]Answer{}
-	cnameSet = map[string][]Answer{}
+	candidateSet = map[string][]RR{}
+	cnameSet = map[string][]RR{}
 	if lookupIpv6 {
-		ipv6, ipv6Trace, ipv6status, _ = s.DoIpsLookup(l, name, nameServer, dns.TypeAAAA, candidateSet, cnameSet, name, 0)
+		ipv6, ipv6Trace, ipv6status, _ = s.DoIpsLookup(ctx, l, name, nameServer, dns.TypeAAAA, candidateSet, cnameSet, name, 0)
 		if len(ipv6) > 0 {
 			ipv6 = Unique(ipv6)
 			res.IPv6Addresses = make([]string, len(ipv6))
@@ -938,43 +1114,33 @@ func (s *Lookup) DoTargetedLookup(l LookupClient, name, nameServer string, looku
 	return res, combinedTrace, zdns.STATUS_NOERROR, nil
 }
 
-func (s *Lookup) DoNSLookup(l LookupClient, name string, lookupIpv4 bool, lookupIpv6 bool, nameServer string) (NSResult, zdns.Trace, zdns.Status, error) {
+func (s *Lookup) DoNSLookup(ctx context.Context, l LookupClient, name string, lookupIpv4 bool, lookupIpv6 bool, nameServer string) (NSResult, zdns.Trace, zdns.Status, error) {
 	var retv NSResult
-	res, trace, status, err := l.ProtocolLookup(s, Question{Name: name, Type: dns.TypeNS}, nameServer)
+	res, trace, status, err := l.ProtocolLookup(ctx, s, Question{Name: name, Type: dns.TypeNS}, nameServer)
 	if status != zdns.STATUS_NOERROR || err != nil {
 		return retv, trace, status, err
 	}
 	ns := res.(Result)
 	ipv4s := make(map[string][]string)
 	ipv6s := make(map[string][]string)
-	for _, ans := range ns.Additional {
-		a, ok := ans.(Answer)
-		if !ok {
-			continue
-		}
-		recName := strings.TrimSuffix(a.Name, ".")
-		if VerifyAddress(a.Type, a.Answer) {
-			if a.Type == "A" {
-				ipv4s[recName] = append(ipv4s[recName], a.Answer)
-			} else if a.Type == "AAAA" {
-				ipv6s[recName] = append(ipv6s[recName], a.Answer)
-			}
+	for _, a := range ns.Additional {
+		recName := strings.TrimSuffix(a.Owner(), ".")
+		switch addr := a.AsA(); {
+		case a.RRType() == dns.TypeA && addr.Is4():
+			ipv4s[recName] = append(ipv4s[recName], a.Data())
+		case a.RRType() == dns.TypeAAAA && addr.Is6():
+			ipv6s[recName] = append(ipv6s[recName], a.Data())
 		}
 	}
-	for _, ans := range ns.Answers {
-		a, ok := ans.(Answer)
-		if !ok {
-			continue
-		}
-
-		if a.Type != "NS" {
+	for _, a := range ns.Answers {
+		if a.RRType() != dns.TypeNS {
 			continue
 		}
 
 		var rec NSRecord
-		rec.Type = a.Type
-		rec.Name = strings.TrimSuffix(a.Answer, ".")
-		rec.TTL = a.Ttl
+		rec.Type = dns.TypeToString[dns.TypeNS]
+		rec.Name = strings.TrimSuffix(a.Data(), ".")
+		rec.TTL = a.TTL()
 
 		var findIpv4 = false
 		var findIpv6 = false
@@ -994,7 +1160,7 @@ func (s *Lookup) DoNSLookup(l LookupClient, name string, lookupIpv4 bool, lookup
 			}
 		}
 		if findIpv4 || findIpv6 {
-			res, nextTrace, _, _ := s.DoTargetedLookup(l, rec.Name, nameServer, findIpv4, findIpv6)
+			res, nextTrace, _, _ := s.DoTargetedLookup(ctx, l, rec.Name, nameServer, findIpv4, findIpv6)
 			if res != nil {
 				if findIpv4 {
 					rec.IPv4Addresses = res.(IpResult).IPv4Addresses
@@ -1011,12 +1177,12 @@ func (s *Lookup) DoNSLookup(l LookupClient, name string, lookupIpv4 bool, lookup
 	return retv, trace, zdns.STATUS_NOERROR, nil
 }
 
-func (s *Lookup) DoLookupAllNameservers(l LookupClient, name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+func (s *Lookup) DoLookupAllNameservers(ctx context.Context, l LookupClient, name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
 	var retv CombinedResult
 	var curServer string
 
 	// Lookup both ipv4 and ipv6 addresses of nameservers.
-	nsResults, nsTrace, nsStatus, nsError := s.DoNSLookup(l, name, true, true, nameServer)
+	nsResults, nsTrace, nsStatus, nsError := s.DoNSLookup(ctx, l, name, true, true, nameServer)
 
 	// Terminate early if nameserver lookup also failed
 	if nsStatus != zdns.STATUS_NOERROR {
@@ -1033,7 +1199,7 @@ func (s *Lookup) DoLookupAllNameservers(l LookupClient, name, nameServer string)
 		ips := append(nserver.IPv4Addresses, nserver.IPv6Addresses...)
 		for _, ip := range ips {
 			curServer = net.JoinHostPort(ip, "53")
-			res, trace, status, _ := l.ProtocolLookup(s, Question{Name: name, Type: s.DNSType, Class: s.DNSClass}, curServer)
+			res, trace, status, _ := l.ProtocolLookup(ctx, s, Question{Name: name, Type: s.DNSType, Class: s.DNSClass}, curServer)
 
 			fullTrace = append(fullTrace, trace...)
 			tmpRes = Result{}
@@ -1053,10 +1219,14 @@ func (s *Lookup) DoLookupAllNameservers(l LookupClient, name, nameServer string)
 
 // allow miekg to be used as a ZDNS module
 func (s *Lookup) DoLookup(name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoLookupContext(context.Background(), name, nameServer)
+}
+
+func (s *Lookup) DoLookupContext(ctx context.Context, name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
 	if s.Factory.LookupAllNameServers {
 		l := MiekgLookupClient{}
-		return s.DoLookupAllNameservers(l, name, nameServer)
+		return s.DoLookupAllNameservers(ctx, l, name, nameServer)
 	} else {
-		return s.DoMiekgLookup(Question{Name: name, Type: s.DNSType, Class: s.DNSClass}, nameServer)
+		return s.DoMiekgLookup(ctx, Question{Name: name, Type: s.DNSType, Class: s.DNSClass}, nameServer)
 	}
 }
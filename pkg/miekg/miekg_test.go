@@ -14,13 +14,39 @@
 package miekg
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/hashicorp/go-version"
+	"github.com/liip/sheriff"
 	"github.com/zmap/dns"
 	"github.com/zmap/zdns/pkg/zdns"
 	"gotest.tools/v3/assert"
@@ -43,7 +69,7 @@ var protocolStatus = make(map[domain_ns]zdns.Status)
 
 type MockLookupClient struct{}
 
-func (mc MockLookupClient) ProtocolLookup(s *Lookup, q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+func (mc MockLookupClient) ProtocolLookup(ctx context.Context, s *Lookup, q Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
 	cur_domain_ns := domain_ns{domain: q.Name, ns: nameServer}
 	if res, ok := mockResults[cur_domain_ns]; ok {
 		var status = zdns.STATUS_NOERROR
@@ -90,7 +116,7 @@ func TestOneA(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -102,7 +128,7 @@ func TestOneA(t *testing.T) {
 		Protocol:    "",
 		Flags:       DNSFlags{},
 	}
-	res, _, _, _ := a.DoTargetedLookup(mc, "example.com", ns1, true, false)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, true, false)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.1"}, nil)
 }
 
@@ -115,7 +141,7 @@ func TestTwoA(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -134,7 +160,7 @@ func TestTwoA(t *testing.T) {
 		Protocol:    "",
 		Flags:       DNSFlags{},
 	}
-	res, _, _, _ := a.DoTargetedLookup(mc, domain1, ns1, true, false)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, domain1, ns1, true, false)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.1", "192.0.2.2"}, nil)
 }
 
@@ -147,7 +173,7 @@ func TestQuadAWithoutFlag(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -167,7 +193,7 @@ func TestQuadAWithoutFlag(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 
-	res, _, _, _ := a.DoTargetedLookup(mc, domain1, ns1, true, false)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, domain1, ns1, true, false)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.1"}, nil)
 }
 
@@ -180,7 +206,7 @@ func TestOnlyQuadA(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "AAAA",
 			Class:  "IN",
@@ -193,7 +219,7 @@ func TestOnlyQuadA(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 
-	res, _, _, _ := a.DoTargetedLookup(mc, "example.com", ns1, false, true)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, false, true)
 	verifyResult(t, res.(IpResult), nil, []string{"2001:db8::1"})
 }
 
@@ -206,7 +232,7 @@ func TestAandQuadA(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -225,7 +251,7 @@ func TestAandQuadA(t *testing.T) {
 		Protocol:    "",
 		Flags:       DNSFlags{},
 	}
-	res, _, _, _ := a.DoTargetedLookup(mc, "example.com", ns1, true, true)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, true, true)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.1"}, []string{"2001:db8::1"})
 }
 
@@ -238,7 +264,7 @@ func TestTwoQuadA(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "AAAA",
 			Class:  "IN",
@@ -257,7 +283,7 @@ func TestTwoQuadA(t *testing.T) {
 		Protocol:    "",
 		Flags:       DNSFlags{},
 	}
-	res, _, _, _ := a.DoTargetedLookup(mc, "example.com", ns1, false, true)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, false, true)
 	verifyResult(t, res.(IpResult), nil, []string{"2001:db8::1", "2001:db8::2"})
 }
 
@@ -277,7 +303,7 @@ func TestNoResults(t *testing.T) {
 		Protocol:    "",
 		Flags:       DNSFlags{},
 	}
-	res, _, _, _ := a.DoTargetedLookup(mc, "example.com", ns1, true, true)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, true, true)
 	verifyResult(t, res.(IpResult), nil, nil)
 }
 
@@ -290,7 +316,7 @@ func TestCname(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "CNAME",
 			Class:  "IN",
@@ -308,7 +334,7 @@ func TestCname(t *testing.T) {
 	domain_ns_2 := domain_ns{domain: dom2, ns: ns1}
 
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -320,7 +346,7 @@ func TestCname(t *testing.T) {
 		Protocol:    "",
 		Flags:       DNSFlags{},
 	}
-	res, _, _, _ := a.DoTargetedLookup(mc, "cname.example.com", ns1, true, false)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "cname.example.com", ns1, true, false)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.1"}, nil)
 }
 
@@ -333,7 +359,7 @@ func TestQuadAWithCname(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "AAAA",
 			Class:  "IN",
@@ -352,7 +378,7 @@ func TestQuadAWithCname(t *testing.T) {
 		Protocol:    "",
 		Flags:       DNSFlags{},
 	}
-	res, _, _, _ := a.DoTargetedLookup(mc, "cname.example.com", ns1, false, true)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "cname.example.com", ns1, false, true)
 	verifyResult(t, res.(IpResult), nil, []string{"2001:db8::3"})
 }
 
@@ -365,7 +391,7 @@ func TestUnexpectedMxOnly(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "MX",
 			Class:  "IN",
@@ -378,7 +404,7 @@ func TestUnexpectedMxOnly(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 
-	res, _, status, _ := a.DoTargetedLookup(mc, "example.com", ns1, true, true)
+	res, _, status, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, true, true)
 
 	if status != zdns.STATUS_ERROR {
 		t.Errorf("Expected ERROR status, got %v", status)
@@ -396,14 +422,14 @@ func TestMxAndAdditionals(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "MX",
 			Class:  "IN",
 			Name:   "example.com",
 			Answer: "mail.example.com.",
 		}},
-		Additional: []interface{}{Answer{
+		Additional: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -422,7 +448,7 @@ func TestMxAndAdditionals(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 
-	res, _, _, _ := a.DoTargetedLookup(mc, "example.com", ns1, true, true)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, true, true)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.3"}, []string{"2001:db8::4"})
 }
 
@@ -435,7 +461,7 @@ func TestMismatchIpType(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -448,7 +474,7 @@ func TestMismatchIpType(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 
-	res, _, status, _ := a.DoTargetedLookup(mc, "example.com", ns1, true, true)
+	res, _, status, _ := a.DoTargetedLookup(context.Background(), mc, "example.com", ns1, true, true)
 
 	if status != zdns.STATUS_ERROR {
 		t.Errorf("Expected ERROR status, got %v", status)
@@ -466,7 +492,7 @@ func TestCnameLoops(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "CNAME",
 			Class:  "IN",
@@ -484,7 +510,7 @@ func TestCnameLoops(t *testing.T) {
 	domain_ns_2 := domain_ns{domain: dom2, ns: ns1}
 
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "CNAME",
 			Class:  "IN",
@@ -497,7 +523,7 @@ func TestCnameLoops(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 
-	res, _, status, _ := a.DoTargetedLookup(mc, "cname1.example.com", ns1, true, true)
+	res, _, status, _ := a.DoTargetedLookup(context.Background(), mc, "cname1.example.com", ns1, true, true)
 
 	if status != zdns.STATUS_ERROR {
 		t.Errorf("Expected ERROR status, got %v", status)
@@ -518,7 +544,7 @@ func TestExtendedRecursion(t *testing.T) {
 			ns:     ns1,
 		}
 		mockResults[domain_ns] = Result{
-			Answers: []interface{}{Answer{
+			Answers: []RR{Answer{
 				Ttl:    3600,
 				Type:   "CNAME",
 				Class:  "IN",
@@ -532,7 +558,7 @@ func TestExtendedRecursion(t *testing.T) {
 		}
 	}
 
-	res, _, status, _ := a.DoTargetedLookup(mc, "cname1.example.com", ns1, true, true)
+	res, _, status, _ := a.DoTargetedLookup(context.Background(), mc, "cname1.example.com", ns1, true, true)
 
 	if status != zdns.STATUS_ERROR {
 		t.Errorf("Expected ERROR status, got %v", status)
@@ -550,7 +576,7 @@ func TestEmptyNonTerminal(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -575,11 +601,11 @@ func TestEmptyNonTerminal(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 	// Verify leaf returns correctly
-	res, _, _, _ := a.DoTargetedLookup(mc, "leaf.intermediate.example.com", ns1, true, false)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "leaf.intermediate.example.com", ns1, true, false)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.3"}, nil)
 
 	// Verify empty non-terminal returns no answer
-	res, _, _, _ = a.DoTargetedLookup(mc, "intermediate.example.com", ns1, true, true)
+	res, _, _, _ = a.DoTargetedLookup(context.Background(), mc, "intermediate.example.com", ns1, true, true)
 	verifyResult(t, res.(IpResult), nil, nil)
 }
 
@@ -587,7 +613,7 @@ func TestEmptyNonTerminal(t *testing.T) {
 func TestNXDomain(t *testing.T) {
 	gc, a, mc := InitTest(t)
 	ns1 := net.JoinHostPort(gc.NameServers[0], "53")
-	res, _, status, _ := a.DoTargetedLookup(mc, "nonexistent.example.com", ns1, true, true)
+	res, _, status, _ := a.DoTargetedLookup(context.Background(), mc, "nonexistent.example.com", ns1, true, true)
 	if status != zdns.STATUS_NXDOMAIN {
 		t.Errorf("Expected STATUS_NXDOMAIN status, got %v", status)
 	} else if res != nil {
@@ -608,7 +634,7 @@ func TestAandQuadADedup(t *testing.T) {
 	domain_ns_3 := domain_ns{domain: domain3, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "CNAME",
 			Class:  "IN",
@@ -640,7 +666,7 @@ func TestAandQuadADedup(t *testing.T) {
 	}
 
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "CNAME",
 			Class:  "IN",
@@ -666,7 +692,7 @@ func TestAandQuadADedup(t *testing.T) {
 	}
 
 	mockResults[domain_ns_3] = Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "A",
 			Class:  "IN",
@@ -685,7 +711,7 @@ func TestAandQuadADedup(t *testing.T) {
 		Flags:       DNSFlags{},
 	}
 
-	res, _, _, _ := a.DoTargetedLookup(mc, domain1, ns1, true, true)
+	res, _, _, _ := a.DoTargetedLookup(context.Background(), mc, domain1, ns1, true, true)
 	verifyResult(t, res.(IpResult), []string{"192.0.2.1"}, []string{"2001:db8::3"})
 }
 
@@ -701,7 +727,7 @@ func TestServFail(t *testing.T) {
 	name := "example.com"
 	protocolStatus[domain_ns_1] = zdns.STATUS_SERVFAIL
 
-	res, _, final_status, _ := a.DoTargetedLookup(mc, name, ns1, true, true)
+	res, _, final_status, _ := a.DoTargetedLookup(context.Background(), mc, name, ns1, true, true)
 
 	if final_status != protocolStatus[domain_ns_1] {
 		t.Errorf("Expected %v status, got %v", protocolStatus, final_status)
@@ -721,7 +747,7 @@ func TestNsAInAdditional(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -730,7 +756,7 @@ func TestNsAInAdditional(t *testing.T) {
 				Answer: "ns1.example.com.",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -749,7 +775,7 @@ func TestNsAInAdditional(t *testing.T) {
 		IPv4Addresses: []string{"192.0.2.3"},
 		IPv6Addresses: nil,
 	}
-	res, _, _, _ := a.DoNSLookup(mc, "example.com", lookupIpv4, lookupIpv6, ns1)
+	res, _, _, _ := a.DoNSLookup(context.Background(), mc, "example.com", lookupIpv4, lookupIpv6, ns1)
 	verifyNsResult(t, res.Servers, expectedServersMap)
 }
 
@@ -763,7 +789,7 @@ func TestTwoNSInAdditional(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -779,7 +805,7 @@ func TestTwoNSInAdditional(t *testing.T) {
 				Answer: "ns2.example.com.",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -809,7 +835,7 @@ func TestTwoNSInAdditional(t *testing.T) {
 		IPv4Addresses: []string{"192.0.2.4"},
 		IPv6Addresses: nil,
 	}
-	res, _, _, _ := a.DoNSLookup(mc, "example.com", lookupIpv4, lookupIpv6, ns1)
+	res, _, _, _ := a.DoNSLookup(context.Background(), mc, "example.com", lookupIpv4, lookupIpv6, ns1)
 	verifyNsResult(t, res.Servers, expectedServersMap)
 }
 
@@ -823,7 +849,7 @@ func TestAandQuadAInAdditional(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -832,7 +858,7 @@ func TestAandQuadAInAdditional(t *testing.T) {
 				Answer: "ns1.example.com.",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -858,7 +884,7 @@ func TestAandQuadAInAdditional(t *testing.T) {
 		IPv4Addresses: []string{"192.0.2.3"},
 		IPv6Addresses: []string{"2001:db8::4"},
 	}
-	res, _, _, _ := a.DoNSLookup(mc, "example.com", lookupIpv4, lookupIpv6, ns1)
+	res, _, _, _ := a.DoNSLookup(context.Background(), mc, "example.com", lookupIpv4, lookupIpv6, ns1)
 	verifyNsResult(t, res.Servers, expectedServersMap)
 }
 
@@ -872,7 +898,7 @@ func TestNsMismatchIpType(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -881,7 +907,7 @@ func TestNsMismatchIpType(t *testing.T) {
 				Answer: "ns1.example.com.",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "AAAA",
@@ -907,7 +933,7 @@ func TestNsMismatchIpType(t *testing.T) {
 		IPv4Addresses: nil,
 		IPv6Addresses: nil,
 	}
-	res, _, _, _ := a.DoNSLookup(mc, "example.com", lookupIpv4, lookupIpv6, ns1)
+	res, _, _, _ := a.DoNSLookup(context.Background(), mc, "example.com", lookupIpv4, lookupIpv6, ns1)
 	verifyNsResult(t, res.Servers, expectedServersMap)
 }
 
@@ -921,7 +947,7 @@ func TestAandQuadALookup(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -941,7 +967,7 @@ func TestAandQuadALookup(t *testing.T) {
 	domain_ns_2 := domain_ns{domain: dom2, ns: ns1}
 
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -968,7 +994,7 @@ func TestAandQuadALookup(t *testing.T) {
 		IPv4Addresses: []string{"192.0.2.3"},
 		IPv6Addresses: []string{"2001:db8::4"},
 	}
-	res, _, _, _ := a.DoNSLookup(mc, "example.com", lookupIpv4, lookupIpv6, ns1)
+	res, _, _, _ := a.DoNSLookup(context.Background(), mc, "example.com", lookupIpv4, lookupIpv6, ns1)
 	verifyNsResult(t, res.Servers, expectedServersMap)
 }
 
@@ -979,7 +1005,7 @@ func TestNsNXDomain(t *testing.T) {
 
 	ns1 := net.JoinHostPort(gc.NameServers[0], "53")
 
-	_, _, status, _ := a.DoNSLookup(mc, "nonexistent.example.com", lookupIpv4, lookupIpv6, ns1)
+	_, _, status, _ := a.DoNSLookup(context.Background(), mc, "nonexistent.example.com", lookupIpv4, lookupIpv6, ns1)
 
 	assert.Equal(t, status, zdns.STATUS_NXDOMAIN)
 }
@@ -996,7 +1022,7 @@ func TestNsServFail(t *testing.T) {
 	mockResults[domain_ns_1] = Result{}
 	protocolStatus[domain_ns_1] = zdns.STATUS_SERVFAIL
 
-	res, _, status, _ := a.DoNSLookup(mc, "example.com", lookupIpv4, lookupIpv6, ns1)
+	res, _, status, _ := a.DoNSLookup(context.Background(), mc, "example.com", lookupIpv4, lookupIpv6, ns1)
 	serversLength := len(res.Servers)
 
 	assert.Equal(t, status, protocolStatus[domain_ns_1])
@@ -1013,7 +1039,7 @@ func TestErrorInTargetedLookup(t *testing.T) {
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -1030,7 +1056,7 @@ func TestErrorInTargetedLookup(t *testing.T) {
 
 	protocolStatus[domain_ns_1] = zdns.STATUS_ERROR
 
-	res, _, status, _ := a.DoNSLookup(mc, "example.com", lookupIpv4, lookupIpv6, ns1)
+	res, _, status, _ := a.DoNSLookup(context.Background(), mc, "example.com", lookupIpv4, lookupIpv6, ns1)
 	assert.Equal(t, len(res.Servers), 0)
 	assert.Equal(t, status, protocolStatus[domain_ns_1])
 }
@@ -1047,7 +1073,7 @@ func TestAllNsLookupOneNs(t *testing.T) {
 
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -1056,7 +1082,7 @@ func TestAllNsLookupOneNs(t *testing.T) {
 				Answer: ns_domain1 + ".",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1081,7 +1107,7 @@ func TestAllNsLookupOneNs(t *testing.T) {
 	domain_ns_2 := domain_ns{domain: domain1, ns: ns2}
 	ipv4_2 := "192.0.2.1"
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1100,7 +1126,7 @@ func TestAllNsLookupOneNs(t *testing.T) {
 	domain_ns_3 := domain_ns{domain: domain1, ns: ns3}
 	ipv4_3 := "192.0.2.2"
 	mockResults[domain_ns_3] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1128,7 +1154,7 @@ func TestAllNsLookupOneNs(t *testing.T) {
 		},
 	}
 
-	res, _, _, _ := a.DoLookupAllNameservers(mc, "example.com", ns1)
+	res, _, _, _ := a.DoLookupAllNameservers(context.Background(), mc, "example.com", ns1)
 	verifyCombinedResult(t, res.(CombinedResult).Results, expectedRes)
 }
 
@@ -1144,7 +1170,7 @@ func TestAllNsLookupOneNsMultipleIps(t *testing.T) {
 
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -1153,7 +1179,7 @@ func TestAllNsLookupOneNsMultipleIps(t *testing.T) {
 				Answer: ns_domain1 + ".",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1179,7 +1205,7 @@ func TestAllNsLookupOneNsMultipleIps(t *testing.T) {
 	ipv4_3 := "192.0.2.3"
 	ipv6_1 := "2001:db8::1"
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1206,7 +1232,7 @@ func TestAllNsLookupOneNsMultipleIps(t *testing.T) {
 	ipv4_4 := "192.0.2.4"
 	ipv6_2 := "2001:db8::2"
 	mockResults[domain_ns_3] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1241,7 +1267,7 @@ func TestAllNsLookupOneNsMultipleIps(t *testing.T) {
 		},
 	}
 
-	res, _, _, _ := a.DoLookupAllNameservers(mc, "example.com", ns1)
+	res, _, _, _ := a.DoLookupAllNameservers(context.Background(), mc, "example.com", ns1)
 	verifyCombinedResult(t, res.(CombinedResult).Results, expectedRes)
 }
 
@@ -1258,7 +1284,7 @@ func TestAllNsLookupTwoNs(t *testing.T) {
 
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -1274,7 +1300,7 @@ func TestAllNsLookupTwoNs(t *testing.T) {
 				Answer: ns_domain2 + ".",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1299,7 +1325,7 @@ func TestAllNsLookupTwoNs(t *testing.T) {
 	domain_ns_2 := domain_ns{domain: domain1, ns: ns2}
 	ipv4_3 := "192.0.2.3"
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1318,7 +1344,7 @@ func TestAllNsLookupTwoNs(t *testing.T) {
 	domain_ns_3 := domain_ns{domain: domain1, ns: ns3}
 	ipv4_4 := "192.0.2.4"
 	mockResults[domain_ns_3] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1346,7 +1372,7 @@ func TestAllNsLookupTwoNs(t *testing.T) {
 		},
 	}
 
-	res, _, _, _ := a.DoLookupAllNameservers(mc, "example.com", ns1)
+	res, _, _, _ := a.DoLookupAllNameservers(context.Background(), mc, "example.com", ns1)
 	verifyCombinedResult(t, res.(CombinedResult).Results, expectedRes)
 }
 
@@ -1362,7 +1388,7 @@ func TestAllNsLookupErrorInOne(t *testing.T) {
 
 	domain_ns_1 := domain_ns{domain: domain1, ns: ns1}
 	mockResults[domain_ns_1] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "NS",
@@ -1371,7 +1397,7 @@ func TestAllNsLookupErrorInOne(t *testing.T) {
 				Answer: ns_domain1 + ".",
 			},
 		},
-		Additional: []interface{}{
+		Additional: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1397,7 +1423,7 @@ func TestAllNsLookupErrorInOne(t *testing.T) {
 	ipv4_3 := "192.0.2.3"
 	ipv6_1 := "2001:db8::1"
 	mockResults[domain_ns_2] = Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "A",
@@ -1437,7 +1463,7 @@ func TestAllNsLookupErrorInOne(t *testing.T) {
 		},
 	}
 
-	res, _, _, _ := a.DoLookupAllNameservers(mc, "example.com", ns1)
+	res, _, _, _ := a.DoLookupAllNameservers(context.Background(), mc, "example.com", ns1)
 	verifyCombinedResult(t, res.(CombinedResult).Results, expectedRes)
 }
 
@@ -1445,7 +1471,7 @@ func TestAllNsLookupNXDomain(t *testing.T) {
 	gc, a, mc := InitTest(t)
 
 	ns1 := net.JoinHostPort(gc.NameServers[0], "53")
-	res, _, status, _ := a.DoLookupAllNameservers(mc, "example.com", ns1)
+	res, _, status, _ := a.DoLookupAllNameservers(context.Background(), mc, "example.com", ns1)
 
 	assert.Equal(t, status, zdns.STATUS_NXDOMAIN)
 	assert.Equal(t, res, nil)
@@ -1461,7 +1487,7 @@ func TestAllNsLookupServFail(t *testing.T) {
 	protocolStatus[domain_ns_1] = zdns.STATUS_SERVFAIL
 	mockResults[domain_ns_1] = Result{}
 
-	res, _, status, _ := a.DoLookupAllNameservers(mc, "example.com", ns1)
+	res, _, status, _ := a.DoLookupAllNameservers(context.Background(), mc, "example.com", ns1)
 
 	assert.Equal(t, status, zdns.STATUS_SERVFAIL)
 	assert.Equal(t, res, nil)
@@ -1560,6 +1586,25 @@ func TestParseAnswer(t *testing.T) {
 	res = ParseAnswer(rr)
 	verifyAnswer(t, res, rr, "::192.0.2.1")
 
+	// only addresses with 12 zero bytes are IPv4-compatible. This one used
+	// to be written as ::192.0.2.1, losing its 12th byte.
+	rr = &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name:     "ipv6.example.com",
+			Rrtype:   dns.TypeAAAA,
+			Class:    dns.ClassINET,
+			Ttl:      7200,
+			Rdlength: 16,
+		},
+		AAAA: net.ParseIP("::ff:192.0.2.1"),
+	}
+
+	res = ParseAnswer(rr)
+	verifyAnswer(t, res, rr, "::ff:c000:201")
+	back, err := res.ToRR()
+	assert.NilError(t, err)
+	assert.Assert(t, back.(*dns.AAAA).AAAA.Equal(rr.(*dns.AAAA).AAAA))
+
 	// IPv4 in AAAA record gets prepended by ::ffff:
 	rr = &dns.AAAA{
 		Hdr: dns.RR_Header{
@@ -1918,7 +1963,7 @@ func TestLookup_DoTxtLookup_1(t *testing.T) {
 	testRegexp := regexp.MustCompile(".*")
 	var txtRecord = Lookup{Factory: &RoutineLookupFactory{PrefixRegexp: testRegexp}}
 	input := Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "TXT",
 			Class:  "IN",
@@ -1940,7 +1985,7 @@ func TestLookup_DoTxtLookup_2(t *testing.T) {
 	testRegexp := regexp.MustCompile("^google-site-verification=.*")
 	var txtRecord = Lookup{Factory: &RoutineLookupFactory{PrefixRegexp: testRegexp}}
 	input := Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "TXT",
@@ -1969,7 +2014,7 @@ func TestLookup_DoTxtLookup_3(t *testing.T) {
 	testRegexp := regexp.MustCompile("(?i)^v=spf1.*")
 	var txtRecord = Lookup{Factory: &RoutineLookupFactory{PrefixRegexp: testRegexp}}
 	input := Result{
-		Answers: []interface{}{
+		Answers: []RR{
 			Answer{
 				Ttl:    3600,
 				Type:   "TXT",
@@ -1997,7 +2042,7 @@ func TestLookup_DoTxtLookup_4(t *testing.T) {
 	testRegexp := regexp.MustCompile("(?i)^v=spf1.*")
 	var txtRecord = Lookup{Factory: &RoutineLookupFactory{PrefixRegexp: testRegexp}}
 	input := Result{
-		Answers: []interface{}{},
+		Answers: []RR{},
 	}
 	resultString, err := txtRecord.FindTxtRecord(input)
 	assert.Error(t, err, "no such TXT record found")
@@ -2007,7 +2052,7 @@ func TestLookup_DoTxtLookup_4(t *testing.T) {
 func TestLookup_DoTxtLookup_5(t *testing.T) {
 	var txtRecord = Lookup{Factory: &RoutineLookupFactory{}}
 	input := Result{
-		Answers: []interface{}{Answer{
+		Answers: []RR{Answer{
 			Ttl:    3600,
 			Type:   "TXT",
 			Class:  "IN",
@@ -2020,6 +2065,817 @@ func TestLookup_DoTxtLookup_5(t *testing.T) {
 	assert.Equal(t, "google-site-verification=A2WZWCNQHrGV_TWwKh7KHY90UY0SHZo_rnyMJoDaG0s", resultString)
 }
 
+// Test that cancelling the context aborts an exchange with a silent server
+// well before the client timeout expires
+func TestDoLookupWorkerCancelled(t *testing.T) {
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NilError(t, err)
+	defer silent.Close()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	udp := new(dns.Client)
+	udp.Timeout = 10 * time.Second
+	q := Question{Name: "example.com", Type: dns.TypeA, Class: dns.ClassINET}
+
+	for _, recycled := range []*dns.Conn{{Conn: conn}, nil} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		_, status, err := DoLookupWorker(ctx, WorkerOptions{UDP: udp, Conn: recycled}, q, silent.LocalAddr().String(), true)
+		assert.Equal(t, status, zdns.STATUS_CANCELLED)
+		assert.ErrorIs(t, err, context.Canceled)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Cancelled lookup took %v to return", elapsed)
+		}
+	}
+}
+
+// serveOneA answers a single query received on pc with an A record
+func serveOneA(t *testing.T, pc net.PacketConn) {
+	buf := make([]byte, 512)
+	n, addr, err := pc.ReadFrom(buf)
+	if err != nil {
+		return
+	}
+	q := new(dns.Msg)
+	if err := q.Unpack(buf[:n]); err != nil {
+		t.Error(err)
+		return
+	}
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Answer = append(r.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.IPv4(192, 0, 2, 1),
+	})
+	out, _ := r.Pack()
+	pc.WriteTo(out, addr)
+}
+
+// Test that the time a query is held back by the rate limiter doesn't count
+// towards its round trip time
+func TestDoLookupWorkerRTT(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NilError(t, err)
+	defer server.Close()
+
+	udp := new(dns.Client)
+	udp.Timeout = 2 * time.Second
+	opts := WorkerOptions{UDP: udp, Limiter: zdns.NewRateLimiter(0, 2)}
+	q := Question{Name: "example.com", Type: dns.TypeA, Class: dns.ClassINET}
+	for i := 0; i < 2; i++ {
+		go serveOneA(t, server)
+		start := time.Now()
+		_, status, rtt, err := doLookupWorker(context.Background(), opts, q, server.LocalAddr().String(), true)
+		elapsed := time.Since(start)
+		assert.NilError(t, err)
+		assert.Equal(t, status, zdns.STATUS_NOERROR)
+		assert.Assert(t, rtt <= elapsed)
+		if i == 1 {
+			// the second query waits half a second for the limiter
+			assert.Assert(t, elapsed >= 400*time.Millisecond, elapsed)
+			assert.Assert(t, rtt < 200*time.Millisecond, rtt)
+		}
+	}
+}
+
+func TestDoLookupWorkerDnstap(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NilError(t, err)
+	defer server.Close()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	path := filepath.Join(t.TempDir(), "zdns.dnstap")
+	dt, err := zdns.NewDnstapWriter(path)
+	assert.NilError(t, err)
+	udp := new(dns.Client)
+	udp.Timeout = 2 * time.Second
+	q := Question{Name: "example.com", Type: dns.TypeA, Class: dns.ClassINET}
+	for _, recycled := range []*dns.Conn{{Conn: conn}, nil} {
+		go serveOneA(t, server)
+		res, status, err := DoLookupWorker(context.Background(), WorkerOptions{UDP: udp, Conn: recycled, WireTap: dt}, q, server.LocalAddr().String(), true)
+		assert.NilError(t, err)
+		assert.Equal(t, status, zdns.STATUS_NOERROR)
+		assert.Equal(t, len(res.Answers), 1)
+	}
+	assert.NilError(t, dt.Close())
+
+	f, err := os.Open(path)
+	assert.NilError(t, err)
+	defer f.Close()
+	r, err := dnstap.NewReader(f, nil)
+	assert.NilError(t, err)
+	dec := dnstap.NewDecoder(r, 1<<16)
+	for i := 0; i < 2; i++ {
+		var d dnstap.Dnstap
+		assert.NilError(t, dec.Decode(&d))
+		m := d.GetMessage()
+		assert.Equal(t, m.GetType(), dnstap.Message_TOOL_RESPONSE)
+		assert.Equal(t, m.GetSocketFamily(), dnstap.SocketFamily_INET)
+		assert.Equal(t, m.GetSocketProtocol(), dnstap.SocketProtocol_UDP)
+		assert.DeepEqual(t, net.IP(m.GetResponseAddress()), server.LocalAddr().(*net.UDPAddr).IP.To4())
+		assert.Equal(t, int(m.GetResponsePort()), server.LocalAddr().(*net.UDPAddr).Port)
+		assert.DeepEqual(t, net.IP(m.GetQueryAddress()), net.IPv4(127, 0, 0, 1).To4())
+		assert.Assert(t, m.GetQueryTimeSec() > 0)
+		assert.Assert(t, m.GetResponseTimeSec() >= m.GetQueryTimeSec())
+
+		query, response := new(dns.Msg), new(dns.Msg)
+		assert.NilError(t, query.Unpack(m.GetQueryMessage()))
+		assert.NilError(t, response.Unpack(m.GetResponseMessage()))
+		assert.Equal(t, query.Question[0].Name, "example.com.")
+		assert.Equal(t, response.Id, query.Id)
+		assert.Equal(t, len(response.Answer), 1)
+	}
+	var d dnstap.Dnstap
+	assert.ErrorIs(t, dec.Decode(&d), io.EOF)
+}
+
+// countingListener keeps the connections it accepted
+type countingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, c)
+		l.mu.Unlock()
+	}
+	return c, err
+}
+
+func (l *countingListener) accepted() []net.Conn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]net.Conn(nil), l.conns...)
+}
+
+// newTestCert returns a self-signed certificate for dns.example and
+// 127.0.0.1 and its key
+func newTestCert(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	assert.NilError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dns.example"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"dns.example"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, &key.PublicKey, key)
+	assert.NilError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NilError(t, err)
+	return cert, key
+}
+
+// serveTLS starts a DNS-over-TLS server on 127.0.0.1 answering every query
+// with an A record, with a certificate of newTestCert followed by chain
+func serveTLS(t *testing.T, chain ...*x509.Certificate) (*countingListener, *x509.Certificate) {
+	cert, key := newTestCert(t)
+	certs := [][]byte{cert.Raw}
+	for _, c := range chain {
+		certs = append(certs, c.Raw)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	cl := &countingListener{Listener: ln}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: certs, PrivateKey: key}}}
+	server := &dns.Server{
+		Listener: tls.NewListener(cl, config),
+		Net:      "tcp-tls",
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+			r := new(dns.Msg)
+			r.SetReply(q)
+			r.Answer = append(r.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IPv4(192, 0, 2, 1),
+			})
+			w.WriteMsg(r)
+		}),
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return cl, cert
+}
+
+func TestDoLookupWorkerTLS(t *testing.T) {
+	ln, cert := serveTLS(t)
+	nameServer := zdns.TLSScheme + ln.Addr().String()
+	q := Question{Name: "example.com", Type: dns.TypeA, Class: dns.ClassINET}
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	dot := NewTLSClient(zdns.NewTLSConfig("", "", nil, nil), 2*time.Second, nil)
+	for i := 0; i < 2; i++ {
+		res, status, err := DoLookupWorker(context.Background(), WorkerOptions{TLS: dot}, q, nameServer, true)
+		assert.NilError(t, err)
+		assert.Equal(t, status, zdns.STATUS_NOERROR)
+		assert.Equal(t, res.Protocol, "tls")
+		assert.Equal(t, res.Resolver, nameServer)
+		assert.Equal(t, res.Answers[0].AsA().String(), "192.0.2.1")
+		assert.Equal(t, res.TLS.Version, "TLS 1.3")
+		assert.Equal(t, len(res.TLS.Certificates), 1)
+		c := res.TLS.Certificates[0]
+		assert.Equal(t, c.Subject, "CN=dns.example")
+		assert.DeepEqual(t, c.DNSNames, []string{"dns.example"})
+		assert.DeepEqual(t, c.IPAddresses, []string{"127.0.0.1"})
+		assert.Equal(t, c.SPKISHA256, base64.StdEncoding.EncodeToString(spki[:]))
+	}
+	// the connection is reused
+	assert.Equal(t, len(ln.accepted()), 1)
+
+	// and made again once the name server closes it
+	ln.accepted()[0].Close()
+	_, status, err := DoLookupWorker(context.Background(), WorkerOptions{TLS: dot}, q, nameServer, true)
+	assert.NilError(t, err)
+	assert.Equal(t, status, zdns.STATUS_NOERROR)
+	assert.Equal(t, len(ln.accepted()), 2)
+
+	// closing the client drops its idle connection
+	assert.NilError(t, dot.Close())
+	_, status, err = DoLookupWorker(context.Background(), WorkerOptions{TLS: dot}, q, nameServer, true)
+	assert.NilError(t, err)
+	assert.Equal(t, status, zdns.STATUS_NOERROR)
+	assert.Equal(t, len(ln.accepted()), 3)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	otherPin := sha256.Sum256([]byte("other"))
+	tests := []struct {
+		authName string
+		roots    *x509.CertPool
+		pins     [][]byte
+		status   zdns.Status
+	}{
+		// the name defaults to the address of the name server
+		{"", roots, nil, zdns.STATUS_NOERROR},
+		{"dns.example", roots, nil, zdns.STATUS_NOERROR},
+		{"other.example", roots, nil, zdns.STATUS_ERROR},
+		// the certificate isn't signed by a system CA
+		{"dns.example", nil, nil, zdns.STATUS_ERROR},
+		{"", nil, [][]byte{otherPin[:], spki[:]}, zdns.STATUS_NOERROR},
+		{"", nil, [][]byte{otherPin[:]}, zdns.STATUS_ERROR},
+	}
+	for _, test := range tests {
+		dot := NewTLSClient(zdns.NewTLSConfig("", test.authName, test.roots, test.pins), 2*time.Second, nil)
+		_, status, _ := DoLookupWorker(context.Background(), WorkerOptions{TLS: dot}, q, nameServer, true)
+		assert.Equal(t, status, test.status, "auth name %q, pins %d", test.authName, len(test.pins))
+	}
+
+	// a name server can't pass for another by sending the certificate of
+	// that one after its own, which it has no key of
+	ln, impostor := serveTLS(t, cert)
+	nameServer = zdns.TLSScheme + ln.Addr().String()
+	roots = x509.NewCertPool()
+	roots.AddCert(impostor)
+	for _, roots := range []*x509.CertPool{nil, roots} {
+		dot := NewTLSClient(zdns.NewTLSConfig("", "", roots, [][]byte{spki[:]}), 2*time.Second, nil)
+		_, status, err := DoLookupWorker(context.Background(), WorkerOptions{TLS: dot}, q, nameServer, true)
+		assert.Equal(t, status, zdns.STATUS_ERROR)
+		assert.ErrorContains(t, err, "SPKI pins")
+	}
+}
+
+// testRecords has a record of every type ParseAnswer knows that has a
+// presentation format, and a few it doesn't
+var testRecords = []string{
+	"example.com. 60 IN A 192.0.2.1",
+	"example.com. 60 IN AAAA 2001:db8::1",
+	"example.com. 60 IN AAAA ::ffff:192.0.2.1",
+	"example.com. 60 IN NS ns1.example.com.",
+	"example.com. 60 IN CNAME x.example.com.",
+	"example.com. 60 IN DNAME x.example.com.",
+	"1.2.0.192.in-addr.arpa. 60 IN PTR x.example.com.",
+	"example.com. 60 IN MX 10 mail.example.com.",
+	"example.com. 60 IN SOA ns1.example.com. hostmaster.example.com. 1 2 3 4 5",
+	`example.com. 60 IN TXT "v=spf1 -all" "second \"q\" \\ x\010y"`,
+	`example.com. 60 IN SPF "v=spf1 -all"`,
+	`example.com. 60 IN CAA 0 issue "letsencrypt.org"`,
+	"_sip._tcp.example.com. 60 IN SRV 10 20 5060 sip.example.com.",
+	"example.com. 60 IN DS 12345 8 2 49FD46E6C4B45C55D4AC69CBD3CD34AC1AFE51DE",
+	"example.com. 60 IN CDS 12345 8 2 49FD46E6C4B45C55D4AC69CBD3CD34AC1AFE51DE",
+	"example.com. 60 IN RRSIG A 8 2 60 20240101000000 20231201000000 12345 example.com. dGVzdA==",
+	"example.com. 60 IN TLSA 3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6",
+	"example.com. 60 IN SMIMEA 3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6",
+	"example.com. 60 IN NSEC next.example.com. A NS SOA RRSIG NSEC",
+	"example.com. 60 IN NSEC3 1 0 10 AABB 2VPTU5TIMAMQTTGL4LUU9KG21E0AOR3S A RRSIG",
+	"example.com. 60 IN NSEC3 1 0 0 - 2VPTU5TIMAMQTTGL4LUU9KG21E0AOR3S A",
+	"example.com. 60 IN NSEC3PARAM 1 0 10 AABB",
+	`example.com. 60 IN NAPTR 100 10 "U" "E2U+sip" "!^.*$!sip:info@example.com!" .`,
+	`example.com. 60 IN HINFO "INTEL" "LINUX"`,
+	"example.com. 60 IN MINFO rmail.example.com. email.example.com.",
+	"example.com. 60 IN DNSKEY 257 3 8 AwEAAag=",
+	"example.com. 60 IN CDNSKEY 257 3 8 AwEAAag=",
+	"example.com. 60 IN AFSDB 1 afs.example.com.",
+	"example.com. 60 IN RT 10 relay.example.com.",
+	"example.com. 60 IN KX 10 kx.example.com.",
+	"example.com. 60 IN NID 10 0014:4fff:ff20:ee64",
+	"example.com. 60 IN L32 10 10.1.2.0",
+	"example.com. 60 IN L64 10 2001:0DB8:1140:1000",
+	"example.com. 60 IN LP 10 l64.example.com.",
+	"example.com. 60 IN CERT PKIX 12345 RSASHA256 dGVzdA==",
+	"example.com. 60 IN PX 10 map822.example.com. mapx400.example.com.",
+	"example.com. 60 IN GPOS -32.6882 116.8652 10.0",
+	"example.com. 60 IN LOC 52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m",
+	"example.com. 60 IN HIP 2 200100107B1A74DF365639CC39F1D578 AwEAAbdxyhNuSutc5EMzxTs9LBPCIkOFH8cIvM4p9+LrV4e19WzK00+CI6zBCQTdtWsuxKbWIy87UOoJTwkUs7lBu+Upr1gsNrut79ryra+bSRGQb1slImA8YVJyuIDsj7kwzG7jnERNqnWxZ48AWkskmdHaVDP4BcelrTI3rMXdXF5D rvs.example.com.",
+	"example.com. 60 IN SSHFP 1 1 DEADBEEF",
+	"example.com. 60 IN TALINK prev.example.com. next.example.com.",
+	"example.com. 60 IN EUI48 00-00-5e-00-53-2a",
+	"example.com. 60 IN EUI64 00-00-5e-ef-10-00-00-2a",
+	"example.com. 60 IN UID 1234",
+	"example.com. 60 IN GID 1234",
+	`example.com. 60 IN UINFO "hi there"`,
+	"example.com. 60 IN X25 311061700956",
+	"example.com. 60 IN OPENPGPKEY dGVzdA==",
+	"example.com. 60 IN DHCID AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA=",
+	"example.com. 60 IN EID 112233",
+	"example.com. 60 IN NIMLOC 112233",
+	`example.com. 60 IN AVC "app-name:WebEx" "app-class:OAM"`,
+	`example.com. 60 IN NINFO "a" "b"`,
+	"example.com. 60 IN MB mb.example.com.",
+	"example.com. 60 IN MG mg.example.com.",
+	"example.com. 60 IN MF mf.example.com.",
+	"example.com. 60 IN MD md.example.com.",
+	"example.com. 60 IN NSAP-PTR nsap.example.com.",
+	`example.com. 60 IN SVCB 1 svc.example.com. mandatory="alpn" alpn="h2,h3" port="8443" ipv4hint="192.0.2.1,192.0.2.2" ipv6hint="2001:db8::1"`,
+	`example.com. 60 IN HTTPS 1 . alpn="h2" no-default-alpn echconfig="AEX+DQBBpQAgACB/RU9jlA==" key65333="ex1"`,
+	`example.com. 60 IN URI 10 1 "ftp://ftp1.example.com/public"`,
+	"example.com. 60 IN APL 1:192.168.32.0/21 !1:192.168.38.0/28",
+	"example.com. 60 IN NULL \\# 3 616263",
+	"example.com. 60 IN MR mr.example.com.",
+	"example.com. 60 IN RP mbox.example.com. txt.example.com.",
+	"example.com. 60 IN KEY 256 3 8 AwEAAag=",
+	"example.com. 60 IN RKEY 256 3 8 AwEAAag=",
+	"example.com. 60 IN DLV 12345 8 2 49FD46E6C4B45C55D4AC69CBD3CD34AC1AFE51DE",
+	"example.com. 60 IN TA 12345 8 2 49FD46E6C4B45C55D4AC69CBD3CD34AC1AFE51DE",
+	"example.com. 60 IN CSYNC 66 3 A NS AAAA",
+	"example.com. 60 IN ZONEMD 2018031500 1 1 FEBE3D4CE2EC2FFA4BA99D46CD69D6D29711E55217057BEE7EB1A7B641A47BA7FED2DD5B97AE499FAFA4F22C6BD647DE",
+	"example.com. 60 IN TYPE999 \\# 3 616263",
+	"example.com. 60 IN TYPE45 \\# 4 0a0b0c0d",
+}
+
+// Test that records written in presentation format from what ParseAnswer
+// keeps parse back into the original record
+func TestZoneRdata(t *testing.T) {
+	for _, s := range testRecords {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		rdata, ok := ZoneRdata(ParseAnswer(rr))
+		if !ok {
+			t.Errorf("%s: no rdata", s)
+			continue
+		}
+		line := rr.Header().String() + rdata
+		back, err := dns.NewRR(line)
+		if err != nil {
+			t.Errorf("%s: %q: %v", s, line, err)
+			continue
+		}
+		if back.String() != rr.String() {
+			t.Errorf("mismatch\n %s\n %s\n via %q", rr, back, rdata)
+		}
+	}
+}
+
+// Test that the answer structs ParseAnswer returns give the header of the
+// record they were made of
+func TestRR(t *testing.T) {
+	for _, typ := range AnswerTypes {
+		assert.Assert(t, typ.Implements(reflect.TypeOf((*RR)(nil)).Elem()), "%s", typ)
+	}
+	for _, s := range testRecords {
+		rr, err := dns.NewRR(s)
+		assert.NilError(t, err)
+		ans := ParseAnswer(rr)
+		hdr := *rr.Header()
+		hdr.Rdlength = 0
+		assert.Equal(t, ans.Header(), hdr, s)
+		assert.Equal(t, ans.RRType(), hdr.Rrtype, s)
+		assert.Equal(t, ans.TTL(), uint32(60), s)
+		assert.Equal(t, ans.Owner(), strings.TrimSuffix(hdr.Name, "."), s)
+		switch hdr.Rrtype {
+		case dns.TypeA, dns.TypeAAAA:
+			assert.Assert(t, ans.AsA().IsValid(), s)
+		default:
+			assert.Assert(t, !ans.AsA().IsValid(), s)
+		}
+		_, isMX := ans.AsMX()
+		assert.Equal(t, isMX, hdr.Rrtype == dns.TypeMX, s)
+		switch hdr.Rrtype {
+		case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeNS, dns.TypePTR, dns.TypeTXT:
+			assert.Assert(t, ans.Data() != "", s)
+		case dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeDS, dns.TypeSOA:
+			assert.Equal(t, ans.Data(), "", s)
+		}
+	}
+
+	a := ParseAnswer(&dns.AAAA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("::ffff:192.0.2.1")})
+	assert.Equal(t, a.AsA(), netip.MustParseAddr("::ffff:192.0.2.1"))
+	mx, ok := ParseAnswer(&dns.MX{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET}, Preference: 10, Mx: "mail.example.com."}).AsMX()
+	assert.Assert(t, ok)
+	assert.Equal(t, mx.Preference, uint16(10))
+	assert.Equal(t, mx.Answer.Answer, "mail.example.com.")
+
+	opt := new(dns.OPT)
+	opt.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}
+	opt.SetUDPSize(1232)
+	opt.SetDo()
+	edns := ParseAnswer(opt)
+	assert.Equal(t, edns.Header(), opt.Hdr)
+	assert.Equal(t, edns.Owner(), "")
+	assert.Equal(t, edns.Data(), "")
+}
+
+// Test that the records signing an answer don't hide it from lookups that
+// look at what they need through the RR accessors
+func TestLookupsWithSignatures(t *testing.T) {
+	gc, a, mc := InitTest(t)
+	ns1 := net.JoinHostPort(gc.NameServers[0], "53")
+	sig := func(covered uint16, name string) RR {
+		return ParseAnswer(&dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+			TypeCovered: covered,
+			Algorithm:   dns.ECDSAP256SHA256,
+			SignerName:  "example.com.",
+			Signature:   "c2ln",
+		})
+	}
+	mockResults[domain_ns{domain: "example.com", ns: ns1}] = Result{
+		Answers: []RR{
+			sig(dns.TypeNS, "example.com."),
+			Answer{Ttl: 3600, Type: "NS", Class: "IN", Name: "example.com.", Answer: "ns1.example.com."},
+		},
+		Additional: []RR{
+			sig(dns.TypeA, "ns1.example.com."),
+			Answer{Ttl: 3600, Type: "A", Class: "IN", Name: "ns1.example.com.", Answer: "192.0.2.3"},
+			ParseAnswer(&dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}),
+		},
+	}
+	res, _, status, err := a.DoNSLookup(context.Background(), mc, "example.com", true, false, ns1)
+	assert.NilError(t, err)
+	assert.Equal(t, status, zdns.STATUS_NOERROR)
+	assert.Equal(t, len(res.Servers), 1)
+	assert.Equal(t, res.Servers[0].Name, "ns1.example.com")
+	assert.Equal(t, res.Servers[0].TTL, uint32(3600))
+	assert.DeepEqual(t, res.Servers[0].IPv4Addresses, []string{"192.0.2.3"})
+
+	mockResults[domain_ns{domain: "www.example.com", ns: ns1}] = Result{
+		Answers: []RR{
+			Answer{Ttl: 3600, Type: "A", Class: "IN", Name: "www.example.com.", Answer: "192.0.2.1"},
+			sig(dns.TypeA, "www.example.com."),
+		},
+	}
+	ips, _, _, _ := a.DoTargetedLookup(context.Background(), mc, "www.example.com", ns1, true, false)
+	verifyResult(t, ips.(IpResult), []string{"192.0.2.1"}, nil)
+}
+
+// Test that the JSON of what ParseAnswer keeps decodes back into the same
+// answer struct
+func TestUnmarshalAnswer(t *testing.T) {
+	for _, s := range testRecords {
+		rr, err := dns.NewRR(s)
+		assert.NilError(t, err)
+		ans := ParseAnswer(rr)
+		j, err := json.Marshal(ans)
+		assert.NilError(t, err)
+		back, err := UnmarshalAnswer(j)
+		assert.NilError(t, err, s)
+		if _, ok := ans.(SVCBAnswer); ok {
+			// SVCB parameters decode as generic JSON values
+			backJSON, err := json.Marshal(back)
+			assert.NilError(t, err)
+			assert.Equal(t, string(backJSON), string(j))
+			assert.Equal(t, back.(SVCBAnswer).Answer, ans.(SVCBAnswer).Answer)
+			continue
+		}
+		assert.DeepEqual(t, back, ans)
+	}
+}
+
+// Test that the records of testRecords are made again of what ParseAnswer
+// keeps of them, and of its JSON
+func TestToRR(t *testing.T) {
+	for _, s := range testRecords {
+		rr, err := dns.NewRR(s)
+		assert.NilError(t, err)
+		ans := ParseAnswer(rr)
+		back, err := ans.ToRR()
+		assert.NilError(t, err, s)
+		assert.Equal(t, back.String(), rr.String())
+		j, err := json.Marshal(ans)
+		assert.NilError(t, err)
+		back, err = UnmarshalRR(j)
+		assert.NilError(t, err, s)
+		assert.Equal(t, back.String(), rr.String())
+	}
+
+	// records of types ParseAnswer doesn't know can't be made of their JSON
+	_, err := UnmarshalRR([]byte(`{"type":"TYPE999","class":"IN"}`))
+	assert.ErrorContains(t, err, "no record")
+	_, err = UnmarshalRR([]byte(`{"type":"A","class":"IN","name":"example.com","answer":"example.com."}`))
+	assert.ErrorContains(t, err, "invalid A answer")
+}
+
+// Test that records of every type there is a module for or zmap/dns knows,
+// and of a type it doesn't, with random data, are made again of what
+// ParseAnswer keeps of them and of its JSON output
+func TestToRRRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	encoder := zdns.NewResultEncoder([]string{"long"})
+	// a private use type
+	seen := map[uint16]bool{65280: true}
+	for _, module := range zdns.Validlookups() {
+		if factory, ok := zdns.GetLookup(module).(*recordLookupFactory); ok {
+			seen[factory.DNSType] = true
+		}
+	}
+	for rrType := range dns.TypeToRR {
+		// OPT pseudo-records don't keep all of their options
+		if rrType != dns.TypeOPT {
+			seen[rrType] = true
+		}
+	}
+	types := make([]uint16, 0, len(seen))
+	for rrType := range seen {
+		types = append(types, rrType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, rrType := range types {
+		for i := 0; i < 100; i++ {
+			rr := randomRR(t, r, rrType)
+			wire := packRR(t, rr)
+			ans := ParseAnswer(rr)
+			back, err := ans.ToRR()
+			assert.NilError(t, err, rr.String())
+			assert.Assert(t, bytes.Equal(packRR(t, back), wire), "%s\n%s", rr, back)
+
+			out, err := encoder.Encode(&zdns.Result{Data: Result{Answers: []RR{ans}}})
+			assert.NilError(t, err)
+			if bytes.Contains(out, []byte("\ufffd")) {
+				// data that isn't UTF-8 isn't kept by JSON
+				continue
+			}
+			var res struct {
+				Data struct {
+					Answers []json.RawMessage `json:"answers"`
+				} `json:"data"`
+			}
+			assert.NilError(t, json.Unmarshal(out, &res))
+			back, err = UnmarshalRR(res.Data.Answers[0])
+			assert.NilError(t, err, string(out))
+			assert.Assert(t, bytes.Equal(packRR(t, back), wire), "%s\n%s\n%s", rr, out, back)
+		}
+	}
+}
+
+func packRR(t *testing.T, rr dns.RR) []byte {
+	buf := make([]byte, dns.MaxMsgSize)
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	assert.NilError(t, err, rr.String())
+	return buf[:off]
+}
+
+// randomRR returns a record of type rrType with random data, as unpacked from
+// the wire. Only records zmap/dns packs again into the same data are returned.
+func randomRR(t *testing.T, r *rand.Rand, rrType uint16) dns.RR {
+	buf := make([]byte, dns.MaxMsgSize)
+	for try := 0; try < 1000; try++ {
+		var rr dns.RR = new(dns.RFC3597)
+		if newRR, ok := dns.TypeToRR[rrType]; ok {
+			rr = newRR()
+		}
+		*rr.Header() = dns.RR_Header{Name: randomName(r), Rrtype: rrType, Class: dns.ClassINET, Ttl: r.Uint32()}
+		if r.Intn(4) == 0 {
+			rr.Header().Class = uint16(r.Intn(1 << 16))
+		}
+		randomFields(t, r, reflect.ValueOf(rr).Elem())
+		off, err := dns.PackRR(rr, buf, 0, nil, false)
+		if err != nil {
+			continue
+		}
+		wire := append([]byte(nil), buf[:off]...)
+		rr, _, err = dns.UnpackRR(wire, 0)
+		if err != nil {
+			continue
+		}
+		if off, err = dns.PackRR(rr, buf, 0, nil, false); err == nil && bytes.Equal(buf[:off], wire) {
+			return rr
+		}
+	}
+	t.Fatalf("no random %s record could be made", dns.Type(rrType))
+	return nil
+}
+
+// randomFields fills the data fields of a zmap/dns record with random values
+// of the encodings given by their dns tags
+func randomFields(t *testing.T, r *rand.Rand, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f, field := v.Field(i), v.Type().Field(i)
+		tag := field.Tag.Get("dns")
+		switch {
+		case field.Type == reflect.TypeOf(dns.RR_Header{}):
+			continue
+		case field.Anonymous:
+			randomFields(t, r, f)
+			continue
+		case strings.HasPrefix(tag, "size-"):
+			// the length is given by another field
+			data := randomBytes(r, 20)
+			enc, lenField, _ := strings.Cut(strings.TrimPrefix(tag, "size-"), ":")
+			switch enc {
+			case "hex":
+				f.SetString(hex.EncodeToString(data))
+			case "base32":
+				f.SetString(base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(data))
+			case "base64":
+				f.SetString(base64.StdEncoding.EncodeToString(data))
+			}
+			v.FieldByName(lenField).SetUint(uint64(len(data)))
+			continue
+		}
+		switch f.Interface().(type) {
+		case uint8, uint16, uint32, uint64:
+			n := r.Uint64()
+			if tag == "uint48" {
+				n &= 1<<48 - 1
+			}
+			f.SetUint(n)
+		case string:
+			switch tag {
+			case "domain-name", "cdomain-name":
+				f.SetString(randomName(r))
+			case "hex":
+				f.SetString(hex.EncodeToString(randomBytes(r, 20)))
+			case "base64":
+				f.SetString(base64.StdEncoding.EncodeToString(randomBytes(r, 20)))
+			case "any", "octet":
+				f.SetString(string(randomBytes(r, 20)))
+			case "":
+				f.SetString(randomString(r))
+			default:
+				t.Fatalf("no random %s field %s", tag, field.Name)
+			}
+		case []string:
+			var s []string
+			switch tag {
+			case "txt":
+				for j := r.Intn(3); j >= 0; j-- {
+					s = append(s, randomString(r))
+				}
+			case "domain-name":
+				for j := r.Intn(3); j > 0; j-- {
+					s = append(s, randomName(r))
+				}
+			default:
+				t.Fatalf("no random %s field %s", tag, field.Name)
+			}
+			f.Set(reflect.ValueOf(s))
+		case []uint16:
+			types := map[uint16]bool{}
+			for j := r.Intn(6); j > 0; j-- {
+				types[uint16(1+r.Intn(300))] = true
+			}
+			bitmap := make([]uint16, 0, len(types))
+			for typ := range types {
+				bitmap = append(bitmap, typ)
+			}
+			sort.Slice(bitmap, func(i, j int) bool { return bitmap[i] < bitmap[j] })
+			f.Set(reflect.ValueOf(bitmap))
+		case net.IP:
+			if tag == "a" {
+				f.Set(reflect.ValueOf(net.IP(randomFixedBytes(r, net.IPv4len))))
+			} else {
+				f.Set(reflect.ValueOf(net.IP(randomFixedBytes(r, net.IPv6len))))
+			}
+		case []dns.SVCBKeyValue:
+			f.Set(reflect.ValueOf(randomSVCBParams(r)))
+		case []dns.APLPrefix:
+			var prefixes []dns.APLPrefix
+			for j := r.Intn(3); j > 0; j-- {
+				ip := net.IP(randomFixedBytes(r, []int{net.IPv4len, net.IPv6len}[r.Intn(2)]))
+				mask := net.CIDRMask(r.Intn(8*len(ip)+1), 8*len(ip))
+				prefixes = append(prefixes, dns.APLPrefix{
+					Negation: r.Intn(2) == 0,
+					Network:  net.IPNet{IP: ip.Mask(mask), Mask: mask},
+				})
+			}
+			f.Set(reflect.ValueOf(prefixes))
+		default:
+			t.Fatalf("no random %s field %s", field.Type, field.Name)
+		}
+	}
+}
+
+// randomSVCBParams returns random parameters of every kind ParseAnswer knows,
+// in the order of their keys
+func randomSVCBParams(r *rand.Rand) []dns.SVCBKeyValue {
+	var params []dns.SVCBKeyValue
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBMandatory{Code: []dns.SVCBKey{dns.SVCB_ALPN, dns.SVCB_PORT}[:1+r.Intn(2)]})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBAlpn{Alpn: []string{string(randomFixedBytes(r, 1+r.Intn(5))), "h2"}[:1+r.Intn(2)]})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBNoDefaultAlpn{})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBPort{Port: uint16(r.Intn(1 << 16))})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBIPv4Hint{Hint: []net.IP{randomFixedBytes(r, net.IPv4len)}})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBECHConfig{ECH: randomFixedBytes(r, 1+r.Intn(20))})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBIPv6Hint{Hint: []net.IP{randomFixedBytes(r, net.IPv6len)}})
+	}
+	if r.Intn(2) == 0 {
+		params = append(params, &dns.SVCBLocal{KeyCode: dns.SVCBKey(7 + r.Intn(65000)), Data: randomBytes(r, 10)})
+	}
+	return params
+}
+
+func randomBytes(r *rand.Rand, max int) []byte {
+	return randomFixedBytes(r, r.Intn(max+1))
+}
+
+func randomFixedBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+// randomString returns a random character string in presentation format,
+// which has any byte
+func randomString(r *rand.Rand) string {
+	var s strings.Builder
+	for _, b := range randomBytes(r, 12) {
+		if b >= 'a' && b <= 'z' || b >= '0' && b <= '9' {
+			s.WriteByte(b)
+		} else {
+			fmt.Fprintf(&s, `\%03d`, b)
+		}
+	}
+	return s.String()
+}
+
+// randomName returns a random fully qualified name in presentation format
+func randomName(r *rand.Rand) string {
+	var name string
+	for i := r.Intn(4); i > 0; i-- {
+		label := randomString(r)
+		if label == "" {
+			label = "x"
+		}
+		name += label + "."
+	}
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func TestUnmarshalResult(t *testing.T) {
+	a, _ := dns.NewRR("example.com. 60 IN A 192.0.2.1")
+	cert, _ := dns.NewRR("example.com. 60 IN CERT PKIX 12345 RSASHA256 dGVzdA==")
+	ns, _ := dns.NewRR("example.com. 60 IN NS ns1.example.com.")
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(1232)
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte("ns1"))})
+	res := Result{
+		Answers:     []RR{ParseAnswer(a), ParseAnswer(cert)},
+		Authorities: []RR{ParseAnswer(ns)},
+		Additional:  []RR{ParseAnswer(opt)},
+		Protocol:    "udp",
+		Resolver:    "192.0.2.53:53",
+		Flags:       DNSFlags{Response: true, RecursionDesired: true},
+	}
+	j, err := json.Marshal(res)
+	assert.NilError(t, err)
+	var back Result
+	assert.NilError(t, json.Unmarshal(j, &back))
+	assert.DeepEqual(t, back, res)
+}
+
 func verifyResult(t *testing.T, res IpResult, ipv4 []string, ipv6 []string) {
 	if !reflect.DeepEqual(ipv4, res.IPv4Addresses) {
 		t.Errorf("Expected %v, Received %v IPv4 address(es)", ipv4, res.IPv4Addresses)
@@ -2057,3 +2913,144 @@ func verifyCombinedResult(t *testing.T, records []ExtendedResult, expectedRecord
 		t.Errorf("Combined result not matching, expected %v, found %v", expectedRecords, records)
 	}
 }
+
+// Test that every module initialized with the same configuration shares a
+// single iterative cache
+func TestIterativeCacheShared(t *testing.T) {
+	gc := &zdns.GlobalConf{CacheSize: 10}
+	a := new(GlobalLookupFactory)
+	a.SetDNSType(dns.TypeA)
+	ns := new(GlobalLookupFactory)
+	ns.SetDNSType(dns.TypeNS)
+	assert.NilError(t, a.Initialize(gc))
+	assert.NilError(t, ns.Initialize(gc))
+	assert.Assert(t, a.IterativeCache != nil)
+	assert.Equal(t, a.IterativeCache, ns.IterativeCache)
+}
+
+func TestFlatRecords(t *testing.T) {
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: "example.com.", Rrtype: rrtype, Class: dns.ClassINET, Ttl: 300}
+	}
+	res := Result{
+		Resolver: "192.0.2.53:53",
+		Answers: []RR{
+			ParseAnswer(&dns.MX{Hdr: hdr(dns.TypeMX), Preference: 10, Mx: "mail.example.com."}),
+			ParseAnswer(&dns.CAA{Hdr: hdr(dns.TypeCAA), Flag: 0, Tag: "issue", Value: "ca.example.net"}),
+		},
+		Authorities: []RR{
+			ParseAnswer(&dns.NS{Hdr: hdr(dns.TypeNS), Ns: "ns1.example.com."}),
+		},
+		Additional: []RR{
+			ParseAnswer(&dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}),
+		},
+	}
+	records := res.FlatRecords("example.com")
+	expected := []zdns.FlatRecord{
+		{Section: "answer", Name: "example.com", Type: "MX", Class: "IN", TTL: 300, HasTTL: true, Answer: "10 mail.example.com.", Rdata: "10 mail.example.com.", Resolver: "192.0.2.53:53"},
+		{Section: "answer", Name: "example.com", Type: "CAA", Class: "IN", TTL: 300, HasTTL: true, Answer: "issue ca.example.net 0", Rdata: `0 issue "ca.example.net"`, Resolver: "192.0.2.53:53"},
+		{Section: "authority", Name: "example.com", Type: "NS", Class: "IN", TTL: 300, HasTTL: true, Answer: "ns1.example.com.", Rdata: "ns1.example.com.", Resolver: "192.0.2.53:53"},
+	}
+	assert.DeepEqual(t, records, expected)
+
+	ips := IpResult{IPv4Addresses: []string{"192.0.2.1"}, IPv6Addresses: []string{"2001:db8::1"}}
+	assert.DeepEqual(t, ips.FlatRecords("example.com"), []zdns.FlatRecord{
+		{Section: "answer", Name: "example.com", Type: "A", Answer: "192.0.2.1", Rdata: "192.0.2.1"},
+		{Section: "answer", Name: "example.com", Type: "AAAA", Answer: "2001:db8::1", Rdata: "2001:db8::1"},
+	})
+}
+
+// encodeTestResult returns a lookup result with a record of every type
+// ParseAnswer knows, an OPT record, and a trace of the lookup
+func encodeTestResult(t testing.TB) *zdns.Result {
+	var answers []RR
+	for _, s := range testRecords {
+		rr, err := dns.NewRR(s)
+		assert.NilError(t, err)
+		answers = append(answers, ParseAnswer(rr))
+	}
+	opt := &dns.OPT{
+		Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT, Class: 1232},
+		Option: []dns.EDNS0{
+			&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte("ns<1>&"))},
+			&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("192.0.2.0").To4()},
+			&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "24a5ac1234567890"},
+			&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeStaleAnswer, ExtraText: "stale & <old>"},
+		},
+	}
+	res := Result{
+		Answers:     answers,
+		Additional:  []RR{ParseAnswer(opt)},
+		Authorities: answers[:3],
+		Protocol:    "udp",
+		Resolver:    "192.0.2.53:53",
+		Flags:       DNSFlags{Response: true, RecursionDesired: true, Authoritative: true},
+	}
+	var trace []interface{}
+	for depth, layer := range []string{".", "com", "example.com"} {
+		trace = append(trace, TraceStep{
+			Result: res, DnsType: dns.TypeA, DnsClass: dns.ClassINET, Name: "example.com", NameServer: "192.0.2.53:53",
+			Depth: depth + 1, Layer: layer, Cached: depth == 0, Try: 0,
+		})
+	}
+	return &zdns.Result{
+		Name: "example.com", Nameserver: "192.0.2.53:53", Class: "IN", Status: "NOERROR", Timestamp: "2022-01-01T00:00:00Z",
+		Metadata: map[string]interface{}{"rank": 1}, Data: res, Trace: trace,
+	}
+}
+
+// sheriffJSON returns the JSON of res as written by sheriff
+func sheriffJSON(t testing.TB, groups []string, res *zdns.Result) []byte {
+	v, _ := version.NewVersion("0.0.0")
+	data, err := sheriff.Marshal(&sheriff.Options{Groups: groups, ApiVersion: v}, res)
+	assert.NilError(t, err)
+	out, err := json.Marshal(data)
+	assert.NilError(t, err)
+	return out
+}
+
+var encodeTestGroups = [][]string{
+	{"short", ""},
+	{"normal", ""},
+	{"long", ""},
+	{"trace", ""},
+	{"short", "class", "ttl", "protocol", "resolver", "flags"},
+}
+
+// Test that the output of ResultEncoder is that of sheriff
+func TestResultEncoder(t *testing.T) {
+	res := encodeTestResult(t)
+	for _, groups := range encodeTestGroups {
+		out, err := zdns.NewResultEncoder(groups).Encode(res)
+		assert.NilError(t, err)
+		assert.Equal(t, string(out), string(sheriffJSON(t, groups, res)), "groups %v", groups)
+	}
+}
+
+func BenchmarkEncodeResult(b *testing.B) {
+	res := encodeTestResult(b)
+	res.Trace = nil
+	benchmarkEncode(b, []string{"normal", ""}, res)
+}
+
+func BenchmarkEncodeTrace(b *testing.B) {
+	benchmarkEncode(b, []string{"trace", ""}, encodeTestResult(b))
+}
+
+func benchmarkEncode(b *testing.B, groups []string, res *zdns.Result) {
+	b.Run("sheriff", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sheriffJSON(b, groups, res)
+		}
+	})
+	b.Run("encoder", func(b *testing.B) {
+		e := zdns.NewResultEncoder(groups)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := e.Encode(res); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
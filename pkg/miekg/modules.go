@@ -15,299 +15,312 @@
 package miekg
 
 import (
+	"reflect"
+
 	"github.com/zmap/dns"
 	"github.com/zmap/zdns/pkg/zdns"
 )
 
+// recordLookupFactory is the factory of the modules looking up a record
+// type, whose results are a Result. The other modules embed
+// GlobalLookupFactory, so it's declared here rather than there.
+type recordLookupFactory struct {
+	GlobalLookupFactory
+}
+
+func (s *recordLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 // previously autogenerated based on types
 // note if you change something manually.
 // - OPT not a real type, comment out
 
 func init() {
-	a := new(GlobalLookupFactory)
+	a := new(recordLookupFactory)
 	a.SetDNSType(dns.TypeA)
 	zdns.RegisterLookup("A", a)
 
-	aaaa := new(GlobalLookupFactory)
+	aaaa := new(recordLookupFactory)
 	aaaa.SetDNSType(dns.TypeAAAA)
 	zdns.RegisterLookup("AAAA", aaaa)
 
-	afsdb := new(GlobalLookupFactory)
+	afsdb := new(recordLookupFactory)
 	afsdb.SetDNSType(dns.TypeAFSDB)
 	zdns.RegisterLookup("AFSDB", afsdb)
 
-	atma := new(GlobalLookupFactory)
+	atma := new(recordLookupFactory)
 	atma.SetDNSType(dns.TypeATMA)
 	zdns.RegisterLookup("ATMA", atma)
 
-	avc := new(GlobalLookupFactory)
+	avc := new(recordLookupFactory)
 	avc.SetDNSType(dns.TypeAVC)
 	zdns.RegisterLookup("AVC", avc)
 
-	caa := new(GlobalLookupFactory)
+	caa := new(recordLookupFactory)
 	caa.SetDNSType(dns.TypeCAA)
 	zdns.RegisterLookup("CAA", caa)
 
-	cert := new(GlobalLookupFactory)
+	cert := new(recordLookupFactory)
 	cert.SetDNSType(dns.TypeCERT)
 	zdns.RegisterLookup("CERT", cert)
 
-	cds := new(GlobalLookupFactory)
+	cds := new(recordLookupFactory)
 	cds.SetDNSType(dns.TypeCDS)
 	zdns.RegisterLookup("CDS", cds)
 
-	cdnskey := new(GlobalLookupFactory)
+	cdnskey := new(recordLookupFactory)
 	cdnskey.SetDNSType(dns.TypeCDNSKEY)
 	zdns.RegisterLookup("CDNSKEY", cdnskey)
 
-	cname := new(GlobalLookupFactory)
+	cname := new(recordLookupFactory)
 	cname.SetDNSType(dns.TypeCNAME)
 	zdns.RegisterLookup("CNAME", cname)
 
-	csync := new(GlobalLookupFactory)
+	csync := new(recordLookupFactory)
 	csync.SetDNSType(dns.TypeCSYNC)
 	zdns.RegisterLookup("CSYNC", csync)
 
-	dhcid := new(GlobalLookupFactory)
+	dhcid := new(recordLookupFactory)
 	dhcid.SetDNSType(dns.TypeDHCID)
 	zdns.RegisterLookup("DHCID", dhcid)
 
-	dname := new(GlobalLookupFactory)
+	dname := new(recordLookupFactory)
 	dname.SetDNSType(dns.TypeDNAME)
 	zdns.RegisterLookup("DNAME", dname)
 
-	dnskey := new(GlobalLookupFactory)
+	dnskey := new(recordLookupFactory)
 	dnskey.SetDNSType(dns.TypeDNSKEY)
 	zdns.RegisterLookup("DNSKEY", dnskey)
 
-	ds := new(GlobalLookupFactory)
+	ds := new(recordLookupFactory)
 	ds.SetDNSType(dns.TypeDS)
 	zdns.RegisterLookup("DS", ds)
 
-	eid := new(GlobalLookupFactory)
+	eid := new(recordLookupFactory)
 	eid.SetDNSType(dns.TypeEID)
 	zdns.RegisterLookup("EID", eid)
 
-	eui48 := new(GlobalLookupFactory)
+	eui48 := new(recordLookupFactory)
 	eui48.SetDNSType(dns.TypeEUI48)
 	zdns.RegisterLookup("EUI48", eui48)
 
-	eui64 := new(GlobalLookupFactory)
+	eui64 := new(recordLookupFactory)
 	eui64.SetDNSType(dns.TypeEUI64)
 	zdns.RegisterLookup("EUI64", eui64)
 
-	gid := new(GlobalLookupFactory)
+	gid := new(recordLookupFactory)
 	gid.SetDNSType(dns.TypeGID)
 	zdns.RegisterLookup("GID", gid)
 
-	gpos := new(GlobalLookupFactory)
+	gpos := new(recordLookupFactory)
 	gpos.SetDNSType(dns.TypeGPOS)
 	zdns.RegisterLookup("GPOS", gpos)
 
-	hinfo := new(GlobalLookupFactory)
+	hinfo := new(recordLookupFactory)
 	hinfo.SetDNSType(dns.TypeHINFO)
 	zdns.RegisterLookup("HINFO", hinfo)
 
-	hip := new(GlobalLookupFactory)
+	hip := new(recordLookupFactory)
 	hip.SetDNSType(dns.TypeHIP)
 	zdns.RegisterLookup("HIP", hip)
 
-	https := new(GlobalLookupFactory)
+	https := new(recordLookupFactory)
 	https.SetDNSType(dns.TypeHTTPS)
 	zdns.RegisterLookup("HTTPS", https)
 
-	isdn := new(GlobalLookupFactory)
+	isdn := new(recordLookupFactory)
 	isdn.SetDNSType(dns.TypeISDN)
 	zdns.RegisterLookup("ISDN", isdn)
 
-	key := new(GlobalLookupFactory)
+	key := new(recordLookupFactory)
 	key.SetDNSType(dns.TypeKEY)
 	zdns.RegisterLookup("KEY", key)
 
-	kx := new(GlobalLookupFactory)
+	kx := new(recordLookupFactory)
 	kx.SetDNSType(dns.TypeKX)
 	zdns.RegisterLookup("KX", kx)
 
-	l32 := new(GlobalLookupFactory)
+	l32 := new(recordLookupFactory)
 	l32.SetDNSType(dns.TypeL32)
 	zdns.RegisterLookup("L32", l32)
 
-	l64 := new(GlobalLookupFactory)
+	l64 := new(recordLookupFactory)
 	l64.SetDNSType(dns.TypeL64)
 	zdns.RegisterLookup("L64", l64)
 
-	loc := new(GlobalLookupFactory)
+	loc := new(recordLookupFactory)
 	loc.SetDNSType(dns.TypeLOC)
 	zdns.RegisterLookup("LOC", loc)
 
-	lp := new(GlobalLookupFactory)
+	lp := new(recordLookupFactory)
 	lp.SetDNSType(dns.TypeLP)
 	zdns.RegisterLookup("LP", lp)
 
-	md := new(GlobalLookupFactory)
+	md := new(recordLookupFactory)
 	md.SetDNSType(dns.TypeMD)
 	zdns.RegisterLookup("MD", md)
 
-	mf := new(GlobalLookupFactory)
+	mf := new(recordLookupFactory)
 	mf.SetDNSType(dns.TypeMF)
 	zdns.RegisterLookup("MF", mf)
 
-	mb := new(GlobalLookupFactory)
+	mb := new(recordLookupFactory)
 	mb.SetDNSType(dns.TypeMB)
 	zdns.RegisterLookup("MB", mb)
 
-	mg := new(GlobalLookupFactory)
+	mg := new(recordLookupFactory)
 	mg.SetDNSType(dns.TypeMG)
 	zdns.RegisterLookup("MG", mg)
 
-	mr := new(GlobalLookupFactory)
+	mr := new(recordLookupFactory)
 	mr.SetDNSType(dns.TypeMR)
 	zdns.RegisterLookup("MR", mr)
 
-	mx := new(GlobalLookupFactory)
+	mx := new(recordLookupFactory)
 	mx.SetDNSType(dns.TypeMX)
 	zdns.RegisterLookup("MX", mx)
 
-	naptr := new(GlobalLookupFactory)
+	naptr := new(recordLookupFactory)
 	naptr.SetDNSType(dns.TypeNAPTR)
 	zdns.RegisterLookup("NAPTR", naptr)
 
-	nimloc := new(GlobalLookupFactory)
+	nimloc := new(recordLookupFactory)
 	nimloc.SetDNSType(dns.TypeNIMLOC)
 	zdns.RegisterLookup("NS", nimloc)
 
-	nid := new(GlobalLookupFactory)
+	nid := new(recordLookupFactory)
 	nid.SetDNSType(dns.TypeNID)
 	zdns.RegisterLookup("NID", nid)
 
-	ninfo := new(GlobalLookupFactory)
+	ninfo := new(recordLookupFactory)
 	ninfo.SetDNSType(dns.TypeNINFO)
 	zdns.RegisterLookup("NINFO", ninfo)
 
-	nsapptr := new(GlobalLookupFactory)
+	nsapptr := new(recordLookupFactory)
 	nsapptr.SetDNSType(dns.TypeNSAPPTR)
 	zdns.RegisterLookup("NSAPPTR", nsapptr)
 
-	ns := new(GlobalLookupFactory)
+	ns := new(recordLookupFactory)
 	ns.SetDNSType(dns.TypeNS)
 	zdns.RegisterLookup("NS", ns)
 
-	nxt := new(GlobalLookupFactory)
+	nxt := new(recordLookupFactory)
 	nxt.SetDNSType(dns.TypeNXT)
 	zdns.RegisterLookup("NXT", nxt)
 
-	nsec := new(GlobalLookupFactory)
+	nsec := new(recordLookupFactory)
 	nsec.SetDNSType(dns.TypeNSEC)
 	zdns.RegisterLookup("NSEC", nsec)
 
-	nsec3 := new(GlobalLookupFactory)
+	nsec3 := new(recordLookupFactory)
 	nsec3.SetDNSType(dns.TypeNSEC3)
 	zdns.RegisterLookup("NSEC3", nsec3)
 
-	nsec3param := new(GlobalLookupFactory)
+	nsec3param := new(recordLookupFactory)
 	nsec3param.SetDNSType(dns.TypeNSEC3PARAM)
 	zdns.RegisterLookup("NSEC3PARAM", nsec3param)
 
-	null := new(GlobalLookupFactory)
+	null := new(recordLookupFactory)
 	null.SetDNSType(dns.TypeNULL)
 	zdns.RegisterLookup("NULL", null)
 
-	openpgpkey := new(GlobalLookupFactory)
+	openpgpkey := new(recordLookupFactory)
 	openpgpkey.SetDNSType(dns.TypeOPENPGPKEY)
 	zdns.RegisterLookup("OPENPGPKEY", openpgpkey)
 
-	//opt := new(GlobalLookupFactory)
+	//opt := new(recordLookupFactory)
 	//opt.SetDNSType(dns.TypeOPT)
 	//zdns.RegisterLookup("OPT", opt)
 
-	ptr := new(GlobalLookupFactory)
+	ptr := new(recordLookupFactory)
 	ptr.SetDNSType(dns.TypePTR)
 	zdns.RegisterLookup("PTR", ptr)
 
-	px := new(GlobalLookupFactory)
+	px := new(recordLookupFactory)
 	px.SetDNSType(dns.TypePX)
 	zdns.RegisterLookup("PX", px)
 
-	rp := new(GlobalLookupFactory)
+	rp := new(recordLookupFactory)
 	rp.SetDNSType(dns.TypeRP)
 	zdns.RegisterLookup("RP", rp)
 
-	rrsig := new(GlobalLookupFactory)
+	rrsig := new(recordLookupFactory)
 	rrsig.SetDNSType(dns.TypeRRSIG)
 	zdns.RegisterLookup("RRSIG", rrsig)
 
-	rt := new(GlobalLookupFactory)
+	rt := new(recordLookupFactory)
 	rt.SetDNSType(dns.TypeRT)
 	zdns.RegisterLookup("RT", rt)
 
-	smimea := new(GlobalLookupFactory)
+	smimea := new(recordLookupFactory)
 	smimea.SetDNSType(dns.TypeSMIMEA)
 	zdns.RegisterLookup("SMIMEA", smimea)
 
-	sshfp := new(GlobalLookupFactory)
+	sshfp := new(recordLookupFactory)
 	sshfp.SetDNSType(dns.TypeSSHFP)
 	zdns.RegisterLookup("SSHFP", sshfp)
 
-	soa := new(GlobalLookupFactory)
+	soa := new(recordLookupFactory)
 	soa.SetDNSType(dns.TypeSOA)
 	zdns.RegisterLookup("SOA", soa)
 
-	spf := new(GlobalLookupFactory)
+	spf := new(recordLookupFactory)
 	spf.SetDNSType(dns.TypeSPF)
 	zdns.RegisterLookup("SPF", spf)
 
-	srv := new(GlobalLookupFactory)
+	srv := new(recordLookupFactory)
 	srv.SetDNSType(dns.TypeSRV)
 	zdns.RegisterLookup("SRV", srv)
 
-	svcb := new(GlobalLookupFactory)
+	svcb := new(recordLookupFactory)
 	svcb.SetDNSType(dns.TypeSVCB)
 	zdns.RegisterLookup("SVCB", svcb)
 
-	talink := new(GlobalLookupFactory)
+	talink := new(recordLookupFactory)
 	talink.SetDNSType(dns.TypeTALINK)
 	zdns.RegisterLookup("TALINK", talink)
 
-	tkey := new(GlobalLookupFactory)
+	tkey := new(recordLookupFactory)
 	tkey.SetDNSType(dns.TypeTKEY)
 	zdns.RegisterLookup("TKEY", tkey)
 
-	tlsa := new(GlobalLookupFactory)
+	tlsa := new(recordLookupFactory)
 	tlsa.SetDNSType(dns.TypeTLSA)
 	zdns.RegisterLookup("TLSA", tlsa)
 
-	txt := new(GlobalLookupFactory)
+	txt := new(recordLookupFactory)
 	txt.SetDNSType(dns.TypeTXT)
 	zdns.RegisterLookup("TXT", txt)
 
-	uid := new(GlobalLookupFactory)
+	uid := new(recordLookupFactory)
 	uid.SetDNSType(dns.TypeUID)
 	zdns.RegisterLookup("UID", uid)
 
-	uinfo := new(GlobalLookupFactory)
+	uinfo := new(recordLookupFactory)
 	uinfo.SetDNSType(dns.TypeUINFO)
 	zdns.RegisterLookup("UINFO", uinfo)
 
-	unspec := new(GlobalLookupFactory)
+	unspec := new(recordLookupFactory)
 	unspec.SetDNSType(dns.TypeUNSPEC)
 	zdns.RegisterLookup("UNSPEC", unspec)
 
-	uri := new(GlobalLookupFactory)
+	uri := new(recordLookupFactory)
 	uri.SetDNSType(dns.TypeURI)
 	zdns.RegisterLookup("URI", uri)
 
 	// Question Only Types
-	any := new(GlobalLookupFactory)
+	any := new(recordLookupFactory)
 	any.SetDNSType(dns.TypeANY)
 	zdns.RegisterLookup("ANY", any)
 
 	// Transfer have their own modules
 
-	//ixfr := new(GlobalLookupFactory)
+	//ixfr := new(recordLookupFactory)
 	//ixfr.SetDNSType(dns.TypeIXFR)
 	//zdns.RegisterLookup("IXFR", ixfr)
 
-	//axfr := new(GlobalLookupFactory)
+	//axfr := new(recordLookupFactory)
 	//axfr.SetDNSType(dns.TypeAXFR)
 	//zdns.RegisterLookup("AXFR", axfr)
 
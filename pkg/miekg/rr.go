@@ -0,0 +1,145 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"net/netip"
+
+	"github.com/zmap/dns"
+)
+
+// RR is a record of a response, as returned by ParseAnswer. It is
+// implemented by every answer struct, most of them through the Answer they
+// embed.
+type RR interface {
+	// Header returns the header of the record, whose name is fully
+	// qualified
+	Header() dns.RR_Header
+	// RRType returns the type of the record, e.g. dns.TypeA
+	RRType() uint16
+	// TTL returns the TTL of the record
+	TTL() uint32
+	// Owner returns the name the record is of, without the trailing dot
+	Owner() string
+	// Data returns the data of records that keep it as a single string,
+	// e.g. the address of A records, the target of CNAME and NS records or
+	// the text of TXT records, and "" for records with structured data
+	Data() string
+	// AsA returns the address of A and AAAA records, and the zero Addr for
+	// other records
+	AsA() netip.Addr
+	// AsMX returns the record if it is an MX record
+	AsMX() (PrefAnswer, bool)
+	// ToRR returns the record the answer was made of, or an error if the
+	// answer doesn't keep enough of it
+	ToRR() (dns.RR, error)
+}
+
+// Header returns the header of a
+func (a Answer) Header() dns.RR_Header {
+	class := a.RrClass
+	if class == 0 {
+		class, _ = stringToClass(a.Class)
+	}
+	return dns.RR_Header{Name: dns.Fqdn(a.Name), Rrtype: a.RRType(), Class: class, Ttl: a.Ttl}
+}
+
+// RRType returns the type of a, from its name if the number isn't known
+func (a Answer) RRType() uint16 {
+	if a.RrType != 0 {
+		return a.RrType
+	}
+	t, _ := stringToType(a.Type)
+	return t
+}
+
+// TTL returns the TTL of a
+func (a Answer) TTL() uint32 {
+	return a.Ttl
+}
+
+// Owner returns the name of a
+func (a Answer) Owner() string {
+	return a.Name
+}
+
+// Data returns the answer of a
+func (a Answer) Data() string {
+	return a.Answer
+}
+
+// AsA returns the address of a if it is an A or AAAA record
+func (a Answer) AsA() netip.Addr {
+	if t := a.RRType(); t != dns.TypeA && t != dns.TypeAAAA {
+		return netip.Addr{}
+	}
+	addr, err := netip.ParseAddr(a.Answer)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
+// AsMX returns false, as MX records are PrefAnswers
+func (a Answer) AsMX() (PrefAnswer, bool) {
+	return PrefAnswer{}, false
+}
+
+// AsMX returns a if it is an MX record rather than another record with a
+// preference
+func (a PrefAnswer) AsMX() (PrefAnswer, bool) {
+	return a, a.RRType() == dns.TypeMX
+}
+
+// Header returns the header of the OPT record a was made of. Its extended
+// RCODE isn't kept.
+func (a EDNSAnswer) Header() dns.RR_Header {
+	return dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT, Class: a.UDPSize, Ttl: a.TTL()}
+}
+
+// RRType returns dns.TypeOPT
+func (a EDNSAnswer) RRType() uint16 {
+	return dns.TypeOPT
+}
+
+// TTL returns the TTL field of the OPT record a was made of, which holds
+// the EDNS version and flags
+func (a EDNSAnswer) TTL() uint32 {
+	ttl := uint32(a.Version) << 16
+	if a.Flags == "do" {
+		ttl |= 1 << 15
+	}
+	return ttl
+}
+
+// Owner returns the root, which OPT records are of
+func (a EDNSAnswer) Owner() string {
+	return ""
+}
+
+// Data returns "", as the data of OPT records is structured
+func (a EDNSAnswer) Data() string {
+	return ""
+}
+
+// AsA returns the zero Addr
+func (a EDNSAnswer) AsA() netip.Addr {
+	return netip.Addr{}
+}
+
+// AsMX returns false
+func (a EDNSAnswer) AsMX() (PrefAnswer, bool) {
+	return PrefAnswer{}, false
+}
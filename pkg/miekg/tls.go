@@ -0,0 +1,188 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zmap/dns"
+	"github.com/zmap/zdns/pkg/zdns"
+)
+
+// TLSInfo is the DNS-over-TLS connection a query went over
+type TLSInfo struct {
+	Version      string           `json:"version" groups:"protocol,long,trace"`
+	CipherSuite  string           `json:"cipher_suite" groups:"protocol,long,trace"`
+	Certificates []TLSCertificate `json:"certificates" groups:"protocol,long,trace"`
+}
+
+// TLSCertificate is a certificate of the chain sent by a DNS-over-TLS name
+// server
+type TLSCertificate struct {
+	Subject      string   `json:"subject" groups:"protocol,long,trace"`
+	Issuer       string   `json:"issuer" groups:"protocol,long,trace"`
+	SerialNumber string   `json:"serial_number" groups:"protocol,long,trace"`
+	NotBefore    string   `json:"not_before" groups:"protocol,long,trace"`
+	NotAfter     string   `json:"not_after" groups:"protocol,long,trace"`
+	DNSNames     []string `json:"dns_names,omitempty" groups:"protocol,long,trace"`
+	IPAddresses  []string `json:"ip_addresses,omitempty" groups:"protocol,long,trace"`
+	// SPKISHA256 is the base64 SHA-256 digest of the public key of the
+	// certificate, the way name servers are pinned with --tls-spki-pin
+	SPKISHA256 string `json:"spki_sha256" groups:"protocol,long,trace"`
+}
+
+func newTLSInfo(cs tls.ConnectionState) *TLSInfo {
+	info := &TLSInfo{
+		Version:      tls.VersionName(cs.Version),
+		CipherSuite:  tls.CipherSuiteName(cs.CipherSuite),
+		Certificates: make([]TLSCertificate, len(cs.PeerCertificates)),
+	}
+	for i, cert := range cs.PeerCertificates {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		c := TLSCertificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: cert.SerialNumber.String(),
+			NotBefore:    cert.NotBefore.UTC().Format(time.RFC3339),
+			NotAfter:     cert.NotAfter.UTC().Format(time.RFC3339),
+			DNSNames:     cert.DNSNames,
+			SPKISHA256:   base64.StdEncoding.EncodeToString(spki[:]),
+		}
+		for _, ip := range cert.IPAddresses {
+			c.IPAddresses = append(c.IPAddresses, ip.String())
+		}
+		info.Certificates[i] = c
+	}
+	return info
+}
+
+// TLSClient queries name servers over DNS-over-TLS (RFC 7858). The
+// connection to a name server is kept open after a query for the next one
+// to reuse, so that a routine only pays for the handshake once per name
+// server. Queries to the same name server at the same time each get their
+// own connection.
+type TLSClient struct {
+	*dns.Client
+
+	mu    sync.Mutex
+	conns map[string]*tlsConn
+}
+
+type tlsConn struct {
+	*dns.Conn
+	info *TLSInfo
+}
+
+// NewTLSClient returns a client making connections with config from
+// localAddr, which time out after timeout
+func NewTLSClient(config *tls.Config, timeout time.Duration, localAddr net.IP) *TLSClient {
+	c := new(dns.Client)
+	c.Net = "tcp-tls"
+	c.TLSConfig = config
+	c.Timeout = timeout
+	c.Dialer = &net.Dialer{
+		Timeout:   timeout,
+		LocalAddr: &net.TCPAddr{IP: localAddr},
+	}
+	return &TLSClient{Client: c, conns: make(map[string]*tlsConn)}
+}
+
+// take returns the idle connection to addr, if any. It's the caller's until
+// put back.
+func (c *TLSClient) take(addr string) *tlsConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn := c.conns[addr]
+	delete(c.conns, addr)
+	return conn
+}
+
+// put keeps conn as the idle connection to addr, unless there is one
+// already
+func (c *TLSClient) put(addr string, conn *tlsConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.conns[addr]; ok {
+		conn.Close()
+		return
+	}
+	c.conns[addr] = conn
+}
+
+// Close closes the idle connections of c. Later queries make new ones.
+func (c *TLSClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for addr, conn := range c.conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(c.conns, addr)
+	}
+	return err
+}
+
+func (c *TLSClient) dial(ctx context.Context, addr string) (*tlsConn, error) {
+	// connecting and the handshake take as long as a query may, which is
+	// raised for retries
+	nd := *c.Dialer
+	nd.Timeout = c.Timeout
+	// the dns library doesn't pass the context on to TLS handshakes
+	d := tls.Dialer{NetDialer: &nd, Config: c.TLSConfig}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsConn{
+		Conn: &dns.Conn{Conn: conn, UDPSize: c.UDPSize},
+		info: newTLSInfo(conn.(*tls.Conn).ConnectionState()),
+	}, nil
+}
+
+// exchange sends m to the name server at addr and returns its response and
+// the connection it went over. If the kept connection fails without timing
+// out, e.g. because the name server closed it while idle, m is sent again
+// over a new one.
+func (c *TLSClient) exchange(ctx context.Context, m *dns.Msg, addr string, wt zdns.WireTap) (*dns.Msg, *TLSInfo, error) {
+	if conn := c.take(addr); conn != nil {
+		r, err := exchange(ctx, c.Client, m, conn.Conn, wt)
+		if err == nil {
+			c.put(addr, conn)
+			return r, conn.info, nil
+		}
+		conn.Close()
+		if nerr, ok := err.(net.Error); ctx.Err() != nil || (ok && nerr.Timeout()) {
+			return nil, conn.info, err
+		}
+	}
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := exchange(ctx, c.Client, m, conn.Conn, wt)
+	if err != nil {
+		conn.Close()
+		return nil, conn.info, err
+	}
+	c.put(addr, conn)
+	return r, conn.info, nil
+}
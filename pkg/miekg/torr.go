@@ -0,0 +1,876 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/dns"
+)
+
+// ToRR methods turn the answer structs returned by ParseAnswer back into the
+// records they were made of, so that ParseAnswer(rr).ToRR() packs into the
+// same wire format as rr. They are the inverse of ParseAnswer, and each type
+// follows the same case of its switch.
+
+// invalidAnswer is the error of answers whose data can't be that of a record
+// of their type
+func invalidAnswer(typ string, data interface{}) error {
+	return fmt.Errorf("invalid %s answer: %v", typ, data)
+}
+
+// noRecord is the error of answers whose type has no record of them, such as
+// the type of another answer struct
+func noRecord(typ string) error {
+	return fmt.Errorf("no record can be made of a %s answer", typ)
+}
+
+// ToRR returns the record a was made of
+func (a Answer) ToRR() (dns.RR, error) {
+	hdr := a.Header()
+	switch hdr.Rrtype {
+	case dns.TypeA:
+		ip := net.ParseIP(a.Answer).To4()
+		if ip == nil {
+			return nil, invalidAnswer(a.Type, a.Answer)
+		}
+		return &dns.A{Hdr: hdr, A: ip}, nil
+	case dns.TypeAAAA:
+		ip := net.ParseIP(a.Answer)
+		if ip == nil {
+			return nil, invalidAnswer(a.Type, a.Answer)
+		}
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case dns.TypeNS:
+		return &dns.NS{Hdr: hdr, Ns: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeCNAME:
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeDNAME:
+		return &dns.DNAME{Hdr: hdr, Target: dns.Fqdn(a.Answer)}, nil
+	case dns.TypePTR:
+		return &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeTXT:
+		return &dns.TXT{Hdr: hdr, Txt: strings.Split(a.Answer, "\n")}, nil
+	case dns.TypeSPF:
+		// the answer holds the whole record
+		rr, err := dns.NewRR(a.Answer)
+		if err != nil {
+			return nil, err
+		}
+		if rr == nil || rr.Header().Rrtype != dns.TypeSPF {
+			return nil, invalidAnswer(a.Type, a.Answer)
+		}
+		return rr, nil
+	case dns.TypeNULL:
+		return &dns.NULL{Hdr: hdr, Data: a.Answer}, nil
+	case dns.TypeMB:
+		return &dns.MB{Hdr: hdr, Mb: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeMG:
+		return &dns.MG{Hdr: hdr, Mg: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeMF:
+		return &dns.MF{Hdr: hdr, Mf: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeMD:
+		return &dns.MD{Hdr: hdr, Md: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeMR:
+		return &dns.MR{Hdr: hdr, Mr: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeNSAPPTR:
+		return &dns.NSAPPTR{Hdr: hdr, Ptr: dns.Fqdn(a.Answer)}, nil
+	case dns.TypeNIMLOC:
+		return &dns.NIMLOC{Hdr: hdr, Locator: a.Answer}, nil
+	case dns.TypeOPENPGPKEY:
+		return &dns.OPENPGPKEY{Hdr: hdr, PublicKey: a.Answer}, nil
+	case dns.TypeAVC:
+		return &dns.AVC{Hdr: hdr, Txt: strings.Split(a.Answer, "\n")}, nil
+	case dns.TypeEID:
+		return &dns.EID{Hdr: hdr, Endpoint: a.Answer}, nil
+	case dns.TypeUINFO:
+		return &dns.UINFO{Hdr: hdr, Uinfo: a.Answer}, nil
+	case dns.TypeDHCID:
+		return &dns.DHCID{Hdr: hdr, Digest: a.Answer}, nil
+	case dns.TypeNINFO:
+		return &dns.NINFO{Hdr: hdr, ZSData: strings.Split(a.Answer, "\n")}, nil
+	case dns.TypeX25:
+		return &dns.X25{Hdr: hdr, PSDNAddress: a.Answer}, nil
+	case dns.TypeEUI48:
+		eui, err := parseEUI(a.Answer, 48)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, a.Answer)
+		}
+		return &dns.EUI48{Hdr: hdr, Address: eui}, nil
+	case dns.TypeEUI64:
+		eui, err := parseEUI(a.Answer, 64)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, a.Answer)
+		}
+		return &dns.EUI64{Hdr: hdr, Address: eui}, nil
+	case dns.TypeUID:
+		uid, err := strconv.ParseUint(a.Answer, 10, 32)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, a.Answer)
+		}
+		return &dns.UID{Hdr: hdr, Uid: uint32(uid)}, nil
+	case dns.TypeGID:
+		gid, err := strconv.ParseUint(a.Answer, 10, 32)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, a.Answer)
+		}
+		return &dns.GID{Hdr: hdr, Gid: uint32(gid)}, nil
+	case dns.TypeANY:
+		return &dns.ANY{Hdr: hdr}, nil
+	}
+	if strings.HasPrefix(a.Answer, `\#`) {
+		return parseGeneric(hdr, a.Answer)
+	}
+	return nil, noRecord(a.Type)
+}
+
+// parseGeneric reads the data of a record written by makeGenericAnswer
+func parseGeneric(hdr dns.RR_Header, s string) (dns.RR, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || fields[0] != `\#` {
+		return nil, invalidAnswer(dns.Type(hdr.Rrtype).String(), s)
+	}
+	rdata := strings.Join(fields[2:], "")
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n*2 != len(rdata) {
+		return nil, invalidAnswer(dns.Type(hdr.Rrtype).String(), s)
+	}
+	if _, err := hex.DecodeString(rdata); err != nil {
+		return nil, invalidAnswer(dns.Type(hdr.Rrtype).String(), s)
+	}
+	return &dns.RFC3597{Hdr: hdr, Rdata: rdata}, nil
+}
+
+// parseEUI reads an address written by euiToString
+func parseEUI(s string, bits int) (uint64, error) {
+	digits := strings.ReplaceAll(s, "-", "")
+	if len(digits) != bits/4 {
+		return 0, fmt.Errorf("invalid EUI-%d address: %s", bits, s)
+	}
+	return strconv.ParseUint(digits, 16, bits)
+}
+
+// ToRR returns the record a was made of
+func (a PrefAnswer) ToRR() (dns.RR, error) {
+	hdr := a.Header()
+	switch hdr.Rrtype {
+	case dns.TypeMX:
+		return &dns.MX{Hdr: hdr, Preference: a.Preference, Mx: dns.Fqdn(a.Answer.Answer)}, nil
+	case dns.TypeRT:
+		return &dns.RT{Hdr: hdr, Preference: a.Preference, Host: dns.Fqdn(a.Answer.Answer)}, nil
+	case dns.TypeKX:
+		return &dns.KX{Hdr: hdr, Preference: a.Preference, Exchanger: dns.Fqdn(a.Answer.Answer)}, nil
+	case dns.TypeLP:
+		return &dns.LP{Hdr: hdr, Preference: a.Preference, Fqdn: dns.Fqdn(a.Answer.Answer)}, nil
+	case dns.TypeNID:
+		node, err := strconv.ParseUint(a.Answer.Answer, 16, 64)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, a.Answer.Answer)
+		}
+		return &dns.NID{Hdr: hdr, Preference: a.Preference, NodeID: node}, nil
+	case dns.TypeL64:
+		locator, err := strconv.ParseUint(a.Answer.Answer, 16, 64)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, a.Answer.Answer)
+		}
+		return &dns.L64{Hdr: hdr, Preference: a.Preference, Locator64: locator}, nil
+	case dns.TypeL32:
+		locator := net.ParseIP(a.Answer.Answer).To4()
+		if locator == nil {
+			return nil, invalidAnswer(a.Type, a.Answer.Answer)
+		}
+		return &dns.L32{Hdr: hdr, Preference: a.Preference, Locator32: locator}, nil
+	}
+	return nil, noRecord(a.Type)
+}
+
+// ToRR returns the record a was made of
+func (a SOAAnswer) ToRR() (dns.RR, error) {
+	return &dns.SOA{
+		Hdr:     a.Header(),
+		Ns:      dns.Fqdn(a.Ns),
+		Mbox:    dns.Fqdn(a.Mbox),
+		Serial:  a.Serial,
+		Refresh: a.Refresh,
+		Retry:   a.Retry,
+		Expire:  a.Expire,
+		Minttl:  a.Minttl,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a CAAAnswer) ToRR() (dns.RR, error) {
+	return &dns.CAA{Hdr: a.Header(), Flag: a.Flag, Tag: a.Tag, Value: a.Value}, nil
+}
+
+// ToRR returns the record a was made of
+func (a SRVAnswer) ToRR() (dns.RR, error) {
+	return &dns.SRV{
+		Hdr:      a.Header(),
+		Priority: a.Priority,
+		Weight:   a.Weight,
+		Port:     a.Port,
+		Target:   dns.Fqdn(a.Target),
+	}, nil
+}
+
+// ToRR returns the DS, CDS, DLV or TA record a was made of
+func (a DSAnswer) ToRR() (dns.RR, error) {
+	ds := dns.DS{
+		Hdr:        a.Header(),
+		KeyTag:     a.KeyTag,
+		Algorithm:  a.Algorithm,
+		DigestType: a.DigestType,
+		Digest:     a.Digest,
+	}
+	switch ds.Hdr.Rrtype {
+	case dns.TypeCDS:
+		return &dns.CDS{DS: ds}, nil
+	case dns.TypeDLV:
+		return &dns.DLV{DS: ds}, nil
+	case dns.TypeTA:
+		return &dns.TA{
+			Hdr:        ds.Hdr,
+			KeyTag:     ds.KeyTag,
+			Algorithm:  ds.Algorithm,
+			DigestType: ds.DigestType,
+			Digest:     ds.Digest,
+		}, nil
+	}
+	return &ds, nil
+}
+
+// ToRR returns the RRSIG or SIG record a was made of
+func (a RRSIGAnswer) ToRR() (dns.RR, error) {
+	expiration, err := dns.StringToTime(a.Expiration)
+	if err != nil {
+		return nil, invalidAnswer(a.Type, a.Expiration)
+	}
+	inception, err := dns.StringToTime(a.Inception)
+	if err != nil {
+		return nil, invalidAnswer(a.Type, a.Inception)
+	}
+	sig := dns.RRSIG{
+		Hdr:         a.Header(),
+		TypeCovered: a.TypeCovered,
+		Algorithm:   a.Algorithm,
+		Labels:      a.Labels,
+		OrigTtl:     a.OriginalTtl,
+		Expiration:  expiration,
+		Inception:   inception,
+		KeyTag:      a.KeyTag,
+		SignerName:  dns.Fqdn(a.SignerName),
+		Signature:   a.Signature,
+	}
+	if sig.Hdr.Rrtype == dns.TypeSIG {
+		return &dns.SIG{RRSIG: sig}, nil
+	}
+	return &sig, nil
+}
+
+// ToRR returns the record a was made of
+func (a TKEYAnswer) ToRR() (dns.RR, error) {
+	expiration, err := dns.StringToTime(a.Expiration)
+	if err != nil {
+		return nil, invalidAnswer(a.Type, a.Expiration)
+	}
+	inception, err := dns.StringToTime(a.Inception)
+	if err != nil {
+		return nil, invalidAnswer(a.Type, a.Inception)
+	}
+	return &dns.TKEY{
+		Hdr:        a.Header(),
+		Algorithm:  dns.Fqdn(a.Algorithm),
+		Inception:  inception,
+		Expiration: expiration,
+		Mode:       a.Mode,
+		Error:      a.Error,
+		KeySize:    a.KeySize,
+		Key:        a.Key,
+		OtherLen:   a.OtherLen,
+		OtherData:  a.OtherData,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a TLSAAnswer) ToRR() (dns.RR, error) {
+	return &dns.TLSA{
+		Hdr:          a.Header(),
+		Usage:        a.CertUsage,
+		Selector:     a.Selector,
+		MatchingType: a.MatchingType,
+		Certificate:  a.Certificate,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a NSECAnswer) ToRR() (dns.RR, error) {
+	bitmap, err := parseBitString(a.TypeBitMap)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.NSEC{Hdr: a.Header(), NextDomain: dns.Fqdn(a.NextDomain), TypeBitMap: bitmap}, nil
+}
+
+// parseBitString reads a type bitmap written by makeBitString
+func parseBitString(s string) ([]uint16, error) {
+	var bitmap []uint16
+	for _, name := range strings.Fields(s) {
+		t, ok := stringToType(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid type in bitmap: %s", name)
+		}
+		bitmap = append(bitmap, t)
+	}
+	return bitmap, nil
+}
+
+// ToRR returns the record a was made of
+func (a NAPTRAnswer) ToRR() (dns.RR, error) {
+	return &dns.NAPTR{
+		Hdr:         a.Header(),
+		Order:       a.Order,
+		Preference:  a.Preference,
+		Flags:       a.Flags,
+		Service:     a.Service,
+		Regexp:      a.Regexp,
+		Replacement: dns.Fqdn(a.Replacement),
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a HINFOAnswer) ToRR() (dns.RR, error) {
+	return &dns.HINFO{Hdr: a.Header(), Cpu: a.Cpu, Os: a.Os}, nil
+}
+
+// ToRR returns the record a was made of
+func (a MINFOAnswer) ToRR() (dns.RR, error) {
+	return &dns.MINFO{Hdr: a.Header(), Rmail: dns.Fqdn(a.Rmail), Email: dns.Fqdn(a.Email)}, nil
+}
+
+// ToRR returns the NSEC3 or NSEC3PARAM record a was made of
+func (a NSEC3Answer) ToRR() (dns.RR, error) {
+	hdr := a.Header()
+	salt, err := hex.DecodeString(a.Salt)
+	if err != nil || len(salt) > math.MaxUint8 {
+		return nil, invalidAnswer(a.Type, a.Salt)
+	}
+	if hdr.Rrtype == dns.TypeNSEC3PARAM {
+		return &dns.NSEC3PARAM{
+			Hdr:        hdr,
+			Hash:       a.HashAlgorithm,
+			Flags:      a.Flags,
+			Iterations: a.Iterations,
+			SaltLength: uint8(len(salt)),
+			Salt:       a.Salt,
+		}, nil
+	}
+	hash, err := base32.HexEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(a.NextDomain))
+	if err != nil || len(hash) > math.MaxUint8 {
+		return nil, invalidAnswer(a.Type, a.NextDomain)
+	}
+	bitmap, err := parseBitString(a.TypeBitMap)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.NSEC3{
+		Hdr:        hdr,
+		Hash:       a.HashAlgorithm,
+		Flags:      a.Flags,
+		Iterations: a.Iterations,
+		SaltLength: uint8(len(salt)),
+		Salt:       a.Salt,
+		HashLength: uint8(len(hash)),
+		NextDomain: a.NextDomain,
+		TypeBitMap: bitmap,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a NSEC3ParamAnswer) ToRR() (dns.RR, error) {
+	return NSEC3Answer{
+		Answer:        a.Answer,
+		HashAlgorithm: a.HashAlgorithm,
+		Flags:         a.Flags,
+		Iterations:    a.Iterations,
+		Salt:          a.Salt,
+	}.ToRR()
+}
+
+// ToRR returns the DNSKEY, CDNSKEY, KEY or RKEY record a was made of
+func (a DNSKEYAnswer) ToRR() (dns.RR, error) {
+	key := dns.DNSKEY{
+		Hdr:       a.Header(),
+		Flags:     a.Flags,
+		Protocol:  a.Protocol,
+		Algorithm: a.Algorithm,
+		PublicKey: a.PublicKey,
+	}
+	switch key.Hdr.Rrtype {
+	case dns.TypeCDNSKEY:
+		return &dns.CDNSKEY{DNSKEY: key}, nil
+	case dns.TypeKEY:
+		return &dns.KEY{DNSKEY: key}, nil
+	case dns.TypeRKEY:
+		return &dns.RKEY{
+			Hdr:       key.Hdr,
+			Flags:     key.Flags,
+			Protocol:  key.Protocol,
+			Algorithm: key.Algorithm,
+			PublicKey: key.PublicKey,
+		}, nil
+	}
+	return &key, nil
+}
+
+// ToRR returns the record a was made of
+func (a AFSDBAnswer) ToRR() (dns.RR, error) {
+	return &dns.AFSDB{Hdr: a.Header(), Subtype: a.Subtype, Hostname: dns.Fqdn(a.Hostname)}, nil
+}
+
+// ToRR returns the record a was made of
+func (a CERTAnswer) ToRR() (dns.RR, error) {
+	certType, ok := dns.StringToCertType[a.Type]
+	if !ok {
+		t, err := strconv.ParseUint(a.Type, 10, 16)
+		if err != nil {
+			return nil, invalidAnswer(a.Answer.Type, a.Type)
+		}
+		certType = uint16(t)
+	}
+	algorithm, ok := dns.StringToAlgorithm[a.Algorithm]
+	if !ok {
+		alg, err := strconv.ParseUint(a.Algorithm, 10, 8)
+		if err != nil {
+			return nil, invalidAnswer(a.Answer.Type, a.Algorithm)
+		}
+		algorithm = uint8(alg)
+	}
+	return &dns.CERT{
+		Hdr:         a.Header(),
+		Type:        certType,
+		KeyTag:      a.KeyTag,
+		Algorithm:   algorithm,
+		Certificate: a.Certificate,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a PXAnswer) ToRR() (dns.RR, error) {
+	return &dns.PX{
+		Hdr:        a.Header(),
+		Preference: a.Preference,
+		Map822:     dns.Fqdn(a.Map822),
+		Mapx400:    dns.Fqdn(a.Mapx400),
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a GPOSAnswer) ToRR() (dns.RR, error) {
+	return &dns.GPOS{Hdr: a.Header(), Longitude: a.Longitude, Latitude: a.Latitude, Altitude: a.Altitude}, nil
+}
+
+// ToRR returns the record a was made of
+func (a LOCAnswer) ToRR() (dns.RR, error) {
+	return &dns.LOC{
+		Hdr:       a.Header(),
+		Version:   a.Version,
+		Size:      a.Size,
+		HorizPre:  a.HorizPre,
+		VertPre:   a.VertPre,
+		Latitude:  a.Latitude,
+		Longitude: a.Longitude,
+		Altitude:  a.Altitude,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a HIPAnswer) ToRR() (dns.RR, error) {
+	servers := make([]string, len(a.RendezvousServers))
+	for i, s := range a.RendezvousServers {
+		servers[i] = dns.Fqdn(s)
+	}
+	return &dns.HIP{
+		Hdr:                a.Header(),
+		HitLength:          a.HitLength,
+		PublicKeyAlgorithm: a.PublicKeyAlgorithm,
+		PublicKeyLength:    a.PublicKeyLength,
+		Hit:                a.Hit,
+		PublicKey:          a.PublicKey,
+		RendezvousServers:  servers,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a SSHFPAnswer) ToRR() (dns.RR, error) {
+	return &dns.SSHFP{Hdr: a.Header(), Algorithm: a.Algorithm, Type: a.Type, FingerPrint: a.FingerPrint}, nil
+}
+
+// ToRR returns the record a was made of
+func (a SMIMEAAnswer) ToRR() (dns.RR, error) {
+	return &dns.SMIMEA{
+		Hdr:          a.Header(),
+		Usage:        a.Usage,
+		Selector:     a.Selector,
+		MatchingType: a.MatchingType,
+		Certificate:  a.Certificate,
+	}, nil
+}
+
+// ToRR returns the record a was made of
+func (a TALINKAnswer) ToRR() (dns.RR, error) {
+	return &dns.TALINK{Hdr: a.Header(), PreviousName: dns.Fqdn(a.PreviousName), NextName: dns.Fqdn(a.NextName)}, nil
+}
+
+// ToRR returns the record a was made of
+func (a RPAnswer) ToRR() (dns.RR, error) {
+	return &dns.RP{Hdr: a.Header(), Mbox: dns.Fqdn(a.Mbox), Txt: dns.Fqdn(a.Txt)}, nil
+}
+
+// ToRR returns the record a was made of
+func (a URIAnswer) ToRR() (dns.RR, error) {
+	return &dns.URI{Hdr: a.Header(), Priority: a.Priority, Weight: a.Weight, Target: a.Target}, nil
+}
+
+// ToRR returns the record a was made of
+func (a APLAnswer) ToRR() (dns.RR, error) {
+	prefixes := make([]dns.APLPrefix, len(a.Prefixes))
+	for i, s := range a.Prefixes {
+		p, err := parseAPLPrefix(s)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, s)
+		}
+		prefixes[i] = p
+	}
+	return &dns.APL{Hdr: a.Header(), Prefixes: prefixes}, nil
+}
+
+// parseAPLPrefix reads a prefix written by makeAPLAnswer
+func parseAPLPrefix(s string) (dns.APLPrefix, error) {
+	var p dns.APLPrefix
+	s, p.Negation = strings.CutPrefix(s, "!")
+	family, s, _ := strings.Cut(s, ":")
+	addr, length, _ := strings.Cut(s, "/")
+	ip := net.ParseIP(addr)
+	switch family {
+	case "1":
+		ip = ip.To4()
+	case "2":
+		ip = ip.To16()
+	default:
+		ip = nil
+	}
+	ones, err := strconv.Atoi(length)
+	if ip == nil || err != nil || ones < 0 || ones > 8*len(ip) {
+		return p, fmt.Errorf("invalid APL prefix: %s", s)
+	}
+	p.Network = net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 8*len(ip))}
+	return p, nil
+}
+
+// ToRR returns the record a was made of
+func (a CSYNCAnswer) ToRR() (dns.RR, error) {
+	bitmap, err := parseBitString(a.TypeBitMap)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.CSYNC{Hdr: a.Header(), Serial: a.Serial, Flags: a.Flags, TypeBitMap: bitmap}, nil
+}
+
+// ToRR returns the record a was made of
+func (a ZONEMDAnswer) ToRR() (dns.RR, error) {
+	return &dns.ZONEMD{Hdr: a.Header(), Serial: a.Serial, Scheme: a.Scheme, Hash: a.Hash, Digest: a.Digest}, nil
+}
+
+// ToRR returns the record a was made of
+func (a TSIGAnswer) ToRR() (dns.RR, error) {
+	return &dns.TSIG{
+		Hdr:        a.Header(),
+		Algorithm:  dns.Fqdn(a.Algorithm),
+		TimeSigned: a.TimeSigned,
+		Fudge:      a.Fudge,
+		MACSize:    a.MACSize,
+		MAC:        a.MAC,
+		OrigId:     a.OrigId,
+		Error:      a.Error,
+		OtherLen:   a.OtherLen,
+		OtherData:  a.OtherData,
+	}, nil
+}
+
+// ToRR returns the SVCB or HTTPS record a was made of. Its parameters may be
+// the values ParseAnswer keeps or the generic values they decode into from
+// JSON.
+func (a SVCBAnswer) ToRR() (dns.RR, error) {
+	svcb := dns.SVCB{Hdr: a.Header(), Priority: a.Priority, Target: dns.Fqdn(a.Target)}
+	keys := make([]string, 0, len(a.SVCParams))
+	for k := range a.SVCParams {
+		keys = append(keys, k)
+	}
+	// parameters are in the order of their keys on the wire
+	sort.Slice(keys, func(i, j int) bool {
+		return svcbKeyOrder(keys[i]) < svcbKeyOrder(keys[j])
+	})
+	for _, k := range keys {
+		kv, err := svcbKeyValue(k, a.SVCParams[k])
+		if err != nil {
+			return nil, err
+		}
+		svcb.Value = append(svcb.Value, kv)
+	}
+	if svcb.Hdr.Rrtype == dns.TypeHTTPS {
+		return &dns.HTTPS{SVCB: svcb}, nil
+	}
+	return &svcb, nil
+}
+
+// svcbKeyValue returns the SVCB parameter key is named by makeSVCBAnswer with
+// value v
+func svcbKeyValue(key string, v interface{}) (dns.SVCBKeyValue, error) {
+	invalid := invalidAnswer("SVCB", key+"="+fmt.Sprint(v))
+	code := svcbKeyOrder(key)
+	if code > math.MaxUint16 {
+		return nil, invalid
+	}
+	switch dns.SVCBKey(code) {
+	case dns.SVCB_MANDATORY:
+		names, ok := jsonStrings(v)
+		if !ok {
+			return nil, invalid
+		}
+		codes := make([]dns.SVCBKey, len(names))
+		for i, n := range names {
+			c := svcbKeyOrder(n)
+			if c > math.MaxUint16 {
+				return nil, invalid
+			}
+			codes[i] = dns.SVCBKey(c)
+		}
+		return &dns.SVCBMandatory{Code: codes}, nil
+	case dns.SVCB_ALPN:
+		alpn, ok := jsonStrings(v)
+		if !ok {
+			return nil, invalid
+		}
+		return &dns.SVCBAlpn{Alpn: alpn}, nil
+	case dns.SVCB_NO_DEFAULT_ALPN:
+		return &dns.SVCBNoDefaultAlpn{}, nil
+	case dns.SVCB_PORT:
+		switch p := v.(type) {
+		case uint16:
+			return &dns.SVCBPort{Port: p}, nil
+		case float64:
+			if p >= 0 && p <= math.MaxUint16 && p == math.Trunc(p) {
+				return &dns.SVCBPort{Port: uint16(p)}, nil
+			}
+		}
+		return nil, invalid
+	case dns.SVCB_IPV4HINT, dns.SVCB_IPV6HINT:
+		hint, ok := jsonIPs(v)
+		if !ok {
+			return nil, invalid
+		}
+		if code == int(dns.SVCB_IPV4HINT) {
+			for i, ip := range hint {
+				if hint[i] = ip.To4(); hint[i] == nil {
+					return nil, invalid
+				}
+			}
+			return &dns.SVCBIPv4Hint{Hint: hint}, nil
+		}
+		return &dns.SVCBIPv6Hint{Hint: hint}, nil
+	case dns.SVCB_ECHCONFIG:
+		ech, ok := jsonBytes(v)
+		if !ok {
+			return nil, invalid
+		}
+		return &dns.SVCBECHConfig{ECH: ech}, nil
+	}
+	data, ok := jsonBytes(v)
+	if !ok {
+		return nil, invalid
+	}
+	return &dns.SVCBLocal{KeyCode: dns.SVCBKey(code), Data: data}, nil
+}
+
+// jsonStrings returns a list of strings as kept by ParseAnswer or decoded
+// from JSON
+func jsonStrings(v interface{}) ([]string, bool) {
+	switch l := v.(type) {
+	case []string:
+		return l, true
+	case []interface{}:
+		s := make([]string, len(l))
+		for i, e := range l {
+			str, ok := e.(string)
+			if !ok {
+				return nil, false
+			}
+			s[i] = str
+		}
+		return s, true
+	}
+	return nil, false
+}
+
+// jsonIPs returns a list of addresses as kept by ParseAnswer or decoded from
+// JSON
+func jsonIPs(v interface{}) ([]net.IP, bool) {
+	if ips, ok := v.([]net.IP); ok {
+		return ips, true
+	}
+	s, ok := jsonStrings(v)
+	if !ok {
+		return nil, false
+	}
+	ips := make([]net.IP, len(s))
+	for i, str := range s {
+		if ips[i] = net.ParseIP(str); ips[i] == nil {
+			return nil, false
+		}
+	}
+	return ips, true
+}
+
+// jsonBytes returns bytes as kept by ParseAnswer or decoded from JSON, where
+// they are written as base64 by encoding/json and as a list of numbers in
+// zdns output
+func jsonBytes(v interface{}) ([]byte, bool) {
+	switch b := v.(type) {
+	case []byte:
+		return b, true
+	case string:
+		data, err := base64.StdEncoding.DecodeString(b)
+		return data, err == nil
+	case []interface{}:
+		data := make([]byte, len(b))
+		for i, e := range b {
+			n, ok := e.(float64)
+			if !ok || n < 0 || n > math.MaxUint8 || n != math.Trunc(n) {
+				return nil, false
+			}
+			data[i] = byte(n)
+		}
+		return data, true
+	}
+	return nil, false
+}
+
+// ToRR returns the OPT record a was made of. Its extended RCODE and the
+// options ParseAnswer doesn't keep are lost, and the options it keeps are in
+// the order of the fields of a.
+func (a EDNSAnswer) ToRR() (dns.RR, error) {
+	opt := &dns.OPT{Hdr: a.Header()}
+	if a.LLQ != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_LLQ{
+			Code:      dns.EDNS0LLQ,
+			Version:   a.LLQ.Version,
+			Opcode:    a.LLQ.Opcode,
+			Error:     a.LLQ.Error,
+			Id:        a.LLQ.Id,
+			LeaseLife: a.LLQ.LeaseLife,
+		})
+	}
+	if a.UL != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: a.UL.Lease, KeyLease: a.UL.KeyLease})
+	}
+	if a.NSID != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte(a.NSID.Nsid))})
+	}
+	if a.DAU != nil {
+		algs, err := parseAlgorithms(a.DAU.AlgCode, dns.StringToAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		opt.Option = append(opt.Option, &dns.EDNS0_DAU{Code: dns.EDNS0DAU, AlgCode: algs})
+	}
+	if a.DHU != nil {
+		algs, err := parseAlgorithms(a.DHU.AlgCode, dns.StringToHash)
+		if err != nil {
+			return nil, err
+		}
+		opt.Option = append(opt.Option, &dns.EDNS0_DHU{Code: dns.EDNS0DHU, AlgCode: algs})
+	}
+	if a.N3U != nil {
+		algs, err := parseAlgorithms(a.N3U.AlgCode, dns.StringToHash)
+		if err != nil {
+			return nil, err
+		}
+		opt.Option = append(opt.Option, &dns.EDNS0_N3U{Code: dns.EDNS0N3U, AlgCode: algs})
+	}
+	if a.ClientSubnet != nil {
+		addr := net.ParseIP(a.ClientSubnet.Address)
+		if addr == nil {
+			return nil, invalidAnswer(a.Type, a.ClientSubnet.Address)
+		}
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        a.ClientSubnet.Family,
+			SourceNetmask: a.ClientSubnet.SourceNetmask,
+			SourceScope:   a.ClientSubnet.SourceScope,
+			Address:       addr,
+		})
+	}
+	if a.Expire != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_EXPIRE{Code: dns.EDNS0EXPIRE, Expire: a.Expire.Expire})
+	}
+	if a.Cookie != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: a.Cookie.Cookie})
+	}
+	if a.TcpKeepalive != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{
+			Code:    dns.EDNS0TCPKEEPALIVE,
+			Timeout: a.TcpKeepalive.Timeout,
+			Length:  a.TcpKeepalive.Length,
+		})
+	}
+	if a.Padding != nil {
+		padding, err := hex.DecodeString(a.Padding.Padding)
+		if err != nil {
+			return nil, invalidAnswer(a.Type, a.Padding.Padding)
+		}
+		opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: padding})
+	}
+	for _, ede := range a.EDE {
+		opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: ede.InfoCode, ExtraText: ede.ExtraText})
+	}
+	return opt, nil
+}
+
+// parseAlgorithms reads the algorithms of a DAU, DHU or N3U option, written
+// by their mnemonics or numbers
+func parseAlgorithms(s string, mnemonics map[string]uint8) ([]uint8, error) {
+	var algs []uint8
+	for _, name := range strings.Fields(s) {
+		alg, ok := mnemonics[name]
+		if !ok {
+			n, err := strconv.ParseUint(name, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid algorithm: %s", name)
+			}
+			alg = uint8(n)
+		}
+		algs = append(algs, alg)
+	}
+	return algs, nil
+}
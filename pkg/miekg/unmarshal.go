@@ -0,0 +1,233 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/dns"
+)
+
+// newAnswer returns a pointer to the answer struct ParseAnswer uses for
+// records of type rrType
+func newAnswer(rrType string) interface{} {
+	switch rrType {
+	case "MX", "RT", "NID", "L32", "L64", "LP", "KX":
+		return new(PrefAnswer)
+	case "SOA":
+		return new(SOAAnswer)
+	case "CAA":
+		return new(CAAAnswer)
+	case "SRV":
+		return new(SRVAnswer)
+	case "DS", "CDS", "DLV", "TA":
+		return new(DSAnswer)
+	case "RRSIG", "SIG":
+		return new(RRSIGAnswer)
+	case "TKEY":
+		return new(TKEYAnswer)
+	case "TLSA":
+		return new(TLSAAnswer)
+	case "NSEC":
+		return new(NSECAnswer)
+	case "NSEC3", "NSEC3PARAM":
+		return new(NSEC3Answer)
+	case "NAPTR":
+		return new(NAPTRAnswer)
+	case "HINFO":
+		return new(HINFOAnswer)
+	case "MINFO":
+		return new(MINFOAnswer)
+	case "DNSKEY", "CDNSKEY", "KEY", "RKEY":
+		return new(DNSKEYAnswer)
+	case "AFSDB":
+		return new(AFSDBAnswer)
+	case "PX":
+		return new(PXAnswer)
+	case "GPOS":
+		return new(GPOSAnswer)
+	case "LOC":
+		return new(LOCAnswer)
+	case "HIP":
+		return new(HIPAnswer)
+	case "SMIMEA":
+		return new(SMIMEAAnswer)
+	case "TALINK":
+		return new(TALINKAnswer)
+	case "SVCB", "HTTPS":
+		return new(SVCBAnswer)
+	case "RP":
+		return new(RPAnswer)
+	case "URI":
+		return new(URIAnswer)
+	case "APL":
+		return new(APLAnswer)
+	case "CSYNC":
+		return new(CSYNCAnswer)
+	case "ZONEMD":
+		return new(ZONEMDAnswer)
+	case "TSIG":
+		return new(TSIGAnswer)
+	}
+	if strings.HasPrefix(rrType, "EDNS") {
+		return new(EDNSAnswer)
+	}
+	// simple answers, and the generic data of records ParseAnswer doesn't
+	// know
+	return new(Answer)
+}
+
+// UnmarshalAnswer decodes a record written by ParseAnswer into the answer
+// struct it was made of, told apart by its type. CERT and SSHFP answers,
+// whose type is hidden by a field of their own, are told apart by their
+// fields instead. The record type and class numbers are restored as well.
+func UnmarshalAnswer(data []byte) (RR, error) {
+	var probe struct {
+		Type        interface{}      `json:"type"`
+		KeyTag      *json.RawMessage `json:"keytag"`
+		Certificate *json.RawMessage `json:"certificate"`
+		FingerPrint *json.RawMessage `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+	var rrType string
+	switch {
+	case probe.FingerPrint != nil:
+		rrType = "SSHFP"
+	case probe.KeyTag != nil && probe.Certificate != nil:
+		rrType = "CERT"
+	default:
+		t, ok := probe.Type.(string)
+		if !ok {
+			return nil, fmt.Errorf("answer has no type: %s", data)
+		}
+		rrType = t
+	}
+	var ans interface{}
+	switch rrType {
+	case "SSHFP":
+		ans = new(SSHFPAnswer)
+	case "CERT":
+		ans = new(CERTAnswer)
+	default:
+		ans = newAnswer(rrType)
+	}
+	if err := json.Unmarshal(data, ans); err != nil {
+		return nil, fmt.Errorf("unable to decode %s answer: %w", rrType, err)
+	}
+	v := reflect.ValueOf(ans).Elem()
+	if a := answerOf(v); a != nil {
+		a.Type = rrType
+		a.RrType, _ = stringToType(rrType)
+		a.RrClass, _ = stringToClass(a.Class)
+	}
+	return v.Interface().(RR), nil
+}
+
+// UnmarshalRR decodes a record written by ParseAnswer back into the record it
+// was made of, see UnmarshalAnswer and RR.ToRR
+func UnmarshalRR(data []byte) (dns.RR, error) {
+	ans, err := UnmarshalAnswer(data)
+	if err != nil {
+		return nil, err
+	}
+	return ans.ToRR()
+}
+
+// stringToType returns the type named s, which is either its mnemonic or
+// the generic TYPEn of RFC 3597
+func stringToType(s string) (uint16, bool) {
+	if t, ok := dns.StringToType[s]; ok {
+		return t, true
+	}
+	if n, ok := strings.CutPrefix(s, "TYPE"); ok {
+		if t, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return uint16(t), true
+		}
+	}
+	return 0, false
+}
+
+// stringToClass returns the class named s, which is either its mnemonic or
+// the generic CLASSn of RFC 3597
+func stringToClass(s string) (uint16, bool) {
+	if c, ok := dns.StringToClass[s]; ok {
+		return c, true
+	}
+	if n, ok := strings.CutPrefix(s, "CLASS"); ok {
+		if c, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return uint16(c), true
+		}
+	}
+	return 0, false
+}
+
+// answerOf returns the Answer of an answer struct, which is either an Answer
+// or embeds one
+func answerOf(v reflect.Value) *Answer {
+	if v.Type() == answerType {
+		return v.Addr().Interface().(*Answer)
+	}
+	if f := v.FieldByName("Answer"); f.IsValid() && f.Type() == answerType {
+		return f.Addr().Interface().(*Answer)
+	}
+	return nil
+}
+
+// UnmarshalAnswers decodes a list of records written by ParseAnswer with
+// UnmarshalAnswer
+func UnmarshalAnswers(data []json.RawMessage) ([]RR, error) {
+	if data == nil {
+		return nil, nil
+	}
+	answers := make([]RR, len(data))
+	for i, d := range data {
+		ans, err := UnmarshalAnswer(d)
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = ans
+	}
+	return answers, nil
+}
+
+// UnmarshalJSON decodes every record of r into its answer struct
+func (r *Result) UnmarshalJSON(data []byte) error {
+	type result Result
+	var raw struct {
+		result
+		Answers     []json.RawMessage `json:"answers"`
+		Additional  []json.RawMessage `json:"additionals"`
+		Authorities []json.RawMessage `json:"authorities"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*r = Result(raw.result)
+	var err error
+	if r.Answers, err = UnmarshalAnswers(raw.Answers); err != nil {
+		return err
+	}
+	if r.Additional, err = UnmarshalAnswers(raw.Additional); err != nil {
+		return err
+	}
+	r.Authorities, err = UnmarshalAnswers(raw.Authorities)
+	return err
+}
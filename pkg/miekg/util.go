@@ -39,8 +39,8 @@ func isStatusAnswer(s zdns.Status) bool {
 	return false
 }
 
-func questionFromAnswer(a Answer) Question {
-	return Question{Name: a.Name, Type: a.RrType, Class: a.RrClass}
+func questionFromAnswer(a RR) Question {
+	return Question{Name: a.Owner(), Type: a.RRType(), Class: a.Header().Class}
 }
 
 func nameIsBeneath(name, layer string) (bool, string) {
@@ -90,16 +90,12 @@ func nextAuthority(name, layer string) (string, error) {
 
 func checkGlue(server string, depth int, result Result) (Result, zdns.Status) {
 	for _, additional := range result.Additional {
-		ans, ok := additional.(Answer)
-		if !ok {
-			continue
-		}
-		if ans.Type == "A" && strings.TrimSuffix(ans.Name, ".") == server {
+		if additional.RRType() == dns.TypeA && strings.TrimSuffix(additional.Owner(), ".") == server {
 			var retv Result
-			retv.Authorities = make([]interface{}, 0)
-			retv.Answers = make([]interface{}, 0)
-			retv.Additional = make([]interface{}, 0)
-			retv.Answers = append(retv.Answers, ans)
+			retv.Authorities = make([]RR, 0)
+			retv.Answers = make([]RR, 0)
+			retv.Additional = make([]RR, 0)
+			retv.Answers = append(retv.Answers, additional)
 			return retv, zdns.STATUS_NOERROR
 		}
 	}
@@ -0,0 +1,114 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"net"
+	"time"
+
+	"github.com/zmap/dns"
+	"github.com/zmap/zdns/pkg/zdns"
+)
+
+// tapConn keeps what is written to and read from a TCP or TLS connection, the
+// latter before encryption. Messages
+// are prefixed with their length there, which is stripped by the
+// exchange. Failed writes never made it onto the wire and are left out.
+type tapConn struct {
+	net.Conn
+	written      []byte
+	read         []byte
+	responseTime time.Time
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written = append(c.written, p[:n]...)
+	return n, err
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read = append(c.read, p[:n]...)
+	c.responseTime = time.Now()
+	return n, err
+}
+
+func (c *tapConn) exchange(protocol string, remote net.Addr, queryTime time.Time) *zdns.WireExchange {
+	e := &zdns.WireExchange{
+		Protocol:   protocol,
+		LocalAddr:  c.LocalAddr(),
+		RemoteAddr: remote,
+		QueryTime:  queryTime,
+		Query:      c.written,
+	}
+	if len(c.read) > 0 {
+		e.ResponseTime = c.responseTime
+		e.Response = c.read
+	}
+	if protocol != "udp" {
+		e.Query = stripLength(e.Query)
+		e.Response = stripLength(e.Response)
+	}
+	return e
+}
+
+func stripLength(msg []byte) []byte {
+	if len(msg) < 2 {
+		return nil
+	}
+	return msg[2:]
+}
+
+// tapPacketConn is a tapConn of a UDP socket, where every read and write is
+// a whole message. Only the last message read is kept, as earlier ones were
+// responses to other queries.
+type tapPacketConn struct {
+	tapConn
+	pc net.PacketConn
+}
+
+func (c *tapPacketConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read = append(c.read[:0], p[:n]...)
+	c.responseTime = time.Now()
+	return n, err
+}
+
+func (c *tapPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.pc.ReadFrom(p)
+	c.read = append(c.read[:0], p[:n]...)
+	c.responseTime = time.Now()
+	return n, addr, err
+}
+
+func (c *tapPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.pc.WriteTo(p, addr)
+	c.written = append(c.written, p[:n]...)
+	return n, err
+}
+
+// tap returns a copy of conn that keeps the messages exchanged over it
+func tap(conn *dns.Conn) (*dns.Conn, *tapConn) {
+	tapped := *conn
+	if pc, ok := conn.Conn.(net.PacketConn); ok {
+		c := &tapPacketConn{tapConn: tapConn{Conn: conn.Conn}, pc: pc}
+		tapped.Conn = c
+		return &tapped, &c.tapConn
+	}
+	c := &tapConn{Conn: conn.Conn}
+	tapped.Conn = c
+	return &tapped, c
+}
@@ -0,0 +1,235 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zmap/dns"
+)
+
+// ZoneName returns a name as found in answers in presentation format, i.e.
+// fully qualified
+func ZoneName(name string) string {
+	return dns.Fqdn(name)
+}
+
+// ZoneText returns TXT data as found in answers, whose strings are joined by
+// newlines, as quoted presentation format strings
+func ZoneText(txt string) string {
+	parts := strings.Split(txt, "\n")
+	for i, p := range parts {
+		parts[i] = `"` + p + `"`
+	}
+	return strings.Join(parts, " ")
+}
+
+func quote(s string) string {
+	return `"` + s + `"`
+}
+
+// rdataOf returns the part of the presentation format of rr after its
+// header, which is made of four tab-separated fields
+func rdataOf(rr dns.RR) string {
+	fields := strings.SplitN(rr.String(), "\t", 5)
+	if len(fields) < 5 {
+		return ""
+	}
+	return fields[4]
+}
+
+// splitHex writes 16 hex digits as four groups separated by colons, as NID
+// and L64 records are presented
+func splitHex(s string) string {
+	if len(s) != 16 {
+		return s
+	}
+	return s[0:4] + ":" + s[4:8] + ":" + s[8:12] + ":" + s[12:16]
+}
+
+// ZoneRdata returns the data of a record returned by ParseAnswer in RFC 1035
+// presentation format. It fails for pseudo-records such as OPT and TKEY and
+// for data that isn't kept by ParseAnswer.
+func ZoneRdata(rec interface{}) (string, bool) {
+	switch a := rec.(type) {
+	case Answer:
+		return baseZoneRdata(a)
+	case PrefAnswer:
+		switch a.Type {
+		case "NID", "L64":
+			return fmt.Sprintf("%d %s", a.Preference, splitHex(a.Answer.Answer)), true
+		case "L32":
+			return fmt.Sprintf("%d %s", a.Preference, a.Answer.Answer), true
+		}
+		return fmt.Sprintf("%d %s", a.Preference, ZoneName(a.Answer.Answer)), true
+	case SOAAnswer:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", ZoneName(a.Ns), ZoneName(a.Mbox), a.Serial, a.Refresh, a.Retry, a.Expire, a.Minttl), true
+	case CAAAnswer:
+		return fmt.Sprintf("%d %s %s", a.Flag, a.Tag, quote(a.Value)), true
+	case SRVAnswer:
+		return fmt.Sprintf("%d %d %d %s", a.Priority, a.Weight, a.Port, ZoneName(a.Target)), true
+	case DSAnswer:
+		return fmt.Sprintf("%d %d %d %s", a.KeyTag, a.Algorithm, a.DigestType, strings.ToUpper(a.Digest)), true
+	case RRSIGAnswer:
+		return fmt.Sprintf("%s %d %d %d %s %s %d %s %s", dns.Type(a.TypeCovered), a.Algorithm, a.Labels, a.OriginalTtl,
+			a.Expiration, a.Inception, a.KeyTag, ZoneName(a.SignerName), a.Signature), true
+	case TLSAAnswer:
+		return fmt.Sprintf("%d %d %d %s", a.CertUsage, a.Selector, a.MatchingType, a.Certificate), true
+	case SMIMEAAnswer:
+		return fmt.Sprintf("%d %d %d %s", a.Usage, a.Selector, a.MatchingType, a.Certificate), true
+	case NSECAnswer:
+		return strings.TrimSpace(ZoneName(a.NextDomain) + " " + a.TypeBitMap), true
+	case NSEC3Answer:
+		salt := a.Salt
+		if salt == "" {
+			salt = "-"
+		}
+		if a.Type == "NSEC3PARAM" {
+			return fmt.Sprintf("%d %d %d %s", a.HashAlgorithm, a.Flags, a.Iterations, salt), true
+		}
+		return strings.TrimSpace(fmt.Sprintf("%d %d %d %s %s %s", a.HashAlgorithm, a.Flags, a.Iterations, salt, a.NextDomain, a.TypeBitMap)), true
+	case NAPTRAnswer:
+		return fmt.Sprintf("%d %d %s %s %s %s", a.Order, a.Preference, quote(a.Flags), quote(a.Service), quote(a.Regexp), ZoneName(a.Replacement)), true
+	case HINFOAnswer:
+		return quote(a.Cpu) + " " + quote(a.Os), true
+	case MINFOAnswer:
+		return ZoneName(a.Rmail) + " " + ZoneName(a.Email), true
+	case DNSKEYAnswer:
+		return fmt.Sprintf("%d %d %d %s", a.Flags, a.Protocol, a.Algorithm, a.PublicKey), true
+	case AFSDBAnswer:
+		return fmt.Sprintf("%d %s", a.Subtype, ZoneName(a.Hostname)), true
+	case CERTAnswer:
+		if a.Type == "" || a.Algorithm == "" {
+			return "", false
+		}
+		return fmt.Sprintf("%s %d %s %s", a.Type, a.KeyTag, a.Algorithm, a.Certificate), true
+	case PXAnswer:
+		return fmt.Sprintf("%d %s %s", a.Preference, ZoneName(a.Map822), ZoneName(a.Mapx400)), true
+	case GPOSAnswer:
+		return a.Longitude + " " + a.Latitude + " " + a.Altitude, true
+	case LOCAnswer:
+		return rdataOf(&dns.LOC{
+			Hdr:     dns.RR_Header{Rrtype: dns.TypeLOC},
+			Version: a.Version, Size: a.Size, HorizPre: a.HorizPre, VertPre: a.VertPre,
+			Latitude: a.Latitude, Longitude: a.Longitude, Altitude: a.Altitude,
+		}), true
+	case HIPAnswer:
+		data := fmt.Sprintf("%d %s %s", a.PublicKeyAlgorithm, a.Hit, a.PublicKey)
+		for _, s := range a.RendezvousServers {
+			data += " " + ZoneName(s)
+		}
+		return data, true
+	case SSHFPAnswer:
+		return fmt.Sprintf("%d %d %s", a.Algorithm, a.Type, strings.ToUpper(a.FingerPrint)), true
+	case TALINKAnswer:
+		return ZoneName(a.PreviousName) + " " + ZoneName(a.NextName), true
+	case SVCBAnswer:
+		return svcbZoneRdata(a), true
+	case RPAnswer:
+		return ZoneName(a.Mbox) + " " + ZoneName(a.Txt), true
+	case URIAnswer:
+		return fmt.Sprintf("%d %d %s", a.Priority, a.Weight, quote(a.Target)), true
+	case APLAnswer:
+		return strings.Join(a.Prefixes, " "), true
+	case CSYNCAnswer:
+		return strings.TrimSpace(fmt.Sprintf("%d %d %s", a.Serial, a.Flags, a.TypeBitMap)), true
+	case ZONEMDAnswer:
+		return fmt.Sprintf("%d %d %d %s", a.Serial, a.Scheme, a.Hash, a.Digest), true
+	}
+	return "", false
+}
+
+func baseZoneRdata(a Answer) (string, bool) {
+	switch a.Type {
+	case "NS", "CNAME", "DNAME", "PTR", "MB", "MG", "MF", "MD", "MR", "NSAP-PTR":
+		return ZoneName(a.Answer), true
+	case "TXT", "AVC", "NINFO":
+		return ZoneText(a.Answer), true
+	case "SPF":
+		// the answer holds the whole record
+		rr, err := dns.NewRR(a.Answer)
+		if err != nil || rr == nil {
+			return "", false
+		}
+		return rdataOf(rr), true
+	case "UINFO":
+		return quote(a.Answer), true
+	case "NULL":
+		// NULL has no presentation format other than the generic one
+		return fmt.Sprintf(`\# %d %s`, len(a.Answer), hex.EncodeToString([]byte(a.Answer))), true
+	}
+	return a.Answer, true
+}
+
+func svcbZoneRdata(a SVCBAnswer) string {
+	data := fmt.Sprintf("%d %s", a.Priority, ZoneName(a.Target))
+	keys := make([]string, 0, len(a.SVCParams))
+	for k := range a.SVCParams {
+		keys = append(keys, k)
+	}
+	// keys are written in the order of their numbers
+	sort.Slice(keys, func(i, j int) bool {
+		return svcbKeyOrder(keys[i]) < svcbKeyOrder(keys[j])
+	})
+	for _, k := range keys {
+		var v string
+		switch p := a.SVCParams[k].(type) {
+		case bool:
+			data += " " + k
+			continue
+		case []string:
+			v = strings.Join(p, ",")
+		case []net.IP:
+			ips := make([]string, len(p))
+			for i, ip := range p {
+				ips[i] = ip.String()
+			}
+			v = strings.Join(ips, ",")
+		case uint16:
+			v = strconv.Itoa(int(p))
+		case []byte:
+			if k == "echconfig" {
+				v = base64.StdEncoding.EncodeToString(p)
+			} else {
+				v = string(p)
+			}
+		default:
+			v = fmt.Sprint(p)
+		}
+		data += " " + k + "=" + quote(v)
+	}
+	return data
+}
+
+var svcbKeys = []string{"mandatory", "alpn", "no-default-alpn", "port", "ipv4hint", "echconfig", "ipv6hint"}
+
+// svcbKeyOrder returns the number of an SVCB key as named by ParseAnswer
+func svcbKeyOrder(key string) int {
+	for i, k := range svcbKeys {
+		if k == key {
+			return i
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(key, "key")); err == nil {
+		return n
+	}
+	return 1 << 16
+}
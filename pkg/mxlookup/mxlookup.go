@@ -15,6 +15,9 @@
 package mxlookup
 
 import (
+	"context"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -46,6 +49,28 @@ type Result struct {
 	Servers []MXRecord `json:"exchanges" groups:"short,normal,long,trace"`
 }
 
+// FlatRecords returns an MX record per exchange, followed by the addresses
+// of the exchanges as additional A and AAAA records
+func (r Result) FlatRecords(name string) []zdns.FlatRecord {
+	var records []zdns.FlatRecord
+	for _, mx := range r.Servers {
+		records = append(records, zdns.FlatRecord{
+			Section: "answer",
+			Name:    name,
+			Type:    mx.Type,
+			Class:   mx.Class,
+			TTL:     mx.TTL,
+			HasTTL:  true,
+			Answer:  strconv.Itoa(int(mx.Preference)) + " " + mx.Name,
+			Rdata:   strconv.Itoa(int(mx.Preference)) + " " + miekg.ZoneName(mx.Name),
+		})
+	}
+	for _, mx := range r.Servers {
+		records = append(records, miekg.FlatAddresses("additional", mx.Name, mx.IPv4Addresses, mx.IPv6Addresses)...)
+	}
+	return records
+}
+
 // Per Connection Lookup ======================================================
 type Lookup struct {
 	Factory *RoutineLookupFactory
@@ -55,11 +80,11 @@ type Lookup struct {
 // This LookupClient is created to call the actual implementation of DoMiekgLookup
 type LookupClient struct{}
 
-func (lc LookupClient) ProtocolLookup(s *miekg.Lookup, q miekg.Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
-	return s.DoMiekgLookup(q, nameServer)
+func (lc LookupClient) ProtocolLookup(ctx context.Context, s *miekg.Lookup, q miekg.Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoMiekgLookup(ctx, q, nameServer)
 }
 
-func (s *Lookup) LookupIPs(l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (CachedAddresses, zdns.Trace) {
+func (s *Lookup) LookupIPs(ctx context.Context, l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (CachedAddresses, zdns.Trace) {
 	s.Factory.Factory.CHmu.Lock()
 	// XXX this should be changed to a miekglookup
 	res, found := s.Factory.Factory.CacheHash.Get(name)
@@ -68,7 +93,7 @@ func (s *Lookup) LookupIPs(l LookupClient, name, nameServer string, lookupIpv4 b
 		return res.(CachedAddresses), make([]interface{}, 0)
 	}
 	retv := CachedAddresses{}
-	res, trace, status, _ := s.DoTargetedLookup(l, name, nameServer, lookupIpv4, lookupIpv6)
+	res, trace, status, _ := s.DoTargetedLookup(ctx, l, name, nameServer, lookupIpv4, lookupIpv6)
 	if status == zdns.STATUS_NOERROR && res != nil {
 		retv.IPv4Addresses = res.(miekg.IpResult).IPv4Addresses
 		retv.IPv6Addresses = res.(miekg.IpResult).IPv6Addresses
@@ -81,8 +106,12 @@ func (s *Lookup) LookupIPs(l LookupClient, name, nameServer string, lookupIpv4 b
 }
 
 func (s *Lookup) DoLookup(name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoLookupContext(context.Background(), name, nameServer)
+}
+
+func (s *Lookup) DoLookupContext(ctx context.Context, name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
 	retv := Result{Servers: []MXRecord{}}
-	res, trace, status, err := s.DoMiekgLookup(miekg.Question{Name: name, Type: dns.TypeMX}, nameServer)
+	res, trace, status, err := s.DoMiekgLookup(ctx, miekg.Question{Name: name, Type: dns.TypeMX}, nameServer)
 	if status != zdns.STATUS_NOERROR || err != nil {
 		return nil, trace, status, err
 	}
@@ -94,10 +123,10 @@ func (s *Lookup) DoLookup(name, nameServer string) (interface{}, zdns.Trace, zdn
 	lookupIpv6 := s.Factory.Factory.IPv6Lookup
 	l := LookupClient{}
 	for _, ans := range r.Answers {
-		if mxAns, ok := ans.(miekg.PrefAnswer); ok {
+		if mxAns, ok := ans.AsMX(); ok {
 			name = strings.TrimSuffix(mxAns.Answer.Answer, ".")
 			rec := MXRecord{TTL: mxAns.Ttl, Type: mxAns.Type, Class: mxAns.Class, Name: name, Preference: mxAns.Preference}
-			ips, secondTrace := s.LookupIPs(l, name, nameServer, lookupIpv4, lookupIpv6)
+			ips, secondTrace := s.LookupIPs(ctx, l, name, nameServer, lookupIpv4, lookupIpv6)
 			rec.IPv4Addresses = ips.IPv4Addresses
 			rec.IPv6Addresses = ips.IPv6Addresses
 			retv.Servers = append(retv.Servers, rec)
@@ -130,6 +159,10 @@ type GlobalLookupFactory struct {
 	CHmu        sync.Mutex
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 func (s *GlobalLookupFactory) SetFlags(f *pflag.FlagSet) {
 	// If there's an error, panic is appropriate since we should at least be getting the default here.
 	var err error
@@ -15,9 +15,13 @@
 package mxlookup
 
 import (
+	"context"
+	"encoding/json"
 	"reflect"
 	"testing"
 
+	"github.com/hashicorp/go-version"
+	"github.com/liip/sheriff"
 	"github.com/zmap/dns"
 	"github.com/zmap/zdns/pkg/miekg"
 	"github.com/zmap/zdns/pkg/zdns"
@@ -27,7 +31,7 @@ var mxResults = make(map[string]miekg.Result)
 var miekgStatus = zdns.STATUS_NOERROR
 
 // Mock the actual Miekg lookup for querying MX records
-func (s *Lookup) DoMiekgLookup(question miekg.Question, nameServer string) (interface{}, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoMiekgLookup(ctx context.Context, question miekg.Question, nameServer string) (interface{}, []interface{}, zdns.Status, error) {
 	if res, ok := mxResults[question.Name]; ok {
 		return res, nil, miekgStatus, nil
 	} else {
@@ -38,7 +42,7 @@ func (s *Lookup) DoMiekgLookup(question miekg.Question, nameServer string) (inte
 var mockResults = make(map[string]miekg.IpResult)
 var protocolStatus = zdns.STATUS_NOERROR
 
-func (s *Lookup) DoTargetedLookup(l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (interface{}, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoTargetedLookup(ctx context.Context, l LookupClient, name, nameServer string, lookupIpv4 bool, lookupIpv6 bool) (interface{}, []interface{}, zdns.Status, error) {
 	if !miekg.SafeStatus(protocolStatus) {
 		return nil, nil, protocolStatus, nil
 	}
@@ -95,7 +99,7 @@ func TestMxA(t *testing.T) {
 	_, _, _, l := InitTest(t)
 
 	mxResults["example.com"] = miekg.Result{
-		Answers: []interface{}{miekg.PrefAnswer{
+		Answers: []miekg.RR{miekg.PrefAnswer{
 			Answer: miekg.Answer{
 				Ttl:    3600,
 				Type:   "MX",
@@ -130,7 +134,7 @@ func TestTwoMxA(t *testing.T) {
 	glf.IPv4Lookup = true
 
 	mxResults["example.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.PrefAnswer{
 				Answer: miekg.Answer{
 					Ttl:    3600,
@@ -185,7 +189,7 @@ func TestMxAandQuadA(t *testing.T) {
 	glf.IPv6Lookup = true
 
 	mxResults["example.com"] = miekg.Result{
-		Answers: []interface{}{miekg.PrefAnswer{
+		Answers: []miekg.RR{miekg.PrefAnswer{
 			Answer: miekg.Answer{
 				Ttl:    3600,
 				Type:   "MX",
@@ -221,7 +225,7 @@ func TestEmptyMx(t *testing.T) {
 	glf.IPv6Lookup = true
 
 	mxResults["example.com"] = miekg.Result{
-		Answers: []interface{}{miekg.PrefAnswer{
+		Answers: []miekg.RR{miekg.PrefAnswer{
 			Answer: miekg.Answer{
 				Ttl:    3600,
 				Type:   "MX",
@@ -272,7 +276,7 @@ func TestServFail(t *testing.T) {
 func TestErrorInTargetedLookup(t *testing.T) {
 	_, _, _, l := InitTest(t)
 	mxResults["example.com"] = miekg.Result{
-		Answers: []interface{}{miekg.PrefAnswer{
+		Answers: []miekg.RR{miekg.PrefAnswer{
 			Answer: miekg.Answer{
 				Ttl:    3600,
 				Type:   "MX",
@@ -321,3 +325,64 @@ func verifyResult(t *testing.T, servers []MXRecord, expectedServersMap map[strin
 		}
 	}
 }
+
+func encodeTestResult() *zdns.Result {
+	res := Result{}
+	for i, name := range []string{"mx1.example.com", "mx2.example.com", "mx3.example.com"} {
+		res.Servers = append(res.Servers, MXRecord{
+			Name: name, Type: "MX", Class: "IN", Preference: uint16(10 * (i + 1)), TTL: 3600,
+			IPv4Addresses: []string{"192.0.2.1", "192.0.2.2"},
+			IPv6Addresses: []string{"2001:db8::1"},
+		})
+	}
+	res.Servers = append(res.Servers, MXRecord{Name: "mx4.example.com", Type: "MX", Class: "IN", Preference: 40})
+	return &zdns.Result{Name: "example.com", Status: "NOERROR", Timestamp: "2022-01-01T00:00:00Z", Data: res}
+}
+
+func sheriffJSON(groups []string, res *zdns.Result) ([]byte, error) {
+	v, _ := version.NewVersion("0.0.0")
+	data, err := sheriff.Marshal(&sheriff.Options{Groups: groups, ApiVersion: v}, res)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+func TestResultEncoder(t *testing.T) {
+	res := encodeTestResult()
+	for _, groups := range [][]string{{"short", ""}, {"normal", ""}, {"short", "ttl"}} {
+		out, err := zdns.NewResultEncoder(groups).Encode(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := sheriffJSON(groups, res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != string(expected) {
+			t.Errorf("groups %v: expected %s, got %s", groups, expected, out)
+		}
+	}
+}
+
+func BenchmarkEncodeResult(b *testing.B) {
+	res := encodeTestResult()
+	groups := []string{"normal", ""}
+	b.Run("sheriff", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := sheriffJSON(groups, res); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encoder", func(b *testing.B) {
+		e := zdns.NewResultEncoder(groups)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := e.Encode(res); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -15,10 +15,12 @@
 package nslookup
 
 import (
+	"context"
 	"github.com/spf13/pflag"
 	"github.com/zmap/dns"
 	"github.com/zmap/zdns/pkg/miekg"
 	"github.com/zmap/zdns/pkg/zdns"
+	"reflect"
 )
 
 // result to be returned by scan of host
@@ -49,15 +51,19 @@ type Lookup struct {
 // This LookupClient is created to call the actual implementation of DoMiekgLookup
 type LookupClient struct{}
 
-func (lc LookupClient) ProtocolLookup(s *miekg.Lookup, q miekg.Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
-	return s.DoMiekgLookup(q, nameServer)
+func (lc LookupClient) ProtocolLookup(ctx context.Context, s *miekg.Lookup, q miekg.Question, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoMiekgLookup(ctx, q, nameServer)
 }
 
 func (s *Lookup) DoLookup(name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	return s.DoLookupContext(context.Background(), name, nameServer)
+}
+
+func (s *Lookup) DoLookupContext(ctx context.Context, name, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
 	l := LookupClient{}
 	lookupIpv4 := s.Factory.Factory.IPv4Lookup || !s.Factory.Factory.IPv6Lookup
 	lookupIpv6 := s.Factory.Factory.IPv6Lookup
-	return s.DoNSLookup(l, name, lookupIpv4, lookupIpv6, nameServer)
+	return s.DoNSLookup(ctx, l, name, lookupIpv4, lookupIpv6, nameServer)
 }
 
 // Per GoRoutine Factory ======================================================
@@ -80,6 +86,10 @@ type GlobalLookupFactory struct {
 	IPv6Lookup bool
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(miekg.NSResult{})
+}
+
 func (s *GlobalLookupFactory) SetFlags(f *pflag.FlagSet) {
 	// If there's an error, panic is appropriate since we should at least be getting the default here.
 	var err error
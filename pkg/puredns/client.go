@@ -63,6 +63,11 @@ func (u *udpConnPool) get() net.Conn {
 	return conn
 }
 
+// report 上报连接池中空闲和已打开的连接数
+func (u *udpConnPool) report(m zdns.Metrics) {
+	m.UDPPoolConns(u.pool.Len(), u.pool.Open())
+}
+
 type Client struct {
 	smp           *semaphore.Weighted
 	udpConnPool   udpConnPool
@@ -161,6 +166,11 @@ func SetLogger(ctx context.Context, handler *slog.Logger) context.Context {
 	return context.WithValue(ctx, clientLogger{}, handler)
 }
 
+// SetMetrics 设置接收查询、缓存和连接池指标的 Metrics，需在 Lookups 之前调用
+func (c *Client) SetMetrics(m zdns.Metrics) {
+	c.globalFactory.GlobalConf.Metrics = m
+}
+
 func (c *Client) getLockupLogger(ctx context.Context) *slog.Logger {
 	v := ctx.Value(clientLogger{})
 	if v == nil {
@@ -202,18 +212,23 @@ func (c *Client) Lookups(ctx context.Context, resolveTy dns.Type, arr []string)
 			r.Conn = new(dns.Conn)
 			r.Conn.Conn = c.udpConnPool.get()
 			udpConn := r.Conn.Conn
+			metrics := c.globalFactory.GlobalConf.GetMetrics()
+			c.udpConnPool.report(metrics)
 
 			defer func() {
 				_ = udpConn.SetDeadline(time.Time{})
 				r.Conn.Conn = nil
 
 				c.udpConnPool.put(udpConn)
+				c.udpConnPool.report(metrics)
 			}()
 
 			logger := logger.With(slog.String("name", name))
 			lookup, _ := r.MakeLookup()
 
-			res, _, status, err := lookup.DoLookup(name, "")
+			start := time.Now()
+			res, _, status, err := lookup.DoLookupContext(ctx, name, "")
+			metrics.LookupDone(resolveTy.String(), status, time.Since(start))
 			if err != nil {
 				logger.Warn("dns解析失败", slog.String("err", err.Error()))
 				return
@@ -230,10 +245,8 @@ func (c *Client) Lookups(ctx context.Context, resolveTy dns.Type, arr []string)
 				return
 			}
 
-			resultAnswer := lo.FilterMap(result.Answers, func(item any, index int) (miekg.Answer, bool) {
-				answer, ok := item.(miekg.Answer)
-
-				return answer, ok
+			resultAnswer := lo.Filter(result.Answers, func(item miekg.RR, index int) bool {
+				return item.Data() != ""
 			})
 			if len(resultAnswer) == 0 {
 				return
@@ -244,12 +257,12 @@ func (c *Client) Lookups(ctx context.Context, resolveTy dns.Type, arr []string)
 
 			lockups = append(lockups, LookupResult{
 				Name: name,
-				Answer: lo.Uniq(lo.Map(resultAnswer, func(item miekg.Answer, index int) RR {
+				Answer: lo.Uniq(lo.Map(resultAnswer, func(item miekg.RR, index int) RR {
 					return RR{
-						Name:  item.Name,
-						TTL:   item.Ttl,
-						Type:  dns.Type(dns.StringToType[item.Type]),
-						Value: item.Answer,
+						Name:  item.Owner(),
+						TTL:   item.TTL(),
+						Type:  dns.Type(item.RRType()),
+						Value: item.Data(),
 					}
 				})),
 			})
@@ -233,3 +233,10 @@ func (c *pool[T]) Release() {
 func (c *pool[T]) Len() int {
 	return len(c.getConns())
 }
+
+// Open 已打开的连接，包括空闲和正在使用的
+func (c *pool[T]) Open() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.openingConns
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"log/slog"
 	"net"
-	"reflect"
 	"time"
 
 	"github.com/samber/lo"
@@ -76,7 +75,7 @@ func (c *ZClient) Lookups(ctx context.Context, resolveTy dns.Type, arr []string)
 
 	var logger = c.getLockupLogger(ctx)
 	go func() {
-		if err := zdns.Run2(execGc, in, out); err != nil {
+		if err := zdns.Run2(ctx, execGc, in, out); err != nil {
 			logger.Error("执行dns查询错误", slog.String("err", err.Error()))
 			return
 		}
@@ -96,13 +95,13 @@ func (c *ZClient) Lookups(ctx context.Context, resolveTy dns.Type, arr []string)
 			continue
 		}
 
-		resultAnswer := lo.FilterMap(result.Answers, func(item any, index int) (miekg.Answer, bool) {
-			answer, ok := item.(miekg.Answer)
+		resultAnswer := lo.Filter(result.Answers, func(item miekg.RR, index int) bool {
+			ok := item.Data() != ""
 			if !ok {
-				slog.Warn("未知的类型", slog.String("类型", reflect.TypeOf(item).String()))
+				slog.Warn("未知的类型", slog.String("类型", dns.TypeToString[item.RRType()]))
 			}
 
-			return answer, ok
+			return ok
 		})
 		if len(resultAnswer) == 0 {
 			continue
@@ -110,12 +109,12 @@ func (c *ZClient) Lookups(ctx context.Context, resolveTy dns.Type, arr []string)
 
 		rr = append(rr, LookupResult{
 			Name: zdnsResult.Name,
-			Answer: lo.Uniq(lo.Map(resultAnswer, func(item miekg.Answer, index int) RR {
+			Answer: lo.Uniq(lo.Map(resultAnswer, func(item miekg.RR, index int) RR {
 				return RR{
-					Name:  item.Name,
-					TTL:   item.Ttl,
-					Type:  dns.Type(dns.StringToType[item.Type]),
-					Value: item.Answer,
+					Name:  item.Owner(),
+					TTL:   item.TTL(),
+					Type:  dns.Type(item.RRType()),
+					Value: item.Data(),
 				}
 			})),
 		})
@@ -15,6 +15,8 @@
 package spf
 
 import (
+	"context"
+	"reflect"
 	"regexp"
 
 	"github.com/zmap/dns"
@@ -29,6 +31,13 @@ type Result struct {
 	Spf string `json:"spf,omitempty" groups:"short,normal,long,trace"`
 }
 
+func (r Result) FlatRecords(name string) []zdns.FlatRecord {
+	if r.Spf == "" {
+		return nil
+	}
+	return []zdns.FlatRecord{{Section: "answer", Name: name, Type: "TXT", Answer: r.Spf, Rdata: miekg.ZoneText(r.Spf)}}
+}
+
 // Per Connection Lookup ======================================================
 type Lookup struct {
 	Factory *RoutineLookupFactory
@@ -36,7 +45,11 @@ type Lookup struct {
 }
 
 func (s *Lookup) DoLookup(name string, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
-	innerRes, trace, status, err := s.DoMiekgLookup(miekg.Question{Name: name, Type: s.DNSType, Class: s.DNSClass}, nameServer)
+	return s.DoLookupContext(context.Background(), name, nameServer)
+}
+
+func (s *Lookup) DoLookupContext(ctx context.Context, name string, nameServer string) (interface{}, zdns.Trace, zdns.Status, error) {
+	innerRes, trace, status, err := s.DoMiekgLookup(ctx, miekg.Question{Name: name, Type: s.DNSType, Class: s.DNSClass}, nameServer)
 	resString, resStatus, err := s.CheckTxtRecords(innerRes, status, err)
 	res := Result{Spf: resString}
 	return res, trace, resStatus, err
@@ -64,6 +77,10 @@ type GlobalLookupFactory struct {
 	miekg.GlobalLookupFactory
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
 	rlf := new(RoutineLookupFactory)
 	rlf.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
@@ -15,6 +15,7 @@
 package spf
 
 import (
+	"context"
 	"testing"
 
 	"github.com/zmap/dns"
@@ -31,7 +32,7 @@ type QueryRecord struct {
 var mockResults = make(map[string]miekg.Result)
 var queries []QueryRecord
 
-func (s *Lookup) DoMiekgLookup(question miekg.Question, nameServer string) (miekg.Result, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoMiekgLookup(ctx context.Context, question miekg.Question, nameServer string) (miekg.Result, []interface{}, zdns.Status, error) {
 	queries = append(queries, QueryRecord{Question: question, NameServer: nameServer})
 	if res, ok := mockResults[question.Name]; ok {
 		return res, nil, zdns.STATUS_NOERROR, nil
@@ -63,7 +64,7 @@ func InitTest() (*zdns.GlobalConf, *GlobalLookupFactory, *RoutineLookupFactory,
 func TestLookup_DoTxtLookup_Valid_1(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["google.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "google.com", Answer: "some TXT record"},
 			miekg.Answer{Name: "google.com", Answer: "v=spf1 mx include:_spf.google.com -all"}},
 	}
@@ -80,7 +81,7 @@ func TestLookup_DoTxtLookup_Valid_1(t *testing.T) {
 func TestLookup_DoTxtLookup_Valid_2(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["google.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "google.com", Answer: "some TXT record"},
 			miekg.Answer{Name: "google.com", Answer: "V=SpF1 mx include:_spf.google.com -all"}},
 	}
@@ -97,7 +98,7 @@ func TestLookup_DoTxtLookup_Valid_2(t *testing.T) {
 func TestLookup_DoTxtLookup_NotValid_1(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["google.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "google.com", Answer: "some TXT record"},
 			miekg.Answer{Name: "google.com", Answer: "  V  =  SpF1 mx include:_spf.google.com -all"}},
 	}
@@ -114,7 +115,7 @@ func TestLookup_DoTxtLookup_NotValid_1(t *testing.T) {
 func TestLookup_DoTxtLookup_NotValid_2(t *testing.T) {
 	_, _, _, l := InitTest()
 	mockResults["google.com"] = miekg.Result{
-		Answers: []interface{}{
+		Answers: []miekg.RR{
 			miekg.Answer{Name: "google.com", Answer: "some TXT record"},
 			miekg.Answer{Name: "google.com", Answer: "some other TXT record but no SPF"}},
 	}
@@ -0,0 +1,48 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sqlite
+
+import (
+	"encoding/json"
+
+	"github.com/zmap/zdns/pkg/zdns"
+	"github.com/zmap/zdns/pkg/zdnsreader"
+)
+
+// records splits the JSON data of a lookup of name by module into records.
+// The data is read back into the module's result type, which splits it the
+// same way for the flat output formats.
+func records(module, name string, raw json.RawMessage) ([]zdns.FlatRecord, error) {
+	data, err := zdnsreader.DecodeData(module, raw)
+	if err != nil {
+		return nil, err
+	}
+	return zdns.FlatRecords(name, data)
+}
+
+// writesTTLs tells whether the TTLs of records are written with groups. As
+// for the JSON output, no groups means every field.
+func writesTTLs(groups []string) bool {
+	if len(groups) == 0 {
+		return true
+	}
+	for _, g := range groups {
+		switch g {
+		case "ttl", "normal", "long", "trace":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,356 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package sqlite provides the sqlite output handler, which writes results to
+// a SQLite database as a row per lookup and a row per record
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/zmap/zdns/pkg/zdns"
+	_ "modernc.org/sqlite"
+)
+
+// A database holds any number of runs, each made of the lookups of a scan.
+// The records of a lookup are those the flat output formats write for it,
+// i.e. those of its answer, authority and additional sections. rdata is the
+// data of a record in RFC 1035 presentation format, e.g. "10 mail.example.com."
+// for MX, if it can be written that way.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	module TEXT NOT NULL,
+	start_time TEXT NOT NULL,
+	end_time TEXT,
+	names INTEGER,
+	statuses TEXT,
+	name_servers TEXT,
+	conf TEXT
+);
+CREATE TABLE IF NOT EXISTS lookups (
+	id INTEGER PRIMARY KEY,
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	name TEXT NOT NULL,
+	altered_name TEXT,
+	class TEXT,
+	module TEXT NOT NULL,
+	status TEXT,
+	error TEXT,
+	resolver TEXT,
+	timestamp TEXT,
+	metadata TEXT
+);
+CREATE TABLE IF NOT EXISTS records (
+	id INTEGER PRIMARY KEY,
+	lookup_id INTEGER NOT NULL REFERENCES lookups(id),
+	section TEXT NOT NULL,
+	name TEXT,
+	type TEXT,
+	class TEXT,
+	ttl INTEGER,
+	answer TEXT,
+	rdata TEXT,
+	resolver TEXT
+);
+`
+
+// indexes are only created once all results are written, which is much
+// faster than keeping them up to date along the way
+const indexes = `
+CREATE INDEX IF NOT EXISTS lookups_run_id ON lookups(run_id);
+CREATE INDEX IF NOT EXISTS lookups_name ON lookups(name);
+CREATE INDEX IF NOT EXISTS lookups_status ON lookups(status);
+CREATE INDEX IF NOT EXISTS records_lookup_id ON records(lookup_id);
+CREATE INDEX IF NOT EXISTS records_name_type ON records(name, type);
+`
+
+// OutputHandler writes results, which must be JSON, to the SQLite database
+// at a path. Lookups are inserted in transactions of batchSize results.
+type OutputHandler struct {
+	path      string
+	batchSize int
+	conf      *zdns.GlobalConf
+}
+
+func NewOutputHandler(path string, batchSize int, conf *zdns.GlobalConf) *OutputHandler {
+	return &OutputHandler{path: path, batchSize: batchSize, conf: conf}
+}
+
+// result is a line of JSON output
+type result struct {
+	Name        string          `json:"name"`
+	Module      string          `json:"module"`
+	AlteredName string          `json:"altered_name"`
+	Nameserver  string          `json:"nameserver"`
+	Class       string          `json:"class"`
+	Metadata    json.RawMessage `json:"metadata"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error"`
+	Timestamp   string          `json:"timestamp"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// moduleResult is the outcome of one module when several are run per name
+type moduleResult struct {
+	Status string          `json:"status"`
+	Error  string          `json:"error"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// writer inserts results into an open database
+type writer struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	lookup  *sql.Stmt
+	record  *sql.Stmt
+	runID   int64
+	module  string
+	modules []string
+	// ttls is unset if TTLs aren't written, which leaves them null
+	ttls bool
+}
+
+func (w *writer) begin() error {
+	var err error
+	w.tx, err = w.db.Begin()
+	return err
+}
+
+func (w *writer) commit() error {
+	err := w.tx.Commit()
+	w.tx = nil
+	return err
+}
+
+func (h *OutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer wg.Done()
+	err := h.write(results)
+	// the lookups mustn't block on a handler that gave up
+	for range results {
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write sqlite file: %w", err)
+	}
+	return nil
+}
+
+func (h *OutputHandler) write(results <-chan string) (err error) {
+	db, err := sql.Open("sqlite", h.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	// transactions and statements have to share the connection
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	module := h.conf.Module
+	if len(h.conf.Modules) > 0 {
+		module = strings.Join(h.conf.Modules, ",")
+	}
+	run, err := db.Exec("INSERT INTO runs (module, start_time) VALUES (?, ?)", module, time.Now().Format(h.conf.TimeFormat))
+	if err != nil {
+		return err
+	}
+	w := &writer{db: db, module: h.conf.Module, modules: h.conf.Modules, ttls: writesTTLs(h.conf.OutputGroups)}
+	if w.runID, err = run.LastInsertId(); err != nil {
+		return err
+	}
+	if w.lookup, err = db.Prepare(`INSERT INTO lookups (run_id, name, altered_name, class, module, status, error, resolver, timestamp, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`); err != nil {
+		return err
+	}
+	defer w.lookup.Close()
+	if w.record, err = db.Prepare(`INSERT INTO records (lookup_id, section, name, type, class, ttl, answer, rdata, resolver)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`); err != nil {
+		return err
+	}
+	defer w.record.Close()
+
+	if err := w.begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if w.tx != nil {
+			w.tx.Rollback()
+		}
+	}()
+	var names int
+	statuses := make(map[string]int)
+	for line := range results {
+		var res result
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			return fmt.Errorf("unable to parse result: %w", err)
+		}
+		if err := w.insert(&res); err != nil {
+			return err
+		}
+		names++
+		statuses[res.Status]++
+		if names%h.batchSize == 0 {
+			if err := w.commit(); err != nil {
+				return err
+			}
+			if err := w.begin(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.commit(); err != nil {
+		return err
+	}
+	if _, err := db.Exec(indexes); err != nil {
+		return err
+	}
+	statusesJSON, _ := json.Marshal(statuses)
+	nameServers, _ := json.Marshal(h.conf.NameServers)
+	conf, err := json.Marshal(h.conf)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE runs SET end_time = ?, names = ?, statuses = ?, name_servers = ?, conf = ? WHERE id = ?",
+		time.Now().Format(h.conf.TimeFormat), names, string(statusesJSON), string(nameServers), string(conf), w.runID)
+	return err
+}
+
+// insert adds the lookups of res, which are one per module when several
+// modules are run
+func (w *writer) insert(res *result) error {
+	if res.Module != "" {
+		// a jsonl input line chose its own module
+		return w.insertLookup(res, res.Module, res.Status, res.Error, res.Data)
+	}
+	if len(w.modules) == 0 {
+		return w.insertLookup(res, w.module, res.Status, res.Error, res.Data)
+	}
+	var results map[string]moduleResult
+	if len(res.Data) > 0 {
+		if err := json.Unmarshal(res.Data, &results); err != nil {
+			return fmt.Errorf("unable to parse result: %w", err)
+		}
+	}
+	for _, module := range w.modules {
+		mr, ok := results[module]
+		if !ok {
+			continue
+		}
+		if err := w.insertLookup(res, module, mr.Status, mr.Error, mr.Data); err != nil {
+			return err
+		}
+	}
+	if len(results) == 0 {
+		// the name was rejected before any module ran
+		return w.insertLookup(res, "", res.Status, res.Error, nil)
+	}
+	return nil
+}
+
+func (w *writer) insertLookup(res *result, module, status, lookupErr string, raw json.RawMessage) error {
+	name := res.Name
+	if res.AlteredName != "" {
+		name = res.AlteredName
+	}
+	recs, err := records(module, name, raw)
+	if err != nil {
+		return fmt.Errorf("unable to parse result: %w", err)
+	}
+	// the name server that answered, if the module says
+	var data struct {
+		Resolver string `json:"resolver"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return fmt.Errorf("unable to parse result: %w", err)
+		}
+	}
+	resolver := res.Nameserver
+	if data.Resolver != "" {
+		resolver = data.Resolver
+	}
+	lookup, err := w.tx.Stmt(w.lookup).Exec(w.runID, res.Name, nullString(res.AlteredName), nullString(res.Class), module,
+		nullString(status), nullString(lookupErr), nullString(resolver), nullString(res.Timestamp), nullJSON(res.Metadata))
+	if err != nil {
+		return err
+	}
+	id, err := lookup.LastInsertId()
+	if err != nil {
+		return err
+	}
+	stmt := w.tx.Stmt(w.record)
+	for _, rec := range recs {
+		ttl := sql.NullInt64{Int64: int64(rec.TTL), Valid: rec.HasTTL && w.ttls}
+		if _, err := stmt.Exec(id, rec.Section, nullString(rec.Name), nullString(rec.Type), nullString(rec.Class), ttl,
+			nullString(rec.Answer), nullString(rec.Rdata), nullString(rec.Resolver)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullJSON(raw json.RawMessage) sql.NullString {
+	if len(raw) == 0 || string(raw) == "null" {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(raw), Valid: true}
+}
+
+type outputHandlerFactory struct{}
+
+func (outputHandlerFactory) AddFlags(flags *pflag.FlagSet) {
+	flags.String("sqlite-file", "", "SQLite database the sqlite output handler writes results to")
+	flags.Int("sqlite-batch-size", 10000, "number of results the sqlite output handler writes per transaction")
+}
+
+func (outputHandlerFactory) MakeOutputHandler(conf *zdns.GlobalConf, flags *pflag.FlagSet) (zdns.OutputHandler, error) {
+	path, err := flags.GetString("sqlite-file")
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := flags.GetInt("sqlite-batch-size")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, errors.New("sqlite output handler requires --sqlite-file")
+	}
+	if batchSize < 1 {
+		return nil, errors.New("--sqlite-batch-size must be positive")
+	}
+	// results are read back from their JSON
+	if conf.OutputFormat != zdns.OUTPUT_FORMAT_JSON {
+		return nil, errors.New("sqlite output handler requires --output-format json")
+	}
+	return NewOutputHandler(path, batchSize, conf), nil
+}
+
+func init() {
+	zdns.RegisterOutputHandler("sqlite", outputHandlerFactory{})
+}
@@ -0,0 +1,189 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmap/zdns/pkg/zdns"
+	"gotest.tools/v3/assert"
+)
+
+func writeResults(t *testing.T, h *OutputHandler, lines ...string) {
+	results := make(chan string, len(lines))
+	for _, l := range lines {
+		results <- l
+	}
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.NilError(t, h.WriteResults(results, &wg))
+	wg.Wait()
+}
+
+func dbRows(t *testing.T, db *sql.DB, query string) []string {
+	rows, err := db.Query(query)
+	assert.NilError(t, err)
+	defer rows.Close()
+	cols, err := rows.Columns()
+	assert.NilError(t, err)
+	var out []string
+	for rows.Next() {
+		vals := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		assert.NilError(t, rows.Scan(ptrs...))
+		fields := make([]string, len(cols))
+		for i, v := range vals {
+			fields[i] = v.String
+		}
+		out = append(out, strings.Join(fields, "|"))
+	}
+	assert.NilError(t, rows.Err())
+	return out
+}
+
+func TestOutputHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	gc := &zdns.GlobalConf{Module: "MX", TimeFormat: time.RFC3339, NameServers: []string{"127.0.0.1:53"}}
+	writeResults(t, NewOutputHandler(path, 1, gc),
+		`{"name":"example.com","status":"NOERROR","timestamp":"2022-01-01T00:00:00Z","metadata":{"rank":1},"data":{"answers":[{"name":"example.com","type":"MX","class":"IN","ttl":300,"answer":"mail.example.com.","preference":10}],"additionals":[{"name":"mail.example.com","type":"A","class":"IN","ttl":60,"answer":"192.0.2.1"}],"resolver":"127.0.0.1:53"}}`,
+		`{"name":"nx.example.com","status":"NXDOMAIN","timestamp":"2022-01-01T00:00:01Z","data":{"resolver":"127.0.0.1:53"}}`,
+	)
+	db, err := sql.Open("sqlite", path)
+	assert.NilError(t, err)
+	defer db.Close()
+
+	assert.DeepEqual(t, dbRows(t, db, "SELECT module, names, statuses, name_servers FROM runs"), []string{
+		`MX|2|{"NOERROR":1,"NXDOMAIN":1}|["127.0.0.1:53"]`,
+	})
+	assert.DeepEqual(t, dbRows(t, db, "SELECT id, run_id, name, module, status, resolver, timestamp, metadata FROM lookups"), []string{
+		`1|1|example.com|MX|NOERROR|127.0.0.1:53|2022-01-01T00:00:00Z|{"rank":1}`,
+		`2|1|nx.example.com|MX|NXDOMAIN|127.0.0.1:53|2022-01-01T00:00:01Z|`,
+	})
+	assert.DeepEqual(t, dbRows(t, db, "SELECT lookup_id, section, name, type, class, ttl, answer, rdata FROM records"), []string{
+		`1|answer|example.com|MX|IN|300|10 mail.example.com.|10 mail.example.com.`,
+		`1|additional|mail.example.com|A|IN|60|192.0.2.1|192.0.2.1`,
+	})
+	assert.DeepEqual(t, dbRows(t, db, "SELECT name FROM sqlite_master WHERE type = 'index' ORDER BY name"), []string{
+		"lookups_name", "lookups_run_id", "lookups_status", "records_lookup_id", "records_name_type",
+	})
+
+	// later runs are added to the same database
+	gc.Module = "A"
+	writeResults(t, NewOutputHandler(path, 10, gc), `{"name":"example.com","status":"NOERROR","data":{"ipv4_addresses":["192.0.2.1"]}}`)
+	assert.DeepEqual(t, dbRows(t, db, "SELECT run_id, module FROM lookups WHERE id = 3"), []string{"2|A"})
+}
+
+func TestOutputHandlerModules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	gc := &zdns.GlobalConf{Modules: []string{"A", "MXLOOKUP"}, TimeFormat: time.RFC3339}
+	writeResults(t, NewOutputHandler(path, 10, gc),
+		`{"name":"example.com","status":"NOERROR","data":{"MXLOOKUP":{"status":"NOERROR","data":{"exchanges":[{"name":"mail.example.com","type":"MX","class":"IN","preference":10,"ipv4_addresses":["192.0.2.1"],"ttl":300}]}},"A":{"status":"TIMEOUT","error":"timeout"}}}`,
+	)
+	db, err := sql.Open("sqlite", path)
+	assert.NilError(t, err)
+	defer db.Close()
+
+	assert.DeepEqual(t, dbRows(t, db, "SELECT module FROM runs"), []string{"A,MXLOOKUP"})
+	assert.DeepEqual(t, dbRows(t, db, "SELECT id, module, status, error FROM lookups"), []string{
+		"1|A|TIMEOUT|timeout",
+		"2|MXLOOKUP|NOERROR|",
+	})
+	assert.DeepEqual(t, dbRows(t, db, "SELECT lookup_id, section, name, type, ttl, answer, rdata FROM records"), []string{
+		`2|answer|example.com|MX|300|10 mail.example.com|10 mail.example.com.`,
+		`2|additional|mail.example.com|A||192.0.2.1|192.0.2.1`,
+	})
+}
+
+// Test that results of jsonl input lines that chose their own module are
+// stored and split as that module's, and that TTLs that weren't written are
+// left null
+func TestOutputHandlerLineModule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	gc := &zdns.GlobalConf{Module: "A", TimeFormat: time.RFC3339, OutputGroups: []string{"short", ""}}
+	writeResults(t, NewOutputHandler(path, 10, gc),
+		`{"name":"example.com","module":"MXLOOKUP","status":"NOERROR","data":{"exchanges":[{"name":"mail.example.com","type":"MX","class":"IN","preference":10}]}}`,
+		`{"name":"example.com","status":"NOERROR","data":{"answers":[{"name":"example.com","type":"A","class":"IN","answer":"192.0.2.1"}]}}`,
+	)
+	db, err := sql.Open("sqlite", path)
+	assert.NilError(t, err)
+	defer db.Close()
+
+	assert.DeepEqual(t, dbRows(t, db, "SELECT id, module FROM lookups"), []string{"1|MXLOOKUP", "2|A"})
+	assert.DeepEqual(t, dbRows(t, db, "SELECT lookup_id, type, ttl IS NULL, answer FROM records"), []string{
+		"1|MX|1|10 mail.example.com",
+		"2|A|1|192.0.2.1",
+	})
+}
+
+func TestRecords(t *testing.T) {
+	tests := []struct {
+		module string
+		data   string
+		want   []string
+	}{
+		{"ALOOKUP", `{"ipv4_addresses":["192.0.2.1"],"ipv6_addresses":["2001:db8::1"]}`, []string{
+			"answer|example.com|A|||192.0.2.1|192.0.2.1|",
+			"answer|example.com|AAAA|||2001:db8::1|2001:db8::1|",
+		}},
+		{"NSLOOKUP", `{"servers":[{"name":"ns1.example.com","type":"NS","ipv4_addresses":["192.0.2.53"],"ttl":3600}]}`, []string{
+			"answer|example.com|NS||3600|ns1.example.com|ns1.example.com.|",
+			"additional|ns1.example.com|A|||192.0.2.53|192.0.2.53|",
+		}},
+		{"AXFR", `{"servers":[{"server":"192.0.2.53","Status":"NOERROR","records":[{"name":"www.example.com","type":"CNAME","class":"IN","ttl":60,"answer":"example.com."}]}]}`, []string{
+			"answer|www.example.com|CNAME|IN|60|example.com.|example.com.|192.0.2.53",
+		}},
+		{"SOA", `{"answers":[{"name":"example.com","type":"SOA","class":"IN","ttl":60,"ns":"ns1.example.com","mbox":"admin.example.com","serial":1,"refresh":2,"retry":3,"expire":4,"min_ttl":5}],"authorities":[{"name":"example.com","type":"NS","class":"IN","ttl":60,"answer":"ns1.example.com."}]}`, []string{
+			"answer|example.com|SOA|IN|60|ns1.example.com admin.example.com 1 2 3 4 5|ns1.example.com. admin.example.com. 1 2 3 4 5|",
+			"authority|example.com|NS|IN|60|ns1.example.com.|ns1.example.com.|",
+		}},
+		{"SPF", `{"spf":"v=spf1 -all"}`, []string{`answer|example.com|TXT|||v=spf1 -all|"v=spf1 -all"|`}},
+		{"DMARC", `{"dmarc":"v=DMARC1; p=none"}`, []string{`answer|example.com|TXT|||v=DMARC1; p=none|"v=DMARC1; p=none"|`}},
+		{"BINDVERSION", `{"version":"9.16","resolver":"192.0.2.53:53"}`, []string{`answer|VERSION.BIND|TXT|CH||9.16|"9.16"|192.0.2.53:53`}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "results.db")
+		gc := &zdns.GlobalConf{Module: test.module, TimeFormat: time.RFC3339}
+		writeResults(t, NewOutputHandler(path, 10, gc), `{"name":"example.com","status":"NOERROR","data":`+test.data+`}`)
+		db, err := sql.Open("sqlite", path)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, dbRows(t, db, "SELECT section, name, type, class, ttl, answer, rdata, resolver FROM records"), test.want)
+		db.Close()
+	}
+}
+
+func TestOutputHandlerBadResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	gc := &zdns.GlobalConf{Module: "A", TimeFormat: time.RFC3339}
+	results := make(chan string, 2)
+	results <- "name,status"
+	results <- `{"name":"example.com"}`
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	err := NewOutputHandler(path, 10, gc).WriteResults(results, &wg)
+	assert.ErrorContains(t, err, "unable to parse result")
+	// the rest of the results are drained
+	_, ok := <-results
+	assert.Assert(t, !ok)
+}
@@ -0,0 +1,241 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how often the checkpoint file is rewritten while results keep coming in
+const checkpointInterval = 10 * time.Second
+
+// inputLine is an input line together with its position in the input
+type inputLine struct {
+	index uint64
+	line  string
+}
+
+// lookupResult is the output for the input line at index. Lines that
+// produced no output (STATUS_NO_OUTPUT) still report back with hasOutput
+// unset, so that they count as completed.
+type lookupResult struct {
+	index     uint64
+	line      string
+	status    Status
+	output    string
+	hasOutput bool
+}
+
+// Checkpoint records how far a scan got. Every input line before InputOffset
+// and every line listed in Completed has been looked up and its result is
+// stored in the first OutputOffset bytes of OutputFile. The lines of those
+// that failed are the first FailedOffset bytes of the failed names file.
+type Checkpoint struct {
+	InputFile    string   `json:"input_file"`
+	OutputFile   string   `json:"output_file"`
+	InputOffset  uint64   `json:"input_offset"`
+	OutputOffset int64    `json:"output_offset"`
+	FailedOffset int64    `json:"failed_offset,omitempty"`
+	Completed    []uint64 `json:"completed,omitempty"`
+}
+
+// LoadCheckpoint reads a checkpoint written by a previous run. It returns
+// nil and no error if the file does not exist.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+// skip reports whether the input line at index was already completed
+func (cp *Checkpoint) skip(index uint64) bool {
+	if index < cp.InputOffset {
+		return true
+	}
+	i := sort.Search(len(cp.Completed), func(i int) bool { return cp.Completed[i] >= index })
+	return i < len(cp.Completed) && cp.Completed[i] == index
+}
+
+// checkpointTracker follows which input lines have been written out and
+// periodically saves that progress to a checkpoint file
+type checkpointTracker struct {
+	path string
+	cp   Checkpoint
+	// failedNames is set if failed lookups are written to a failed names file
+	failedNames bool
+	completed   map[uint64]bool
+	lastSaved   time.Time
+}
+
+func newCheckpointTracker(path string, c *GlobalConf, resumeFrom *Checkpoint) *checkpointTracker {
+	t := &checkpointTracker{
+		path: path,
+		cp: Checkpoint{
+			InputFile:  c.InputFilePath,
+			OutputFile: c.OutputFilePath,
+		},
+		failedNames: c.FailedNamesFilePath != "",
+		completed:   make(map[uint64]bool),
+		lastSaved:   time.Now(),
+	}
+	if resumeFrom != nil {
+		t.cp.InputOffset = resumeFrom.InputOffset
+		t.cp.OutputOffset = resumeFrom.OutputOffset
+		t.cp.FailedOffset = resumeFrom.FailedOffset
+		for _, index := range resumeFrom.Completed {
+			t.completed[index] = true
+		}
+	}
+	return t
+}
+
+// complete marks the line at index as done, with n bytes of output that
+// have been handed to the output handler
+func (t *checkpointTracker) complete(index uint64, n int) {
+	t.cp.OutputOffset += int64(n)
+	t.completed[index] = true
+	for t.completed[t.cp.InputOffset] {
+		delete(t.completed, t.cp.InputOffset)
+		t.cp.InputOffset++
+	}
+	if time.Since(t.lastSaved) >= checkpointInterval {
+		if err := t.save(); err != nil {
+			log.Warn("unable to write checkpoint: ", err)
+		}
+	}
+}
+
+// completeResult marks the line of res as done, once its output and, if it
+// failed, its input line have been handed over
+func (t *checkpointTracker) completeResult(res *lookupResult) {
+	if t.failedNames && isFailedStatus(res.status) {
+		t.cp.FailedOffset += int64(len(res.line) + 1)
+	}
+	n := 0
+	if res.hasOutput {
+		n = len(res.output) + 1
+	}
+	t.complete(res.index, n)
+}
+
+// save atomically replaces the checkpoint file
+func (t *checkpointTracker) save() error {
+	t.lastSaved = time.Now()
+	t.cp.Completed = t.cp.Completed[:0]
+	for index := range t.completed {
+		t.cp.Completed = append(t.cp.Completed, index)
+	}
+	sort.Slice(t.cp.Completed, func(i, j int) bool { return t.cp.Completed[i] < t.cp.Completed[j] })
+	data, err := json.Marshal(&t.cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
+
+// numberInput numbers the lines read by the input handler and passes on
+// those that a resumed scan has not completed yet. It stops early when ctx is
+// done.
+func numberInput(ctx context.Context, in <-chan interface{}, out chan<- inputLine, resumeFrom *Checkpoint, p *progress) {
+	defer close(out)
+	var index uint64
+	for {
+		var line interface{}
+		var ok bool
+		select {
+		case line, ok = <-in:
+		case <-ctx.Done():
+			log.Warn("interrupted. cancelling the lookups in flight")
+			return
+		}
+		if !ok {
+			return
+		}
+		if resumeFrom == nil || !resumeFrom.skip(index) {
+			p.read.Add(1)
+			select {
+			case out <- inputLine{index: index, line: line.(string)}:
+			case <-ctx.Done():
+				p.read.Add(-1)
+				log.Warn("interrupted. cancelling the lookups in flight")
+				return
+			}
+		}
+		index++
+	}
+}
+
+// collectResults hands the results of the lookup routines to the output
+// handler and the input lines of failed lookups to failed. When tracker is
+// set, it records which lines have been written, and the lookups cancelled by
+// an interrupt are dropped, to be done again by the resumed scan.
+//
+// The output channel is unbuffered and output handlers write one result
+// before receiving the next, so a result only counts as written once the
+// following one has been handed over, or once the output handler is done.
+// The last written result is left for the caller to complete.
+func collectResults(results <-chan lookupResult, out *outputChan, tracker *checkpointTracker, failed *failedNames) *lookupResult {
+	defer out.close()
+	var pending *lookupResult
+	for res := range results {
+		res := res
+		if tracker != nil && res.status == STATUS_CANCELLED {
+			continue
+		}
+		failed.add(&res)
+		if !res.hasOutput {
+			if tracker != nil {
+				tracker.completeResult(&res)
+			}
+			continue
+		}
+		out.send(res.output, res.status)
+		if tracker != nil && pending != nil {
+			tracker.completeResult(pending)
+		}
+		pending = &res
+	}
+	return pending
+}
@@ -0,0 +1,86 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCheckpointTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	tracker := newCheckpointTracker(path, &GlobalConf{InputFilePath: "in", OutputFilePath: "out"}, nil)
+
+	// lines completed out of order only advance the offset once the gap is
+	// filled
+	tracker.complete(1, 10)
+	tracker.complete(3, 0)
+	tracker.complete(4, 5)
+	assert.Equal(t, tracker.cp.InputOffset, uint64(0))
+	tracker.complete(0, 10)
+	assert.Equal(t, tracker.cp.InputOffset, uint64(2))
+	assert.Equal(t, tracker.cp.OutputOffset, int64(25))
+	assert.NilError(t, tracker.save())
+
+	cp, err := LoadCheckpoint(path)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cp, &Checkpoint{
+		InputFile:    "in",
+		OutputFile:   "out",
+		InputOffset:  2,
+		OutputOffset: 25,
+		Completed:    []uint64{3, 4},
+	})
+	for index, skip := range []bool{true, true, false, true, true, false} {
+		assert.Equal(t, cp.skip(uint64(index)), skip, index)
+	}
+
+	// resuming picks up where the saved tracker left off
+	resumed := newCheckpointTracker(path, &GlobalConf{}, cp)
+	resumed.complete(2, 7)
+	assert.Equal(t, resumed.cp.InputOffset, uint64(5))
+	assert.Equal(t, resumed.cp.OutputOffset, int64(32))
+}
+
+func TestLoadMissingCheckpoint(t *testing.T) {
+	cp, err := LoadCheckpoint(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NilError(t, err)
+	assert.Assert(t, cp == nil)
+}
+
+// Test that the lookups cancelled by an interrupt are neither written nor
+// recorded as completed when checkpointing, so that a resumed scan does them
+func TestCollectResultsCancelled(t *testing.T) {
+	tracker := newCheckpointTracker(filepath.Join(t.TempDir(), "checkpoint.json"), &GlobalConf{}, nil)
+	results := make(chan lookupResult, 3)
+	results <- lookupResult{index: 0, line: "a.example", status: STATUS_NOERROR, output: "a", hasOutput: true}
+	results <- lookupResult{index: 1, line: "b.example", status: STATUS_CANCELLED, output: "b", hasOutput: true}
+	results <- lookupResult{index: 2, line: "c.example", status: STATUS_NOERROR, output: "c", hasOutput: true}
+	close(results)
+	out := &outputChan{lines: make(chan string, 3)}
+	last := collectResults(results, out, tracker, nil)
+	tracker.complete(last.index, len(last.output)+1)
+
+	var lines []string
+	for line := range out.lines {
+		lines = append(lines, line)
+	}
+	assert.DeepEqual(t, lines, []string{"a", "c"})
+	assert.Equal(t, tracker.cp.InputOffset, uint64(1))
+	assert.Equal(t, tracker.cp.OutputOffset, int64(4))
+	assert.Assert(t, tracker.completed[2])
+}
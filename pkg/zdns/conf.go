@@ -15,6 +15,8 @@
 package zdns
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"net"
 	"time"
 
@@ -29,9 +31,11 @@ type GlobalConf struct {
 	AlexaFormat           bool
 	MetadataFormat        bool
 	NameServerInputFormat bool
-	IterativeResolution   bool
-	FollowCName           bool
-	LookupAllNameServers  bool
+	// InputFormat is either INPUT_FORMAT_TEXT or INPUT_FORMAT_JSONL
+	InputFormat          string
+	IterativeResolution  bool
+	FollowCName          bool
+	LookupAllNameServers bool
 
 	ResultVerbosity string
 	IncludeInOutput string
@@ -55,45 +59,164 @@ type GlobalConf struct {
 	Dnssec               bool
 	CheckingDisabled     bool
 
-	InputHandler  InputHandler
-	OutputHandler OutputHandler
+	// TLS makes name servers given without a scheme be queried over
+	// DNS-over-TLS, as if given as tls://host
+	TLS bool
+	// TLSServerName, when set, is sent as the server name indication to
+	// DNS-over-TLS name servers in place of their host
+	TLSServerName string
+	// TLSAuthName and TLSCAFilePath, when either is set, make the
+	// certificates of DNS-over-TLS name servers be verified: for
+	// TLSAuthName, or else the server name, and against the CAs of the PEM
+	// bundle at TLSCAFilePath, or else the system CAs
+	TLSAuthName   string
+	TLSCAFilePath string
+	// TLSSPKIPins, when set, are base64 SHA-256 digests of public keys, one
+	// of which the certificate of DNS-over-TLS name servers, or a CA of
+	// their verified chain, must have
+	TLSSPKIPins []string
+	// TLSConfig is the configuration of DNS-over-TLS connections. It is
+	// created from the options above when left nil.
+	TLSConfig *tls.Config `json:"-"`
+
+	// Shards splits the input between that many instances, of which this
+	// is number Shard (counting from 0). All instances must use the same
+	// Seed.
+	Shards int
+	Shard  int
+	Seed   int64
+
+	InputHandlerName  string
+	OutputHandlerName string
+	InputHandler      InputHandler
+	OutputHandler     OutputHandler
+
+	// QueryStats, when set, is fed by lookup modules with every query they
+	// put on the wire
+	QueryStats *QueryStats `json:"-"`
+
+	// Rate and PerNameServerRate limit the queries per second put on the
+	// wire in total and to any single name server. 0 means unlimited.
+	Rate              float64
+	PerNameServerRate float64
+	// RateLimiter enforces Rate and PerNameServerRate. It is created from
+	// them when left nil.
+	RateLimiter *RateLimiter `json:"-"`
+	// Dnstap, when set, receives every query put on the wire and its
+	// response. It is created from DnstapFilePath when left nil.
+	Dnstap *DnstapWriter `json:"-"`
+	// Pcap, when set, receives every query put on the wire and its
+	// response. It is created from PcapFilePath when left nil.
+	Pcap *PcapWriter `json:"-"`
+	// Metrics, when set, receives measurements of queries, lookups and
+	// caches as a scan runs
+	Metrics Metrics `json:"-"`
+	// IterativeCache is the iterative resolution cache shared by all lookup
+	// modules of a run. It is created by the first module that needs one.
+	IterativeCache interface{} `json:"-"`
 
 	InputFilePath    string
 	OutputFilePath   string
 	LogFilePath      string
 	MetadataFilePath string
+	// InputCompression and OutputCompression say how the input and output
+	// files of the file handlers are compressed: auto (from the .gz or .zst
+	// extension), none, gzip or zstd
+	InputCompression  string
+	OutputCompression string
+	// OutputFormat is how results are written: json (the default), csv
+	// and tsv with one row per record, or zone as master file records
+	OutputFormat string
+	// ZoneSections are the sections of a response written by the zone
+	// output format. Only the answers are written when left empty.
+	ZoneSections []string
+	// OutputDir, when set, makes the file output handler write the results
+	// of each status to their own file in this directory instead of to
+	// OutputFilePath
+	OutputDir string
+	// FailedNamesFilePath, when set, receives the raw input line of every
+	// lookup that timed out or failed
+	FailedNamesFilePath string
+	// DnstapFilePath, when set, is where the queries and responses of the
+	// scan are written as dnstap messages
+	DnstapFilePath string
+	// PcapFilePath, when set, is where the queries and responses of the
+	// scan are written as the packets they were on the wire
+	PcapFilePath string
+	// CheckpointFilePath, when set, is where the progress of the scan is
+	// periodically saved. With Resume, the scan continues from the progress
+	// saved there by an earlier run.
+	CheckpointFilePath string
+	// StatusUpdatesFilePath, when set, receives a CSV row on the progress of
+	// the scan every second
+	StatusUpdatesFilePath string
+	Resume                bool
 
 	NamePrefix     string
 	NameOverride   string
 	NameServerMode bool
 
 	Module string
-	Class  uint16
+	// Modules, when set, lists every module each name is looked up with.
+	// Results are combined into a single record keyed by module.
+	Modules []string
+	Class   uint16
 }
 
 type Metadata struct {
-	Names       int            `json:"names"`
-	Status      map[string]int `json:"statuses"`
-	StartTime   string         `json:"start_time"`
-	EndTime     string         `json:"end_time"`
-	NameServers []string       `json:"name_servers"`
-	Timeout     int            `json:"timeout"`
-	Retries     int            `json:"retries"`
-	Conf        *GlobalConf    `json:"conf"`
+	Names           int                           `json:"names"`
+	Status          map[string]int                `json:"statuses"`
+	StartTime       string                        `json:"start_time"`
+	EndTime         string                        `json:"end_time"`
+	NameServers     []string                      `json:"name_servers"`
+	Timeout         int                           `json:"timeout"`
+	Retries         int                           `json:"retries"`
+	NameServerStats map[string]NameServerMetadata `json:"name_server_stats,omitempty"`
+	// QueriesPerSecond is the rate at which queries were actually sent
+	QueriesPerSecond float64        `json:"queries_per_second"`
+	Sharding         *ShardMetadata `json:"sharding,omitempty"`
+	Conf             *GlobalConf    `json:"conf"`
+}
+
+type ShardMetadata struct {
+	Shards int   `json:"shards"`
+	Shard  int   `json:"shard"`
+	Seed   int64 `json:"seed"`
+}
+
+type NameServerMetadata struct {
+	Queries    int     `json:"queries"`
+	Retries    int     `json:"retries"`
+	LatencyP50 float64 `json:"latency_p50_ms"`
+	LatencyP90 float64 `json:"latency_p90_ms"`
+	LatencyP99 float64 `json:"latency_p99_ms"`
 }
 
 type Result struct {
-	AlteredName string        `json:"altered_name,omitempty" groups:"short,normal,long,trace"`
-	Name        string        `json:"name,omitempty" groups:"short,normal,long,trace"`
-	Nameserver  string        `json:"nameserver,omitempty" groups:"normal,long,trace"`
-	Class       string        `json:"class,omitempty" groups:"long,trace"`
-	AlexaRank   int           `json:"alexa_rank,omitempty" groups:"short,normal,long,trace"`
-	Metadata    string        `json:"metadata,omitempty" groups:"short,normal,long,trace"`
-	Status      string        `json:"status,omitempty" groups:"short,normal,long,trace"`
-	Error       string        `json:"error,omitempty" groups:"short,normal,long,trace"`
-	Timestamp   string        `json:"timestamp,omitempty" groups:"short,normal,long,trace"`
-	Data        interface{}   `json:"data,omitempty" groups:"short,normal,long,trace"`
-	Trace       []interface{} `json:"trace,omitempty" groups:"trace"`
+	AlteredName string `json:"altered_name,omitempty" groups:"short,normal,long,trace"`
+	Name        string `json:"name,omitempty" groups:"short,normal,long,trace"`
+	// Module is the module of the lookup if a jsonl input line asked for
+	// another one than the run was started with
+	Module     string        `json:"module,omitempty" groups:"short,normal,long,trace"`
+	Nameserver string        `json:"nameserver,omitempty" groups:"normal,long,trace"`
+	Class      string        `json:"class,omitempty" groups:"long,trace"`
+	AlexaRank  int           `json:"alexa_rank,omitempty" groups:"short,normal,long,trace"`
+	Metadata   interface{}   `json:"metadata,omitempty" groups:"short,normal,long,trace"`
+	Status     string        `json:"status,omitempty" groups:"short,normal,long,trace"`
+	Error      string        `json:"error,omitempty" groups:"short,normal,long,trace"`
+	Timestamp  string        `json:"timestamp,omitempty" groups:"short,normal,long,trace"`
+	Data       interface{}   `json:"data,omitempty" groups:"short,normal,long,trace"`
+	Trace      []interface{} `json:"trace,omitempty" groups:"trace"`
+}
+
+// ModuleResult is the outcome of one module's lookup when several modules
+// are run per name (see GlobalConf.Modules). They're stored in Result.Data,
+// keyed by module name.
+type ModuleResult struct {
+	Status string        `json:"status,omitempty" groups:"short,normal,long,trace"`
+	Error  string        `json:"error,omitempty" groups:"short,normal,long,trace"`
+	Data   interface{}   `json:"data,omitempty" groups:"short,normal,long,trace"`
+	Trace  []interface{} `json:"trace,omitempty" groups:"trace"`
 }
 
 type TargetedDomain struct {
@@ -101,6 +224,24 @@ type TargetedDomain struct {
 	Nameservers []string `json:"nameservers"`
 }
 
+const (
+	// INPUT_FORMAT_TEXT reads one name per line, optionally followed by
+	// a name server (or metadata/rank, depending on the other flags)
+	INPUT_FORMAT_TEXT = "text"
+	// INPUT_FORMAT_JSONL reads one JSON object per line, see JSONInput
+	INPUT_FORMAT_JSONL = "jsonl"
+)
+
+// JSONInput is a single line of --input-format jsonl input. Every field but
+// Name is optional and falls back to the value configured for the run.
+type JSONInput struct {
+	Name       string          `json:"name"`
+	Module     string          `json:"module,omitempty"`
+	NameServer string          `json:"nameserver,omitempty"`
+	Class      string          `json:"class,omitempty"`
+	Metadata   json.RawMessage `json:"metadata,omitempty"`
+}
+
 type Status string
 
 const (
@@ -125,6 +266,7 @@ const (
 	STATUS_TEMPORARY     Status = "TEMPORARY"
 	STATUS_NOAUTH        Status = "NOAUTH"
 	STATUS_NODATA        Status = "NODATA"
+	STATUS_CANCELLED     Status = "CANCELLED"
 )
 
 var RootServers = [...]string{
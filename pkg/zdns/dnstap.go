@@ -0,0 +1,132 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"google.golang.org/protobuf/proto"
+)
+
+// DnstapWriter writes wire exchanges to a file as dnstap messages in a Frame
+// Streams stream. A nil *DnstapWriter discards everything. It is safe for
+// concurrent use.
+type DnstapWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   dnstap.Writer
+	enc *dnstap.Encoder
+	// err is the first error writing to the file, reported by Close
+	err error
+}
+
+// NewDnstapWriter creates the file at path and starts a stream in it
+func NewDnstapWriter(path string) (*DnstapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open dnstap file: %w", err)
+	}
+	w, err := dnstap.NewWriter(f, nil)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to write dnstap file: %w", err)
+	}
+	return &DnstapWriter{f: f, w: w, enc: dnstap.NewEncoder(w)}, nil
+}
+
+var dnstapVersion = []byte("zdns")
+
+// dnstapMessage turns e into a TOOL_RESPONSE message holding both the query
+// and the response, or a TOOL_QUERY message if there was no response
+func dnstapMessage(e *WireExchange) *dnstap.Dnstap {
+	msgType := dnstap.Message_TOOL_QUERY
+	if e.Response != nil {
+		msgType = dnstap.Message_TOOL_RESPONSE
+	}
+	protocol := dnstap.SocketProtocol_UDP
+	switch e.Protocol {
+	case "tcp":
+		protocol = dnstap.SocketProtocol_TCP
+	case "tls":
+		protocol = dnstap.SocketProtocol_DOT
+	}
+	m := &dnstap.Message{
+		Type:           &msgType,
+		SocketProtocol: &protocol,
+		QueryTimeSec:   proto.Uint64(uint64(e.QueryTime.Unix())),
+		QueryTimeNsec:  proto.Uint32(uint32(e.QueryTime.Nanosecond())),
+		QueryMessage:   e.Query,
+	}
+	family := dnstap.SocketFamily_INET
+	if ip, port := addrPort(e.LocalAddr); ip != nil {
+		if ip.To4() != nil {
+			ip = ip.To4()
+		}
+		m.QueryAddress = ip
+		m.QueryPort = proto.Uint32(uint32(port))
+	}
+	if ip, port := addrPort(e.RemoteAddr); ip != nil {
+		if ip.To4() != nil {
+			ip = ip.To4()
+		} else {
+			family = dnstap.SocketFamily_INET6
+		}
+		m.ResponseAddress = ip
+		m.ResponsePort = proto.Uint32(uint32(port))
+	}
+	m.SocketFamily = &family
+	if e.Response != nil {
+		m.ResponseTimeSec = proto.Uint64(uint64(e.ResponseTime.Unix()))
+		m.ResponseTimeNsec = proto.Uint32(uint32(e.ResponseTime.Nanosecond()))
+		m.ResponseMessage = e.Response
+	}
+	t := dnstap.Dnstap_MESSAGE
+	return &dnstap.Dnstap{Type: &t, Version: dnstapVersion, Message: m}
+}
+
+// Write adds e to the stream
+func (w *DnstapWriter) Write(e *WireExchange) {
+	if w == nil {
+		return
+	}
+	m := dnstapMessage(e)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	w.err = w.enc.Encode(m)
+}
+
+// Close ends the stream and closes the file
+func (w *DnstapWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	if err == nil {
+		err = w.w.Close()
+	}
+	if err != nil {
+		w.f.Close()
+		return fmt.Errorf("unable to write dnstap file: %w", err)
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,555 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/hashicorp/go-version"
+	"github.com/liip/sheriff"
+)
+
+// ResultEncoder writes results as JSON with the fields of its output groups.
+// The output is that of sheriff.Marshal followed by json.Marshal, but the
+// fields written for each type are worked out once, the first time a value
+// of the type is encoded, instead of from the tags of every value. It is
+// safe for concurrent use.
+type ResultEncoder struct {
+	groups  []string
+	version *version.Version
+	// encoders holds a *typeEncoder per reflect.Type. Encoders are compiled
+	// while holding mu, and stored once complete.
+	encoders sync.Map
+	mu       sync.Mutex
+	states   sync.Pool
+}
+
+// encodeState is what is kept while encoding a result. Like sheriff, it
+// remembers the groups of the embedding field of every field of the
+// embedded structs seen so far, by field name. Untagged fields of that name
+// anywhere in the result take those groups.
+type encodeState struct {
+	nestedGroups map[string][]string
+	// buffers hold the values of the fields of the structs being encoded
+	buffers [][]byte
+}
+
+func (st *encodeState) buffer() []byte {
+	if n := len(st.buffers); n > 0 {
+		b := st.buffers[n-1]
+		st.buffers = st.buffers[:n-1]
+		return b[:0]
+	}
+	return make([]byte, 0, 256)
+}
+
+func (st *encodeState) release(b []byte) {
+	st.buffers = append(st.buffers, b)
+}
+
+type encoderFunc func(st *encodeState, b []byte, v reflect.Value) ([]byte, error)
+
+// typeEncoder lets the encoders of recursive types refer to themselves
+// before they're compiled
+type typeEncoder struct {
+	fn encoderFunc
+}
+
+func (te *typeEncoder) encode(st *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return te.fn(st, b, v)
+}
+
+// structOp is a step of encoding a struct, in the order sheriff takes them:
+// either noting the groups of the fields of an embedded struct, or encoding
+// a field
+type structOp struct {
+	// nested and nestedGroups are the names of the fields of an embedded
+	// struct and the groups of the embedding field
+	nested       []string
+	nestedGroups []string
+
+	// name is the key of the field, key its index among the sorted keys of
+	// the struct, and index its path through embedded structs
+	name      string
+	key       int
+	index     []int
+	omitEmpty bool
+	// untagged is the name of a field without groups, which are only known
+	// while encoding, or "" if the field is written
+	untagged string
+	enc      encoderFunc
+}
+
+// fieldSpan is where the value of a field is in the buffer of its struct
+type fieldSpan struct {
+	start, end int
+	set        bool
+}
+
+var sheriffMarshallerType = reflect.TypeOf((*sheriff.Marshaller)(nil)).Elem()
+
+// NewResultEncoder returns an encoder of results with the fields of groups,
+// see GlobalConf.OutputGroups
+func NewResultEncoder(groups []string) *ResultEncoder {
+	v, _ := version.NewVersion("0.0.0")
+	return &ResultEncoder{groups: groups, version: v}
+}
+
+// Encode returns the JSON of res
+func (e *ResultEncoder) Encode(res *Result) ([]byte, error) {
+	st, _ := e.states.Get().(*encodeState)
+	if st == nil {
+		st = new(encodeState)
+	}
+	// each result is marshalled by sheriff with options of its own
+	st.nestedGroups = nil
+	defer e.states.Put(st)
+	return e.encoder(resultType).fn(st, make([]byte, 0, 512), reflect.ValueOf(res).Elem())
+}
+
+// sheriffOptions returns the options of sheriff for the few values it's left
+// to. They aren't shared, as sheriff keeps state in them while marshalling,
+// so the groups of the embedding fields noted in st don't carry over to
+// them and back. None of the output types of zdns is left to sheriff.
+func (e *ResultEncoder) sheriffOptions() *sheriff.Options {
+	return &sheriff.Options{Groups: e.groups, ApiVersion: e.version}
+}
+
+// encoder returns the encoder of values of type t, compiling it if needed
+func (e *ResultEncoder) encoder(t reflect.Type) *typeEncoder {
+	if te, ok := e.encoders.Load(t); ok {
+		return te.(*typeEncoder)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	compiled := make(map[reflect.Type]*typeEncoder)
+	te := e.compile(t, compiled)
+	for t, te := range compiled {
+		e.encoders.Store(t, te)
+	}
+	return te
+}
+
+// compile returns the encoder of values of type t. The encoders compiled on
+// the way are added to compiled.
+func (e *ResultEncoder) compile(t reflect.Type, compiled map[reflect.Type]*typeEncoder) *typeEncoder {
+	if te, ok := e.encoders.Load(t); ok {
+		return te.(*typeEncoder)
+	}
+	if te, ok := compiled[t]; ok {
+		return te
+	}
+	te := new(typeEncoder)
+	compiled[t] = te
+	te.fn = e.newValueEncoder(t, compiled)
+	return te
+}
+
+// newValueEncoder returns an encoder of values of type t the way sheriff
+// writes them, i.e. structs with the fields of the output groups, and values
+// that marshal themselves as encoding/json does
+func (e *ResultEncoder) newValueEncoder(t reflect.Type, compiled map[reflect.Type]*typeEncoder) encoderFunc {
+	if t.Kind() == reflect.Interface {
+		return e.encodeInterface
+	}
+	if t.Implements(sheriffMarshallerType) {
+		return e.encodeSheriffMarshaller
+	}
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) || t.Implements(stringerType) {
+		return encodeJSON
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return encodeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return encodeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return encodeUint
+	case reflect.Float32:
+		return encodeFloat32
+	case reflect.Float64:
+		return encodeFloat64
+	case reflect.String:
+		return encodeString
+	case reflect.Ptr:
+		return ptrEncoder(e.compile(t.Elem(), compiled))
+	case reflect.Struct:
+		return e.newStructEncoder(t, compiled)
+	case reflect.Slice:
+		return sliceEncoder(e.compile(t.Elem(), compiled))
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			// sheriff fails on these unless they're empty
+			return e.encodeSheriff
+		}
+		return mapEncoder(e.compile(t.Elem(), compiled))
+	}
+	// arrays and the like are left to encoding/json by sheriff
+	return encodeJSON
+}
+
+// newStructEncoder returns an encoder of structs of type t as objects of the
+// fields of the output groups. The fields are encoded in the order sheriff
+// visits them, which matters for the groups of untagged fields, and written
+// in the order of their keys, as encoding/json sorts the maps sheriff makes.
+func (e *ResultEncoder) newStructEncoder(t reflect.Type, compiled map[reflect.Type]*typeEncoder) encoderFunc {
+	keys := make(map[string]int)
+	var ops []structOp
+	if !e.addStructOps(&ops, keys, t, nil, compiled) {
+		return e.encodeSheriff
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	prefixes := make([][]byte, len(names))
+	for i, name := range names {
+		keys[name] = i
+		prefix, _ := json.Marshal(name)
+		prefixes[i] = append(prefix, ':')
+	}
+	for i := range ops {
+		if ops[i].nested == nil {
+			ops[i].key = keys[ops[i].name]
+		}
+	}
+	return func(st *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+		buf := st.buffer()
+		defer func() { st.release(buf) }()
+		var spanArray [16]fieldSpan
+		spans := spanArray[:]
+		if len(prefixes) > len(spanArray) {
+			spans = make([]fieldSpan, len(prefixes))
+		}
+		var err error
+		for i := range ops {
+			op := &ops[i]
+			if op.nested != nil {
+				if st.nestedGroups == nil {
+					st.nestedGroups = make(map[string][]string)
+				}
+				for _, name := range op.nested {
+					st.nestedGroups[name] = op.nestedGroups
+				}
+				continue
+			}
+			fv := v
+			for _, i := range op.index {
+				fv = fv.Field(i)
+			}
+			if op.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			if op.untagged != "" && !e.showUntagged(st, op.untagged) {
+				continue
+			}
+			start := len(buf)
+			if buf, err = op.enc(st, buf, fv); err != nil {
+				return nil, err
+			}
+			spans[op.key] = fieldSpan{start: start, end: len(buf), set: true}
+		}
+		b = append(b, '{')
+		first := true
+		for i, span := range spans[:len(prefixes)] {
+			if !span.set {
+				continue
+			}
+			if !first {
+				b = append(b, ',')
+			}
+			first = false
+			b = append(b, prefixes[i]...)
+			b = append(b, buf[span.start:span.end]...)
+		}
+		return append(b, '}'), nil
+	}
+}
+
+// showUntagged tells if an untagged field named name is written, which it
+// is if the embedding field of the last embedded struct seen with a field
+// of that name has one of the output groups
+func (e *ResultEncoder) showUntagged(st *encodeState, name string) bool {
+	groups := st.nestedGroups[name]
+	for _, g := range groups {
+		if inGroups(g, e.groups) {
+			return true
+		}
+	}
+	return false
+}
+
+// addStructOps adds the steps of encoding the fields of t to ops, in the
+// order sheriff takes them, and the keys of the fields to keys. It returns
+// false if t uses features of sheriff that aren't compiled, i.e. since and
+// until tags or embedded pointers, in which case it's left to sheriff.
+func (e *ResultEncoder) addStructOps(ops *[]structOp, keys map[string]int, t reflect.Type, index []int, compiled map[reflect.Type]*typeEncoder) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		omitEmpty := tagContains(opts, "omitempty")
+		if f.Anonymous && f.Type.Kind() == reflect.Ptr && f.Type.Elem().Kind() == reflect.Struct {
+			return false
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if f.Type.Implements(sheriffMarshallerType) {
+				return false
+			}
+			// the groups of the embedding field are noted for every field
+			// of the embedded struct, whether it's written or not
+			nested := make([]string, f.Type.NumField())
+			for j := range nested {
+				nested[j] = f.Type.Field(j).Name
+			}
+			*ops = append(*ops, structOp{nested: nested, nestedGroups: strings.Split(f.Tag.Get("groups"), ",")})
+			if f.Type.Implements(jsonMarshalerType) || f.Type.Implements(textMarshalerType) || f.Type.Implements(stringerType) {
+				// written whole under its own name, whatever the groups
+				keys[name] = 0
+				*ops = append(*ops, structOp{name: name, index: fieldIndex, omitEmpty: omitEmpty, enc: e.compile(f.Type, compiled).encode})
+				continue
+			}
+			if !e.addStructOps(ops, keys, f.Type, fieldIndex, compiled) {
+				return false
+			}
+			continue
+		}
+		if f.Tag.Get("since") != "" || f.Tag.Get("until") != "" {
+			return false
+		}
+		op := structOp{name: name, index: fieldIndex, omitEmpty: omitEmpty}
+		if len(e.groups) > 0 {
+			if g := f.Tag.Get("groups"); g != "" {
+				shown := false
+				for _, g := range strings.Split(g, ",") {
+					if inGroups(g, e.groups) {
+						shown = true
+						break
+					}
+				}
+				if !shown {
+					continue
+				}
+			} else {
+				op.untagged = f.Name
+			}
+		}
+		op.enc = e.compile(f.Type, compiled).encode
+		if f.Type.Kind() == reflect.Ptr {
+			// sheriff follows pointer fields before checking if their values
+			// marshal themselves
+			op.enc = ptrEncoder(e.compile(f.Type.Elem(), compiled))
+		}
+		keys[name] = 0
+		*ops = append(*ops, op)
+	}
+	return true
+}
+
+func tagContains(opts, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyValue tells if v is empty as far as omitempty is concerned
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func (e *ResultEncoder) encodeInterface(st *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	if v.IsNil() {
+		return append(b, "null"...), nil
+	}
+	v = v.Elem()
+	return e.encoder(v.Type()).fn(st, b, v)
+}
+
+func (e *ResultEncoder) encodeSheriffMarshaller(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	data, err := v.Interface().(sheriff.Marshaller).Marshal(e.sheriffOptions())
+	if err != nil {
+		return nil, err
+	}
+	return appendJSON(b, data)
+}
+
+func (e *ResultEncoder) encodeSheriff(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	data, err := sheriff.Marshal(e.sheriffOptions(), v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return appendJSON(b, data)
+}
+
+func ptrEncoder(elem *typeEncoder) encoderFunc {
+	return func(st *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+		if v.IsNil() {
+			return append(b, "null"...), nil
+		}
+		return elem.fn(st, b, v.Elem())
+	}
+}
+
+// sliceEncoder returns an encoder of slices, which sheriff writes as []
+// even if they're nil. Byte slices are written as lists of numbers.
+func sliceEncoder(elem *typeEncoder) encoderFunc {
+	return func(st *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+		b = append(b, '[')
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if b, err = elem.fn(st, b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, ']'), nil
+	}
+}
+
+// mapEncoder returns an encoder of maps, which sheriff writes as null if
+// they're empty
+func mapEncoder(elem *typeEncoder) encoderFunc {
+	return func(st *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+		if v.Len() == 0 {
+			return append(b, "null"...), nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		b = append(b, '{')
+		var err error
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendString(b, k.String())
+			b = append(b, ':')
+			if b, err = elem.fn(st, b, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	}
+}
+
+func appendJSON(b []byte, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
+
+func encodeJSON(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return appendJSON(b, v.Interface())
+}
+
+func encodeBool(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return strconv.AppendBool(b, v.Bool()), nil
+}
+
+func encodeInt(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return strconv.AppendInt(b, v.Int(), 10), nil
+}
+
+func encodeUint(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return strconv.AppendUint(b, v.Uint(), 10), nil
+}
+
+func encodeFloat32(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return appendFloat(b, v, 32)
+}
+
+func encodeFloat64(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return appendFloat(b, v, 64)
+}
+
+// appendFloat writes floats like encoding/json, which uses exponents only
+// for very small and large numbers
+func appendFloat(b []byte, v reflect.Value, bits int) ([]byte, error) {
+	f := v.Float()
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		// for the error
+		return encodeJSON(nil, b, v)
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+func encodeString(_ *encodeState, b []byte, v reflect.Value) ([]byte, error) {
+	return appendString(b, v.String()), nil
+}
+
+// appendString writes s as a JSON string. Strings that need escaping, which
+// encoding/json also does for HTML characters, are left to encoding/json.
+func appendString(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' || c >= utf8.RuneSelf {
+			data, _ := json.Marshal(s)
+			return append(b, data...)
+		}
+	}
+	b = append(b, '"')
+	b = append(b, s...)
+	return append(b, '"')
+}
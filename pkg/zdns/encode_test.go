@@ -0,0 +1,106 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+	"github.com/liip/sheriff"
+	"gotest.tools/v3/assert"
+)
+
+// encodeTestUntagged has a field without groups, which sheriff writes with
+// the groups of the embedding field of the last embedded struct it saw with
+// a field of that name
+type encodeTestUntagged struct {
+	Note string `json:"note"`
+	Kind string `json:"kind" groups:"short,normal"`
+}
+
+type EncodeTestEmbedded struct {
+	Kind   string `json:"kind" groups:"short"`
+	Note   string `json:"note"`
+	hidden int
+}
+
+type encodeTestValue struct {
+	First              encodeTestUntagged `json:"first" groups:"short,normal"`
+	EncodeTestEmbedded `groups:"normal"`
+	Last               []encodeTestUntagged `json:"last" groups:"short,normal"`
+	A                  string               `json:"a" groups:"short"`
+	Kind               string               `json:"kind,omitempty" groups:"normal"`
+}
+
+type encodeTestUngrouped struct {
+	EncodeTestEmbedded
+	Last encodeTestUntagged `json:"last" groups:"short"`
+}
+
+func encodeTestSheriff(t *testing.T, groups []string, res *Result) string {
+	t.Helper()
+	v, _ := version.NewVersion("0.0.0")
+	data, err := sheriff.Marshal(&sheriff.Options{Groups: groups, ApiVersion: v}, res)
+	assert.NilError(t, err)
+	out, err := json.Marshal(data)
+	assert.NilError(t, err)
+	return string(out)
+}
+
+// Test that the fields without groups are written as sheriff writes them,
+// i.e. depending on the embedded structs encoded before them
+func TestResultEncoderNestedGroups(t *testing.T) {
+	untagged := encodeTestUntagged{Note: "untagged", Kind: "untagged"}
+	results := []*Result{
+		{Name: "example.com", Data: encodeTestValue{
+			First:              untagged,
+			EncodeTestEmbedded: EncodeTestEmbedded{Kind: "embedded", Note: "embedded"},
+			Last:               []encodeTestUntagged{untagged, {Note: "last"}},
+			A:                  "a",
+		}},
+		{Name: "example.com", Data: encodeTestValue{
+			EncodeTestEmbedded: EncodeTestEmbedded{Kind: "embedded", Note: "embedded"},
+			Kind:               "outer",
+		}},
+		{Name: "example.com", Data: encodeTestUngrouped{
+			EncodeTestEmbedded: EncodeTestEmbedded{Kind: "embedded", Note: "embedded"},
+			Last:               untagged,
+		}},
+		// after a result with embedded structs, as each result starts afresh
+		{Name: "example.com", Data: untagged},
+	}
+	for _, groups := range [][]string{nil, {"short"}, {"normal"}, {"short", ""}, {"long", ""}} {
+		e := NewResultEncoder(groups)
+		for _, res := range results {
+			out, err := e.Encode(res)
+			assert.NilError(t, err)
+			assert.Equal(t, string(out), encodeTestSheriff(t, groups, res), "groups %v", groups)
+		}
+	}
+}
+
+// Test that a field without groups is written once an embedded struct with
+// a field of its name has been
+func TestResultEncoderUntaggedAfterEmbedded(t *testing.T) {
+	res := &Result{Data: encodeTestValue{
+		First:              encodeTestUntagged{Note: "first"},
+		EncodeTestEmbedded: EncodeTestEmbedded{Note: "embedded"},
+		Last:               []encodeTestUntagged{{Note: "last"}},
+	}}
+	out, err := NewResultEncoder([]string{"normal"}).Encode(res)
+	assert.NilError(t, err)
+	assert.Equal(t, string(out), `{"data":{"first":{"kind":""},"last":[{"kind":"","note":"last"}],"note":"embedded"}}`)
+}
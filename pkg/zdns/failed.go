@@ -0,0 +1,78 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// isFailedStatus reports whether a lookup with status is worth retrying,
+// i.e. it timed out or failed rather than got an answer
+func isFailedStatus(status Status) bool {
+	return isTimeoutStatus(status) || isErrorStatus(status)
+}
+
+// failedNames writes the raw input lines of failed lookups, so that they can
+// be fed back in as the input of another scan. A nil *failedNames discards
+// everything.
+type failedNames struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// openFailedNames creates the file at path, or when resuming a scan, cuts it
+// off after the first offset bytes, those of the lines completed at the last
+// checkpoint, and appends to it
+func openFailedNames(path string, resume bool, offset int64) (*failedNames, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open failed names file: %w", err)
+	}
+	if resume {
+		// lines still buffered when the scan was killed are missing, and
+		// can't be made up for
+		info, err := f.Stat()
+		if err == nil && info.Size() > offset {
+			err = f.Truncate(offset)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to truncate failed names file: %w", err)
+		}
+	}
+	return &failedNames{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (n *failedNames) add(res *lookupResult) {
+	if n == nil || !isFailedStatus(res.status) {
+		return
+	}
+	n.w.WriteString(res.line)
+	n.w.WriteByte('\n')
+}
+
+func (n *failedNames) close() error {
+	if err := n.w.Flush(); err != nil {
+		n.f.Close()
+		return fmt.Errorf("unable to write failed names file: %w", err)
+	}
+	return n.f.Close()
+}
@@ -0,0 +1,88 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestFailedNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failed.txt")
+	failed, err := openFailedNames(path, false, 0)
+	assert.NilError(t, err)
+
+	results := make(chan lookupResult, 5)
+	results <- lookupResult{index: 0, line: "a.example", status: STATUS_NOERROR, output: "a", hasOutput: true}
+	results <- lookupResult{index: 1, line: "b.example,192.0.2.53", status: STATUS_TIMEOUT, output: "b", hasOutput: true}
+	results <- lookupResult{index: 2, line: "c.example", status: STATUS_NXDOMAIN, output: "c", hasOutput: true}
+	results <- lookupResult{index: 3, line: "d.example", status: STATUS_SERVFAIL, output: "d", hasOutput: true}
+	results <- lookupResult{index: 4, line: "e.example", status: STATUS_ITER_TIMEOUT}
+	close(results)
+	out := &outputChan{lines: make(chan string, 5)}
+	collectResults(results, out, nil, failed)
+	assert.NilError(t, failed.close())
+
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "b.example,192.0.2.53\nd.example\ne.example\n")
+
+	// a nil *failedNames ignores results
+	var none *failedNames
+	none.add(&lookupResult{status: STATUS_TIMEOUT})
+}
+
+// Test that resuming a scan drops the failed names written after the last
+// checkpoint, which are looked up again, rather than writing them twice
+func TestFailedNamesResume(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "failed.txt")
+	conf := &GlobalConf{FailedNamesFilePath: path}
+	failed, err := openFailedNames(path, false, 0)
+	assert.NilError(t, err)
+	tracker := newCheckpointTracker(filepath.Join(dir, "checkpoint.json"), conf, nil)
+
+	results := make(chan lookupResult, 3)
+	results <- lookupResult{index: 0, line: "a.example", status: STATUS_TIMEOUT, output: "a", hasOutput: true}
+	results <- lookupResult{index: 1, line: "b.example", status: STATUS_NOERROR, output: "b", hasOutput: true}
+	results <- lookupResult{index: 2, line: "c.example", status: STATUS_SERVFAIL, output: "c", hasOutput: true}
+	close(results)
+	out := &outputChan{lines: make(chan string, 3)}
+	// the scan is killed after c.example is written, but before it's
+	// completed
+	collectResults(results, out, tracker, failed)
+	assert.NilError(t, tracker.save())
+	assert.NilError(t, failed.close())
+	assert.Equal(t, tracker.cp.FailedOffset, int64(len("a.example\n")))
+
+	cp, err := LoadCheckpoint(tracker.path)
+	assert.NilError(t, err)
+	failed, err = openFailedNames(path, true, cp.FailedOffset)
+	assert.NilError(t, err)
+	tracker = newCheckpointTracker(tracker.path, conf, cp)
+	results = make(chan lookupResult, 1)
+	results <- lookupResult{index: 2, line: "c.example", status: STATUS_SERVFAIL, output: "c", hasOutput: true}
+	close(results)
+	tracker.completeResult(collectResults(results, &outputChan{lines: make(chan string, 1)}, tracker, failed))
+	assert.NilError(t, failed.close())
+
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "a.example\nc.example\n")
+	assert.Equal(t, tracker.cp.FailedOffset, int64(len(data)))
+}
@@ -0,0 +1,383 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	OUTPUT_FORMAT_JSON = "json"
+	OUTPUT_FORMAT_CSV  = "csv"
+	OUTPUT_FORMAT_TSV  = "tsv"
+	OUTPUT_FORMAT_ZONE = "zone"
+)
+
+// ZoneSections are the sections of a response that can be written by the
+// zone output format
+var ZoneSections = []string{"answer", "authority", "additional"}
+
+// FlatRecord is a single DNS record of a lookup result, as written by the
+// flat output formats
+type FlatRecord struct {
+	// Section is answer, authority or additional
+	Section string
+	Name    string
+	Type    string
+	Class   string
+	// TTL is only known if HasTTL is set
+	TTL    uint32
+	HasTTL bool
+	Answer string
+	// Rdata is the data of the record in RFC 1035 presentation format, or
+	// "" if it can't be written that way
+	Rdata string
+	// Resolver is the name server the record was received from, if known
+	Resolver string
+}
+
+// Flattener is implemented by lookup results that can be split into
+// records for the flat output formats. name is the name that was looked up.
+type Flattener interface {
+	FlatRecords(name string) []FlatRecord
+}
+
+// resultFormatter turns results into lines of output
+type resultFormatter interface {
+	// header returns a line to write before any result, or ""
+	header() string
+	// format returns the output for res, which may span several lines
+	format(res *Result) (string, error)
+}
+
+func newResultFormatter(gc *GlobalConf) resultFormatter {
+	switch gc.OutputFormat {
+	case OUTPUT_FORMAT_CSV:
+		return newFlatFormatter(gc, ',')
+	case OUTPUT_FORMAT_TSV:
+		return newFlatFormatter(gc, '\t')
+	case OUTPUT_FORMAT_ZONE:
+		return newZoneFormatter(gc)
+	}
+	return &jsonFormatter{encoder: NewResultEncoder(gc.OutputGroups)}
+}
+
+type jsonFormatter struct {
+	encoder *ResultEncoder
+}
+
+func (f *jsonFormatter) header() string {
+	return ""
+}
+
+func (f *jsonFormatter) format(res *Result) (string, error) {
+	data, err := f.encoder.Encode(res)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// flatColumn is a column of the flat output formats. It is written if any of
+// its groups is among the output groups, like the result field it comes
+// from.
+type flatColumn struct {
+	name   string
+	groups []string
+}
+
+var allGroups = []string{"short", "normal", "long", "trace"}
+
+var flatColumns = []flatColumn{
+	{"name", allGroups},
+	{"module", nil},
+	{"status", allGroups},
+	{"resolver", []string{"resolver", "normal", "long", "trace"}},
+	{"section", allGroups},
+	{"rr_name", allGroups},
+	{"rr_type", allGroups},
+	{"rr_class", allGroups},
+	{"ttl", []string{"ttl", "normal", "long", "trace"}},
+	{"answer", allGroups},
+}
+
+// flatFormatter writes one row per record of a result, or a single row
+// without a record if there are none
+type flatFormatter struct {
+	comma   rune
+	columns []string
+	// modules are the modules whose results are combined per name, in the
+	// order their rows are written
+	modules []string
+	// module is the module of the results of lines of jsonl input that
+	// don't pick one
+	module string
+}
+
+func newFlatFormatter(gc *GlobalConf, comma rune) *flatFormatter {
+	f := &flatFormatter{comma: comma, modules: gc.Modules}
+	if len(gc.Modules) == 0 {
+		f.module = gc.Module
+	}
+	for _, c := range flatColumns {
+		if c.name == "module" {
+			// results of several modules, or of the modules picked by the
+			// lines of jsonl input, are told apart by this column
+			if len(f.modules) > 0 || gc.InputFormat == INPUT_FORMAT_JSONL {
+				f.columns = append(f.columns, c.name)
+			}
+			continue
+		}
+		for _, g := range c.groups {
+			if inGroups(g, gc.OutputGroups) {
+				f.columns = append(f.columns, c.name)
+				break
+			}
+		}
+	}
+	return f
+}
+
+// OutputGroups returns the output groups of results written with the given
+// --result-verbosity and --include-fields: the verbosity, plus any
+// additional fields the user wants. Without additional fields, the groups
+// include "", the groups of the fields of embedded structs without groups.
+func OutputGroups(verbosity, includeFields string) ([]string, error) {
+	if verbosity != "short" && verbosity != "normal" && verbosity != "long" && verbosity != "trace" {
+		return nil, newValidationError("--result-verbosity", "invalid result verbosity %q. Options: short, normal, long, trace", verbosity)
+	}
+	return append([]string{verbosity}, strings.Split(includeFields, ",")...), nil
+}
+
+func inGroups(group string, groups []string) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *flatFormatter) writeRows(rows [][]string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Comma = f.comma
+	w.WriteAll(rows)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (f *flatFormatter) header() string {
+	return f.writeRows([][]string{f.columns})
+}
+
+func (f *flatFormatter) row(res *Result, module, status string, rec *FlatRecord) []string {
+	row := make([]string, 0, len(f.columns))
+	for _, c := range f.columns {
+		var v string
+		switch c {
+		case "name":
+			v = res.Name
+		case "module":
+			v = module
+		case "status":
+			v = status
+		}
+		if rec != nil {
+			switch c {
+			case "resolver":
+				v = rec.Resolver
+			case "section":
+				v = rec.Section
+			case "rr_name":
+				v = rec.Name
+			case "rr_type":
+				v = rec.Type
+			case "rr_class":
+				v = rec.Class
+			case "ttl":
+				if rec.HasTTL {
+					v = strconv.FormatUint(uint64(rec.TTL), 10)
+				}
+			case "answer":
+				v = rec.Answer
+			}
+		}
+		row = append(row, v)
+	}
+	return row
+}
+
+// FlatRecords splits the data of a lookup of name into records, as the flat
+// output formats write them. Data that isn't a Flattener is kept whole, as
+// the JSON answer of a single record.
+func FlatRecords(name string, data interface{}) ([]FlatRecord, error) {
+	switch d := data.(type) {
+	case nil:
+		return nil, nil
+	case Flattener:
+		return d.FlatRecords(name), nil
+	}
+	// anything else is kept whole
+	j, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return []FlatRecord{{Section: "answer", Name: name, Answer: string(j)}}, nil
+}
+
+func (f *flatFormatter) appendRows(rows [][]string, res *Result, module, status string, data interface{}) ([][]string, error) {
+	name := res.Name
+	if res.AlteredName != "" {
+		name = res.AlteredName
+	}
+	records, err := FlatRecords(name, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return append(rows, f.row(res, module, status, nil)), nil
+	}
+	for i := range records {
+		rows = append(rows, f.row(res, module, status, &records[i]))
+	}
+	return rows, nil
+}
+
+func (f *flatFormatter) format(res *Result) (string, error) {
+	var rows [][]string
+	var err error
+	if results, ok := res.Data.(map[string]ModuleResult); ok {
+		for _, module := range f.modules {
+			mr, ok := results[module]
+			if !ok {
+				continue
+			}
+			if rows, err = f.appendRows(rows, res, module, mr.Status, mr.Data); err != nil {
+				return "", fmt.Errorf("unable to flatten result: %w", err)
+			}
+		}
+		if len(rows) == 0 {
+			rows = append(rows, f.row(res, "", res.Status, nil))
+		}
+	} else {
+		module := res.Module
+		if module == "" {
+			module = f.module
+		}
+		if rows, err = f.appendRows(rows, res, module, res.Status, res.Data); err != nil {
+			return "", fmt.Errorf("unable to flatten result: %w", err)
+		}
+	}
+	return f.writeRows(rows), nil
+}
+
+// zoneFormatter writes the records of a result as RFC 1035 master file
+// lines, preceded by a comment on the lookup they came from
+type zoneFormatter struct {
+	sections []string
+	modules  []string
+}
+
+func newZoneFormatter(gc *GlobalConf) *zoneFormatter {
+	sections := gc.ZoneSections
+	if len(sections) == 0 {
+		sections = ZoneSections[:1]
+	}
+	return &zoneFormatter{sections: sections, modules: gc.Modules}
+}
+
+func (f *zoneFormatter) header() string {
+	return ""
+}
+
+// zoneLine writes rec in presentation format, or as a comment if its data
+// can't be written that way
+func zoneLine(rec *FlatRecord) string {
+	owner := rec.Name
+	if !strings.HasSuffix(owner, ".") {
+		owner += "."
+	}
+	fields := []string{owner}
+	if rec.HasTTL {
+		fields = append(fields, strconv.FormatUint(uint64(rec.TTL), 10))
+	}
+	if rec.Class != "" {
+		fields = append(fields, rec.Class)
+	}
+	fields = append(fields, rec.Type)
+	if rec.Rdata == "" || rec.Type == "" {
+		return "; " + strings.Join(append(fields, rec.Answer), "\t")
+	}
+	return strings.Join(append(fields, rec.Rdata), "\t")
+}
+
+func (f *zoneFormatter) appendLines(lines []string, res *Result, module, status string, data interface{}) ([]string, error) {
+	name := res.Name
+	if res.AlteredName != "" {
+		name = res.AlteredName
+	}
+	records, err := FlatRecords(name, data)
+	if err != nil {
+		return nil, err
+	}
+	resolver := res.Nameserver
+	for _, rec := range records {
+		if rec.Resolver != "" {
+			resolver = rec.Resolver
+			break
+		}
+	}
+	comment := "; name: " + name
+	if module != "" {
+		comment += ", module: " + module
+	}
+	comment += ", status: " + status
+	if resolver != "" {
+		comment += ", resolver: " + resolver
+	}
+	lines = append(lines, comment)
+	for i := range records {
+		if inGroups(records[i].Section, f.sections) {
+			lines = append(lines, zoneLine(&records[i]))
+		}
+	}
+	return lines, nil
+}
+
+func (f *zoneFormatter) format(res *Result) (string, error) {
+	var lines []string
+	var err error
+	if results, ok := res.Data.(map[string]ModuleResult); ok {
+		for _, module := range f.modules {
+			mr, ok := results[module]
+			if !ok {
+				continue
+			}
+			if lines, err = f.appendLines(lines, res, module, mr.Status, mr.Data); err != nil {
+				return "", fmt.Errorf("unable to write result as zone: %w", err)
+			}
+		}
+		if len(lines) == 0 {
+			lines, _ = f.appendLines(lines, res, "", res.Status, nil)
+		}
+	} else if lines, err = f.appendLines(lines, res, "", res.Status, res.Data); err != nil {
+		return "", fmt.Errorf("unable to write result as zone: %w", err)
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,135 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+type flatTestResult []string
+
+func (r flatTestResult) FlatRecords(name string) []FlatRecord {
+	var records []FlatRecord
+	for _, answer := range r {
+		records = append(records, FlatRecord{Section: "answer", Name: name, Type: "TXT", Class: "IN", TTL: 60, HasTTL: true, Answer: answer, Resolver: "192.0.2.53:53"})
+	}
+	return records
+}
+
+func TestFlatFormatter(t *testing.T) {
+	gc := &GlobalConf{OutputFormat: OUTPUT_FORMAT_CSV, OutputGroups: []string{"normal"}}
+	f := newResultFormatter(gc)
+	assert.Equal(t, f.header(), "name,status,resolver,section,rr_name,rr_type,rr_class,ttl,answer")
+
+	out, err := f.format(&Result{Name: "example.com", Status: "NOERROR", Data: flatTestResult{"a", "b,\"c\""}})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "example.com,NOERROR,192.0.2.53:53,answer,example.com,TXT,IN,60,a\n"+
+		"example.com,NOERROR,192.0.2.53:53,answer,example.com,TXT,IN,60,\"b,\"\"c\"\"\"")
+
+	out, err = f.format(&Result{Name: "example.com", Status: "TIMEOUT"})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "example.com,TIMEOUT,,,,,,,")
+
+	// data that can't be flattened is kept whole
+	out, err = f.format(&Result{Name: "example.com", Status: "NOERROR", Data: map[string]int{"n": 1}})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "example.com,NOERROR,,answer,example.com,,,,\"{\"\"n\"\":1}\"")
+}
+
+func TestFlatFormatterColumns(t *testing.T) {
+	// short leaves out the resolver and ttl unless they are included
+	gc := &GlobalConf{OutputFormat: OUTPUT_FORMAT_TSV, OutputGroups: []string{"short", "ttl"}}
+	f := newResultFormatter(gc)
+	assert.Equal(t, f.header(), "name\tstatus\tsection\trr_name\trr_type\trr_class\tttl\tanswer")
+}
+
+func TestFlatFormatterModules(t *testing.T) {
+	gc := &GlobalConf{OutputFormat: OUTPUT_FORMAT_CSV, OutputGroups: []string{"short"}, Modules: []string{"TXT", "A"}}
+	f := newResultFormatter(gc)
+	assert.Equal(t, f.header(), "name,module,status,section,rr_name,rr_type,rr_class,answer")
+
+	out, err := f.format(&Result{Name: "example.com", Status: "NXDOMAIN", Data: map[string]ModuleResult{
+		"A":   {Status: "NXDOMAIN"},
+		"TXT": {Status: "NOERROR", Data: flatTestResult{"x"}},
+	}})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "example.com,TXT,NOERROR,answer,example.com,TXT,IN,x\n"+
+		"example.com,A,NXDOMAIN,,,,,")
+}
+
+// Test that the rows of jsonl input lines picking their own module say which
+// module they come from
+func TestFlatFormatterLineModules(t *testing.T) {
+	gc := &GlobalConf{OutputFormat: OUTPUT_FORMAT_CSV, OutputGroups: []string{"short"}, Module: "A", InputFormat: INPUT_FORMAT_JSONL}
+	f := newResultFormatter(gc)
+	assert.Equal(t, f.header(), "name,module,status,section,rr_name,rr_type,rr_class,answer")
+
+	out, err := f.format(&Result{Name: "example.com", Module: "TXT", Status: "NOERROR", Data: flatTestResult{"x"}})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "example.com,TXT,NOERROR,answer,example.com,TXT,IN,x")
+	// lines that don't pick a module are looked up with the primary one
+	out, err = f.format(&Result{Name: "example.com", Status: "NXDOMAIN"})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "example.com,A,NXDOMAIN,,,,,")
+
+	// other input has no such lines
+	gc.InputFormat = INPUT_FORMAT_TEXT
+	assert.Equal(t, newResultFormatter(gc).header(), "name,status,section,rr_name,rr_type,rr_class,answer")
+}
+
+type zoneTestResult []FlatRecord
+
+func (r zoneTestResult) FlatRecords(name string) []FlatRecord {
+	return r
+}
+
+func TestZoneFormatter(t *testing.T) {
+	data := zoneTestResult{
+		{Section: "answer", Name: "example.com", Type: "MX", Class: "IN", TTL: 60, HasTTL: true, Answer: "10 mail.example.com", Rdata: "10 mail.example.com.", Resolver: "192.0.2.53:53"},
+		{Section: "additional", Name: "mail.example.com", Type: "A", Answer: "192.0.2.1", Rdata: "192.0.2.1"},
+		{Section: "answer", Name: "example.com", Type: "TYPE1234", Class: "IN", Answer: "unknown"},
+	}
+	f := newResultFormatter(&GlobalConf{OutputFormat: OUTPUT_FORMAT_ZONE})
+	assert.Equal(t, f.header(), "")
+	out, err := f.format(&Result{Name: "example.com", Status: "NOERROR", Data: data})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "; name: example.com, status: NOERROR, resolver: 192.0.2.53:53\n"+
+		"example.com.\t60\tIN\tMX\t10 mail.example.com.\n"+
+		"; example.com.\tIN\tTYPE1234\tunknown")
+
+	f = newResultFormatter(&GlobalConf{OutputFormat: OUTPUT_FORMAT_ZONE, ZoneSections: []string{"answer", "additional"}})
+	out, err = f.format(&Result{Name: "example.com", Status: "NOERROR", Data: data[:2]})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "; name: example.com, status: NOERROR, resolver: 192.0.2.53:53\n"+
+		"example.com.\t60\tIN\tMX\t10 mail.example.com.\n"+
+		"mail.example.com.\tA\t192.0.2.1")
+
+	out, err = f.format(&Result{Name: "example.com", Status: "NXDOMAIN"})
+	assert.NilError(t, err)
+	assert.Equal(t, out, "; name: example.com, status: NXDOMAIN")
+}
+
+func TestOutputGroups(t *testing.T) {
+	groups, err := OutputGroups("normal", "")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, groups, []string{"normal", ""})
+	groups, err = OutputGroups("short", "ttl,class")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, groups, []string{"short", "ttl", "class"})
+	_, err = OutputGroups("verbose", "")
+	assert.ErrorContains(t, err, "invalid result verbosity")
+}
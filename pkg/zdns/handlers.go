@@ -0,0 +1,65 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/zmap/zdns/iohandlers"
+)
+
+// The file handlers are the default and read/write the paths given by
+// --input-file and --output-file (or --output-dir), compressed as given by
+// --input-compression and --output-compression, so they define no options of
+// their own.
+
+type fileInputHandlerFactory struct{}
+
+func (fileInputHandlerFactory) AddFlags(flags *pflag.FlagSet) {
+}
+
+func (fileInputHandlerFactory) MakeInputHandler(conf *GlobalConf, flags *pflag.FlagSet) (InputHandler, error) {
+	h := iohandlers.NewFileInputHandler(conf.InputFilePath)
+	h.SetCompression(conf.InputCompression)
+	return h, nil
+}
+
+type fileOutputHandlerFactory struct{}
+
+func (fileOutputHandlerFactory) AddFlags(flags *pflag.FlagSet) {
+}
+
+func (fileOutputHandlerFactory) MakeOutputHandler(conf *GlobalConf, flags *pflag.FlagSet) (OutputHandler, error) {
+	if conf.OutputDir != "" {
+		h := iohandlers.NewDirOutputHandler(conf.OutputDir)
+		if conf.OutputFormat != "" && conf.OutputFormat != OUTPUT_FORMAT_JSON {
+			h.SetExtension("." + conf.OutputFormat)
+		}
+		h.SetCompression(conf.OutputCompression)
+		return h, nil
+	}
+	var h *iohandlers.FileOutputHandler
+	if conf.Resume {
+		h = iohandlers.NewAppendingFileOutputHandler(conf.OutputFilePath)
+	} else {
+		h = iohandlers.NewFileOutputHandler(conf.OutputFilePath)
+	}
+	h.SetCompression(conf.OutputCompression)
+	return h, nil
+}
+
+func init() {
+	RegisterInputHandler("file", fileInputHandlerFactory{})
+	RegisterOutputHandler("file", fileOutputHandlerFactory{})
+}
@@ -0,0 +1,75 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"gotest.tools/v3/assert"
+)
+
+type sliceOutputHandler struct {
+	prefix  string
+	results []string
+}
+
+func (h *sliceOutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer wg.Done()
+	for r := range results {
+		h.results = append(h.results, h.prefix+r)
+	}
+	return nil
+}
+
+type sliceOutputHandlerFactory struct{}
+
+func (sliceOutputHandlerFactory) AddFlags(flags *pflag.FlagSet) {
+	flags.String("slice-prefix", "", "prefix prepended to every result")
+}
+
+func (sliceOutputHandlerFactory) MakeOutputHandler(conf *GlobalConf, flags *pflag.FlagSet) (OutputHandler, error) {
+	prefix, err := flags.GetString("slice-prefix")
+	if err != nil {
+		return nil, err
+	}
+	return &sliceOutputHandler{prefix: prefix}, nil
+}
+
+func TestRegisterOutputHandler(t *testing.T) {
+	RegisterOutputHandler("slice", sliceOutputHandlerFactory{})
+	defer delete(outputHandlers, "slice")
+
+	assert.Equal(t, ValidOutputHandlersString(), "file, slice")
+	assert.Assert(t, GetOutputHandler("missing") == nil)
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	AddHandlerFlags(flags)
+	assert.NilError(t, flags.Parse([]string{"--slice-prefix=> "}))
+
+	h, err := GetOutputHandler("slice").MakeOutputHandler(new(GlobalConf), flags)
+	assert.NilError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	results := make(chan string, 2)
+	results <- "a"
+	results <- "b"
+	close(results)
+	assert.NilError(t, h.WriteResults(results, &wg))
+	wg.Wait()
+	assert.DeepEqual(t, h.(*sliceOutputHandler).results, []string{"> a", "> b"})
+}
@@ -15,14 +15,18 @@
 package zdns
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
 
 	"github.com/spf13/pflag"
+	"github.com/zmap/zdns/iohandlers"
 )
 
 /* Each lookup module registers a single GlobalLookupFactory, which is
@@ -50,6 +54,16 @@ type Trace []interface{}
 
 type Lookup interface {
 	DoLookup(name, nameServer string) (interface{}, Trace, Status, error)
+	// DoLookupContext behaves like DoLookup, but abandons any in-flight
+	// exchange as soon as ctx is done and reports STATUS_CANCELLED.
+	DoLookupContext(ctx context.Context, name, nameServer string) (interface{}, Trace, Status, error)
+}
+
+// ClassSetter is implemented by lookups that can query a DNS class other
+// than the one configured for the whole run, e.g. for jsonl input lines that
+// carry their own class
+type ClassSetter interface {
+	SetDNSClass(dnsClass uint16)
 }
 
 type BaseLookup struct {
@@ -60,6 +74,8 @@ func (base *BaseLookup) DoLookup(name string, class uint16) (interface{}, Status
 }
 
 // one RoutineLookupFactory per goroutine =====================================
+// Routine factories that hold on to resources, e.g. open connections,
+// implement io.Closer and are closed once their goroutine is done.
 type RoutineLookupFactory interface {
 	MakeLookup() (Lookup, error)
 }
@@ -85,18 +101,69 @@ type GlobalLookupFactory interface {
 	RandomNameServer() string
 }
 
+// ResultTyper is implemented by global factories that declare the type of
+// the data of their results, e.g. for output to be read back into it.
+// Factories that other modules embed shouldn't implement it, so that a
+// module doesn't pass for having results it doesn't have.
+type ResultTyper interface {
+	ResultType() reflect.Type
+}
+
 // handle domain input
 type InputHandler interface {
 	// FeedChannel takes a channel to write domains to, the WaitGroup managing them, and if it's a zonefile input
 	FeedChannel(in chan<- interface{}, wg *sync.WaitGroup) error
 }
 
+// ContextInputHandler is an InputHandler that stops feeding lines once ctx
+// is done, so that an interrupted scan doesn't leave it behind. It's given
+// the input with FeedChannelContext in place of FeedChannel.
+type ContextInputHandler interface {
+	InputHandler
+	FeedChannelContext(ctx context.Context, in chan<- interface{}, wg *sync.WaitGroup) error
+}
+
+// feedInput runs h until the input is exhausted or, if h can be stopped,
+// ctx is done
+func feedInput(ctx context.Context, h InputHandler, in chan<- interface{}, wg *sync.WaitGroup) error {
+	if ch, ok := h.(ContextInputHandler); ok {
+		return ch.FeedChannelContext(ctx, in, wg)
+	}
+	return h.FeedChannel(in, wg)
+}
+
 // handle output results
 type OutputHandler interface {
 	// takes a channel (results) to write the query results to, and the WaitGroup managing the handlers
 	WriteResults(results <-chan string, wg *sync.WaitGroup) error
 }
 
+// StatusOutputHandler is an OutputHandler that is also told the status of
+// every result, e.g. to sort results by status whatever the output format.
+// It's given the results with WriteStatusResults in place of WriteResults.
+type StatusOutputHandler interface {
+	OutputHandler
+	WriteStatusResults(results <-chan iohandlers.StatusLine, wg *sync.WaitGroup) error
+}
+
+// one InputHandlerFactory per registered input handler =======================
+type InputHandlerFactory interface {
+	// Define any handler-specific command line options. Called once for
+	// every registered handler before flags are parsed.
+	AddFlags(flags *pflag.FlagSet)
+	// Build the input handler once flags have been parsed
+	MakeInputHandler(conf *GlobalConf, flags *pflag.FlagSet) (InputHandler, error)
+}
+
+// one OutputHandlerFactory per registered output handler =====================
+type OutputHandlerFactory interface {
+	// Define any handler-specific command line options. Called once for
+	// every registered handler before flags are parsed.
+	AddFlags(flags *pflag.FlagSet)
+	// Build the output handler once flags have been parsed
+	MakeOutputHandler(conf *GlobalConf, flags *pflag.FlagSet) (OutputHandler, error)
+}
+
 type BaseGlobalLookupFactory struct {
 	GlobalConf *GlobalConf
 }
@@ -151,10 +218,10 @@ func (s *BaseGlobalLookupFactory) ZonefileInput() bool {
 var lookups map[string]GlobalLookupFactory
 
 // keep a mapping from name to input handler
-var inputHandlers map[string]InputHandler
+var inputHandlers map[string]InputHandlerFactory
 
 // keep a mapping from name to output handler
-var outputHandlers map[string]OutputHandler
+var outputHandlers map[string]OutputHandlerFactory
 
 func RegisterLookup(name string, s GlobalLookupFactory) {
 	if lookups == nil {
@@ -191,3 +258,85 @@ func GetLookup(name string) GlobalLookupFactory {
 	}
 	return nil
 }
+
+// LookupResultType returns the type of the data of the results of module, as
+// declared by its factory
+func LookupResultType(module string) (reflect.Type, error) {
+	factory := GetLookup(module)
+	if factory == nil {
+		return nil, fmt.Errorf("invalid lookup module %q", module)
+	}
+	rt, ok := factory.(ResultTyper)
+	if !ok {
+		return nil, fmt.Errorf("lookup module %q doesn't declare its result type", module)
+	}
+	return rt.ResultType(), nil
+}
+
+func RegisterInputHandler(name string, h InputHandlerFactory) {
+	if inputHandlers == nil {
+		inputHandlers = make(map[string]InputHandlerFactory)
+	}
+	inputHandlers[name] = h
+}
+
+func RegisterOutputHandler(name string, h OutputHandlerFactory) {
+	if outputHandlers == nil {
+		outputHandlers = make(map[string]OutputHandlerFactory)
+	}
+	outputHandlers[name] = h
+}
+
+func ValidInputHandlersString() string {
+	valid := make([]string, 0, len(inputHandlers))
+	for k := range inputHandlers {
+		valid = append(valid, k)
+	}
+	sort.Strings(valid)
+	return strings.Join(valid, ", ")
+}
+
+func ValidOutputHandlersString() string {
+	valid := make([]string, 0, len(outputHandlers))
+	for k := range outputHandlers {
+		valid = append(valid, k)
+	}
+	sort.Strings(valid)
+	return strings.Join(valid, ", ")
+}
+
+func GetInputHandler(name string) InputHandlerFactory {
+	if factory, ok := inputHandlers[name]; ok {
+		return factory
+	}
+	return nil
+}
+
+func GetOutputHandler(name string) OutputHandlerFactory {
+	if factory, ok := outputHandlers[name]; ok {
+		return factory
+	}
+	return nil
+}
+
+// AddHandlerFlags lets every registered input and output handler define its
+// own command line options. Handlers can be registered from any package's
+// init, so this should be called once all of them have run.
+func AddHandlerFlags(flags *pflag.FlagSet) {
+	names := make([]string, 0, len(inputHandlers))
+	for k := range inputHandlers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		inputHandlers[name].AddFlags(flags)
+	}
+	names = names[:0]
+	for k := range outputHandlers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		outputHandlers[name].AddFlags(flags)
+	}
+}
@@ -15,18 +15,22 @@
 package zdns
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/hashicorp/go-version"
-	"github.com/liip/sheriff"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 	"github.com/zmap/dns"
-	"github.com/zmap/zdns/internal/util"
+	"github.com/zmap/zdns/iohandlers"
 )
 
 type routineMetadata struct {
@@ -67,7 +71,7 @@ func parseMetadataInputLine(line string) (string, string) {
 	return s[0], s[1]
 }
 
-func parseNormalInputLine(line string) (string, string) {
+func parseNormalInputLine(line string, useTLS bool) (string, string) {
 	r := csv.NewReader(strings.NewReader(line))
 	s, err := r.Read()
 	if err != nil || len(s) == 0 {
@@ -76,10 +80,147 @@ func parseNormalInputLine(line string) (string, string) {
 	if len(s) == 1 {
 		return s[0], ""
 	} else {
-		return s[0], util.AddDefaultPortToDNSServerName(s[1])
+		return s[0], nameServerAddr(s[1], useTLS)
 	}
 }
 
+func parseJSONInputLine(line string, useTLS bool) (JSONInput, error) {
+	var in JSONInput
+	if err := json.Unmarshal([]byte(line), &in); err != nil {
+		return in, fmt.Errorf("invalid JSON input line: %w", err)
+	}
+	if in.NameServer != "" {
+		in.NameServer = nameServerAddr(in.NameServer, useTLS)
+	}
+	return in, nil
+}
+
+// lookupModules hands out the lookup modules requested by jsonl input lines.
+// Modules other than the one the run was started with are initialized the
+// first time a line asks for them.
+type lookupModules struct {
+	primary GlobalLookupFactory
+	conf    *GlobalConf
+	flags   *pflag.FlagSet
+
+	mu      sync.Mutex
+	modules map[string]GlobalLookupFactory
+}
+
+func newLookupModules(primary GlobalLookupFactory, conf *GlobalConf, flags *pflag.FlagSet) *lookupModules {
+	return &lookupModules{
+		primary: primary,
+		conf:    conf,
+		flags:   flags,
+		modules: make(map[string]GlobalLookupFactory),
+	}
+}
+
+func (m *lookupModules) get(module string) (GlobalLookupFactory, error) {
+	if module == "" || module == m.conf.Module {
+		return m.primary, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if g, ok := m.modules[module]; ok {
+		return g, nil
+	}
+	g := GetLookup(module)
+	if g == nil {
+		return nil, fmt.Errorf("invalid lookup module %q", module)
+	}
+	if m.flags == nil {
+		return nil, fmt.Errorf("lookup module %q cannot be initialized without command line flags", module)
+	}
+	g.SetFlags(m.flags)
+	if err := g.Initialize(m.conf); err != nil {
+		return nil, fmt.Errorf("lookup module %q was unable to initialize: %w", module, err)
+	}
+	m.modules[module] = g
+	return g, nil
+}
+
+// finalize finalizes every module initialized by get. The primary module is
+// left to whoever initialized it.
+func (m *lookupModules) finalize() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, g := range m.modules {
+		if err := g.Finalize(); err != nil {
+			return fmt.Errorf("lookup module %q was unable to finalize: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// makeInputLookup returns a lookup of the requested module, using a routine
+// factory cached in routineFactories, that queries class instead of the
+// run-wide defaultClass
+func makeInputLookup(modules *lookupModules, routineFactories map[string]RoutineLookupFactory, module string, class, defaultClass uint16, threadID int) (Lookup, error) {
+	if module == modules.conf.Module {
+		module = ""
+	}
+	f, ok := routineFactories[module]
+	if !ok {
+		g, err := modules.get(module)
+		if err != nil {
+			return nil, err
+		}
+		if f, err = g.MakeRoutineFactory(threadID); err != nil {
+			return nil, err
+		}
+		routineFactories[module] = f
+	}
+	l, err := f.MakeLookup()
+	if err != nil {
+		return nil, err
+	}
+	if class != defaultClass {
+		cs, ok := l.(ClassSetter)
+		if !ok {
+			return nil, fmt.Errorf("lookup module does not support querying class %s", dns.Class(class))
+		}
+		cs.SetDNSClass(class)
+	}
+	return l, nil
+}
+
+// doModuleLookups looks name up with every module in gc.Modules. The overall
+// status is NOERROR if every module succeeded, and otherwise that of the first
+// module, in the order given, that did not.
+func doModuleLookups(ctx context.Context, modules *lookupModules, routineFactories map[string]RoutineLookupFactory, gc *GlobalConf, class uint16, name, nameServer string, threadID int) (map[string]ModuleResult, Status) {
+	results := make(map[string]ModuleResult, len(gc.Modules))
+	overall := STATUS_NOERROR
+	for _, module := range gc.Modules {
+		var res ModuleResult
+		var innerRes interface{}
+		var trace []interface{}
+		var status Status
+		l, err := makeInputLookup(modules, routineFactories, module, class, gc.Class, threadID)
+		if err != nil {
+			status = STATUS_ERROR
+		} else {
+			start := time.Now()
+			innerRes, trace, status, err = l.DoLookupContext(ctx, name, nameServer)
+			gc.GetMetrics().LookupDone(module, status, time.Since(start))
+		}
+		if status == STATUS_NO_OUTPUT {
+			continue
+		}
+		res.Status = string(status)
+		res.Data = innerRes
+		res.Trace = trace
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results[module] = res
+		if status != STATUS_NOERROR && overall == STATUS_NOERROR {
+			overall = status
+		}
+	}
+	return results, overall
+}
+
 func makeName(name, prefix, nameOverride string) (string, bool) {
 	if nameOverride != "" {
 		return nameOverride, true
@@ -92,11 +233,21 @@ func makeName(name, prefix, nameOverride string) (string, bool) {
 	}
 }
 
-func doLookup2(g GlobalLookupFactory, gc *GlobalConf, input <-chan string, output chan<- any, wg *sync.WaitGroup, threadID int) error {
+// closeRoutineFactory releases what f holds on to once its routine is done
+func closeRoutineFactory(f RoutineLookupFactory) {
+	if c, ok := f.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Warn("unable to close lookup routine: ", err)
+		}
+	}
+}
+
+func doLookup2(ctx context.Context, g GlobalLookupFactory, gc *GlobalConf, input <-chan string, output chan<- any, wg *sync.WaitGroup, threadID int) error {
 	f, err := g.MakeRoutineFactory(threadID)
 	if err != nil {
 		return err
 	}
+	defer closeRoutineFactory(f)
 	var metadata routineMetadata
 	metadata.Status = make(map[Status]int)
 	for genericInput := range input {
@@ -113,14 +264,16 @@ func doLookup2(g GlobalLookupFactory, gc *GlobalConf, input <-chan string, outpu
 		var lookupName string
 		rawName := ""
 		nameServer := ""
-		rawName, nameServer = parseNormalInputLine(line)
+		rawName, nameServer = parseNormalInputLine(line, gc.TLS)
 		lookupName, changed = makeName(rawName, gc.NamePrefix, gc.NameOverride)
 		if changed {
 			res.AlteredName = lookupName
 		}
 		res.Name = rawName
 		res.Class = dns.Class(gc.Class).String()
-		innerRes, _, status, err = l.DoLookup(lookupName, nameServer)
+		start := time.Now()
+		innerRes, _, status, err = l.DoLookupContext(ctx, lookupName, nameServer)
+		gc.GetMetrics().LookupDone(gc.Module, status, time.Since(start))
 		//res.Timestamp = time.Now().Format(gc.TimeFormat)
 		if status != STATUS_NO_OUTPUT {
 			res.Status = string(status)
@@ -138,48 +291,86 @@ func doLookup2(g GlobalLookupFactory, gc *GlobalConf, input <-chan string, outpu
 	return nil
 }
 
-func doLookup(g GlobalLookupFactory, gc *GlobalConf, input <-chan interface{}, output chan<- string, metaChan chan<- routineMetadata, wg *sync.WaitGroup, threadID int) error {
-	f, err := g.MakeRoutineFactory(threadID)
+func doLookup(ctx context.Context, modules *lookupModules, gc *GlobalConf, formatter resultFormatter, input <-chan inputLine, output chan<- lookupResult, metaChan chan<- routineMetadata, p *progress, wg *sync.WaitGroup, threadID int) error {
+	defer wg.Done()
+	var metadata routineMetadata
+	metadata.Status = make(map[Status]int)
+	defer func() { metaChan <- metadata }()
+	f, err := modules.primary.MakeRoutineFactory(threadID)
 	if err != nil {
 		return err
 	}
-	var metadata routineMetadata
-	metadata.Status = make(map[Status]int)
+	// routine factories for the modules requested by jsonl input
+	routineFactories := map[string]RoutineLookupFactory{"": f}
+	defer func() {
+		for _, f := range routineFactories {
+			closeRoutineFactory(f)
+		}
+	}()
 	for genericInput := range input {
 		var res Result
 		var innerRes interface{}
 		var trace []interface{}
 		var status Status
 		var err error
-		l, err := f.MakeLookup()
-		if err != nil {
-			return err
-		}
-		line := genericInput.(string)
+		line := genericInput.line
 		var changed bool
 		var lookupName string
 		rawName := ""
 		nameServer := ""
 		var rank int
 		var entryMetadata string
-		if gc.AlexaFormat == true {
+		module := ""
+		class := gc.Class
+		var inputErr error
+		if gc.InputFormat == INPUT_FORMAT_JSONL {
+			var in JSONInput
+			in, inputErr = parseJSONInputLine(line, gc.TLS)
+			rawName, nameServer, module = in.Name, in.NameServer, strings.ToUpper(in.Module)
+			if in.Class != "" && inputErr == nil {
+				class, inputErr = parseClass(in.Class)
+			}
+			if len(in.Metadata) > 0 && string(in.Metadata) != "null" {
+				res.Metadata = in.Metadata
+			}
+			if module != gc.Module {
+				res.Module = module
+			}
+		} else if gc.AlexaFormat == true {
 			rawName, rank, _ = parseAlexa(line)
 			res.AlexaRank = rank
 		} else if gc.MetadataFormat {
 			rawName, entryMetadata = parseMetadataInputLine(line)
-			res.Metadata = entryMetadata
+			if entryMetadata != "" {
+				res.Metadata = entryMetadata
+			}
 		} else if gc.NameServerMode {
-			nameServer = util.AddDefaultPortToDNSServerName(line)
+			nameServer = nameServerAddr(line, gc.TLS)
 		} else {
-			rawName, nameServer = parseNormalInputLine(line)
+			rawName, nameServer = parseNormalInputLine(line, gc.TLS)
 		}
 		lookupName, changed = makeName(rawName, gc.NamePrefix, gc.NameOverride)
 		if changed {
 			res.AlteredName = lookupName
 		}
 		res.Name = rawName
-		res.Class = dns.Class(gc.Class).String()
-		innerRes, trace, status, err = l.DoLookup(lookupName, nameServer)
+		res.Class = dns.Class(class).String()
+		var l Lookup
+		if inputErr == nil && (module != "" || len(gc.Modules) == 0) {
+			l, inputErr = makeInputLookup(modules, routineFactories, module, class, gc.Class, threadID)
+		}
+		if inputErr != nil {
+			innerRes, trace, status, err = nil, nil, STATUS_ILLEGAL_INPUT, inputErr
+		} else if module == "" && len(gc.Modules) > 0 {
+			innerRes, status = doModuleLookups(ctx, modules, routineFactories, gc, class, lookupName, nameServer, threadID)
+		} else {
+			start := time.Now()
+			innerRes, trace, status, err = l.DoLookupContext(ctx, lookupName, nameServer)
+			if module == "" {
+				module = gc.Module
+			}
+			gc.GetMetrics().LookupDone(module, status, time.Since(start))
+		}
 		res.Timestamp = time.Now().Format(gc.TimeFormat)
 		if status != STATUS_NO_OUTPUT {
 			res.Status = string(status)
@@ -188,23 +379,27 @@ func doLookup(g GlobalLookupFactory, gc *GlobalConf, input <-chan interface{}, o
 			if err != nil {
 				res.Error = err.Error()
 			}
-			v, _ := version.NewVersion("0.0.0")
-			o := &sheriff.Options{
-				Groups:     gc.OutputGroups,
-				ApiVersion: v,
+			out, err := formatter.format(&res)
+			if err != nil {
+				// the error is written in place of what couldn't be
+				status = STATUS_ERROR
+				res.Status, res.Data, res.Trace = string(status), nil, nil
+				res.Error = "unable to format result: " + err.Error()
+				out, err = formatter.format(&res)
 			}
-			data, err := sheriff.Marshal(o, res)
-			jsonRes, err := json.Marshal(data)
 			if err != nil {
-				return err
+				log.Error("unable to format result: ", err)
+				output <- lookupResult{index: genericInput.index, line: line, status: status}
+			} else {
+				output <- lookupResult{index: genericInput.index, line: line, status: status, output: out, hasOutput: true}
 			}
-			output <- string(jsonRes)
+		} else {
+			output <- lookupResult{index: genericInput.index, line: line, status: status}
 		}
 		metadata.Names++
 		metadata.Status[status]++
+		p.complete(status)
 	}
-	//metaChan <- metadata
-	wg.Done()
 	return nil
 }
 
@@ -221,15 +416,70 @@ func aggregateMetadata(c <-chan routineMetadata) Metadata {
 }
 
 func DoLookups(g GlobalLookupFactory, c *GlobalConf) error {
+	var resumeFrom *Checkpoint
+	if c.Resume {
+		var err error
+		if resumeFrom, err = LoadCheckpoint(c.CheckpointFilePath); err != nil {
+			return err
+		}
+	}
+	return doLookups(context.Background(), c, newLookupModules(g, c, nil), resumeFrom)
+}
+
+// outputChan hands output lines to the output handler, along with their
+// status if it's a StatusOutputHandler. Both channels are unbuffered.
+type outputChan struct {
+	lines       chan string
+	statusLines chan iohandlers.StatusLine
+}
+
+func newOutputChan(h OutputHandler) *outputChan {
+	if _, ok := h.(StatusOutputHandler); ok {
+		return &outputChan{statusLines: make(chan iohandlers.StatusLine)}
+	}
+	return &outputChan{lines: make(chan string)}
+}
+
+// write runs h on the lines sent to o
+func (o *outputChan) write(h OutputHandler, wg *sync.WaitGroup) error {
+	if o.statusLines != nil {
+		return h.(StatusOutputHandler).WriteStatusResults(o.statusLines, wg)
+	}
+	return h.WriteResults(o.lines, wg)
+}
+
+// send hands line to the output handler. Lines that aren't the result of a
+// lookup, like headers, have no status.
+func (o *outputChan) send(line string, status Status) {
+	if o.statusLines != nil {
+		o.statusLines <- iohandlers.StatusLine{Line: line, Status: string(status)}
+		return
+	}
+	o.lines <- line
+}
+
+func (o *outputChan) close() {
+	if o.statusLines != nil {
+		close(o.statusLines)
+		return
+	}
+	close(o.lines)
+}
+
+// doLookups runs a scan until the input is exhausted or ctx is done. Once ctx
+// is done no more input is read, and the lookups in flight are abandoned and
+// their results written with STATUS_CANCELLED.
+func doLookups(ctx context.Context, c *GlobalConf, modules *lookupModules, resumeFrom *Checkpoint) error {
 	// DoLookup:
 	//	- n threads that do processing from in and place results in out
 	//	- process until inChan closes, then wg.done()
 	// Once we processing threads have all finished, wait until the
 	// output and metadata threads have completed
 	inChan := make(chan interface{})
-	outChan := make(chan string)
+	lineChan := make(chan inputLine)
+	resultChan := make(chan lookupResult)
 	metaChan := make(chan routineMetadata, c.Threads)
-	var routineWG sync.WaitGroup
+	var inputWG, outputWG sync.WaitGroup
 
 	inHandler := c.InputHandler
 	if inHandler == nil {
@@ -241,32 +491,218 @@ func DoLookups(g GlobalLookupFactory, c *GlobalConf) error {
 		log.Panic("Output handler is nil")
 	}
 
+	if c.QueryStats == nil {
+		c.QueryStats = NewQueryStats()
+	}
+	if c.RateLimiter == nil {
+		c.RateLimiter = NewRateLimiter(c.Rate, c.PerNameServerRate)
+	}
+
+	var tracker *checkpointTracker
+	if c.CheckpointFilePath != "" {
+		if c.Resume {
+			// drop whatever was written after the last checkpoint. The file
+			// output handler appends to what is left.
+			var offset int64
+			if resumeFrom != nil {
+				offset = resumeFrom.OutputOffset
+			}
+			if err := os.Truncate(c.OutputFilePath, offset); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("unable to truncate output file: %w", err)
+			}
+		}
+		tracker = newCheckpointTracker(c.CheckpointFilePath, c, resumeFrom)
+	}
+
+	// wire taps opened here are closed once the lookups are done
+	var dnstap *DnstapWriter
+	if c.Dnstap == nil && c.DnstapFilePath != "" {
+		var err error
+		if dnstap, err = NewDnstapWriter(c.DnstapFilePath); err != nil {
+			return err
+		}
+		c.Dnstap = dnstap
+		defer func() { c.Dnstap = nil }()
+	}
+	var pcap *PcapWriter
+	if c.Pcap == nil && c.PcapFilePath != "" {
+		var err error
+		if pcap, err = NewPcapWriter(c.PcapFilePath); err != nil {
+			return err
+		}
+		c.Pcap = pcap
+		defer func() { c.Pcap = nil }()
+	}
+
+	var failed *failedNames
+	if c.FailedNamesFilePath != "" {
+		var err error
+		var offset int64
+		if resumeFrom != nil {
+			offset = resumeFrom.FailedOffset
+		}
+		if failed, err = openFailedNames(c.FailedNamesFilePath, c.Resume, offset); err != nil {
+			return err
+		}
+	}
+
 	// Use handlers to populate the input and output/results channel
-	go inHandler.FeedChannel(inChan, &routineWG)
-	go outHandler.WriteResults(outChan, &routineWG)
-	routineWG.Add(2)
+	inErr := make(chan error, 1)
+	outErr := make(chan error, 1)
+	inputWG.Add(1)
+	outputWG.Add(1)
+	go func() { inErr <- feedInput(ctx, inHandler, inChan, &inputWG) }()
+	outChan := newOutputChan(outHandler)
+	go func() { outErr <- outChan.write(outHandler, &outputWG) }()
+	p := newProgress()
+	var updates *statusUpdates
+	if c.StatusUpdatesFilePath != "" {
+		var err error
+		if updates, err = startStatusUpdates(c.StatusUpdatesFilePath, c, p, resumeFrom); err != nil {
+			return err
+		}
+	}
+	go numberInput(ctx, inChan, lineChan, resumeFrom, p)
+	formatter := newResultFormatter(c)
+	// a resumed scan continues an output that already has a header
+	header := formatter.header()
+	if resumeFrom != nil && resumeFrom.OutputOffset > 0 {
+		header = ""
+	}
+	lastWritten := make(chan *lookupResult, 1)
+	go func() {
+		if header != "" {
+			outChan.send(header, "")
+			if tracker != nil {
+				tracker.cp.OutputOffset += int64(len(header) + 1)
+			}
+		}
+		lastWritten <- collectResults(resultChan, outChan, tracker, failed)
+	}()
 
 	// create pool of worker goroutines
 	var lookupWG sync.WaitGroup
 	lookupWG.Add(c.Threads)
-	//startTime := time.Now().Format(c.TimeFormat)
+	start := time.Now()
+	startTime := start.Format(c.TimeFormat)
 	for i := 0; i < c.Threads; i++ {
-		go doLookup(g, c, inChan, outChan, metaChan, &lookupWG, i)
+		go func(i int) {
+			if err := doLookup(ctx, modules, c, formatter, lineChan, resultChan, metaChan, p, &lookupWG, i); err != nil {
+				log.Error("lookup routine ", i, " failed: ", err)
+			}
+		}(i)
 	}
 	lookupWG.Wait()
-	close(outChan)
+	close(resultChan)
+	if dnstap != nil {
+		if err := dnstap.Close(); err != nil {
+			return err
+		}
+	}
+	if pcap != nil {
+		if err := pcap.Close(); err != nil {
+			return err
+		}
+	}
 	close(metaChan)
-	routineWG.Wait()
+	outputWG.Wait()
+	if updates != nil {
+		if err := updates.stop(); err != nil {
+			return err
+		}
+	}
+	if err := <-outErr; err != nil {
+		return fmt.Errorf("unable to write results: %w", err)
+	}
+	if failed != nil {
+		if err := failed.close(); err != nil {
+			return err
+		}
+	}
+	_, stoppable := inHandler.(ContextInputHandler)
+	if ctx.Err() != nil && !stoppable {
+		// after an interrupt, an input handler that can't be stopped is left
+		// to run through the rest of the input
+		go func() {
+			for range inChan {
+			}
+		}()
+	} else {
+		inputWG.Wait()
+		if err := <-inErr; err != nil {
+			return fmt.Errorf("unable to read input: %w", err)
+		}
+	}
+	if tracker != nil {
+		// the output handler is done, so the last result has been written too
+		if last := <-lastWritten; last != nil {
+			tracker.completeResult(last)
+		}
+		if err := tracker.save(); err != nil {
+			return fmt.Errorf("unable to write checkpoint: %w", err)
+		}
+	}
+	if err := modules.finalize(); err != nil {
+		return err
+	}
+	if c.MetadataFilePath != "" {
+		// we're done processing data. aggregate all the data from individual routines
+		metaData := aggregateMetadata(metaChan)
+		metaData.StartTime = startTime
+		metaData.EndTime = time.Now().Format(c.TimeFormat)
+		metaData.NameServers = c.NameServers
+		metaData.Retries = c.Retries
+		// Seconds() returns a float. However, timeout is passed in as an integer
+		// command line argument, so there should be no loss of data when casting
+		// back to an integer here.
+		metaData.Timeout = int(c.Timeout.Seconds())
+		metaData.NameServerStats = c.QueryStats.Summary()
+		var queries int
+		for _, ns := range metaData.NameServerStats {
+			queries += ns.Queries
+		}
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			metaData.QueriesPerSecond = float64(queries) / elapsed
+		}
+		if c.Shards > 1 {
+			metaData.Sharding = &ShardMetadata{Shards: c.Shards, Shard: c.Shard, Seed: c.Seed}
+		}
+		metaData.Conf = c
+		if err := writeMetadata(c.MetadataFilePath, &metaData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMetadata(path string, metaData *Metadata) error {
+	j, err := json.Marshal(metaData)
+	if err != nil {
+		return fmt.Errorf("unable to JSON encode metadata: %w", err)
+	}
+	var f *os.File
+	if path == "-" {
+		f = os.Stderr
+	} else {
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to open metadata file: %w", err)
+		}
+		defer f.Close()
+	}
+	if _, err := f.Write(append(j, '\n')); err != nil {
+		return fmt.Errorf("unable to write metadata file: %w", err)
+	}
 	return nil
 }
 
-func DoLookups2(g GlobalLookupFactory, c *GlobalConf, inChan <-chan string, outChan chan<- any) error {
+func DoLookups2(ctx context.Context, g GlobalLookupFactory, c *GlobalConf, inChan <-chan string, outChan chan<- any) error {
 	defer close(outChan)
 	var lookupWG sync.WaitGroup
 	lookupWG.Add(c.Threads)
 
 	for i := 0; i < c.Threads; i++ {
-		go doLookup2(g, c, inChan, outChan, &lookupWG, i)
+		go doLookup2(ctx, g, c, inChan, outChan, &lookupWG, i)
 	}
 	lookupWG.Wait()
 	return nil
@@ -0,0 +1,276 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/zmap/dns"
+	"github.com/zmap/zdns/iohandlers"
+	"gotest.tools/v3/assert"
+)
+
+// testLookupFactory is a lookup module answering every name with what it was
+// asked. The answer to "nan" can't be written as JSON, and "slow" takes ten
+// seconds unless cancelled.
+type testLookupFactory struct {
+	BaseGlobalLookupFactory
+	module string
+}
+
+func (f *testLookupFactory) MakeRoutineFactory(threadID int) (RoutineLookupFactory, error) {
+	return f, nil
+}
+
+func (f *testLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(testLookupResult{})
+}
+
+func (f *testLookupFactory) MakeLookup() (Lookup, error) {
+	return &testLookup{module: f.module, class: f.GlobalConf.Class}, nil
+}
+
+type testLookup struct {
+	module string
+	class  uint16
+}
+
+type testLookupResult struct {
+	Module     string `json:"module" groups:"short,normal,long,trace"`
+	NameServer string `json:"name_server" groups:"short,normal,long,trace"`
+	Class      string `json:"class" groups:"short,normal,long,trace"`
+}
+
+func (l *testLookup) SetDNSClass(class uint16) {
+	l.class = class
+}
+
+func (l *testLookup) DoLookup(name, nameServer string) (interface{}, Trace, Status, error) {
+	return l.DoLookupContext(context.Background(), name, nameServer)
+}
+
+func (l *testLookup) DoLookupContext(ctx context.Context, name, nameServer string) (interface{}, Trace, Status, error) {
+	if name == "nan" {
+		return math.NaN(), nil, STATUS_NOERROR, nil
+	}
+	if name == "slow" {
+		select {
+		case <-ctx.Done():
+			return nil, nil, STATUS_CANCELLED, ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+	return testLookupResult{Module: l.module, NameServer: nameServer, Class: dns.Class(l.class).String()}, nil, STATUS_NOERROR, nil
+}
+
+// runTestLookups runs a scan of input with the TESTA module, and TESTB for
+// lines asking for it, and returns its output lines
+func runTestLookups(t *testing.T, gc GlobalConf, input string) []string {
+	return runTestLookupsContext(context.Background(), t, gc, strings.NewReader(input))
+}
+
+func runTestLookupsContext(ctx context.Context, t *testing.T, gc GlobalConf, input io.Reader) []string {
+	primary := &testLookupFactory{module: "TESTA"}
+	RegisterLookup("TESTB", &testLookupFactory{module: "TESTB"})
+	t.Cleanup(func() { delete(lookups, "TESTB") })
+	out := &sliceOutputHandler{}
+	if gc.OutputHandler == nil {
+		gc.OutputHandler = out
+	}
+	gc.Module = "TESTA"
+	gc.Threads = 2
+	gc.Class = dns.ClassINET
+	gc.TimeFormat = time.RFC3339
+	gc.InputHandler = iohandlers.NewStreamInputHandler(input)
+	if gc.OutputGroups == nil {
+		groups, err := OutputGroups("normal", "")
+		assert.NilError(t, err)
+		gc.OutputGroups = groups
+	}
+	assert.NilError(t, primary.Initialize(&gc))
+	assert.NilError(t, doLookups(ctx, &gc, newLookupModules(primary, &gc, pflag.NewFlagSet("test", pflag.ContinueOnError)), nil))
+	return out.results
+}
+
+// Test that a result that can't be formatted is written as an error rather
+// than stopping its routine
+func TestLookupsFormatError(t *testing.T) {
+	results := runTestLookups(t, GlobalConf{}, "nan\nexample.com\n")
+	assert.Equal(t, len(results), 2)
+	joined := strings.Join(results, "\n")
+	assert.Assert(t, strings.Contains(joined, `"status":"ERROR"`), joined)
+	assert.Assert(t, strings.Contains(joined, `"error":"unable to format result: `), joined)
+	assert.Assert(t, strings.Contains(joined, `"module":"TESTA"`), joined)
+}
+
+func TestParseJSONInputLine(t *testing.T) {
+	in, err := parseJSONInputLine(`{"name":"example.com","module":"MX","nameserver":"8.8.8.8","class":"CH","metadata":{"id":7, "tags":["a"]}}`, false)
+	assert.NilError(t, err)
+	assert.Equal(t, in.Name, "example.com")
+	assert.Equal(t, in.Module, "MX")
+	assert.Equal(t, in.NameServer, "8.8.8.8:53")
+	assert.Equal(t, in.Class, "CH")
+	assert.Equal(t, string(in.Metadata), `{"id":7, "tags":["a"]}`)
+
+	in, err = parseJSONInputLine(`{"name":"example.com"}`, false)
+	assert.NilError(t, err)
+	assert.Equal(t, in.NameServer, "")
+	assert.Assert(t, in.Metadata == nil)
+
+	_, err = parseJSONInputLine("example.com,8.8.8.8", false)
+	assert.ErrorContains(t, err, "invalid JSON input line")
+}
+
+// Test that jsonl input lines keep their metadata as given and are looked up
+// with the module they ask for, which their results record
+func TestLookupsJSONInput(t *testing.T) {
+	input := `{"name":"a.example","metadata":{"z":1.50,"a":[1,"x"]}}
+{"name":"b.example","module":"testb"}
+{"name":"c.example","module":"TESTA"}
+`
+	results := runTestLookups(t, GlobalConf{InputFormat: INPUT_FORMAT_JSONL}, input)
+	assert.Equal(t, len(results), 3)
+	byName := make(map[string]string)
+	for _, r := range results {
+		var res struct {
+			Name string `json:"name"`
+		}
+		assert.NilError(t, json.Unmarshal([]byte(r), &res))
+		byName[res.Name] = r
+	}
+
+	a := byName["a.example"]
+	assert.Assert(t, strings.Contains(a, `"metadata":{"z":1.50,"a":[1,"x"]}`), a)
+	assert.Assert(t, strings.Count(a, `"module"`) == 1, a)
+	assert.Assert(t, strings.Contains(a, `"module":"TESTA","name_server"`), a)
+	b := byName["b.example"]
+	assert.Assert(t, strings.Contains(b, `"module":"TESTB","name":"b.example"`), b)
+	assert.Assert(t, strings.Contains(b, `"module":"TESTB","name_server"`), b)
+	assert.Assert(t, !strings.Contains(b, `"metadata"`), b)
+	// the module of the run isn't repeated
+	c := byName["c.example"]
+	assert.Assert(t, strings.Count(c, `"module"`) == 1, c)
+	assert.Assert(t, strings.Contains(c, `"module":"TESTA","name_server"`), c)
+}
+
+func TestLookupResultType(t *testing.T) {
+	RegisterLookup("TESTTYPED", &testLookupFactory{module: "TESTTYPED"})
+	// only the methods of GlobalLookupFactory are passed on
+	RegisterLookup("TESTUNTYPED", struct{ GlobalLookupFactory }{&testLookupFactory{module: "TESTUNTYPED"}})
+	t.Cleanup(func() {
+		delete(lookups, "TESTTYPED")
+		delete(lookups, "TESTUNTYPED")
+	})
+	rt, err := LookupResultType("TESTTYPED")
+	assert.NilError(t, err)
+	assert.Equal(t, rt, reflect.TypeOf(testLookupResult{}))
+	_, err = LookupResultType("TESTUNTYPED")
+	assert.ErrorContains(t, err, `lookup module "TESTUNTYPED" doesn't declare its result type`)
+	_, err = LookupResultType("TESTMISSING")
+	assert.ErrorContains(t, err, `invalid lookup module "TESTMISSING"`)
+}
+
+// Test that results are split by their status without being parsed, so that
+// it works for CSV output too
+func TestLookupsOutputDirStatus(t *testing.T) {
+	gc := GlobalConf{OutputDir: filepath.Join(t.TempDir(), "out"), OutputFormat: OUTPUT_FORMAT_CSV}
+	h, err := fileOutputHandlerFactory{}.MakeOutputHandler(&gc, nil)
+	assert.NilError(t, err)
+	gc.OutputHandler = h
+	runTestLookups(t, gc, "nan\nexample.com\n")
+
+	entries, err := os.ReadDir(gc.OutputDir)
+	assert.NilError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.DeepEqual(t, names, []string{"ERROR.csv", "NOERROR.csv"})
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(gc.OutputDir, name))
+		assert.NilError(t, err)
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		assert.Equal(t, len(lines), 2, string(data))
+		assert.Assert(t, strings.HasPrefix(lines[0], "name,"), lines[0])
+		assert.Assert(t, strings.Contains(lines[1], ","+strings.TrimSuffix(name, ".csv")+","), lines[1])
+	}
+}
+
+// endlessInput is input that never ends. It calls cancel once it has been
+// read for a while.
+type endlessInput struct {
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	read   int
+}
+
+func (r *endlessInput) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	line := "example.com\n"
+	n := 0
+	for n+len(line) <= len(p) {
+		n += copy(p[n:], line)
+	}
+	r.read += n
+	if r.read > 1<<16 {
+		r.cancel()
+	}
+	return n, nil
+}
+
+func (r *endlessInput) bytesRead() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read
+}
+
+// Test that an interrupted scan stops reading its input before returning
+func TestLookupsInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	input := &endlessInput{cancel: cancel}
+	results := runTestLookupsContext(ctx, t, GlobalConf{}, input)
+	assert.Assert(t, len(results) > 0)
+	read := input.bytesRead()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, input.bytesRead(), read)
+}
+
+// Test that the lookups in flight when a scan is interrupted are cancelled
+// rather than waited for
+func TestLookupsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	results := runTestLookupsContext(ctx, t, GlobalConf{}, strings.NewReader("slow\nslow\n"))
+	assert.Assert(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, len(results), 2)
+	for _, res := range results {
+		assert.Assert(t, strings.Contains(res, `"status":"CANCELLED"`), res)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import "time"
+
+// Metrics receives measurements as lookups happen, e.g. to export them to a
+// monitoring system. Implementations must be safe for concurrent use and
+// should return quickly, since they're called on the lookup path.
+type Metrics interface {
+	// QueryDone is called for every query put on the wire, including
+	// retries (try > 0). rcode is empty if no response was received. rtt
+	// doesn't include the time the query was held back by the rate limit.
+	QueryDone(protocol, nameServer string, try int, rcode string, status Status, rtt time.Duration)
+	// LookupDone is called once per name and module, when the lookup has
+	// finished
+	LookupDone(module string, status Status, duration time.Duration)
+	// CacheLookup is called whenever the iterative cache is consulted
+	CacheLookup(hit bool)
+	// CacheEviction is called when an entry is evicted from the iterative
+	// cache to make room for another one
+	CacheEviction()
+	// UDPPoolConns reports the number of idle and open sockets in a UDP
+	// socket pool whenever it changes
+	UDPPoolConns(idle, open int)
+}
+
+// NoopMetrics discards all measurements
+type NoopMetrics struct{}
+
+func (NoopMetrics) QueryDone(protocol, nameServer string, try int, rcode string, status Status, rtt time.Duration) {
+}
+
+func (NoopMetrics) LookupDone(module string, status Status, duration time.Duration) {}
+
+func (NoopMetrics) CacheLookup(hit bool) {}
+
+func (NoopMetrics) CacheEviction() {}
+
+func (NoopMetrics) UDPPoolConns(idle, open int) {}
+
+// GetMetrics returns c.Metrics, or NoopMetrics if it isn't set
+func (c *GlobalConf) GetMetrics() Metrics {
+	if c == nil || c.Metrics == nil {
+		return NoopMetrics{}
+	}
+	return c.Metrics
+}
@@ -0,0 +1,290 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	pcapMagic   = 0xa1b2c3d4
+	pcapSnapLen = 262144
+	// pcapLinkTypeRaw is LINKTYPE_RAW: packets start with an IPv4 or IPv6
+	// header
+	pcapLinkTypeRaw = 101
+	// pcapMSS is the most data put in a synthesized TCP segment
+	pcapMSS = 1460
+
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+)
+
+// PcapWriter writes wire exchanges to a libpcap file as the IP packets they
+// would have been on the wire. TCP exchanges get a handshake and teardown
+// around the messages, as zdns uses a connection per TCP query. A nil
+// *PcapWriter discards everything. It is safe for concurrent use.
+type PcapWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+	// ipID numbers the IPv4 packets written
+	ipID uint16
+	// err is the first error writing to the file, reported by Close
+	err error
+}
+
+// NewPcapWriter creates the file at path and writes the pcap header
+func NewPcapWriter(path string) (*PcapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open pcap file: %w", err)
+	}
+	w := &PcapWriter{f: f, w: bufio.NewWriter(f)}
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeRaw)
+	w.w.Write(hdr)
+	return w, nil
+}
+
+// endpoint is one side of an exchange
+type endpoint struct {
+	ip   net.IP
+	port uint16
+}
+
+// endpoints returns the two sides of e. A local address that is unknown or
+// of another family than the name server's is written as the unspecified
+// address.
+func endpoints(e *WireExchange) (local, remote endpoint) {
+	rip, rport := addrPort(e.RemoteAddr)
+	if rip.To4() != nil {
+		rip = rip.To4()
+	} else if rip == nil {
+		rip = net.IPv4zero.To4()
+	}
+	lip, lport := addrPort(e.LocalAddr)
+	if lip.To4() != nil {
+		lip = lip.To4()
+	}
+	if lip == nil || lip.IsUnspecified() || len(lip) != len(rip) {
+		lip = net.IPv4zero.To4()
+		if len(rip) == net.IPv6len {
+			lip = net.IPv6zero
+		}
+	}
+	return endpoint{lip, uint16(lport)}, endpoint{rip, uint16(rport)}
+}
+
+// Write adds the packets of e to the file
+func (w *PcapWriter) Write(e *WireExchange) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	local, remote := endpoints(e)
+	if e.Protocol != "udp" {
+		// DNS-over-TLS is written as the TCP exchange it would be
+		// without encryption
+		w.writeTCPExchange(e, local, remote)
+	} else {
+		w.writeUDP(e.QueryTime, local, remote, e.Query)
+		if e.Response != nil {
+			w.writeUDP(e.ResponseTime, remote, local, e.Response)
+		}
+	}
+}
+
+func (w *PcapWriter) writeUDP(t time.Time, src, dst endpoint, payload []byte) {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], src.port)
+	binary.BigEndian.PutUint16(udp[2:], dst.port)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[8:], payload)
+	sum := transportChecksum(src.ip, dst.ip, ipProtoUDP, udp)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	w.writePacket(t, src.ip, dst.ip, ipProtoUDP, udp)
+}
+
+// tcpConn tracks the sequence numbers of a synthesized TCP connection
+type tcpConn struct {
+	w              *PcapWriter
+	client, server endpoint
+	// seq is the next sequence number of the client and server
+	clientSeq, serverSeq uint32
+}
+
+func (c *tcpConn) send(t time.Time, fromClient bool, flags byte, data []byte) {
+	src, dst := c.client, c.server
+	seq, ack := &c.clientSeq, c.serverSeq
+	if !fromClient {
+		src, dst = c.server, c.client
+		seq, ack = &c.serverSeq, c.clientSeq
+	}
+	tcp := make([]byte, 20+len(data))
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], *seq)
+	if flags&tcpFlagACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], ack)
+	}
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], data)
+	binary.BigEndian.PutUint16(tcp[16:], transportChecksum(src.ip, dst.ip, ipProtoTCP, tcp))
+	c.w.writePacket(t, src.ip, dst.ip, ipProtoTCP, tcp)
+
+	*seq += uint32(len(data))
+	if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+		*seq++
+	}
+}
+
+// sendMessage sends a DNS message, prefixed with its length, in segments of
+// at most pcapMSS bytes
+func (c *tcpConn) sendMessage(t time.Time, fromClient bool, msg []byte) {
+	data := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(data, uint16(len(msg)))
+	copy(data[2:], msg)
+	for len(data) > 0 {
+		n := len(data)
+		if n > pcapMSS {
+			n = pcapMSS
+		}
+		c.send(t, fromClient, tcpFlagPSH|tcpFlagACK, data[:n])
+		data = data[n:]
+	}
+}
+
+func (w *PcapWriter) writeTCPExchange(e *WireExchange, local, remote endpoint) {
+	c := &tcpConn{w: w, client: local, server: remote, clientSeq: rand.Uint32(), serverSeq: rand.Uint32()}
+	c.send(e.QueryTime, true, tcpFlagSYN, nil)
+	c.send(e.QueryTime, false, tcpFlagSYN|tcpFlagACK, nil)
+	c.send(e.QueryTime, true, tcpFlagACK, nil)
+	c.sendMessage(e.QueryTime, true, e.Query)
+	if e.Response == nil {
+		return
+	}
+	c.sendMessage(e.ResponseTime, false, e.Response)
+	c.send(e.ResponseTime, true, tcpFlagFIN|tcpFlagACK, nil)
+	c.send(e.ResponseTime, false, tcpFlagFIN|tcpFlagACK, nil)
+	c.send(e.ResponseTime, true, tcpFlagACK, nil)
+}
+
+// writePacket writes an IP packet carrying payload as a pcap record
+func (w *PcapWriter) writePacket(t time.Time, src, dst net.IP, proto byte, payload []byte) {
+	var ip []byte
+	if len(src) == net.IPv4len {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(payload)))
+		binary.BigEndian.PutUint16(ip[4:], w.ipID)
+		w.ipID++
+		// don't fragment
+		ip[6] = 0x40
+		ip[8] = 64
+		ip[9] = proto
+		copy(ip[12:], src)
+		copy(ip[16:], dst)
+		binary.BigEndian.PutUint16(ip[10:], checksum(0, ip))
+	} else {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(payload)))
+		ip[6] = proto
+		ip[7] = 64
+		copy(ip[8:], src)
+		copy(ip[24:], dst)
+	}
+	rec := make([]byte, 16)
+	n := uint32(len(ip) + len(payload))
+	binary.LittleEndian.PutUint32(rec[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], n)
+	binary.LittleEndian.PutUint32(rec[12:], n)
+	w.w.Write(rec)
+	w.w.Write(ip)
+	if _, err := w.w.Write(payload); err != nil {
+		w.err = err
+	}
+}
+
+// checksum adds data to the ones' complement sum and returns its complement
+func checksum(sum uint32, data []byte) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// transportChecksum is the UDP or TCP checksum of segment, which covers a
+// pseudo header of the IP addresses as well
+func transportChecksum(src, dst net.IP, proto byte, segment []byte) uint16 {
+	var sum uint32
+	for _, ip := range []net.IP{src, dst} {
+		for i := 0; i < len(ip); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(ip[i:]))
+		}
+	}
+	sum += uint32(proto) + uint32(len(segment))
+	return checksum(sum, segment)
+}
+
+// Close flushes the file and closes it
+func (w *PcapWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if err != nil {
+		w.f.Close()
+		return fmt.Errorf("unable to write pcap file: %w", err)
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,140 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+type pcapPacket struct {
+	time time.Time
+	data []byte
+}
+
+func readPcap(t *testing.T, path string) []pcapPacket {
+	data, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, len(data) >= 24)
+	assert.Equal(t, binary.LittleEndian.Uint32(data[0:]), uint32(pcapMagic))
+	assert.Equal(t, binary.LittleEndian.Uint32(data[20:]), uint32(pcapLinkTypeRaw))
+	var packets []pcapPacket
+	for data = data[24:]; len(data) > 0; {
+		sec := binary.LittleEndian.Uint32(data[0:])
+		usec := binary.LittleEndian.Uint32(data[4:])
+		n := binary.LittleEndian.Uint32(data[8:])
+		assert.Equal(t, binary.LittleEndian.Uint32(data[12:]), n)
+		packets = append(packets, pcapPacket{time.Unix(int64(sec), int64(usec)*1000), data[16 : 16+n]})
+		data = data[16+n:]
+	}
+	return packets
+}
+
+func TestPcapWriterUDP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zdns.pcap")
+	w, err := NewPcapWriter(path)
+	assert.NilError(t, err)
+	queryTime := time.Unix(1700000000, 123456000)
+	w.Write(&WireExchange{
+		Protocol:     "udp",
+		LocalAddr:    &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 40000},
+		RemoteAddr:   &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53},
+		QueryTime:    queryTime,
+		Query:        []byte("query"),
+		ResponseTime: queryTime.Add(time.Millisecond),
+		Response:     []byte("a response"),
+	})
+	assert.NilError(t, w.Close())
+
+	packets := readPcap(t, path)
+	assert.Equal(t, len(packets), 2)
+	assert.Assert(t, packets[0].time.Equal(queryTime))
+	assert.Assert(t, packets[1].time.Equal(queryTime.Add(time.Millisecond)))
+
+	query := packets[0].data
+	assert.Equal(t, query[0], byte(0x45))
+	assert.Equal(t, query[9], byte(ipProtoUDP))
+	assert.Equal(t, checksum(0, query[:20]), uint16(0))
+	assert.DeepEqual(t, net.IP(query[12:16]), net.ParseIP("192.0.2.2").To4())
+	assert.DeepEqual(t, net.IP(query[16:20]), net.ParseIP("192.0.2.53").To4())
+	udp := query[20:]
+	assert.Equal(t, binary.BigEndian.Uint16(udp[0:]), uint16(40000))
+	assert.Equal(t, binary.BigEndian.Uint16(udp[2:]), uint16(53))
+	assert.Equal(t, transportChecksum(query[12:16], query[16:20], ipProtoUDP, udp), uint16(0))
+	assert.Equal(t, string(udp[8:]), "query")
+
+	response := packets[1].data
+	assert.DeepEqual(t, net.IP(response[12:16]), net.ParseIP("192.0.2.53").To4())
+	assert.Equal(t, string(response[28:]), "a response")
+}
+
+func TestPcapWriterTCP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zdns.pcap")
+	w, err := NewPcapWriter(path)
+	assert.NilError(t, err)
+	w.Write(&WireExchange{
+		Protocol:     "tcp",
+		LocalAddr:    &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 40000},
+		RemoteAddr:   &net.TCPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53},
+		QueryTime:    time.Now(),
+		Query:        []byte("query"),
+		ResponseTime: time.Now(),
+		Response:     make([]byte, 3000),
+	})
+	assert.NilError(t, w.Close())
+
+	packets := readPcap(t, path)
+	// handshake, query, response in 3 segments and teardown
+	assert.Equal(t, len(packets), 10)
+	var clientSeq, serverSeq uint32
+	for i, p := range packets {
+		assert.Equal(t, p.data[0]>>4, byte(6))
+		assert.Equal(t, p.data[6], byte(ipProtoTCP))
+		tcp := p.data[40:]
+		assert.Equal(t, transportChecksum(p.data[8:24], p.data[24:40], ipProtoTCP, tcp), uint16(0))
+		seq := binary.BigEndian.Uint32(tcp[4:])
+		fromClient := binary.BigEndian.Uint16(tcp[0:]) == 40000
+		switch {
+		case i == 0:
+			assert.Equal(t, tcp[13], byte(tcpFlagSYN))
+			clientSeq = seq + 1
+		case i == 1:
+			assert.Equal(t, tcp[13], byte(tcpFlagSYN|tcpFlagACK))
+			assert.Equal(t, binary.BigEndian.Uint32(tcp[8:]), clientSeq)
+			serverSeq = seq + 1
+		case fromClient:
+			assert.Equal(t, seq, clientSeq)
+			clientSeq += uint32(len(tcp) - 20)
+			if tcp[13]&tcpFlagFIN != 0 {
+				clientSeq++
+			}
+		default:
+			assert.Equal(t, seq, serverSeq)
+			serverSeq += uint32(len(tcp) - 20)
+			if tcp[13]&tcpFlagFIN != 0 {
+				serverSeq++
+			}
+		}
+	}
+	query := packets[3].data[60:]
+	assert.Equal(t, binary.BigEndian.Uint16(query), uint16(5))
+	assert.Equal(t, string(query[2:]), "query")
+}
@@ -0,0 +1,139 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket hands out one token per 1/rate seconds. Callers reserve a
+// token up front and are told how long to wait for it, so that concurrent
+// callers are spread evenly instead of waking up all at once.
+type tokenBucket struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is when the next token becomes available
+	next time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// reserve takes the next token and returns when it may be used
+func (b *tokenBucket) reserve(now time.Time) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// an idle bucket doesn't save up tokens for a later burst
+	if b.next.Before(now) {
+		b.next = now
+	}
+	at := b.next
+	b.next = b.next.Add(b.interval)
+	return at
+}
+
+// cancel returns a token reserved for at that won't be used after all
+func (b *tokenBucket) cancel(at time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// only the most recent reservation can be handed back without
+	// disturbing the ones made after it
+	if b.next.Sub(at) == b.interval {
+		b.next = at
+	}
+}
+
+// RateLimiter limits the queries put on the wire, both in total and per name
+// server. A nil *RateLimiter doesn't limit anything. It is safe for
+// concurrent use.
+type RateLimiter struct {
+	global            *tokenBucket
+	perNameServerRate float64
+
+	mu          sync.Mutex
+	nameServers map[string]*tokenBucket
+}
+
+// NewRateLimiter returns a limiter allowing rate queries per second in total
+// and perNameServerRate queries per second to any single name server. A rate
+// of 0 means unlimited. If both are 0, it returns nil.
+func NewRateLimiter(rate, perNameServerRate float64) *RateLimiter {
+	if rate <= 0 && perNameServerRate <= 0 {
+		return nil
+	}
+	l := &RateLimiter{
+		perNameServerRate: perNameServerRate,
+		nameServers:       make(map[string]*tokenBucket),
+	}
+	if rate > 0 {
+		l.global = newTokenBucket(rate)
+	}
+	return l
+}
+
+func (l *RateLimiter) nameServerBucket(nameServer string) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.nameServers[nameServer]
+	if !ok {
+		b = newTokenBucket(l.perNameServerRate)
+		l.nameServers[nameServer] = b
+	}
+	return b
+}
+
+// Wait blocks until a query may be sent to nameServer, or until ctx is done,
+// in which case it returns ctx.Err().
+func (l *RateLimiter) Wait(ctx context.Context, nameServer string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	now := time.Now()
+	at := now
+	var globalAt, nsAt time.Time
+	var ns *tokenBucket
+	if l.global != nil {
+		globalAt = l.global.reserve(now)
+		at = globalAt
+	}
+	if l.perNameServerRate > 0 {
+		ns = l.nameServerBucket(nameServer)
+		nsAt = ns.reserve(now)
+		if nsAt.After(at) {
+			at = nsAt
+		}
+	}
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		if l.global != nil {
+			l.global.cancel(globalAt)
+		}
+		if ns != nil {
+			ns.cancel(nsAt)
+		}
+		return ctx.Err()
+	}
+}
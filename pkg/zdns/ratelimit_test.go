@@ -0,0 +1,63 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestTokenBucketSpacing(t *testing.T) {
+	b := newTokenBucket(10)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, b.reserve(now), now.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	// an idle bucket starts over instead of allowing a burst
+	later := now.Add(time.Minute)
+	assert.Equal(t, b.reserve(later), later)
+	assert.Equal(t, b.reserve(later), later.Add(100*time.Millisecond))
+}
+
+func TestRateLimiterPerNameServer(t *testing.T) {
+	l := NewRateLimiter(0, 20)
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, l.Wait(ctx, "192.0.2.1:53"))
+		assert.NilError(t, l.Wait(ctx, "192.0.2.2:53"))
+	}
+	// two name servers, three queries each at 20/s: 2 intervals of 50ms
+	elapsed := time.Since(start)
+	assert.Assert(t, elapsed >= 100*time.Millisecond, elapsed)
+	assert.Assert(t, elapsed < 500*time.Millisecond, elapsed)
+}
+
+func TestRateLimiterCancelled(t *testing.T) {
+	l := NewRateLimiter(1, 0)
+	assert.NilError(t, l.Wait(context.Background(), ""))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, l.Wait(ctx, ""), context.DeadlineExceeded)
+}
+
+func TestNilRateLimiter(t *testing.T) {
+	var l *RateLimiter = NewRateLimiter(0, 0)
+	assert.Assert(t, l == nil)
+	assert.NilError(t, l.Wait(context.Background(), "192.0.2.1:53"))
+}
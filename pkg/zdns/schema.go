@@ -0,0 +1,311 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// InterfaceTyper is implemented by result types that keep values of several
+// types in interface{} fields, such as the records of a response, to name
+// those types in the JSON Schema of their output
+type InterfaceTyper interface {
+	// InterfaceTypes returns the types of the values of the field named
+	// field, or of its elements if it's a slice
+	InterfaceTypes(field string) []reflect.Type
+}
+
+// SchemaOptions describes the output a JSON Schema is generated for
+type SchemaOptions struct {
+	// Groups are the output groups, see GlobalConf.OutputGroups
+	Groups []string
+	// Module is the module the results are of
+	Module string
+	// Modules, when set, are the modules whose results are combined per
+	// name, see GlobalConf.Modules
+	Modules []string
+	// ResultType returns the type of the data of a module's results
+	ResultType func(module string) reflect.Type
+	// TraceStepType is the type of the steps of a trace
+	TraceStepType reflect.Type
+}
+
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	interfaceTyper    = reflect.TypeOf((*InterfaceTyper)(nil)).Elem()
+	resultType        = reflect.TypeOf(Result{})
+	moduleResultType  = reflect.TypeOf(ModuleResult{})
+)
+
+// schemaWriter builds the schemas of Go types as they're written by the
+// JSON output format, i.e. by sheriff with the output groups. Structs are
+// kept in defs and referred to by the name of their type.
+type schemaWriter struct {
+	groups []string
+	defs   map[string]interface{}
+	// data and trace override the types of the data and trace fields of
+	// results
+	data  reflect.Type
+	trace reflect.Type
+	// modules, when set, is the schema of the data of results of several
+	// modules
+	modules map[string]interface{}
+}
+
+// OutputSchema returns the JSON Schema of the results written for opts by
+// the JSON output format
+func OutputSchema(opts SchemaOptions) map[string]interface{} {
+	w := &schemaWriter{groups: opts.Groups, defs: make(map[string]interface{}), trace: opts.TraceStepType}
+	title := "zdns " + opts.Module
+	if len(opts.Modules) == 0 {
+		w.data = opts.ResultType(opts.Module)
+	} else {
+		title = "zdns " + strings.Join(opts.Modules, ",")
+		// each module has its own result, which isn't written if the
+		// module didn't run
+		props := make(map[string]interface{}, len(opts.Modules))
+		for _, module := range opts.Modules {
+			w.data = opts.ResultType(module)
+			props[module] = w.structSchema(moduleResultType, true)
+		}
+		w.data = nil
+		w.modules = map[string]interface{}{"type": "object", "properties": props, "additionalProperties": false}
+	}
+	result := w.structSchema(resultType, true)
+	result["$schema"] = schemaDialect
+	var groups []string
+	for _, g := range opts.Groups {
+		if g != "" {
+			groups = append(groups, g)
+		}
+	}
+	result["title"] = title + " output (" + strings.Join(groups, ",") + ")"
+	if len(w.defs) > 0 {
+		result["$defs"] = w.defs
+	}
+	return result
+}
+
+// schemaRef returns a reference to the definition of the struct t
+func (w *schemaWriter) schemaRef(t reflect.Type, checkGroups bool) map[string]interface{} {
+	name := t.String()
+	if !checkGroups {
+		name += ".json"
+	}
+	if _, ok := w.defs[name]; !ok {
+		// placeholder for recursive types
+		w.defs[name] = true
+		w.defs[name] = w.structSchema(t, checkGroups)
+	}
+	return map[string]interface{}{"$ref": "#/$defs/" + name}
+}
+
+// schema returns the schema of values of type t. checkGroups is unset for
+// values sheriff leaves to encoding/json, which ignores groups.
+func (w *schemaWriter) schema(t reflect.Type, checkGroups bool) map[string]interface{} {
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		// anything goes
+		return map[string]interface{}{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+	if t.Implements(stringerType) {
+		checkGroups = false
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Ptr:
+		return w.schema(t.Elem(), checkGroups)
+	case reflect.Slice, reflect.Array:
+		if !checkGroups && t.Elem().Kind() == reflect.Uint8 {
+			// base64
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": w.elemSchema(t.Elem(), checkGroups)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": w.elemSchema(t.Elem(), checkGroups)}
+	case reflect.Struct:
+		return w.schemaRef(t, checkGroups)
+	}
+	// interface{} values without known types
+	return map[string]interface{}{}
+}
+
+// elemSchema returns the schema of the elements of slices and maps of type
+// t, which are null if they're nil
+func (w *schemaWriter) elemSchema(t reflect.Type, checkGroups bool) map[string]interface{} {
+	s := w.schema(t, checkGroups)
+	if canBeNil(t) {
+		return nullable(s)
+	}
+	return s
+}
+
+// canBeNil tells if values of type t are written as null when nil. sheriff
+// writes empty maps as null too, and empty slices as [].
+func canBeNil(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// nullable allows s to be null as well
+func nullable(s map[string]interface{}) map[string]interface{} {
+	if len(s) == 0 {
+		return s
+	}
+	if typ, ok := s["type"].(string); ok {
+		s["type"] = []string{typ, "null"}
+		return s
+	}
+	return map[string]interface{}{"anyOf": []interface{}{s, map[string]interface{}{"type": "null"}}}
+}
+
+// unionSchema returns the schema of values of any of types
+func (w *schemaWriter) unionSchema(types []reflect.Type, checkGroups bool) map[string]interface{} {
+	if len(types) == 1 {
+		return w.schema(types[0], checkGroups)
+	}
+	schemas := make([]interface{}, len(types))
+	for i, t := range types {
+		schemas[i] = w.schema(t, checkGroups)
+	}
+	return map[string]interface{}{"anyOf": schemas}
+}
+
+// fieldSchema returns the schema of the value of field f of the struct
+// owner. Fields that are omitted when empty are never null.
+func (w *schemaWriter) fieldSchema(owner reflect.Type, f reflect.StructField, omitempty, checkGroups bool) map[string]interface{} {
+	s := w.fieldValueSchema(owner, f, checkGroups)
+	if !omitempty && canBeNil(f.Type) {
+		return nullable(s)
+	}
+	return s
+}
+
+func (w *schemaWriter) fieldValueSchema(owner reflect.Type, f reflect.StructField, checkGroups bool) map[string]interface{} {
+	t := f.Type
+	var types []reflect.Type
+	switch {
+	case owner == resultType && f.Name == "Data" && w.modules != nil:
+		return w.modules
+	case owner == resultType || owner == moduleResultType:
+		if f.Name == "Data" && w.data != nil {
+			types = []reflect.Type{w.data}
+		} else if f.Name == "Trace" && w.trace != nil {
+			types = []reflect.Type{w.trace}
+		}
+	case owner.Implements(interfaceTyper):
+		types = reflect.Zero(owner).Interface().(InterfaceTyper).InterfaceTypes(f.Name)
+	}
+	if len(types) == 0 {
+		return w.schema(t, checkGroups)
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return w.unionSchema(types, checkGroups)
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": w.unionSchema(types, checkGroups)}
+	}
+	return w.schema(t, checkGroups)
+}
+
+// structSchema returns the schema of the object written for the struct t,
+// which has the fields whose groups are among the output groups, including
+// those of embedded structs
+func (w *schemaWriter) structSchema(t reflect.Type, checkGroups bool) map[string]interface{} {
+	props := make(map[string]interface{})
+	required := make(map[string]bool)
+	w.addFields(props, required, t, nil, checkGroups)
+	var req []string
+	for name := range required {
+		req = append(req, name)
+	}
+	sort.Strings(req)
+	s := map[string]interface{}{"type": "object", "properties": props, "additionalProperties": false}
+	if len(req) > 0 {
+		s["required"] = req
+	}
+	return s
+}
+
+func (w *schemaWriter) addFields(props map[string]interface{}, required map[string]bool, t reflect.Type, parentGroups []string, checkGroups bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitempty := strings.Contains(","+opts+",", ",omitempty,")
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			// the fields of embedded structs are merged, and take the groups
+			// of the embedding field if they have none
+			w.addFields(props, required, f.Type, strings.Split(f.Tag.Get("groups"), ","), checkGroups)
+			continue
+		}
+		if checkGroups {
+			var groups []string
+			if g := f.Tag.Get("groups"); g != "" {
+				groups = strings.Split(g, ",")
+			} else {
+				groups = parentGroups
+			}
+			shown := false
+			for _, g := range groups {
+				if inGroups(g, w.groups) {
+					shown = true
+					break
+				}
+			}
+			if !shown {
+				continue
+			}
+		}
+		// fields are overwritten by later ones of the same name
+		props[name] = w.fieldSchema(t, f, omitempty, checkGroups)
+		if omitempty {
+			delete(required, name)
+		} else {
+			required[name] = true
+		}
+	}
+}
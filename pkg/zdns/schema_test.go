@@ -0,0 +1,140 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+type SchemaTestHeader struct {
+	Name    string `json:"name,omitempty" groups:"short,normal"`
+	TTL     uint32 `json:"ttl" groups:"ttl,normal"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type schemaTestRecord struct {
+	SchemaTestHeader
+	Address string `json:"address" groups:"short,normal"`
+}
+
+type schemaTestResult struct {
+	Records  []interface{}       `json:"records" groups:"short,normal"`
+	Next     *schemaTestResult   `json:"next,omitempty" groups:"normal"`
+	Labels   map[string]string   `json:"labels" groups:"normal"`
+	Resolver string              `json:"resolver" groups:"resolver"`
+	Hidden   string              `json:"hidden"`
+	Extra    map[string][]string `json:"extra" groups:"long"`
+}
+
+func (r schemaTestResult) InterfaceTypes(field string) []reflect.Type {
+	if field == "Records" {
+		return []reflect.Type{reflect.TypeOf(schemaTestRecord{}), reflect.TypeOf(SchemaTestHeader{})}
+	}
+	return nil
+}
+
+func schemaTestOptions(groups ...string) SchemaOptions {
+	return SchemaOptions{
+		Groups:     groups,
+		Module:     "TEST",
+		ResultType: func(string) reflect.Type { return reflect.TypeOf(schemaTestResult{}) },
+	}
+}
+
+func TestOutputSchema(t *testing.T) {
+	s := OutputSchema(schemaTestOptions("normal"))
+	assert.Equal(t, s["$schema"], schemaDialect)
+	assert.Equal(t, s["title"], "zdns TEST output (normal)")
+	// every field of a result is omitted when empty
+	_, ok := s["required"]
+	assert.Assert(t, !ok)
+	props := s["properties"].(map[string]interface{})
+	assert.DeepEqual(t, props["data"], map[string]interface{}{"$ref": "#/$defs/zdns.schemaTestResult"})
+	// fields of the class group are left out
+	_, ok = props["class"]
+	assert.Assert(t, !ok)
+
+	defs := s["$defs"].(map[string]interface{})
+	assert.DeepEqual(t, defs["zdns.schemaTestResult"], map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"records": map[string]interface{}{"type": "array", "items": map[string]interface{}{"anyOf": []interface{}{
+				map[string]interface{}{"$ref": "#/$defs/zdns.schemaTestRecord"},
+				map[string]interface{}{"$ref": "#/$defs/zdns.SchemaTestHeader"},
+			}}},
+			"next":   map[string]interface{}{"$ref": "#/$defs/zdns.schemaTestResult"},
+			"labels": map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": map[string]interface{}{"type": "string"}},
+		},
+		"additionalProperties": false,
+		"required":             []string{"labels", "records"},
+	})
+	// the fields of embedded structs are merged
+	assert.DeepEqual(t, defs["zdns.schemaTestRecord"], map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":    map[string]interface{}{"type": "string"},
+			"ttl":     map[string]interface{}{"type": "integer", "minimum": 0},
+			"address": map[string]interface{}{"type": "string"},
+		},
+		"additionalProperties": false,
+		"required":             []string{"address", "ttl"},
+	})
+}
+
+func TestOutputSchemaGroups(t *testing.T) {
+	s := OutputSchema(schemaTestOptions("short", "resolver"))
+	defs := s["$defs"].(map[string]interface{})
+	props := defs["zdns.schemaTestResult"].(map[string]interface{})["properties"].(map[string]interface{})
+	var names []string
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.DeepEqual(t, names, []string{"records", "resolver"})
+	_, ok := defs["zdns.schemaTestRecord"].(map[string]interface{})["properties"].(map[string]interface{})["ttl"]
+	assert.Assert(t, !ok)
+}
+
+// Test that the fields without groups of embedded structs without groups are
+// written without additional fields, whose groups include ""
+func TestOutputSchemaEmptyGroup(t *testing.T) {
+	groups, err := OutputGroups("normal", "")
+	assert.NilError(t, err)
+	s := OutputSchema(schemaTestOptions(groups...))
+	assert.Equal(t, s["title"], "zdns TEST output (normal)")
+	defs := s["$defs"].(map[string]interface{})
+	props := defs["zdns.schemaTestRecord"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.DeepEqual(t, props["comment"], map[string]interface{}{"type": "string"})
+	// but not those of other structs
+	_, ok := defs["zdns.schemaTestResult"].(map[string]interface{})["properties"].(map[string]interface{})["hidden"]
+	assert.Assert(t, !ok)
+}
+
+func TestOutputSchemaModules(t *testing.T) {
+	opts := schemaTestOptions("short")
+	opts.Module = "A"
+	opts.Modules = []string{"A", "TEST"}
+	s := OutputSchema(opts)
+	assert.Equal(t, s["title"], "zdns A,TEST output (short)")
+	data := s["properties"].(map[string]interface{})["data"].(map[string]interface{})
+	modules := data["properties"].(map[string]interface{})
+	assert.Equal(t, len(modules), 2)
+	assert.DeepEqual(t, modules["TEST"].(map[string]interface{})["properties"].(map[string]interface{})["data"],
+		map[string]interface{}{"$ref": "#/$defs/zdns.schemaTestResult"})
+}
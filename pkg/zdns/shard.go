@@ -0,0 +1,118 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"sync"
+)
+
+// shardedInputHandler passes on the subset of another input handler's lines
+// that belongs to one shard of a distributed scan.
+//
+// The input is cut into consecutive blocks of shards lines. Within every
+// block, the lines are dealt out to the shards by a permutation derived from
+// the seed and the block number, so each shard receives exactly one line per
+// full block. Instances that share the input, shard count and seed therefore
+// process disjoint subsets that together cover the whole input, without
+// having to know its length up front.
+type shardedInputHandler struct {
+	inner  InputHandler
+	shards int
+	shard  int
+	seed   int64
+}
+
+func newShardedInputHandler(inner InputHandler, shards, shard int, seed int64) *shardedInputHandler {
+	return &shardedInputHandler{
+		inner:  inner,
+		shards: shards,
+		shard:  shard,
+		seed:   seed,
+	}
+}
+
+func (h *shardedInputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup) error {
+	return h.FeedChannelContext(context.Background(), in, wg)
+}
+
+func (h *shardedInputHandler) FeedChannelContext(ctx context.Context, in chan<- interface{}, wg *sync.WaitGroup) error {
+	defer close(in)
+	defer (*wg).Done()
+
+	lines := make(chan interface{})
+	errChan := make(chan error, 1)
+	var innerWG sync.WaitGroup
+	innerWG.Add(1)
+	go func() {
+		errChan <- feedInput(ctx, h.inner, lines, &innerWG)
+	}()
+
+	perm := make([]int, h.shards)
+	var block uint64
+	offset, keep := 0, 0
+loop:
+	for line := range lines {
+		if offset == 0 {
+			keep = h.position(perm, block)
+			block++
+		}
+		if offset == keep {
+			select {
+			case in <- line:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		offset = (offset + 1) % h.shards
+	}
+	// let the inner handler see that ctx is done
+	for range lines {
+	}
+	innerWG.Wait()
+	return <-errChan
+}
+
+// position returns the offset within the given block of the line that
+// belongs to h.shard
+func (h *shardedInputHandler) position(perm []int, block uint64) int {
+	state := uint64(h.seed) ^ (block * 0x9e3779b97f4a7c15)
+	for i := range perm {
+		perm[i] = i
+	}
+	// Fisher-Yates shuffle. The generator is spelled out rather than taken
+	// from math/rand so that shard assignments can never change between Go
+	// releases.
+	for i := len(perm) - 1; i > 0; i-- {
+		var r uint64
+		state, r = splitMix64(state)
+		j := int(r % uint64(i+1))
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	for i, s := range perm {
+		if s == h.shard {
+			return i
+		}
+	}
+	panic("shard missing from permutation")
+}
+
+func splitMix64(state uint64) (uint64, uint64) {
+	state += 0x9e3779b97f4a7c15
+	z := state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return state, z ^ (z >> 31)
+}
@@ -0,0 +1,67 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zmap/zdns/iohandlers"
+	"gotest.tools/v3/assert"
+)
+
+func readShard(t *testing.T, input string, shards, shard int, seed int64) []string {
+	h := newShardedInputHandler(iohandlers.NewStreamInputHandler(strings.NewReader(input)), shards, shard, seed)
+	in := make(chan interface{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		assert.NilError(t, h.FeedChannel(in, &wg))
+	}()
+	var lines []string
+	for line := range in {
+		lines = append(lines, line.(string))
+	}
+	wg.Wait()
+	return lines
+}
+
+func TestShardsAreDisjointAndComplete(t *testing.T) {
+	// 1004 lines make 143 full blocks of 7 and a final block of 3
+	const total = 1004
+	var b strings.Builder
+	for i := 0; i < total; i++ {
+		b.WriteString(strconv.Itoa(i) + "\n")
+	}
+	seen := make(map[string]int)
+	read := 0
+	for shard := 0; shard < 7; shard++ {
+		lines := readShard(t, b.String(), 7, shard, 42)
+		assert.Assert(t, len(lines) == 143 || len(lines) == 144, "shard %d has %d lines", shard, len(lines))
+		read += len(lines)
+		for _, line := range lines {
+			seen[line]++
+		}
+		assert.DeepEqual(t, lines, readShard(t, b.String(), 7, shard, 42))
+	}
+	// every line is read by exactly one shard
+	assert.Equal(t, read, total)
+	for i := 0; i < total; i++ {
+		assert.Equal(t, seen[strconv.Itoa(i)], 1, i)
+	}
+	assert.Assert(t, strings.Join(readShard(t, b.String(), 7, 0, 42), ",") != strings.Join(readShard(t, b.String(), 7, 0, 43), ","))
+}
@@ -0,0 +1,133 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// latency buckets grow by a factor of 2^(1/4) (~19%), starting at 1µs, which
+// covers everything up to a couple of minutes in a fixed amount of memory
+const (
+	latencyBucketsPerOctave = 4
+	latencyBuckets          = 28 * latencyBucketsPerOctave
+)
+
+type latencyHistogram struct {
+	counts [latencyBuckets]uint32
+	total  uint64
+}
+
+func latencyBucket(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us < 1 {
+		return 0
+	}
+	b := int(math.Log2(us) * latencyBucketsPerOctave)
+	if b >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return b
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	h.counts[latencyBucket(d)]++
+	h.total++
+}
+
+// percentile returns the upper bound of the bucket holding the p-th
+// percentile (0 < p <= 1) in milliseconds
+func (h *latencyHistogram) percentile(p float64) float64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p * float64(h.total)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += uint64(c)
+		if seen >= rank {
+			us := math.Exp2(float64(i+1) / latencyBucketsPerOctave)
+			return math.Round(us) / 1000
+		}
+	}
+	return 0
+}
+
+type nameServerStats struct {
+	queries int
+	retries int
+	latency latencyHistogram
+}
+
+// QueryStats collects wire-level statistics about the queries lookup modules
+// send, keyed by name server. It is safe for concurrent use.
+type QueryStats struct {
+	mu          sync.Mutex
+	nameServers map[string]*nameServerStats
+	queries     int
+}
+
+func NewQueryStats() *QueryStats {
+	return &QueryStats{nameServers: make(map[string]*nameServerStats)}
+}
+
+// Record accounts for a single query sent to nameServer. try is the zero-based
+// attempt number, so anything above zero is counted as a retry. Latency is
+// only tracked for exchanges that got a response.
+func (s *QueryStats) Record(nameServer string, try int, status Status, rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ns, ok := s.nameServers[nameServer]
+	if !ok {
+		ns = new(nameServerStats)
+		s.nameServers[nameServer] = ns
+	}
+	ns.queries++
+	s.queries++
+	if try > 0 {
+		ns.retries++
+	}
+	switch status {
+	case STATUS_TIMEOUT, STATUS_TEMPORARY, STATUS_ERROR, STATUS_CANCELLED:
+	default:
+		ns.latency.add(rtt)
+	}
+}
+
+// Queries returns the number of queries recorded so far
+func (s *QueryStats) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// Summary returns the per name server totals and latency percentiles
+func (s *QueryStats) Summary() map[string]NameServerMetadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := make(map[string]NameServerMetadata, len(s.nameServers))
+	for name, ns := range s.nameServers {
+		summary[name] = NameServerMetadata{
+			Queries:    ns.queries,
+			Retries:    ns.retries,
+			LatencyP50: ns.latency.percentile(0.50),
+			LatencyP90: ns.latency.percentile(0.90),
+			LatencyP99: ns.latency.percentile(0.99),
+		}
+	}
+	return summary
+}
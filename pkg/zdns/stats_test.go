@@ -0,0 +1,58 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestQueryStatsSummary(t *testing.T) {
+	s := NewQueryStats()
+	for i := 0; i < 98; i++ {
+		s.Record("1.1.1.1:53", 0, STATUS_NOERROR, 10*time.Millisecond)
+	}
+	s.Record("1.1.1.1:53", 0, STATUS_NXDOMAIN, 200*time.Millisecond)
+	s.Record("1.1.1.1:53", 0, STATUS_NOERROR, 2*time.Second)
+	// timeouts count as queries, but must not skew latency
+	s.Record("8.8.8.8:53", 0, STATUS_TIMEOUT, 15*time.Second)
+	s.Record("8.8.8.8:53", 1, STATUS_NOERROR, 30*time.Millisecond)
+
+	summary := s.Summary()
+	assert.Equal(t, len(summary), 2)
+
+	one := summary["1.1.1.1:53"]
+	assert.Equal(t, one.Queries, 100)
+	assert.Equal(t, one.Retries, 0)
+	// percentiles are bucket upper bounds, so allow for the bucket width
+	assert.Assert(t, one.LatencyP50 >= 10 && one.LatencyP50 < 12.5, one.LatencyP50)
+	assert.Assert(t, one.LatencyP90 >= 10 && one.LatencyP90 < 12.5, one.LatencyP90)
+	assert.Assert(t, one.LatencyP99 >= 200 && one.LatencyP99 < 250, one.LatencyP99)
+
+	eight := summary["8.8.8.8:53"]
+	assert.Equal(t, eight.Queries, 2)
+	assert.Equal(t, eight.Retries, 1)
+	assert.Assert(t, eight.LatencyP99 >= 30 && eight.LatencyP99 < 37.5, eight.LatencyP99)
+}
+
+func TestQueryStatsEmpty(t *testing.T) {
+	s := NewQueryStats()
+	s.Record("1.1.1.1:53", 0, STATUS_TIMEOUT, time.Second)
+	ns := s.Summary()["1.1.1.1:53"]
+	assert.Equal(t, ns.Queries, 1)
+	assert.Equal(t, ns.LatencyP50, float64(0))
+}
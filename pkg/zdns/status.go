@@ -0,0 +1,277 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zmap/zdns/iohandlers"
+)
+
+// progress counts names as they move through a scan. It is updated by the
+// lookup routines and read by the status updates.
+type progress struct {
+	read      atomic.Int64
+	completed atomic.Int64
+	// expected is the number of names the scan will read, or -1 while that
+	// is unknown
+	expected atomic.Int64
+	statuses sync.Map // Status -> *atomic.Int64
+}
+
+func newProgress() *progress {
+	p := new(progress)
+	p.expected.Store(-1)
+	return p
+}
+
+func (p *progress) complete(status Status) {
+	p.completed.Add(1)
+	n, ok := p.statuses.Load(status)
+	if !ok {
+		n, _ = p.statuses.LoadOrStore(status, new(atomic.Int64))
+	}
+	n.(*atomic.Int64).Add(1)
+}
+
+func (p *progress) statusCounts() map[Status]int64 {
+	counts := make(map[Status]int64)
+	p.statuses.Range(func(k, v interface{}) bool {
+		counts[k.(Status)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return counts
+}
+
+// countExpectedNames estimates how many names a scan of c will read, by
+// counting the lines of the input file, decompressed if need be. Only the file
+// input handler reading an actual file can be counted.
+func countExpectedNames(c *GlobalConf, resumeFrom *Checkpoint) (int64, bool) {
+	if c.InputHandlerName != "file" || c.InputFilePath == "" || c.InputFilePath == "-" {
+		return 0, false
+	}
+	f, err := os.Open(c.InputFilePath)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return 0, false
+	}
+	compression, err := iohandlers.ResolveCompression(c.InputCompression, c.InputFilePath)
+	if err != nil {
+		return 0, false
+	}
+	r, err := iohandlers.NewDecompressingReader(f, compression)
+	if err != nil {
+		return 0, false
+	}
+	defer r.Close()
+	var lines int64
+	buf := make([]byte, 1<<16)
+	last := byte('\n')
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, false
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+	if c.Shards > 1 {
+		lines /= int64(c.Shards)
+	}
+	if resumeFrom != nil {
+		lines -= int64(resumeFrom.InputOffset) + int64(len(resumeFrom.Completed))
+	}
+	if lines < 0 {
+		lines = 0
+	}
+	return lines, true
+}
+
+// timeout and error rates are the share of completed names with these
+// statuses. Statuses that are neither, e.g. NXDOMAIN, are valid answers.
+func isTimeoutStatus(status Status) bool {
+	return status == STATUS_TIMEOUT || status == STATUS_ITER_TIMEOUT
+}
+
+func isErrorStatus(status Status) bool {
+	switch status {
+	case STATUS_ERROR, STATUS_SERVFAIL, STATUS_REFUSED, STATUS_FORMERR, STATUS_NOTIMP,
+		STATUS_TRUNCATED, STATUS_TEMPORARY, STATUS_AUTHFAIL, STATUS_NOAUTH:
+		return true
+	}
+	return false
+}
+
+var statusUpdateHeader = []string{
+	"time", "elapsed_s", "names_read", "names_completed", "names_in_flight",
+	"queries_per_second", "timeout_rate", "error_rate", "eta_s", "statuses",
+}
+
+// statusUpdates writes a CSV row describing the progress of a scan once per
+// second, in the style of zmap's --status-updates-file
+type statusUpdates struct {
+	w        *csv.Writer
+	f        *os.File
+	progress *progress
+	stats    *QueryStats
+	format   string
+	start    time.Time
+
+	lastTime    time.Time
+	lastQueries int
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func startStatusUpdates(path string, c *GlobalConf, p *progress, resumeFrom *Checkpoint) (*statusUpdates, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stderr
+	} else {
+		var err error
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open status updates file: %w", err)
+		}
+	}
+	s := &statusUpdates{
+		w:        csv.NewWriter(f),
+		f:        f,
+		progress: p,
+		stats:    c.QueryStats,
+		format:   c.TimeFormat,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	s.lastTime = s.start
+	s.w.Write(statusUpdateHeader)
+	s.w.Flush()
+	go func() {
+		if n, ok := countExpectedNames(c, resumeFrom); ok {
+			p.expected.Store(n)
+		}
+	}()
+	go s.run()
+	return s, nil
+}
+
+func (s *statusUpdates) run() {
+	defer close(s.stopped)
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			s.write(now)
+		case <-s.done:
+			s.write(time.Now())
+			return
+		}
+	}
+}
+
+func (s *statusUpdates) write(now time.Time) {
+	// completed first, so that it can't overtake read in between
+	completed := s.progress.completed.Load()
+	read := s.progress.read.Load()
+	counts := s.progress.statusCounts()
+
+	queries := s.stats.Queries()
+	var qps float64
+	if d := now.Sub(s.lastTime).Seconds(); d > 0 {
+		qps = float64(queries-s.lastQueries) / d
+	}
+	s.lastTime, s.lastQueries = now, queries
+
+	var timeouts, errs int64
+	names := make([]string, 0, len(counts))
+	for status, n := range counts {
+		if isTimeoutStatus(status) {
+			timeouts += n
+		} else if isErrorStatus(status) {
+			errs += n
+		}
+		names = append(names, string(status))
+	}
+	sort.Strings(names)
+	histogram := make([]string, len(names))
+	for i, name := range names {
+		histogram[i] = name + ":" + strconv.FormatInt(counts[Status(name)], 10)
+	}
+	var timeoutRate, errorRate float64
+	if completed > 0 {
+		timeoutRate = float64(timeouts) / float64(completed)
+		errorRate = float64(errs) / float64(completed)
+	}
+
+	elapsed := now.Sub(s.start).Seconds()
+	eta := ""
+	if expected := s.progress.expected.Load(); expected >= 0 && completed > 0 {
+		remaining := expected - completed
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta = strconv.FormatFloat(float64(remaining)/(float64(completed)/elapsed), 'f', 0, 64)
+	}
+
+	s.w.Write([]string{
+		now.Format(s.format),
+		strconv.FormatFloat(elapsed, 'f', 0, 64),
+		strconv.FormatInt(read, 10),
+		strconv.FormatInt(completed, 10),
+		strconv.FormatInt(read-completed, 10),
+		strconv.FormatFloat(qps, 'f', 1, 64),
+		strconv.FormatFloat(timeoutRate, 'f', 4, 64),
+		strconv.FormatFloat(errorRate, 'f', 4, 64),
+		eta,
+		strings.Join(histogram, ";"),
+	})
+	s.w.Flush()
+}
+
+// stop writes a final status row and closes the status updates file
+func (s *statusUpdates) stop() error {
+	close(s.done)
+	<-s.stopped
+	if err := s.w.Error(); err != nil {
+		return fmt.Errorf("unable to write status updates: %w", err)
+	}
+	if s.f != os.Stderr {
+		return s.f.Close()
+	}
+	return nil
+}
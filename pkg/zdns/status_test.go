@@ -0,0 +1,69 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCountExpectedNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names.txt")
+	assert.NilError(t, os.WriteFile(path, []byte("a\nb\nc\nd\ne\nf\ng"), 0644))
+	c := &GlobalConf{InputHandlerName: "file", InputFilePath: path}
+
+	n, ok := countExpectedNames(c, nil)
+	assert.Assert(t, ok)
+	assert.Equal(t, n, int64(7))
+
+	c.Shards = 2
+	n, _ = countExpectedNames(c, &Checkpoint{InputOffset: 1, Completed: []uint64{2}})
+	assert.Equal(t, n, int64(1))
+
+	c.InputFilePath = "-"
+	_, ok = countExpectedNames(c, nil)
+	assert.Assert(t, !ok)
+}
+
+func TestStatusUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.csv")
+	c := &GlobalConf{QueryStats: NewQueryStats(), TimeFormat: time.RFC3339}
+	p := newProgress()
+	s, err := startStatusUpdates(path, c, p, nil)
+	assert.NilError(t, err)
+	p.read.Add(4)
+	p.complete(STATUS_NOERROR)
+	p.complete(STATUS_NOERROR)
+	p.complete(STATUS_TIMEOUT)
+	c.QueryStats.Record("192.0.2.1:53", 0, STATUS_NOERROR, time.Millisecond)
+	assert.NilError(t, s.stop())
+
+	f, err := os.Open(path)
+	assert.NilError(t, err)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, rows[0], statusUpdateHeader)
+	last := rows[len(rows)-1]
+	assert.DeepEqual(t, last[2:5], []string{"4", "3", "1"})
+	assert.DeepEqual(t, last[6:8], []string{"0.3333", "0.0000"})
+	assert.Equal(t, last[8], "")
+	assert.Equal(t, last[9], "NOERROR:2;TIMEOUT:1")
+}
@@ -0,0 +1,135 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/zmap/zdns/internal/util"
+)
+
+// TLSScheme prefixes name servers that are queried over DNS-over-TLS
+// (RFC 7858), e.g. tls://1.1.1.1:853
+const TLSScheme = util.TLSScheme
+
+// NewTLSConfig returns the configuration of DNS-over-TLS connections.
+// serverName, if set, is sent as the server name indication in place of the
+// host of the name server. Certificates are only verified if authName or
+// roots is set: their chain against roots, or the system CAs if nil, and
+// their name against authName, or else the server name. If spkiPins is set,
+// one of the public keys of the verified chain must have one of these
+// SHA-256 digests, or without verification the public key of the
+// certificate of the name server itself, as the handshake only proves that
+// the name server holds that one.
+func NewTLSConfig(serverName, authName string, roots *x509.CertPool, spkiPins [][]byte) *tls.Config {
+	verify := authName != "" || roots != nil
+	return &tls.Config{
+		ServerName: serverName,
+		// certificates are checked by VerifyConnection instead, since the
+		// name they're for may differ from the server name
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tls: name server sent no certificate")
+			}
+			chains := [][]*x509.Certificate{cs.PeerCertificates[:1]}
+			if verify {
+				name := authName
+				if name == "" {
+					name = cs.ServerName
+				}
+				opts := x509.VerifyOptions{DNSName: name, Roots: roots, Intermediates: x509.NewCertPool()}
+				for _, cert := range cs.PeerCertificates[1:] {
+					opts.Intermediates.AddCert(cert)
+				}
+				var err error
+				if chains, err = cs.PeerCertificates[0].Verify(opts); err != nil {
+					return err
+				}
+			}
+			if len(spkiPins) > 0 {
+				return checkSPKIPins(chains, spkiPins)
+			}
+			return nil
+		},
+	}
+}
+
+// checkSPKIPins checks that a public key of one of chains has one of pins as
+// its digest
+func checkSPKIPins(chains [][]*x509.Certificate, pins [][]byte) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(digest[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("tls: no public key of the certificate chain matches the SPKI pins")
+}
+
+// GetTLSConfig returns c.TLSConfig, or the configuration of DNS-over-TLS
+// connections without verification if it isn't set
+func (c *GlobalConf) GetTLSConfig() *tls.Config {
+	if c == nil || c.TLSConfig == nil {
+		return NewTLSConfig("", "", nil, nil)
+	}
+	return c.TLSConfig
+}
+
+// nameServerAddr completes the address of a name server given by the user
+// with its default port. With useTLS, name servers given without a scheme
+// are queried over DNS-over-TLS.
+func nameServerAddr(s string, useTLS bool) string {
+	if useTLS && !strings.Contains(s, "://") {
+		s = TLSScheme + s
+	}
+	return util.AddDefaultPortToDNSServerName(s)
+}
+
+// loadTLSConfig makes gc.TLSConfig of the DNS-over-TLS options
+func loadTLSConfig(gc *GlobalConf) error {
+	var roots *x509.CertPool
+	if gc.TLSCAFilePath != "" {
+		pem, err := os.ReadFile(gc.TLSCAFilePath)
+		if err != nil {
+			return newValidationError("--tls-ca-file", "unable to read file (%s): %s", gc.TLSCAFilePath, err.Error())
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return newValidationError("--tls-ca-file", "no PEM certificates in %s", gc.TLSCAFilePath)
+		}
+	}
+	pins := make([][]byte, len(gc.TLSSPKIPins))
+	for i, p := range gc.TLSSPKIPins {
+		pin, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || len(pin) != sha256.Size {
+			return newValidationError("--tls-spki-pin", "invalid pin %q. Must be the base64 SHA-256 digest of a SubjectPublicKeyInfo", p)
+		}
+		pins[i] = pin
+	}
+	gc.TLSConfig = NewTLSConfig(gc.TLSServerName, gc.TLSAuthName, roots, pins)
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNameServerAddr(t *testing.T) {
+	tests := []struct {
+		in     string
+		useTLS bool
+		want   string
+	}{
+		{"8.8.8.8", false, "8.8.8.8:53"},
+		{"8.8.8.8:5353", false, "8.8.8.8:5353"},
+		{"tls://1.1.1.1", false, "tls://1.1.1.1:853"},
+		{"tls://1.1.1.1:8853", false, "tls://1.1.1.1:8853"},
+		{"tls://2606:4700::1111", false, "tls://[2606:4700::1111]:853"},
+		{"1.1.1.1", true, "tls://1.1.1.1:853"},
+		{"1.1.1.1:8853", true, "tls://1.1.1.1:8853"},
+		{"tls://1.1.1.1", true, "tls://1.1.1.1:853"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, nameServerAddr(test.in, test.useTLS), test.want, test.in)
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	gc := GlobalConf{TLSSPKIPins: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}}
+	assert.NilError(t, loadTLSConfig(&gc))
+	assert.Assert(t, gc.TLSConfig != nil)
+
+	var verr *ValidationError
+	gc = GlobalConf{TLSSPKIPins: []string{"c2hvcnQ="}}
+	assert.Assert(t, errors.As(loadTLSConfig(&gc), &verr))
+	assert.Equal(t, verr.Option, "--tls-spki-pin")
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NilError(t, os.WriteFile(path, []byte("not a certificate"), 0644))
+	gc = GlobalConf{TLSCAFilePath: path}
+	assert.Assert(t, errors.As(loadTLSConfig(&gc), &verr))
+	assert.Equal(t, verr.Option, "--tls-ca-file")
+}
@@ -0,0 +1,81 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"net"
+	"time"
+)
+
+// WireExchange is a query a lookup module put on the wire and the response it
+// got back, if any
+type WireExchange struct {
+	// Protocol is udp, tcp or tls. The messages of tls exchanges are kept
+	// as they were before encryption.
+	Protocol string
+	// LocalAddr is the address the query was sent from and RemoteAddr the
+	// name server it was sent to
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	QueryTime  time.Time
+	Query      []byte
+	// ResponseTime and Response are only set if a response was received
+	ResponseTime time.Time
+	Response     []byte
+}
+
+// WireTap receives the exchanges lookup modules put on the wire. It must be
+// safe for concurrent use.
+type WireTap interface {
+	Write(e *WireExchange)
+}
+
+type wireTaps []WireTap
+
+func (t wireTaps) Write(e *WireExchange) {
+	for _, w := range t {
+		w.Write(e)
+	}
+}
+
+// GetWireTap returns where the exchanges put on the wire are to be written,
+// or nil if nowhere
+func (c *GlobalConf) GetWireTap() WireTap {
+	var taps wireTaps
+	if c.Dnstap != nil {
+		taps = append(taps, c.Dnstap)
+	}
+	if c.Pcap != nil {
+		taps = append(taps, c.Pcap)
+	}
+	switch len(taps) {
+	case 0:
+		return nil
+	case 1:
+		return taps[0]
+	}
+	return taps
+}
+
+// addrPort splits a UDP or TCP address
+func addrPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	}
+	return nil, 0
+}
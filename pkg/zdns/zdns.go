@@ -15,10 +15,12 @@
 package zdns
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -31,133 +33,170 @@ import (
 	"github.com/zmap/zdns/iohandlers"
 )
 
-func Run(gc GlobalConf, flags *pflag.FlagSet,
-	timeout *int, iterationTimeout *int,
-	class_string *string, servers_string *string,
-	config_file *string, localaddr_string *string,
-	localif_string *string, nanoSeconds *bool,
-	clientsubnet_string *string, nsid *bool) {
+// RunOptions carries the raw command line values that still have to be parsed
+// and validated before they can be stored in a GlobalConf
+type RunOptions struct {
+	// Flags is handed to the lookup module and the i/o handlers so that
+	// they can read their own options
+	Flags            *pflag.FlagSet
+	Timeout          int
+	IterationTimeout int
+	Class            string
+	NameServers      string
+	ConfigFile       string
+	LocalAddrs       string
+	LocalInterface   string
+	NanoSeconds      bool
+	ClientSubnet     string
+	NSID             bool
+}
 
-	factory := GetLookup(gc.Module)
+// Runner is a fully validated scan, ready to be executed
+type Runner struct {
+	conf    GlobalConf
+	factory GlobalLookupFactory
+	flags   *pflag.FlagSet
+	// resumeFrom is the checkpoint a --resume run continues from
+	resumeFrom *Checkpoint
+}
 
+// NewRunner validates gc and opts and resolves everything needed to run the
+// scan up front, so that configuration mistakes are reported as a
+// *ValidationError instead of surfacing halfway through a run.
+func NewRunner(gc GlobalConf, opts RunOptions) (*Runner, error) {
+	r := &Runner{}
+	if err := r.setup(&gc, opts); err != nil {
+		return nil, err
+	}
+	r.conf = gc
+	return r, nil
+}
+
+func (r *Runner) setup(gc *GlobalConf, opts RunOptions) error {
+	if len(gc.Modules) > 0 {
+		if gc.Module != "" {
+			return newValidationError("--modules", "cannot be combined with module %s", gc.Module)
+		}
+		modules := make([]string, len(gc.Modules))
+		for i, m := range gc.Modules {
+			modules[i] = strings.ToUpper(strings.TrimSpace(m))
+			mf := GetLookup(modules[i])
+			if mf == nil {
+				return newValidationError("--modules", "invalid lookup module %q. Valid modules: %s", m, ValidlookupsString())
+			}
+			if !mf.AllowStdIn() && gc.InputFilePath == "-" {
+				return newValidationError("--input-file", "module %s does not allow reading from stdin", modules[i])
+			}
+		}
+		gc.Modules = modules
+		// the first module doubles as the run's primary module
+		gc.Module = modules[0]
+	}
+	factory := GetLookup(gc.Module)
 	if factory == nil {
-		log.Panic("Invalid lookup module specified. Valid modules: ", ValidlookupsString())
+		return newValidationError("module", "invalid lookup module %q. Valid modules: %s", gc.Module, ValidlookupsString())
 	}
+	r.factory = factory
 
-	factory.SetFlags(flags)
+	if opts.Flags == nil {
+		opts.Flags = pflag.NewFlagSet("zdns", pflag.ContinueOnError)
+	}
+	factory.SetFlags(opts.Flags)
+	r.flags = opts.Flags
 
+	if gc.Verbosity < 0 || gc.Verbosity > 5 {
+		return newValidationError("--verbosity", "unknown verbosity level %d. Must be between 1 (lowest)--5 (highest)", gc.Verbosity)
+	}
 	if gc.LogFilePath != "" {
-		f, err := os.OpenFile(gc.LogFilePath, os.O_WRONLY|os.O_CREATE, 0666)
-		if err != nil {
-			log.Panicf("Unable to open log file (%s): %s", gc.LogFilePath, err.Error())
+		if err := checkLogFile(gc.LogFilePath); err != nil {
+			return newValidationError("--log-file", "unable to open log file (%s): %s", gc.LogFilePath, err.Error())
 		}
-		log.SetOutput(f)
-	}
-
-	// Translate the assigned verbosity level to a logrus log level.
-	switch gc.Verbosity {
-	case 1: // Fatal
-		log.SetLevel(log.FatalLevel)
-	case 2: // Error
-		log.SetLevel(log.ErrorLevel)
-	case 3: // Warnings  (default)
-		log.SetLevel(log.WarnLevel)
-	case 4: // Information
-		log.SetLevel(log.InfoLevel)
-	case 5: // Debugging
-		log.SetLevel(log.DebugLevel)
-	default:
-		panic("Unknown verbosity level specified. Must be between 1 (lowest)--5 (highest)")
 	}
 
 	// complete post facto global initialization based on command line arguments
-	gc.Timeout = time.Second * time.Duration(*timeout)
-	gc.IterationTimeout = time.Second * time.Duration(*iterationTimeout)
+	gc.Timeout = time.Second * time.Duration(opts.Timeout)
+	gc.IterationTimeout = time.Second * time.Duration(opts.IterationTimeout)
 
 	// class initialization
-	switch strings.ToUpper(*class_string) {
-	case "INET", "IN":
-		gc.Class = dns.ClassINET
-	case "CSNET", "CS":
-		gc.Class = dns.ClassCSNET
-	case "CHAOS", "CH":
-		gc.Class = dns.ClassCHAOS
-	case "HESIOD", "HS":
-		gc.Class = dns.ClassHESIOD
-	case "NONE":
-		gc.Class = dns.ClassNONE
-	case "ANY":
-		gc.Class = dns.ClassANY
-	default:
-		panic("Unknown record class specified. Valid valued are INET (default), CSNET, CHAOS, HESIOD, NONE, ANY")
-
+	class, err := parseClass(opts.Class)
+	if err != nil {
+		return &ValidationError{Option: "--class", Err: err}
 	}
+	gc.Class = class
 
 	if gc.LookupAllNameServers {
-		if *servers_string != "" {
-			panic("Name servers cannot be specified in --all-nameservers mode.")
+		if opts.NameServers != "" {
+			return newValidationError("--name-servers", "name servers cannot be specified in --all-nameservers mode")
 		}
 	}
 
-	if *servers_string == "" {
+	if opts.NameServers == "" {
 		// if we're doing recursive resolution, figure out default OS name servers
 		// otherwise, use the set of 13 root name servers
 		if gc.IterativeResolution {
 			gc.NameServers = RootServers[:]
 		} else {
-			ns, err := GetDNSServers(*config_file)
+			ns, err := GetDNSServers(opts.ConfigFile)
 			if err != nil {
 				ns = util.GetDefaultResolvers()
 				log.Warn("Unable to parse resolvers file. Using ZDNS defaults: ", strings.Join(ns, ", "))
 			}
+			if gc.TLS {
+				// the resolvers are listed on port 53, and serve
+				// DNS-over-TLS on 853 if at all
+				for i, s := range ns {
+					host, _, _ := net.SplitHostPort(s)
+					ns[i] = TLSScheme + net.JoinHostPort(host, "853")
+				}
+			}
 			gc.NameServers = ns
 		}
 		gc.NameServersSpecified = false
 		log.Info("No name servers specified. will use: ", strings.Join(gc.NameServers, ", "))
 	} else {
 		if gc.NameServerMode {
-			panic("name servers cannot be specified on command line in --name-server-mode")
+			return newValidationError("--name-servers", "name servers cannot be specified on command line in --name-server-mode")
 		}
 		var ns []string
-		if (*servers_string)[0] == '@' {
-			filepath := (*servers_string)[1:]
+		if opts.NameServers[0] == '@' {
+			filepath := opts.NameServers[1:]
 			f, err := os.ReadFile(filepath)
 			if err != nil {
-				log.Panicf("Unable to read file (%s): %s", filepath, err.Error())
+				return newValidationError("--name-servers", "unable to read file (%s): %s", filepath, err.Error())
 			}
 			if len(f) == 0 {
-				log.Panicf("Empty file (%s)", filepath)
+				return newValidationError("--name-servers", "empty file (%s)", filepath)
 			}
 			ns = strings.Split(strings.Trim(string(f), "\n"), "\n")
 		} else {
-			ns = strings.Split(*servers_string, ",")
+			ns = strings.Split(opts.NameServers, ",")
 		}
 		for i, s := range ns {
-			ns[i] = util.AddDefaultPortToDNSServerName(s)
+			ns[i] = nameServerAddr(s, gc.TLS)
 		}
 		gc.NameServers = ns
 		gc.NameServersSpecified = true
 	}
 
-	if *nsid {
+	if opts.NSID {
 		gc.NSID = new(dns.EDNS0_NSID)
 	}
 
-	if *clientsubnet_string != "" {
-		parts := strings.Split(*clientsubnet_string, "/")
+	if opts.ClientSubnet != "" {
+		parts := strings.Split(opts.ClientSubnet, "/")
 		if len(parts) != 2 {
-			log.Panicf("Client subnet should be in CIDR format: %s", *clientsubnet_string)
+			return newValidationError("--client-subnet", "client subnet should be in CIDR format: %s", opts.ClientSubnet)
 		}
 		ip := net.ParseIP(parts[0])
 		if ip == nil {
-			log.Panicf("Client subnet invalid: %s", *clientsubnet_string)
+			return newValidationError("--client-subnet", "client subnet invalid: %s", opts.ClientSubnet)
 		}
 		netmask, err := strconv.Atoi(parts[1])
 		if err != nil {
-			log.Panicf("Client subnet netmask invalid: %s", *clientsubnet_string)
+			return newValidationError("--client-subnet", "client subnet netmask invalid: %s", opts.ClientSubnet)
 		}
 		if netmask > 24 || netmask < 8 {
-			log.Panicf("Client subnet netmask must be in 8..24: %s", *clientsubnet_string)
+			return newValidationError("--client-subnet", "client subnet netmask must be in 8..24: %s", opts.ClientSubnet)
 		}
 		gc.ClientSubnet = new(dns.EDNS0_SUBNET)
 		gc.ClientSubnet.Code = dns.EDNS0SUBNET
@@ -170,113 +209,366 @@ func Run(gc GlobalConf, flags *pflag.FlagSet,
 		gc.ClientSubnet.Address = ip
 	}
 
-	if *localaddr_string != "" {
-		for _, la := range strings.Split(*localaddr_string, ",") {
+	if opts.LocalAddrs != "" {
+		for _, la := range strings.Split(opts.LocalAddrs, ",") {
 			ip := net.ParseIP(la)
 			if ip != nil {
 				gc.LocalAddrs = append(gc.LocalAddrs, ip)
 			} else {
-				log.Panic("Invalid argument for --local-addr (", la, "). Must be a comma-separated list of valid IP addresses.")
+				return newValidationError("--local-addr", "invalid argument (%s). Must be a comma-separated list of valid IP addresses", la)
 			}
 		}
-		log.Info("using local address: ", localaddr_string)
+		log.Info("using local address: ", opts.LocalAddrs)
 		gc.LocalAddrSpecified = true
 	}
 
-	if *localif_string != "" {
+	if opts.LocalInterface != "" {
 		if gc.LocalAddrSpecified {
-			log.Panic("Both --local-addr and --local-interface specified.")
-		} else {
-			li, err := net.InterfaceByName(*localif_string)
-			if err != nil {
-				log.Panic("Invalid local interface specified: ", err)
-			}
-			addrs, err := li.Addrs()
-			if err != nil {
-				log.Panic("Unable to detect addresses of local interface: ", err)
-			}
-			for _, la := range addrs {
-				gc.LocalAddrs = append(gc.LocalAddrs, la.(*net.IPNet).IP)
-				gc.LocalAddrSpecified = true
-			}
-			log.Info("using local interface: ", localif_string)
+			return newValidationError("--local-interface", "conflicts with --local-addr")
 		}
-	}
-	if !gc.LocalAddrSpecified {
-		// Find local address for use in unbound UDP sockets
-		if conn, err := net.Dial("udp", "8.8.8.8:53"); err != nil {
-			log.Panic("Unable to find default IP address: ", err)
-		} else {
-			gc.LocalAddrs = append(gc.LocalAddrs, conn.LocalAddr().(*net.UDPAddr).IP)
-			err := conn.Close()
-			if err != nil {
-				log.Warn("Unable to close test connection to Google Public DNS: ", err)
-			}
+		li, err := net.InterfaceByName(opts.LocalInterface)
+		if err != nil {
+			return newValidationError("--local-interface", "invalid local interface specified: %s", err)
+		}
+		addrs, err := li.Addrs()
+		if err != nil {
+			return newValidationError("--local-interface", "unable to detect addresses of local interface: %s", err)
 		}
+		for _, la := range addrs {
+			gc.LocalAddrs = append(gc.LocalAddrs, la.(*net.IPNet).IP)
+			gc.LocalAddrSpecified = true
+		}
+		log.Info("using local interface: ", opts.LocalInterface)
 	}
-	if *nanoSeconds {
+	if opts.NanoSeconds {
 		gc.TimeFormat = time.RFC3339Nano
 	} else {
 		gc.TimeFormat = time.RFC3339
 	}
 	if gc.GoMaxProcs < 0 {
-		log.Panic("Invalid argument for --go-processes. Must be >1.")
-	}
-
-	if gc.GoMaxProcs != 0 {
-		runtime.GOMAXPROCS(gc.GoMaxProcs)
+		return newValidationError("--go-processes", "must be >1")
 	}
 
-	// the margin for limit of open files covers different build platforms (linux/darwin), metadata files, or
-	// input and output files etc.
-	// check ulimit if value is high enough and if not, try to fix it
-	_ = ulimitCheck(uint64(gc.Threads + 100))
-
 	if gc.UDPOnly && gc.TCPOnly {
-		log.Panic("TCP Only and UDP Only are conflicting")
+		return newValidationError("--tcp-only", "conflicts with --udp-only")
+	}
+	if gc.TLS && gc.UDPOnly {
+		return newValidationError("--tls", "conflicts with --udp-only")
+	}
+	if gc.TLS && gc.IterativeResolution {
+		return newValidationError("--tls", "incompatible with --iterative, as authoritative name servers aren't queried over DNS-over-TLS")
+	}
+	if gc.TLSConfig == nil {
+		if err := loadTLSConfig(gc); err != nil {
+			return err
+		}
 	}
 	if gc.NameServerMode && gc.AlexaFormat {
-		log.Panic("Alexa mode is incompatible with name server mode")
+		return newValidationError("--alexa", "incompatible with --name-server-mode")
 	}
 	if gc.NameServerMode && gc.MetadataFormat {
-		log.Panic("Metadata mode is incompatible with name server mode")
+		return newValidationError("--metadata-passthrough", "incompatible with --name-server-mode")
+	}
+	switch gc.InputFormat {
+	case "":
+		gc.InputFormat = INPUT_FORMAT_TEXT
+	case INPUT_FORMAT_TEXT:
+	case INPUT_FORMAT_JSONL:
+		if gc.AlexaFormat {
+			return newValidationError("--alexa", "incompatible with --input-format %s", gc.InputFormat)
+		}
+		if gc.MetadataFormat {
+			return newValidationError("--metadata-passthrough", "incompatible with --input-format %s", gc.InputFormat)
+		}
+		if gc.NameServerMode {
+			return newValidationError("--name-server-mode", "incompatible with --input-format %s", gc.InputFormat)
+		}
+	default:
+		return newValidationError("--input-format", "invalid input format %q. Options: %s, %s", gc.InputFormat, INPUT_FORMAT_TEXT, INPUT_FORMAT_JSONL)
 	}
 	if gc.NameServerMode && gc.NameOverride == "" && gc.Module != "BINDVERSION" {
-		log.Panic("Static Name must be defined with --override-name in --name-server-mode unless DNS module does not expect names (e.g., BINDVERSION).")
+		return newValidationError("--override-name", "static name must be defined in --name-server-mode unless DNS module does not expect names (e.g., BINDVERSION)")
 	}
-	// Output Groups are defined by a base + any additional fields that the user wants
-	groups := strings.Split(gc.IncludeInOutput, ",")
-	if gc.ResultVerbosity != "short" && gc.ResultVerbosity != "normal" && gc.ResultVerbosity != "long" && gc.ResultVerbosity != "trace" {
-		log.Panic("Invalid result verbosity. Options: short, normal, long, trace")
+	if gc.Rate < 0 {
+		return newValidationError("--rate", "must not be negative")
+	}
+	if gc.PerNameServerRate < 0 {
+		return newValidationError("--per-nameserver-rate", "must not be negative")
+	}
+	if gc.Shards == 0 {
+		gc.Shards = 1
+	}
+	if gc.Shards < 0 {
+		return newValidationError("--shards", "must be at least 1")
+	}
+	if gc.Shard < 0 || gc.Shard >= gc.Shards {
+		return newValidationError("--shard", "must be between 0 and %d (--shards - 1)", gc.Shards-1)
+	}
+	groups, err := OutputGroups(gc.ResultVerbosity, gc.IncludeInOutput)
+	if err != nil {
+		return err
 	}
-
-	gc.OutputGroups = append(gc.OutputGroups, gc.ResultVerbosity)
 	gc.OutputGroups = append(gc.OutputGroups, groups...)
 
 	// some modules require multiple passes over a file (this is really just the case for zone files)
 	if !factory.AllowStdIn() && gc.InputFilePath == "-" {
-		log.Panic("Specified module does not allow reading from stdin")
+		return newValidationError("--input-file", "specified module does not allow reading from stdin")
 	}
 
 	// setup i/o
-	gc.InputHandler = iohandlers.NewFileInputHandler(gc.InputFilePath)
-	gc.OutputHandler = iohandlers.NewFileOutputHandler(gc.OutputFilePath)
+	if gc.InputHandlerName == "" {
+		gc.InputHandlerName = "file"
+	}
+	if gc.OutputHandlerName == "" {
+		gc.OutputHandlerName = "file"
+	}
+	if gc.InputCompression, err = iohandlers.ResolveCompression(gc.InputCompression, gc.InputFilePath); err != nil {
+		return &ValidationError{Option: "--input-compression", Err: err}
+	}
+	if gc.OutputCompression, err = iohandlers.ResolveCompression(gc.OutputCompression, gc.OutputFilePath); err != nil {
+		return &ValidationError{Option: "--output-compression", Err: err}
+	}
+	switch gc.OutputFormat {
+	case "":
+		gc.OutputFormat = OUTPUT_FORMAT_JSON
+	case OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_CSV, OUTPUT_FORMAT_TSV, OUTPUT_FORMAT_ZONE:
+	default:
+		return newValidationError("--output-format", "invalid output format %q. Options: json, csv, tsv, zone", gc.OutputFormat)
+	}
+	for _, section := range gc.ZoneSections {
+		if !inGroups(section, ZoneSections) {
+			return newValidationError("--zone-sections", "invalid section %q. Options: %s", section, strings.Join(ZoneSections, ", "))
+		}
+	}
+	if gc.OutputDir != "" {
+		if gc.OutputHandlerName != "file" {
+			return newValidationError("--output-dir", "requires --output-handler file")
+		}
+		if gc.OutputFilePath != "" && gc.OutputFilePath != "-" {
+			return newValidationError("--output-dir", "cannot be combined with --output-file")
+		}
+	}
+	if gc.Resume && gc.CheckpointFilePath == "" {
+		return newValidationError("--resume", "requires --checkpoint-file")
+	}
+	if gc.CheckpointFilePath != "" {
+		// resuming truncates the output file to the checkpointed offset,
+		// which only makes sense for a regular file written by the file
+		// output handler
+		if gc.OutputHandlerName != "file" {
+			return newValidationError("--checkpoint-file", "requires --output-handler file")
+		}
+		if gc.OutputFilePath == "" || gc.OutputFilePath == "-" {
+			return newValidationError("--checkpoint-file", "requires --output-file")
+		}
+		if gc.OutputCompression != iohandlers.COMPRESSION_NONE {
+			return newValidationError("--checkpoint-file", "cannot be used with compressed output")
+		}
+	}
+	if gc.Resume {
+		cp, err := LoadCheckpoint(gc.CheckpointFilePath)
+		if err != nil {
+			return &ValidationError{Option: "--checkpoint-file", Err: err}
+		}
+		if cp == nil {
+			log.Info("no checkpoint found at ", gc.CheckpointFilePath, ". starting from the beginning")
+		} else if cp.InputFile != gc.InputFilePath || cp.OutputFile != gc.OutputFilePath {
+			return newValidationError("--resume", "checkpoint is for input %s and output %s", cp.InputFile, cp.OutputFile)
+		}
+		r.resumeFrom = cp
+	}
+	inFactory := GetInputHandler(gc.InputHandlerName)
+	if inFactory == nil {
+		return newValidationError("--input-handler", "invalid input handler %q. Valid input handlers: %s", gc.InputHandlerName, ValidInputHandlersString())
+	}
+	outFactory := GetOutputHandler(gc.OutputHandlerName)
+	if outFactory == nil {
+		return newValidationError("--output-handler", "invalid output handler %q. Valid output handlers: %s", gc.OutputHandlerName, ValidOutputHandlersString())
+	}
+	if gc.InputHandler, err = inFactory.MakeInputHandler(gc, opts.Flags); err != nil {
+		return &ValidationError{Option: "--input-handler", Err: err}
+	}
+	if gc.Shards > 1 {
+		gc.InputHandler = newShardedInputHandler(gc.InputHandler, gc.Shards, gc.Shard, gc.Seed)
+	}
+	if gc.OutputHandler, err = outFactory.MakeOutputHandler(gc, opts.Flags); err != nil {
+		return &ValidationError{Option: "--output-handler", Err: err}
+	}
+	return nil
+}
+
+// parseClass translates the name of a DNS class, as accepted by --class, to
+// its numeric value
+func parseClass(class string) (uint16, error) {
+	switch strings.ToUpper(class) {
+	case "INET", "IN":
+		return dns.ClassINET, nil
+	case "CSNET", "CS":
+		return dns.ClassCSNET, nil
+	case "CHAOS", "CH":
+		return dns.ClassCHAOS, nil
+	case "HESIOD", "HS":
+		return dns.ClassHESIOD, nil
+	case "NONE":
+		return dns.ClassNONE, nil
+	case "ANY":
+		return dns.ClassANY, nil
+	default:
+		return 0, fmt.Errorf("unknown record class %q. Valid valued are INET (default), CSNET, CHAOS, HESIOD, NONE, ANY", class)
+	}
+}
+
+// Conf returns the validated configuration the runner will use. Unless
+// local addresses were given, they're only filled in once the runner runs.
+func (r *Runner) Conf() *GlobalConf {
+	return &r.conf
+}
+
+// logLevels translates --verbosity to a logrus log level. 0 leaves logging
+// as configured by the embedding program.
+var logLevels = map[int]log.Level{
+	1: log.FatalLevel,
+	2: log.ErrorLevel,
+	3: log.WarnLevel, // default
+	4: log.InfoLevel,
+	5: log.DebugLevel,
+}
+
+// defaultLocalAddr returns the address of the interface the default route
+// goes out of, for use in unbound UDP sockets
+func defaultLocalAddr() (net.IP, error) {
+	// connecting a UDP socket sends nothing
+	conn, err := net.Dial("udp", "8.8.8.8:53")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Run is RunContext with a context that is never done
+func (r *Runner) Run() error {
+	return r.RunContext(context.Background())
+}
+
+// RunContext applies the process-wide settings (logging, GOMAXPROCS, open
+// file limit), then performs every lookup and blocks until all results have
+// been written. Once ctx is done no more input is read, and the lookups in
+// flight are abandoned and written with STATUS_CANCELLED, so that the output
+// is complete and the checkpoint is saved.
+func (r *Runner) RunContext(ctx context.Context) error {
+	gc := &r.conf
+	if level, ok := logLevels[gc.Verbosity]; ok {
+		prev := log.GetLevel()
+		log.SetLevel(level)
+		defer log.SetLevel(prev)
+	}
+	if gc.LogFilePath != "" {
+		f, err := os.OpenFile(gc.LogFilePath, os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to open log file (%s): %w", gc.LogFilePath, err)
+		}
+		defer f.Close()
+		prev := log.StandardLogger().Out
+		log.SetOutput(f)
+		defer log.SetOutput(prev)
+	}
+
+	if gc.GoMaxProcs != 0 {
+		runtime.GOMAXPROCS(gc.GoMaxProcs)
+	}
+
+	if !gc.LocalAddrSpecified && len(gc.LocalAddrs) == 0 {
+		ip, err := defaultLocalAddr()
+		if err != nil {
+			return fmt.Errorf("unable to find default IP address: %w", err)
+		}
+		gc.LocalAddrs = append(gc.LocalAddrs, ip)
+	}
+
+	// the margin for limit of open files covers different build platforms (linux/darwin), metadata files, or
+	// input and output files etc.
+	// check ulimit if value is high enough and if not, try to fix it
+	_ = ulimitCheck(uint64(gc.Threads + 100))
 
 	// allow the factory to initialize itself
-	if err := factory.Initialize(&gc); err != nil {
-		log.Panic("Factory was unable to initialize:", err.Error())
+	if err := r.factory.Initialize(gc); err != nil {
+		return fmt.Errorf("factory was unable to initialize: %w", err)
 	}
 	// run it.
-	if err := DoLookups(factory, &gc); err != nil {
-		log.Panic("Unable to run lookups:", err.Error())
+	if err := doLookups(ctx, gc, newLookupModules(r.factory, gc, r.flags), r.resumeFrom); err != nil {
+		return fmt.Errorf("unable to run lookups: %w", err)
 	}
 	// allow the factory to finalize itself
-	if err := factory.Finalize(); err != nil {
-		log.Panic("Factory was unable to finalize:", err.Error())
+	if err := r.factory.Finalize(); err != nil {
+		return fmt.Errorf("factory was unable to finalize: %w", err)
+	}
+	return nil
+}
+
+// Run validates the configuration and runs the scan, exiting the process if
+// either fails.
+//
+// Deprecated: use NewRunner, which reports invalid configurations as a
+// *ValidationError instead of exiting, and Runner.RunContext.
+func Run(gc GlobalConf, flags *pflag.FlagSet,
+	timeout *int, iterationTimeout *int,
+	class_string *string, servers_string *string,
+	config_file *string, localaddr_string *string,
+	localif_string *string, nanoSeconds *bool,
+	clientsubnet_string *string, nsid *bool) {
+
+	r, err := NewRunner(gc, RunOptions{
+		Flags:            flags,
+		Timeout:          *timeout,
+		IterationTimeout: *iterationTimeout,
+		Class:            *class_string,
+		NameServers:      *servers_string,
+		ConfigFile:       *config_file,
+		LocalAddrs:       *localaddr_string,
+		LocalInterface:   *localif_string,
+		NanoSeconds:      *nanoSeconds,
+		ClientSubnet:     *clientsubnet_string,
+		NSID:             *nsid,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := r.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkLogFile checks that the log file at path can be written, without
+// creating it: the file is created when the scan runs
+func checkLogFile(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		dir, err := os.Stat(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if !dir.IsDir() {
+			return fmt.Errorf("%s is not a directory", filepath.Dir(path))
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
 	}
+	return f.Close()
 }
 
-func Run2(gc GlobalConf, in <-chan string, out chan<- any) error {
+// Run2 resolves every name received on in and sends a Result per name to out.
+// Lookups still in flight when ctx is done are abandoned and reported with
+// STATUS_CANCELLED.
+func Run2(ctx context.Context, gc GlobalConf, in <-chan string, out chan<- any) error {
 	factory := GetLookup(gc.Module)
 	if factory == nil {
 		close(out)
@@ -297,7 +589,7 @@ func Run2(gc GlobalConf, in <-chan string, out chan<- any) error {
 		return err
 	}
 
-	if err := DoLookups2(factory, &gc, in, out); err != nil {
+	if err := DoLookups2(ctx, factory, &gc, in, out); err != nil {
 		return err
 	}
 
@@ -0,0 +1,78 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"gotest.tools/v3/assert"
+)
+
+func TestNewRunnerUnknownModule(t *testing.T) {
+	_, err := NewRunner(GlobalConf{Module: "NOSUCHMODULE"}, RunOptions{})
+	var verr *ValidationError
+	assert.Assert(t, errors.As(err, &verr))
+	assert.Equal(t, verr.Option, "module")
+}
+
+func TestNewRunnerValidation(t *testing.T) {
+	RegisterLookup("TESTVALIDATE", &testLookupFactory{module: "TESTVALIDATE"})
+	t.Cleanup(func() { delete(lookups, "TESTVALIDATE") })
+	opts := RunOptions{Class: "INET", NameServers: "192.0.2.53"}
+	tests := []struct {
+		name   string
+		gc     GlobalConf
+		opts   RunOptions
+		option string
+	}{
+		{"bad class", GlobalConf{}, RunOptions{Class: "NOSUCHCLASS", NameServers: opts.NameServers}, "--class"},
+		{"tcp and udp only", GlobalConf{TCPOnly: true, UDPOnly: true}, opts, "--tcp-only"},
+		{"client subnet without netmask", GlobalConf{}, RunOptions{Class: opts.Class, NameServers: opts.NameServers, ClientSubnet: "192.0.2.0"}, "--client-subnet"},
+		{"client subnet netmask too wide", GlobalConf{}, RunOptions{Class: opts.Class, NameServers: opts.NameServers, ClientSubnet: "192.0.2.0/32"}, "--client-subnet"},
+		{"unreadable name server file", GlobalConf{}, RunOptions{Class: opts.Class, NameServers: "@" + filepath.Join(t.TempDir(), "missing.txt")}, "--name-servers"},
+		{"log file in missing directory", GlobalConf{LogFilePath: filepath.Join(t.TempDir(), "missing", "zdns.log")}, opts, "--log-file"},
+		{"log file is a directory", GlobalConf{LogFilePath: t.TempDir()}, opts, "--log-file"},
+	}
+	for _, test := range tests {
+		test.gc.Module = "TESTVALIDATE"
+		_, err := NewRunner(test.gc, test.opts)
+		var verr *ValidationError
+		assert.Assert(t, errors.As(err, &verr), "%s: %v", test.name, err)
+		assert.Equal(t, verr.Option, test.option, test.name)
+	}
+}
+
+// Test that validating a configuration leaves the process alone
+func TestNewRunnerNoSideEffects(t *testing.T) {
+	RegisterLookup("TESTVALIDATE", &testLookupFactory{module: "TESTVALIDATE"})
+	t.Cleanup(func() { delete(lookups, "TESTVALIDATE") })
+	level := log.GetLevel()
+	r, err := NewRunner(GlobalConf{Module: "TESTVALIDATE", Verbosity: 5, ResultVerbosity: "normal"}, RunOptions{Class: "INET", NameServers: "192.0.2.53"})
+	assert.NilError(t, err)
+	assert.Equal(t, log.GetLevel(), level)
+	// the default local address is looked up when the scan runs
+	assert.Equal(t, len(r.Conf().LocalAddrs), 0)
+
+	// and the log file is created then
+	logFile := filepath.Join(t.TempDir(), "zdns.log")
+	_, err = NewRunner(GlobalConf{Module: "TESTVALIDATE", Verbosity: 3, ResultVerbosity: "normal", LogFilePath: logFile}, RunOptions{Class: "INET", NameServers: "192.0.2.53"})
+	assert.NilError(t, err)
+	_, err = os.Stat(logFile)
+	assert.Assert(t, errors.Is(err, os.ErrNotExist))
+}
@@ -0,0 +1,235 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+// Package zdnsreader reads the JSON output of zdns back into zdns.Result
+// values, whose data has the result type of the module that produced it and
+// whose records are the answer structs of miekg.ParseAnswer. The result type
+// is the one the module's lookup factory declares, see zdns.ResultTyper.
+// Modules other than those of zdns have to be registered by the program.
+package zdnsreader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/zmap/zdns/iohandlers"
+	"github.com/zmap/zdns/pkg/miekg"
+	"github.com/zmap/zdns/pkg/zdns"
+
+	// the modules of zdns, whose results are read
+	_ "github.com/zmap/zdns/pkg/alookup"
+	_ "github.com/zmap/zdns/pkg/axfr"
+	_ "github.com/zmap/zdns/pkg/bindversion"
+	_ "github.com/zmap/zdns/pkg/dmarc"
+	_ "github.com/zmap/zdns/pkg/mxlookup"
+	_ "github.com/zmap/zdns/pkg/nslookup"
+	_ "github.com/zmap/zdns/pkg/spf"
+)
+
+// Metadata is what a Reader needs to know about the scan that wrote an
+// output. It is the conf of a --metadata-file.
+type Metadata struct {
+	Module string
+	// Modules is set if several modules were run per name
+	Modules []string
+}
+
+// ReadMetadata reads the modules of a scan from its --metadata-file
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	var meta struct {
+		Conf *Metadata `json:"conf"`
+	}
+	if err := json.NewDecoder(r).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("unable to parse metadata: %w", err)
+	}
+	if meta.Conf == nil || meta.Conf.Module == "" {
+		return nil, errors.New("metadata doesn't name a module")
+	}
+	return meta.Conf, nil
+}
+
+// DecodeData decodes the data of a result of module into the module's
+// result type
+func DecodeData(module string, data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	t, err := zdns.LookupResultType(module)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode data: %w", err)
+	}
+	res := reflect.New(t)
+	if err := json.Unmarshal(data, res.Interface()); err != nil {
+		return nil, fmt.Errorf("unable to decode %s data: %w", module, err)
+	}
+	// lookups return their results by value
+	return res.Elem().Interface(), nil
+}
+
+// DecodeTrace decodes the steps of a trace into miekg.TraceStep values
+func DecodeTrace(trace []json.RawMessage) ([]interface{}, error) {
+	if trace == nil {
+		return nil, nil
+	}
+	steps := make([]interface{}, len(trace))
+	for i, t := range trace {
+		var step miekg.TraceStep
+		if err := json.Unmarshal(t, &step); err != nil {
+			return nil, fmt.Errorf("unable to decode trace: %w", err)
+		}
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+// Reader reads results from the output of a scan, a result per line
+type Reader struct {
+	r    *bufio.Reader
+	c    io.Closer
+	meta Metadata
+	line int
+}
+
+// NewReader returns a Reader of the results in r, written by the scan
+// described by meta
+func NewReader(r io.Reader, meta *Metadata) *Reader {
+	return &Reader{r: bufio.NewReader(r), meta: *meta}
+}
+
+// Open returns a Reader of the results in the file at path, which is
+// decompressed according to its extension like --input-file
+func Open(path string, meta *Metadata) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := iohandlers.ResolveCompression(iohandlers.COMPRESSION_AUTO, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	dr, err := iohandlers.NewDecompressingReader(f, compression)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := NewReader(dr, meta)
+	r.c = closers{dr, f}
+	return r, nil
+}
+
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var err error
+	for _, c := range cs {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Close closes the file opened by Open
+func (r *Reader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// Next returns the next result, or io.EOF once all have been read
+func (r *Reader) Next() (*zdns.Result, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+		r.line++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		res, err := r.decode(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return res, nil
+	}
+}
+
+// moduleResult is a zdns.ModuleResult whose data isn't decoded yet
+type moduleResult struct {
+	Status string            `json:"status"`
+	Error  string            `json:"error"`
+	Data   json.RawMessage   `json:"data"`
+	Trace  []json.RawMessage `json:"trace"`
+}
+
+func (r *Reader) decode(line []byte) (*zdns.Result, error) {
+	type result zdns.Result
+	var raw struct {
+		result
+		Data  json.RawMessage   `json:"data"`
+		Trace []json.RawMessage `json:"trace"`
+	}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, err
+	}
+	res := zdns.Result(raw.result)
+	var err error
+	if res.Trace, err = DecodeTrace(raw.Trace); err != nil {
+		return nil, err
+	}
+	if len(r.meta.Modules) == 0 {
+		// jsonl input lines may have chosen their own module
+		module := r.meta.Module
+		if res.Module != "" {
+			module = res.Module
+		}
+		if res.Data, err = DecodeData(module, raw.Data); err != nil {
+			return nil, err
+		}
+		return &res, nil
+	}
+	var modules map[string]moduleResult
+	if len(raw.Data) > 0 {
+		if err := json.Unmarshal(raw.Data, &modules); err != nil {
+			return nil, err
+		}
+	}
+	if modules == nil {
+		return &res, nil
+	}
+	results := make(map[string]zdns.ModuleResult, len(modules))
+	for module, mr := range modules {
+		data, err := DecodeData(module, mr.Data)
+		if err != nil {
+			return nil, err
+		}
+		trace, err := DecodeTrace(mr.Trace)
+		if err != nil {
+			return nil, err
+		}
+		results[module] = zdns.ModuleResult{Status: mr.Status, Error: mr.Error, Data: data, Trace: trace}
+	}
+	res.Data = results
+	return &res, nil
+}
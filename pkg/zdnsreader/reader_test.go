@@ -0,0 +1,164 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdnsreader
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zmap/zdns/pkg/miekg"
+	"github.com/zmap/zdns/pkg/mxlookup"
+	"github.com/zmap/zdns/pkg/zdns"
+	"gotest.tools/v3/assert"
+)
+
+func TestReadMetadata(t *testing.T) {
+	meta, err := ReadMetadata(strings.NewReader(`{"names":1,"conf":{"Threads":10,"Module":"A","Modules":["A","MXLOOKUP"],"InputHandler":{}}}`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, meta, &Metadata{Module: "A", Modules: []string{"A", "MXLOOKUP"}})
+
+	_, err = ReadMetadata(strings.NewReader(`{"names":1}`))
+	assert.ErrorContains(t, err, "doesn't name a module")
+}
+
+func TestReader(t *testing.T) {
+	output := `{"name":"example.com","status":"NOERROR","timestamp":"2022-01-01T00:00:00Z","data":{"answers":[{"ttl":60,"type":"MX","class":"IN","name":"example.com","answer":"mail.example.com","preference":10},{"ttl":60,"type":"SOA","class":"IN","name":"example.com","ns":"ns1.example.com","mbox":"hostmaster.example.com","serial":1,"refresh":2,"retry":3,"expire":4,"min_ttl":5}],"protocol":"udp","resolver":"192.0.2.53:53"}}
+
+{"name":"nx.example.com","status":"NXDOMAIN","data":{"protocol":"udp","resolver":"192.0.2.53:53"},"trace":[{"results":{"answers":[{"ttl":60,"type":"A","class":"IN","name":"example.com","answer":"192.0.2.1"}],"protocol":"udp","resolver":"192.0.2.53:53","flags":{"response":true}},"type":1,"class":1,"name":"example.com","name_server":"192.0.2.53:53","depth":1,"layer":".","cached":false,"try":0}]}
+{"name":"nx.example.com","status":"ILLEGAL_INPUT","error":"bad name"}
+`
+	r := NewReader(strings.NewReader(output), &Metadata{Module: "MX"})
+	res, err := r.Next()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res, &zdns.Result{
+		Name:      "example.com",
+		Status:    "NOERROR",
+		Timestamp: "2022-01-01T00:00:00Z",
+		Data: miekg.Result{
+			Answers: []miekg.RR{
+				miekg.PrefAnswer{
+					Answer:     miekg.Answer{Ttl: 60, Type: "MX", RrType: 15, Class: "IN", RrClass: 1, Name: "example.com", Answer: "mail.example.com"},
+					Preference: 10,
+				},
+				miekg.SOAAnswer{
+					Answer: miekg.Answer{Ttl: 60, Type: "SOA", RrType: 6, Class: "IN", RrClass: 1, Name: "example.com"},
+					Ns:     "ns1.example.com", Mbox: "hostmaster.example.com", Serial: 1, Refresh: 2, Retry: 3, Expire: 4, Minttl: 5,
+				},
+			},
+			Protocol: "udp",
+			Resolver: "192.0.2.53:53",
+		},
+	})
+
+	res, err = r.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, res.Status, "NXDOMAIN")
+	assert.DeepEqual(t, res.Trace, []interface{}{miekg.TraceStep{
+		Result: miekg.Result{
+			Answers: []miekg.RR{
+				miekg.Answer{Ttl: 60, Type: "A", RrType: 1, Class: "IN", RrClass: 1, Name: "example.com", Answer: "192.0.2.1"},
+			},
+			Protocol: "udp",
+			Resolver: "192.0.2.53:53",
+			Flags:    miekg.DNSFlags{Response: true},
+		},
+		DnsType: 1, DnsClass: 1, Name: "example.com", NameServer: "192.0.2.53:53", Depth: 1, Layer: ".",
+	}})
+
+	res, err = r.Next()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res, &zdns.Result{Name: "nx.example.com", Status: "ILLEGAL_INPUT", Error: "bad name"})
+
+	_, err = r.Next()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestReaderModules(t *testing.T) {
+	output := `{"name":"example.com","status":"NOERROR","data":{"A":{"status":"TIMEOUT","error":"timeout"},"MXLOOKUP":{"status":"NOERROR","data":{"exchanges":[{"name":"mail.example.com","type":"MX","class":"IN","preference":10,"ipv4_addresses":["192.0.2.1"],"ttl":60}]}}}}` + "\n"
+	path := filepath.Join(t.TempDir(), "results.json.gz")
+	f, err := os.Create(path)
+	assert.NilError(t, err)
+	w := gzip.NewWriter(f)
+	_, err = io.WriteString(w, output)
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	assert.NilError(t, f.Close())
+
+	r, err := Open(path, &Metadata{Module: "A", Modules: []string{"A", "MXLOOKUP"}})
+	assert.NilError(t, err)
+	defer r.Close()
+	res, err := r.Next()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res.Data, map[string]zdns.ModuleResult{
+		"A": {Status: "TIMEOUT", Error: "timeout"},
+		"MXLOOKUP": {Status: "NOERROR", Data: mxlookup.Result{Servers: []mxlookup.MXRecord{
+			{Name: "mail.example.com", Type: "MX", Class: "IN", Preference: 10, IPv4Addresses: []string{"192.0.2.1"}, TTL: 60},
+		}}},
+	})
+	_, err = r.Next()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestReaderBadLine(t *testing.T) {
+	r := NewReader(strings.NewReader("{\"name\":\"a\"}\nname,status\n"), &Metadata{Module: "A"})
+	_, err := r.Next()
+	assert.NilError(t, err)
+	_, err = r.Next()
+	assert.ErrorContains(t, err, "line 2:")
+}
+
+// Test that every module declares the type of its results, and that a module
+// embedding the factory of another one doesn't inherit its type
+func TestResultTypes(t *testing.T) {
+	for _, module := range zdns.Validlookups() {
+		_, err := zdns.LookupResultType(module)
+		assert.NilError(t, err, module)
+	}
+	rt, err := zdns.LookupResultType("MXLOOKUP")
+	assert.NilError(t, err)
+	assert.Equal(t, rt, reflect.TypeOf(mxlookup.Result{}))
+	rt, err = zdns.LookupResultType("NSLOOKUP")
+	assert.NilError(t, err)
+	assert.Equal(t, rt, reflect.TypeOf(miekg.NSResult{}))
+
+	var factory interface{} = &struct{ miekg.GlobalLookupFactory }{}
+	_, ok := factory.(zdns.ResultTyper)
+	assert.Assert(t, !ok)
+}
+
+// Test that results of jsonl input lines that chose their own module are
+// decoded into its result type
+func TestReaderLineModule(t *testing.T) {
+	output := `{"name":"example.com","module":"MXLOOKUP","status":"NOERROR","data":{"exchanges":[{"name":"mail.example.com","type":"MX","class":"IN","preference":10,"ttl":60}]}}
+{"name":"example.com","status":"NOERROR","data":{"answers":[{"ttl":60,"type":"A","class":"IN","name":"example.com","answer":"192.0.2.1"}]}}
+`
+	r := NewReader(strings.NewReader(output), &Metadata{Module: "A"})
+	res, err := r.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, res.Module, "MXLOOKUP")
+	assert.DeepEqual(t, res.Data, mxlookup.Result{Servers: []mxlookup.MXRecord{
+		{Name: "mail.example.com", Type: "MX", Class: "IN", Preference: 10, TTL: 60},
+	}})
+	res, err = r.Next()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res.Data, miekg.Result{Answers: []miekg.RR{
+		miekg.Answer{Ttl: 60, Type: "A", RrType: 1, Class: "IN", RrClass: 1, Name: "example.com", Answer: "192.0.2.1"},
+	}})
+}
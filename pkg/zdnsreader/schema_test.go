@@ -0,0 +1,248 @@
+/*
+ * ZDNS Copyright 2022 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdnsreader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zmap/dns"
+	"github.com/zmap/zdns/pkg/miekg"
+	"github.com/zmap/zdns/pkg/mxlookup"
+	"github.com/zmap/zdns/pkg/zdns"
+	"gotest.tools/v3/assert"
+)
+
+// validateSchema checks the JSON value v against schema s, whose
+// definitions are those of root. Only the keywords zdns.OutputSchema writes
+// are supported.
+func validateSchema(root, s map[string]interface{}, v interface{}, path string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		defs, _ := root["$defs"].(map[string]interface{})
+		def, ok := defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: unknown reference %s", path, ref)
+		}
+		return validateSchema(root, def, v, path)
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		for _, sub := range anyOf {
+			if validateSchema(root, sub.(map[string]interface{}), v, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %v matches none of %v", path, v, anyOf)
+	}
+	if t, ok := s["type"]; ok {
+		types, ok := t.([]interface{})
+		if !ok {
+			types = []interface{}{t}
+		}
+		matched := false
+		for _, t := range types {
+			matched = matched || isJSONType(v, t.(string))
+		}
+		if !matched {
+			return fmt.Errorf("%s: %v isn't of type %v", path, v, t)
+		}
+	}
+	switch v := v.(type) {
+	case json.Number:
+		if min, ok := s["minimum"].(float64); ok {
+			if f, _ := v.Float64(); f < min {
+				return fmt.Errorf("%s: %v is below %v", path, v, min)
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateSchema(root, items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		required, _ := s["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := v[r.(string)]; !ok {
+				return fmt.Errorf("%s: %s is missing", path, r)
+			}
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, ok := props[k].(map[string]interface{})
+			if !ok {
+				switch ap := s["additionalProperties"].(type) {
+				case bool:
+					if !ap {
+						return fmt.Errorf("%s: %s isn't allowed", path, k)
+					}
+					continue
+				case map[string]interface{}:
+					sub = ap
+				default:
+					continue
+				}
+			}
+			if err := validateSchema(root, sub, v[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isJSONType(v interface{}, t string) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	case json.Number:
+		if t == "integer" {
+			_, err := v.Int64()
+			return err == nil
+		}
+		return t == "number"
+	}
+	return false
+}
+
+// checkSchema checks that res, as written with groups, is valid according
+// to the schema zdns schema prints for it
+func checkSchema(t *testing.T, opts zdns.SchemaOptions, res *zdns.Result) {
+	t.Helper()
+	modules := opts.Modules
+	if len(modules) == 0 {
+		modules = []string{opts.Module}
+	}
+	types := make(map[string]reflect.Type)
+	for _, module := range modules {
+		rt, err := zdns.LookupResultType(module)
+		assert.NilError(t, err)
+		types[module] = rt
+	}
+	opts.ResultType = func(module string) reflect.Type { return types[module] }
+	opts.TraceStepType = reflect.TypeOf(miekg.TraceStep{})
+	// the schema as printed
+	j, err := json.Marshal(zdns.OutputSchema(opts))
+	assert.NilError(t, err)
+	var schema map[string]interface{}
+	assert.NilError(t, json.Unmarshal(j, &schema))
+
+	out, err := zdns.NewResultEncoder(opts.Groups).Encode(res)
+	assert.NilError(t, err)
+	d := json.NewDecoder(bytes.NewReader(out))
+	d.UseNumber()
+	var v interface{}
+	assert.NilError(t, d.Decode(&v))
+	assert.NilError(t, validateSchema(schema, schema, v, "$"), "%s %v: %s", opts.Module, opts.Groups, out)
+}
+
+func schemaTestRecords(t *testing.T, records ...string) []miekg.RR {
+	var rrs []miekg.RR
+	for _, r := range records {
+		rr, err := dns.NewRR(r)
+		assert.NilError(t, err)
+		rrs = append(rrs, miekg.ParseAnswer(rr))
+	}
+	return rrs
+}
+
+var schemaTestVerbosities = [][]string{
+	{"short", ""},
+	{"normal", ""},
+	{"long", ""},
+	{"trace", ""},
+	{"short", "ttl", "resolver", "flags", "protocol"},
+}
+
+// Test that the output of modules is valid according to the schema
+// generated for it with every verbosity
+func TestOutputSchemaValidates(t *testing.T) {
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(1232)
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e73"})
+	answers := miekg.Result{
+		Answers: schemaTestRecords(t,
+			"example.com. 300 IN A 192.0.2.1",
+			"example.com. 300 IN MX 10 mail.example.com.",
+			"example.com. 300 IN TXT \"v=spf1 -all\"",
+			"example.com. 300 IN CAA 0 issue \"ca.example.net\"",
+		),
+		Authorities: schemaTestRecords(t, "example.com. 3600 IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300"),
+		Additional:  append(schemaTestRecords(t, "ns1.example.com. 3600 IN AAAA 2001:db8::53"), miekg.ParseAnswer(opt)),
+		Protocol:    "udp",
+		Resolver:    "192.0.2.53:53",
+		Flags:       miekg.DNSFlags{Response: true, RecursionDesired: true, RecursionAvailable: true},
+	}
+	trace := []interface{}{
+		miekg.TraceStep{Result: answers, DnsType: dns.TypeA, DnsClass: dns.ClassINET, Name: "example.com", NameServer: "192.0.2.53:53", Depth: 1, Layer: "."},
+		miekg.TraceStep{Result: miekg.Result{Protocol: "tcp"}, DnsType: dns.TypeA, DnsClass: dns.ClassINET, Name: "example.com", Cached: true, Try: 1},
+	}
+	exchanges := mxlookup.Result{Servers: []mxlookup.MXRecord{
+		{Name: "mail.example.com", Type: "MX", Class: "IN", Preference: 10, IPv4Addresses: []string{"192.0.2.25"}, IPv6Addresses: []string{"2001:db8::25"}, TTL: 300},
+		{Name: "backup.example.com", Type: "MX", Class: "IN", Preference: 20, TTL: 300},
+	}}
+
+	for _, groups := range schemaTestVerbosities {
+		res := &zdns.Result{Name: "example.com", Class: "IN", Status: "NOERROR", Timestamp: "2022-01-01T00:00:00Z", Metadata: json.RawMessage(`{"id":1}`), Data: answers}
+		if groups[0] == "trace" {
+			res.Trace = trace
+		}
+		checkSchema(t, zdns.SchemaOptions{Groups: groups, Module: "A"}, res)
+
+		res = &zdns.Result{Name: "example.com", Module: "MXLOOKUP", Status: "NOERROR", Data: exchanges}
+		checkSchema(t, zdns.SchemaOptions{Groups: groups, Module: "MXLOOKUP"}, res)
+
+		res = &zdns.Result{Name: "example.com", Status: "NOERROR", Data: map[string]zdns.ModuleResult{
+			"A":        {Status: "NOERROR", Data: answers, Trace: trace},
+			"MXLOOKUP": {Status: "NOERROR", Data: exchanges},
+		}}
+		checkSchema(t, zdns.SchemaOptions{Groups: groups, Module: "A", Modules: []string{"A", "MXLOOKUP"}}, res)
+	}
+}
+
+// Test that the validation used above does catch output the schema doesn't
+// describe
+func TestValidateSchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{"ttl": map[string]interface{}{"type": "integer", "minimum": float64(0)}},
+		"required":             []interface{}{"ttl"},
+		"additionalProperties": false,
+	}
+	assert.NilError(t, validateSchema(schema, schema, map[string]interface{}{"ttl": json.Number("1")}, "$"))
+	assert.ErrorContains(t, validateSchema(schema, schema, map[string]interface{}{}, "$"), "ttl is missing")
+	assert.ErrorContains(t, validateSchema(schema, schema, map[string]interface{}{"ttl": json.Number("-1")}, "$"), "below")
+	assert.ErrorContains(t, validateSchema(schema, schema, map[string]interface{}{"ttl": "1"}, "$"), "isn't of type")
+	assert.ErrorContains(t, validateSchema(schema, schema, map[string]interface{}{"ttl": json.Number("1"), "x": true}, "$"), "x isn't allowed")
+}